@@ -0,0 +1,41 @@
+package main
+
+import (
+	"time"
+)
+
+// Length of the window the burst size is measured over
+const burstWindow = time.Second
+
+// When messages on one channel arrive, to tell steady channels from bursty
+// ones
+type channelArrivals struct {
+	last     time.Time
+	gaps     durationStats // Time between consecutive messages
+	window   []time.Time   // Arrivals within the last burstWindow, oldest first
+	maxBurst int           // Most messages seen within any burstWindow
+}
+
+func (a *channelArrivals) add(at time.Time) {
+	if !a.last.IsZero() {
+		a.gaps.add(at.Sub(a.last))
+	}
+	a.last = at
+
+	// Each arrival is appended and removed once, so this is O(1) amortized
+	evict := 0
+	for evict < len(a.window) && at.Sub(a.window[evict]) >= burstWindow {
+		evict++
+	}
+	a.window = append(a.window[evict:], at)
+	if len(a.window) > a.maxBurst {
+		a.maxBurst = len(a.window)
+	}
+}
+
+// Returns a copy that doesn't share any slices with a
+func (a channelArrivals) clone() channelArrivals {
+	a.gaps = a.gaps.clone()
+	a.window = append([]time.Time(nil), a.window...)
+	return a
+}
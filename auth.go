@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gorilla/websocket"
+)
+
+// Set at startup from the credential options
+var auth pushclient.AuthProvider
+
+// Creates the provider chosen by the credential options
+func newAuthProviderFromFlags() pushclient.AuthProvider {
+	if *clientV3SecretFlag != "" {
+		return pushclient.NewSecretAuth(*clientV3SecretFlag)
+	} else if *secretFileFlag != "" {
+		return secretFileAuth{fileName: *secretFileFlag}
+	}
+
+	tokens := &pushclient.V2TokenSource{
+		URL:          accessTokenBaseURL(),
+		ClientID:     *clientV2IDFlag,
+		ClientSecret: *clientV2SecretFlag,
+		OnTokenRequest: func(took time.Duration) {
+			currentPhases.measured("token", took)
+		},
+	}
+	if *clientSecretFileFlag != "" {
+		fileName := *clientSecretFileFlag
+		tokens.LoadClientSecret = func() (string, error) {
+			return readSecretFile(fileName)
+		}
+	}
+	if *v2AuthStyleFlag == "header" {
+		return pushclient.NewV2HeaderAuth(tokens)
+	}
+
+	return pushclient.NewV2QueryAuth(tokens)
+}
+
+// Explains a close by the server for authorization reasons, during setup or
+// mid-stream, with the credential options to check. Returns nil for other
+// errors. Only the length of the secret is included, never the secret.
+func explainAuthClose(err error, subscriptionIDOrName string) error {
+	code := 0
+	var setupErr *pushclient.ServerCloseError
+	var closeErr *websocket.CloseError
+	if errors.As(err, &setupErr) {
+		code = setupErr.Code
+	} else if errors.As(err, &closeErr) {
+		code = closeErr.Code
+	}
+	if !pushclient.IsAuthCloseCode(code) {
+		return nil
+	}
+
+	var credentials string
+	if *clientV3SecretFlag != "" {
+		credentials = fmt.Sprintf("'--secret' (%d characters)", len(*clientV3SecretFlag))
+	} else if *secretFileFlag != "" {
+		secret, _ := readSecretFile(*secretFileFlag)
+		credentials = fmt.Sprintf("'--secret-file' '%s' (%d characters)", *secretFileFlag, len(secret))
+	} else if *clientSecretFileFlag != "" {
+		secret, _ := readSecretFile(*clientSecretFileFlag)
+		credentials = fmt.Sprintf("'--client-id' ('%s') and '--client-secret-file' '%s' (%d characters)", *clientV2IDFlag, *clientSecretFileFlag, len(secret))
+	} else {
+		credentials = fmt.Sprintf("'--client-id' ('%s') and '--client-secret' (%d characters)", *clientV2IDFlag, len(*clientV2SecretFlag))
+	}
+
+	var hint string
+	switch code {
+	case pushclient.CloseMissingSecret:
+		hint = "No credentials reached the server, check that " + credentials + " is set"
+	case pushclient.CloseInvalidSecret:
+		hint = "The credentials were rejected, check " + credentials
+	case pushclient.CloseNotAuthorized:
+		hint = "The account of " + credentials + " does not have access to the push API, contact support to enable it"
+	}
+
+	return fmt.Errorf("Server closed the connection to subscription '%s' with code %d, reconnecting won't help. %s. Error: %v", subscriptionIDOrName, code, hint, err)
+}
+
+// Reads a credential from a file, like the secrets mounted by Kubernetes or
+// Docker Swarm, without surrounding whitespace
+func readSecretFile(fileName string) (string, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("The secret file '%s' does not exist", fileName)
+	} else if os.IsPermission(err) {
+		return "", fmt.Errorf("No permission to read the secret file '%s'", fileName)
+	} else if err != nil {
+		return "", fmt.Errorf("Failed to read the secret file '%s'. Error: %v", fileName, err)
+	}
+
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("The secret file '%s' is empty", fileName)
+	}
+
+	return secret, nil
+}
+
+// v3 authentication with the secret read from '--secret-file' whenever the
+// credentials are added, so that a rotated secret is used from the next
+// request or reconnect on
+type secretFileAuth struct {
+	fileName string
+}
+
+func (a secretFileAuth) provider() (pushclient.AuthProvider, error) {
+	secret, err := readSecretFile(a.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return pushclient.NewSecretAuth(secret), nil
+}
+
+func (a secretFileAuth) Apply(req *http.Request) error {
+	p, err := a.provider()
+	if err != nil {
+		return err
+	}
+
+	return p.Apply(req)
+}
+
+func (a secretFileAuth) WebsocketHeaders(ctx context.Context) (http.Header, error) {
+	p, err := a.provider()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.WebsocketHeaders(ctx)
+}
+
+func (a secretFileAuth) QueryParams(ctx context.Context) (url.Values, error) {
+	return nil, nil
+}
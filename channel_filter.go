@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var onlyChannelFlag = flag.StringArray("only-channel", nil, "Only print and deliver the messages on this channel, e.g. 'series' or 'series*' (repeatable)")
+
+// Set at startup if '--only-channel' or '--exclude-channel' is given
+var channelFilter *localChannelFilter
+
+// Decides which received messages are printed and delivered to the sinks,
+// without changing the filters of the subscription on the server
+type localChannelFilter struct {
+	only    []string
+	exclude []string
+}
+
+func newLocalChannelFilter(only []string, exclude []string) (*localChannelFilter, error) {
+	for _, o := range only {
+		for _, e := range exclude {
+			if strings.EqualFold(o, e) {
+				return nil, fmt.Errorf("The channel '%s' is given to both '--only-channel' and '--exclude-channel'", o)
+			}
+		}
+	}
+
+	if len(only) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	return &localChannelFilter{only: only, exclude: exclude}, nil
+}
+
+// Reports if messages on the channel are printed and delivered
+func (f *localChannelFilter) shows(channel string) bool {
+	if matchesAnyChannel(f.exclude, channel) {
+		return false
+	}
+
+	return len(f.only) == 0 || matchesAnyChannel(f.only, channel)
+}
+
+func matchesAnyChannel(patterns []string, channel string) bool {
+	for _, p := range patterns {
+		if matchesChannel(p, channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches a channel name case-insensitively, a '*' at the end of the
+// pattern matches any rest of the name
+func matchesChannel(pattern string, channel string) bool {
+	pattern = strings.ToLower(pattern)
+	channel = strings.ToLower(channel)
+
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(channel, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return pattern == channel
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	flag "github.com/spf13/pflag"
+)
+
+var colorFlag = flag.String("color", "auto", "Colorize the pretty-printed JSON: 'auto' when stdout is a terminal, 'always' or 'never' (the same as '--no-pp')")
+
+// Set once at startup, when false JSON is pretty-printed without color
+var colorOutput bool
+
+func validateColorFlag() error {
+	switch *colorFlag {
+	case "auto", "always", "never":
+	default:
+		return fmt.Errorf("Unknown color mode '%s', must be 'auto', 'always' or 'never'", *colorFlag)
+	}
+
+	if *noPPFlag && *colorFlag == "always" {
+		return fmt.Errorf("The options '--no-pp' and '--color=always' can't be used together")
+	}
+
+	return nil
+}
+
+// Decides if the output is colored, for the printed JSON and everything
+// else using the color library. Output meant to be parsed, like ndjson, is
+// never colored.
+func setupColor() {
+	switch {
+	case *noPPFlag || *colorFlag == "never" || ndjsonOutput || csvOutput:
+		colorOutput = false
+	case *colorFlag == "always":
+		colorOutput = true
+	default:
+		// The color library has checked if stdout is a terminal and that
+		// TERM isn't 'dumb'. See https://no-color.org for NO_COLOR.
+		colorOutput = !color.NoColor && os.Getenv("NO_COLOR") == ""
+	}
+
+	color.NoColor = !colorOutput
+}
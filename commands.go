@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Command-line options only useful with the commands
+var outFlag = flag.String("out", "", "File to write the output of a command to, stdout if not given")
+var allChannelsFlag = flag.Bool("all-channels", false, "generate: add one filter for every channel in the push service config")
+var gameIDFlag = flag.Int("game-id", 0, "generate: restrict the filters to a game")
+var seriesIDFlag = flag.Int("series-id", 0, "generate: restrict the filters to a series")
+var matchIDFlag = flag.Int("match-id", 0, "generate: restrict the filters to a match")
+var excludeChannelFlag = flag.StringArray("exclude-channel", nil, "generate: leave out a channel from the filters, otherwise: don't print or deliver the messages on the channel, e.g. 'series' or 'series*' (repeatable)")
+var outputFlag = flag.String("output", "text", "diff, list: output format, 'text' or 'json'")
+var jsonFlag = flag.Bool("json", false, "list: same as '--output=json', get: print the subscription as compact JSON on a single line")
+
+// Returned by commands that need to exit with a specific code. Err is
+// logged before exiting unless it is nil.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("Exit code %d", e.code)
+	}
+
+	return e.err.Error()
+}
+
+// Commands are given as the first positional argument, e.g.
+// '$ ./push-api-client validate spec.json'. They do a single task and exit
+// without connecting a subscriber.
+var commands = map[string]func(args []string) error{
+	"delete":        deleteCommand,
+	"diff":          diffCommand,
+	"edit":          editCommand,
+	"events":        eventsCommand,
+	"export":        exportCommand,
+	"generate":      generateCommand,
+	"get":           getCommand,
+	"list":          listCommand,
+	"output-schema": outputSchemaCommand,
+	"prune":         pruneCommand,
+	"register":      registerCommand,
+	"replay":        replayCommand,
+	"sign":          signCommand,
+	"validate":      validateCommand,
+}
+
+func runCommand(name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("Unknown command '%s'", name)
+	}
+
+	return cmd(args)
+}
+
+// Writes a subscription specification that captures every channel in the
+// push service config, optionally restricted to a game, series or match.
+func generateCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("The generate command takes no arguments")
+	}
+	if !*allChannelsFlag {
+		return fmt.Errorf("The generate command needs the option '--all-channels'")
+	}
+
+	err := validateCredentialFlags()
+	if err != nil {
+		return err
+	}
+
+	config, err := apiClient.FetchPushServiceConfig(context.Background())
+	if err != nil {
+		return fmt.Errorf("Config request failed. Error: %v", err)
+	}
+
+	sub := generateSubscription(config.Channels, *excludeChannelFlag, SubscriptionFilter{
+		GameID:   *gameIDFlag,
+		SeriesID: *seriesIDFlag,
+		MatchID:  *matchIDFlag,
+	})
+
+	// The generated spec is meant to be passed back with '--subscription-file'
+	// so it has to pass the same validation
+	err = validateSubscription(sub)
+	if err != nil {
+		return fmt.Errorf("Generated subscription is invalid. Error: %v", err)
+	}
+
+	s, err := json.MarshalIndent(sub, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeCommandOutput(append(s, '\n'))
+}
+
+// Creates a subscription with one filter per channel, each filter
+// restricted with the IDs set in scope
+func generateSubscription(channels []ConfigChannel, excluded []string, scope SubscriptionFilter) Subscription {
+	sub := Subscription{
+		Description: "All channels",
+		Filters:     []SubscriptionFilter{},
+	}
+	if scope.GameID != 0 {
+		sub.Description += fmt.Sprintf(" for game %d", scope.GameID)
+	}
+
+	for _, c := range channels {
+		if matchesAnyChannel(excluded, c.Name) {
+			continue
+		}
+
+		f := scope
+		f.Channel = c.Name
+		sub.Filters = append(sub.Filters, f)
+	}
+
+	return sub
+}
+
+// Checks the subscription specification files without registering them
+func validateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("The validate command needs at least one subscription file")
+	}
+
+	invalid := 0
+	for _, fileName := range args {
+		_, err := readSubscriptionSpec(fileName)
+		if err != nil {
+			log.Printf("[ERROR] '%s' is not a valid subscription specification. Error: %v\n", fileName, err)
+			invalid++
+			continue
+		}
+
+		log.Printf("[INFO] '%s' is a valid subscription specification\n", fileName)
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d subscription files are invalid", invalid, len(args))
+	}
+
+	return nil
+}
+
+// Signs a message with the key given by '--verify-signature-key-file', for
+// creating test messages when working with signature verification
+func signCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("The sign command needs exactly one message file")
+	}
+	if *verifySignatureKeyFileFlag == "" {
+		return fmt.Errorf("The sign command needs the option '--verify-signature-key-file'")
+	}
+
+	key, err := readSignatureKey(*verifySignatureKeyFileFlag)
+	if err != nil {
+		return err
+	}
+
+	msg, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	signed, err := signMessage(msg, key)
+	if err != nil {
+		return err
+	}
+
+	return writeCommandOutput(append(signed, '\n'))
+}
+
+// Exit codes of the diff command
+const (
+	diffExitIdentical = 0
+	diffExitDifferent = 1
+	diffExitError     = 2
+)
+
+// Compares a local spec file with a subscription registered on the server.
+// Exits with 0 if they are identical, 1 if they differ and 2 on errors, so
+// it can be used to detect drift in CI.
+func diffCommand(args []string) error {
+	changes, err := diffSpecWithServer(args)
+	if err != nil {
+		return &exitCodeError{code: diffExitError, err: err}
+	}
+
+	if len(changes) > 0 {
+		return &exitCodeError{code: diffExitDifferent}
+	}
+
+	return nil
+}
+
+func diffSpecWithServer(args []string) ([]subscriptionChange, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("The diff command needs a subscription file and a subscription id or name")
+	}
+	if *outputFlag != "text" && *outputFlag != "json" {
+		return nil, fmt.Errorf("Unknown output format '%s', must be 'text' or 'json'", *outputFlag)
+	}
+
+	err := validateCredentialFlags()
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := readSubscriptionSpec(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("Could not read subscription spec from file. Error: %v", err)
+	}
+
+	server, err := apiClient.FetchSubscription(context.Background(), args[1])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch subscription '%s'. Error: %v", args[1], err)
+	}
+
+	// Compared the way register would update the subscription, so that a
+	// description only kept on the server isn't reported as a change
+	if *ownerTagFlag != "" {
+		local.Description = withOwnerTag(local.Description, *ownerTagFlag)
+	}
+	local = keepExistingDescription(server, local, *clearDescriptionFlag, *ownerTagFlag)
+	changes := diffSubscriptions(server, local)
+	if *outputFlag == "json" {
+		if changes == nil {
+			changes = []subscriptionChange{}
+		}
+		b, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return changes, writeCommandOutput(append(b, '\n'))
+	}
+
+	if len(changes) == 0 {
+		log.Printf("[INFO] '%s' is identical to subscription '%s'\n", args[0], args[1])
+		return changes, nil
+	}
+	writeSubscriptionDiff(os.Stdout, args[1]+" (server)", args[0]+" (local)", server, changes)
+
+	return changes, nil
+}
+
+func writeCommandOutput(b []byte) error {
+	if *outFlag == "" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+
+	return ioutil.WriteFile(*outFlag, b, 0644)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+)
+
+// Points apiClient at a push service with the given '/config' response
+func useConfigServer(t *testing.T, config string) {
+	t.Helper()
+
+	useAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(config))
+	})
+}
+
+// Points apiClient at a push service whose HTTP API is handled by handler
+func useAPIServer(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := pushclient.New(pushclient.Config{
+		Addr: "ws" + strings.TrimPrefix(server.URL, "http"),
+		Auth: pushclient.NewSecretAuth("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiClient = client
+	*clientV3SecretFlag = "secret"
+	t.Cleanup(func() {
+		apiClient = nil
+		*clientV3SecretFlag = ""
+	})
+}
+
+// The spec written by generate passes validate and reads back as generated
+func TestGenerateValidateRoundTrip(t *testing.T) {
+	discardLog(t)
+	useConfigServer(t, `{"channels": ["series", {"name": "match"}, "series_odds", "player"]}`)
+
+	tests := []struct {
+		name     string
+		gameID   int
+		seriesID int
+		excluded []string
+		want     []SubscriptionFilter
+	}{
+		{
+			"all channels", 0, 0, nil,
+			[]SubscriptionFilter{{Channel: "series"}, {Channel: "match"}, {Channel: "series_odds"}, {Channel: "player"}},
+		},
+		{
+			"for a game", 1, 0, nil,
+			[]SubscriptionFilter{{Channel: "series", GameID: 1}, {Channel: "match", GameID: 1}, {Channel: "series_odds", GameID: 1}, {Channel: "player", GameID: 1}},
+		},
+		{
+			"for a series without some channels", 0, 7, []string{"series*", "player"},
+			[]SubscriptionFilter{{Channel: "match", SeriesID: 7}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "spec.json")
+			*allChannelsFlag, *gameIDFlag, *seriesIDFlag, *excludeChannelFlag, *outFlag = true, test.gameID, test.seriesID, test.excluded, fileName
+			defer func() {
+				*allChannelsFlag, *gameIDFlag, *seriesIDFlag, *excludeChannelFlag, *outFlag = false, 0, 0, nil, ""
+			}()
+
+			if err := generateCommand(nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := validateCommand([]string{fileName}); err != nil {
+				t.Fatalf("the generated spec didn't validate: %v", err)
+			}
+
+			sub, err := readSubscriptionSpec(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sub.Filters, test.want) {
+				t.Errorf("got filters %+v, want %+v", sub.Filters, test.want)
+			}
+		})
+	}
+}
+
+// A spec without any filters left isn't written
+func TestGenerateEverythingExcluded(t *testing.T) {
+	discardLog(t)
+	useConfigServer(t, `{"channels": ["series"]}`)
+	fileName := filepath.Join(t.TempDir(), "spec.json")
+	*allChannelsFlag, *excludeChannelFlag, *outFlag = true, []string{"*"}, fileName
+	defer func() {
+		*allChannelsFlag, *excludeChannelFlag, *outFlag = false, nil, ""
+	}()
+
+	if err := generateCommand(nil); err == nil {
+		t.Error("a spec without filters was generated")
+	}
+}
+
+func TestValidateCommandInvalid(t *testing.T) {
+	discardLog(t)
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	invalid := filepath.Join(dir, "invalid.json")
+	for fileName, spec := range map[string]string{valid: `{"filters": [{"channel": "series"}]}`, invalid: `{"filters": [{"channel": "series"}`} {
+		if err := ioutil.WriteFile(fileName, []byte(spec), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := validateCommand([]string{valid}); err != nil {
+		t.Errorf("a valid spec failed: %v", err)
+	}
+	err := validateCommand([]string{valid, invalid, filepath.Join(dir, "missing.json")})
+	if err == nil || err.Error() != "2 of 3 subscription files are invalid" {
+		t.Errorf("got %v, want 2 of 3 invalid", err)
+	}
+}
+
+// diff compares the spec the way register would send it, so a description
+// only set on the server is no difference unless '--clear-description' is
+// given
+func TestDiffCommandDescription(t *testing.T) {
+	tests := []struct {
+		name             string
+		spec             string
+		server           string
+		clearDescription bool
+		ownerTag         string
+		wantChanges      []subscriptionChange
+	}{
+		{
+			name:   "spec without description",
+			spec:   `{"name": "dev", "filters": [{"channel": "series"}]}`,
+			server: "Owned by the data team",
+		},
+		{
+			name:             "spec without description with --clear-description",
+			spec:             `{"name": "dev", "filters": [{"channel": "series"}]}`,
+			server:           "Owned by the data team",
+			clearDescription: true,
+			wantChanges:      []subscriptionChange{{Op: "change", Field: "description", From: "Owned by the data team"}},
+		},
+		{
+			name:     "spec without description with owner tag",
+			spec:     `{"name": "dev", "filters": [{"channel": "series"}]}`,
+			server:   "Owned by the data team [owner:data]",
+			ownerTag: "data",
+		},
+		{
+			name:        "spec with another description",
+			spec:        `{"name": "dev", "description": "Dev", "filters": [{"channel": "series"}]}`,
+			server:      "Owned by the data team",
+			wantChanges: []subscriptionChange{{Op: "change", Field: "description", From: "Owned by the data team", To: "Dev"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLog(t)
+			useAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != "/subscription/dev" {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprintf(w, `{"id": "%s", "name": "dev", "description": %q, "filters": [{"channel": "series"}]}`, testSubscriptionID, test.server)
+			})
+			defer func(clear bool, owner, output, out string) {
+				*clearDescriptionFlag, *ownerTagFlag, *outputFlag, *outFlag = clear, owner, output, out
+			}(*clearDescriptionFlag, *ownerTagFlag, *outputFlag, *outFlag)
+
+			dir := t.TempDir()
+			specFile := filepath.Join(dir, "dev.json")
+			if err := ioutil.WriteFile(specFile, []byte(test.spec), 0644); err != nil {
+				t.Fatal(err)
+			}
+			*clearDescriptionFlag, *ownerTagFlag = test.clearDescription, test.ownerTag
+			*outputFlag, *outFlag = "json", filepath.Join(dir, "diff.json")
+
+			err := diffCommand([]string{specFile, "dev"})
+			wantCode := diffExitIdentical
+			if len(test.wantChanges) > 0 {
+				wantCode = diffExitDifferent
+			}
+			code := diffExitIdentical
+			if exitErr, ok := err.(*exitCodeError); ok {
+				code = exitErr.code
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != wantCode {
+				t.Errorf("diff exited with %d, want %d", code, wantCode)
+			}
+
+			b, err := ioutil.ReadFile(*outFlag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var changes []subscriptionChange
+			if err := json.Unmarshal(b, &changes); err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != len(test.wantChanges) || (len(changes) > 0 && !reflect.DeepEqual(changes, test.wantChanges)) {
+				t.Errorf("got changes %+v, want %+v", changes, test.wantChanges)
+			}
+		})
+	}
+}
+
+// '--json' is the same as '--output=json' for list
+func TestListCommandJSON(t *testing.T) {
+	const registered = `[{"id": "7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b", "name": "dev", "filters": [{"channel": "series"}]}]`
+	tests := []struct {
+		name     string
+		json     bool
+		output   string
+		wantJSON bool
+	}{
+		{"table", false, "text", false},
+		{"--json", true, "text", true},
+		{"--output=json", false, "json", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLog(t)
+			useAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != "/subscription" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(registered))
+			})
+			defer func(j bool, output, out string) { *jsonFlag, *outputFlag, *outFlag = j, output, out }(*jsonFlag, *outputFlag, *outFlag)
+			*jsonFlag, *outputFlag, *outFlag = test.json, test.output, filepath.Join(t.TempDir(), "list")
+
+			if err := listCommand(nil); err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(*outFlag)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var subs []Subscription
+			err = json.Unmarshal(b, &subs)
+			if gotJSON := err == nil; gotJSON != test.wantJSON {
+				t.Fatalf("printed JSON %v, want %v:\n%s", gotJSON, test.wantJSON, b)
+			}
+			if test.wantJSON && (len(subs) != 1 || subs[0].Name != "dev") {
+				t.Errorf("printed %+v, want the registered subscription", subs)
+			} else if !test.wantJSON && !strings.Contains(string(b), "dev") {
+				t.Errorf("the table doesn't show the subscription:\n%s", b)
+			}
+		})
+	}
+}
+
+func TestGetCommand(t *testing.T) {
+	tests := []struct {
+		name           string
+		idOrName       string
+		json           bool
+		wantSingleLine bool
+		wantCode       int
+	}{
+		{"indented", "dev", false, false, getExitFound},
+		{"--json", "dev", true, true, getExitFound},
+		{"not found", "prod", false, false, getExitNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLog(t)
+			useAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != "/subscription/dev" {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprintf(w, `{"id": "%s", "name": "dev", "filters": [{"channel": "series"}]}`, testSubscriptionID)
+			})
+			defer func(j bool, out string) { *jsonFlag, *outFlag = j, out }(*jsonFlag, *outFlag)
+			*jsonFlag, *outFlag = test.json, filepath.Join(t.TempDir(), "dev.json")
+
+			err := getCommand([]string{test.idOrName})
+			code := getExitFound
+			if exitErr, ok := err.(*exitCodeError); ok {
+				code = exitErr.code
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != test.wantCode {
+				t.Fatalf("get exited with %d, want %d", code, test.wantCode)
+			}
+			if code == getExitNotFound {
+				return
+			}
+
+			b, err := ioutil.ReadFile(*outFlag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if singleLine := strings.Count(string(b), "\n") == 1; singleLine != test.wantSingleLine {
+				t.Errorf("printed on a single line %v, want %v:\n%s", singleLine, test.wantSingleLine, b)
+			}
+			var sub Subscription
+			if err := json.Unmarshal(b, &sub); err != nil || sub.ID != testSubscriptionID {
+				t.Errorf("printed %s, want the subscription", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Warns before registering when the account has used up, or nearly used
+// up, the subscriptions the config allows. Updating an existing
+// subscription doesn't need a free one, so registering may still work.
+func warnSubscriptionLimit(config PushServiceConfig, subsJSON []byte) {
+	if config.MaxSubscriptions == 0 {
+		return
+	}
+
+	var subs []Subscription
+	if json.Unmarshal(subsJSON, &subs) != nil {
+		return
+	}
+
+	free := config.MaxSubscriptions - len(subs)
+	near := config.MaxSubscriptions / 10
+	if near < 1 {
+		near = 1
+	}
+
+	if free <= 0 {
+		log.Printf("[WARN] The account has %d subscriptions registered and the push service allows %d, registering a new one fails unless a subscription with the same name exists. Leftover subscriptions can be removed with 'prune'.\n", len(subs), config.MaxSubscriptions)
+	} else if free <= near {
+		log.Printf("[WARN] The account has %d subscriptions registered and the push service allows %d, only %d more can be registered\n", len(subs), config.MaxSubscriptions, free)
+	}
+}
+
+// Logs the fields of the config the client doesn't use, they are still
+// shown in the 'PUSH CONFIG' output
+func logUnknownConfigFields(config PushServiceConfig) {
+	if len(config.Extra) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(config.Extra))
+	for name := range config.Extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	log.Printf("[DEBUG] Push service config fields not used by the client: %s\n", strings.Join(names, ", "))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The JSON of the subscription list with n subscriptions
+func subscriptionList(n int) []byte {
+	subs := make([]Subscription, n)
+	for i := range subs {
+		subs[i].Name = fmt.Sprintf("sub-%d", i)
+	}
+	b, _ := json.Marshal(subs)
+
+	return b
+}
+
+func TestWarnSubscriptionLimit(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxSubscriptions int
+		registered       int
+		want             string // Empty for no warning
+	}{
+		{"no limit", 0, 100, ""},
+		{"far from the limit", 25, 10, ""},
+		{"just outside a tenth", 25, 22, ""},
+		{"within a tenth", 25, 23, "only 2 more can be registered"},
+		{"one left", 25, 24, "only 1 more can be registered"},
+		{"at the limit", 25, 25, "registering a new one fails"},
+		{"over the limit", 25, 30, "30 subscriptions registered and the push service allows 25"},
+		{"small limit, one left", 3, 2, "only 1 more can be registered"},
+		{"small limit, two left", 3, 1, ""},
+		{"limit of one", 1, 1, "registering a new one fails"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+			warnSubscriptionLimit(PushServiceConfig{MaxSubscriptions: test.maxSubscriptions}, subscriptionList(test.registered))
+
+			if test.want == "" {
+				if logged.Len() > 0 {
+					t.Errorf("got a warning: %s", logged)
+				}
+			} else if !strings.Contains(logged.String(), "[WARN] ") || !strings.Contains(logged.String(), test.want) {
+				t.Errorf("got %q, want a warning with %q", logged, test.want)
+			}
+		})
+	}
+}
+
+// The limit comes from a config as the push service sends it, and the
+// subscriber limit alone isn't warned about
+func TestWarnSubscriptionLimitFromConfig(t *testing.T) {
+	tests := []struct {
+		config string
+		warned bool
+	}{
+		{`{"channels": ["series"], "max_subscriptions": 2}`, true},
+		{`{"channels": ["series"], "max_subscribers": 2}`, false},
+		{`{"channels": ["series"]}`, false},
+	}
+
+	for _, test := range tests {
+		logged := captureLog(t)
+		var config PushServiceConfig
+		if err := json.Unmarshal([]byte(test.config), &config); err != nil {
+			t.Fatal(err)
+		}
+		warnSubscriptionLimit(config, subscriptionList(2))
+
+		if warned := logged.Len() > 0; warned != test.warned {
+			t.Errorf("%s: got a warning %t, want %t: %s", test.config, warned, test.warned, logged)
+		}
+	}
+}
+
+func TestWarnSubscriptionLimitInvalidList(t *testing.T) {
+	logged := captureLog(t)
+	warnSubscriptionLimit(PushServiceConfig{MaxSubscriptions: 1}, []byte(`{"error": "not a list"}`))
+
+	if logged.Len() > 0 {
+		t.Errorf("got a warning for a list that couldn't be read: %s", logged)
+	}
+}
+
+func TestLogUnknownConfigFields(t *testing.T) {
+	logged := captureLog(t)
+	var config PushServiceConfig
+	err := json.Unmarshal([]byte(`{"channels": [], "region": "eu", "beta": true, "max_subscribers": 2}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logUnknownConfigFields(config)
+	if want := "[DEBUG] Push service config fields not used by the client: beta, region\n"; !strings.HasSuffix(logged.String(), want) {
+		t.Errorf("got %q, want it to end with %q", logged, want)
+	}
+
+	logged.Reset()
+	logUnknownConfigFields(PushServiceConfig{})
+	if logged.Len() > 0 {
+		t.Errorf("got %q for a config without unknown fields", logged)
+	}
+}
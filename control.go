@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	flag "github.com/spf13/pflag"
+)
+
+var controlAddrFlag = flag.String("control-addr", "", "Serve '/health', '/recent', '/drain', '/pause' and '/resume' over HTTP on this address, e.g. 'localhost:8090'")
+
+// Response of the '/health' endpoint
+type healthResponse struct {
+	Status           string     `json:"status"` // "ok" when connected, otherwise "reconnecting"
+	SubscriptionID   string     `json:"subscription_id"`
+	MessagesReceived int        `json:"messages_received"`
+	LastMessageAt    *time.Time `json:"last_message_at"`
+	Reconnects       int        `json:"reconnects"`
+	Paused           bool       `json:"paused"` // Whether the printing of messages is paused
+	LastCloseCode    int        `json:"last_close_code,omitempty"`
+	LastCloseReason  string     `json:"last_close_reason,omitempty"` // Reason text of the last close frame from the server
+	RemainingTTL     string     `json:"subscription_remaining_ttl,omitempty"`
+}
+
+// Starts the control HTTP server in the background. Fails if the address
+// can't be listened on.
+func startControlServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("/recent", handleRecent)
+	mux.HandleFunc("/drain", handleDrain)
+	mux.HandleFunc("/pause", handlePause)
+	mux.HandleFunc("/resume", handleResume)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on control address '%s'. Error: %v", addr, err)
+	}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil {
+			log.Println("[ERROR] Control server stopped. Error: ", err)
+		}
+	}()
+	log.Printf("[INFO] Serving the control endpoint on http://%s\n", listener.Addr())
+
+	return nil
+}
+
+// Returns 200 when the websocket is connected and 503 otherwise
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	s := stats.snapshot()
+	health := healthResponse{
+		Status:           "ok",
+		SubscriptionID:   currentSubscription(),
+		MessagesReceived: s.messagesReceived,
+		Reconnects:       s.reconnects,
+		Paused:           pause.isPaused(),
+	}
+	if !s.lastMessageAt.IsZero() {
+		t := s.lastMessageAt.UTC()
+		health.LastMessageAt = &t
+	}
+	if !s.expiresAt.IsZero() {
+		health.RemainingTTL = roundDuration(time.Until(s.expiresAt), time.Second).String()
+	}
+	if s.lastCloseCode != 0 {
+		health.LastCloseCode = s.lastCloseCode
+		health.LastCloseReason = pushclient.CloseReason(s.lastCloseReason)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !s.connected {
+		health.Status = "reconnecting"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+// Returns the recent messages as NDJSON, optionally only the last 'count'
+// and only those on 'channel'
+func handleRecent(w http.ResponseWriter, r *http.Request) {
+	count := 0
+	if v := r.URL.Query().Get("count"); v != "" {
+		var err error
+		count, err = strconv.Atoi(v)
+		if err != nil || count < 0 {
+			http.Error(w, "count must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	msgs := recentMessages.recent(count, r.URL.Query().Get("channel"))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	err := writeNDJSON(w, msgs)
+	if err != nil {
+		log.Println("[ERROR] Failed to write recent messages. Error: ", err)
+	}
+}
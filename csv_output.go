@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var csvFieldsFlag = flag.StringSlice("csv-fields", nil, "The columns printed with '--output-format=csv', dot separated paths in the message, e.g. 'channel,uuid,created,payload.series.id'")
+
+// Set at startup, when true the messages are printed as rows with the
+// '--csv-fields'
+var csvOutput bool
+
+// Set once the header row has been printed
+var csvHeaderWritten bool
+
+// Formats the message as a CSV row, preceded by the header row for the
+// first message. A path that isn't in the message gives an empty cell.
+func csvLine(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if !csvHeaderWritten {
+		err := w.Write(*csvFieldsFlag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fields, _ := v.(map[string]interface{})
+	row := make([]string, len(*csvFieldsFlag))
+	for i, path := range *csvFieldsFlag {
+		value, ok := selectPayloadValue(fields, path)
+		if !ok {
+			continue
+		}
+		cell, err := csvCell(value)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = cell
+	}
+
+	err := w.Write(row)
+	if err != nil {
+		return nil, err
+	}
+	w.Flush()
+	if w.Error() != nil {
+		return nil, w.Error()
+	}
+
+	csvHeaderWritten = true
+	return buf.Bytes(), nil
+}
+
+// Strings are written as they are and null as an empty cell, objects and
+// arrays as compact JSON
+func csvCell(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(value)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
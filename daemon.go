@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Command-line options for running the client in the background
+var daemonFlag = flag.Bool("daemon", false, "Run in the background and log to a file instead of the terminal")
+var pidFileFlag = flag.String("pid-file", "", "Write the process id to this file while the client is running")
+var logFileFlag = flag.String("log-file", "", "Append the log to this file instead of stderr (default '"+defaultDaemonLogFile+"' with '--daemon')")
+
+const defaultDaemonLogFile = "push-api-client.log"
+
+// Set in the environment of the background process started by '--daemon'
+const daemonEnvVar = "PUSH_API_CLIENT_DAEMONIZED"
+
+// Returns the file the log should be written to. A background process has
+// no terminal, so in daemon mode the log goes to a file by default.
+func openLogOutput() (*os.File, error) {
+	fileName := *logFileFlag
+	if fileName == "" && *daemonFlag {
+		fileName = defaultDaemonLogFile
+	}
+	if fileName == "" {
+		return os.Stderr, nil
+	}
+
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open log file '%s'. Error: %v", fileName, err)
+	}
+
+	return f, nil
+}
+
+// Writes the pid of this process to the file. A pid file left behind by a
+// process that is no longer running is replaced, but it is an error if the
+// process in the file is still running.
+func writePidFile(fileName string) error {
+	b, err := ioutil.ReadFile(fileName)
+	if err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err == nil && pid != os.Getpid() && processAlive(pid) {
+			return fmt.Errorf("The pid file '%s' belongs to process %d which is still running", fileName, pid)
+		}
+
+		log.Printf("[WARN] Replacing stale pid file '%s'\n", fileName)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to read pid file '%s'. Error: %v", fileName, err)
+	}
+
+	err = ioutil.WriteFile(fileName, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to write pid file '%s'. Error: %v", fileName, err)
+	}
+
+	return nil
+}
+
+func removePidFile(fileName string) {
+	err := os.Remove(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("[ERROR] Failed to remove pid file. Error: ", err)
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Starts the client again as a detached process in a new session and exits.
+// Returns without doing anything when called in the detached process.
+func daemonize() error {
+	if os.Getenv(daemonEnvVar) != "" {
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnvVar+"=1")
+	cmd.Stdin = devNull
+	cmd.Stdout = devNull
+	cmd.Stderr = devNull
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("Failed to start background process. Error: %v", err)
+	}
+
+	log.Printf("[INFO] Started in the background with pid %d\n", cmd.Process.Pid)
+	os.Exit(0)
+
+	return nil
+}
+
+func processAlive(pid int) bool {
+	// Signal 0 only checks if the process exists
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Returns the pid in the pid file, or -1 if there is no pid file
+func readPidFile(t *testing.T, fileName string) int {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return -1
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatalf("the pid file holds '%s'", b)
+	}
+
+	return pid
+}
+
+func TestPidFileLifecycle(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string // Content of the pid file before the start, none if empty
+		wantErr  bool
+	}{
+		{"no pid file", "", false},
+		{"stale pid file", "999999999\n", false},
+		{"garbage in pid file", "not a pid", false},
+		{"own pid", strconv.Itoa(os.Getpid()), false},
+		{"running process", strconv.Itoa(os.Getppid()), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLog(t)
+			fileName := filepath.Join(t.TempDir(), "client.pid")
+			if test.existing != "" {
+				if err := ioutil.WriteFile(fileName, []byte(test.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := writePidFile(fileName)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("the pid file of a running process was replaced")
+				}
+				if got := readPidFile(t, fileName); got != os.Getppid() {
+					t.Errorf("the pid file holds %d, want it unchanged", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readPidFile(t, fileName); got != os.Getpid() {
+				t.Errorf("the pid file holds %d, want %d", got, os.Getpid())
+			}
+
+			removePidFile(fileName)
+			if got := readPidFile(t, fileName); got != -1 {
+				t.Errorf("the pid file still holds %d after the exit", got)
+			}
+		})
+	}
+}
+
+func TestRemoveMissingPidFile(t *testing.T) {
+	logged := captureLog(t)
+	removePidFile(filepath.Join(t.TempDir(), "client.pid"))
+	if logged.Len() > 0 {
+		t.Errorf("removing a missing pid file logged: %s", logged)
+	}
+}
+
+func TestOpenLogOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		daemon   bool
+		logFile  string
+		wantFile string // Empty for stderr
+	}{
+		{"terminal", false, "", ""},
+		{"daemon", true, "", defaultDaemonLogFile},
+		{"log file", false, "client.log", "client.log"},
+		{"daemon with log file", true, "client.log", "client.log"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chdir(dir); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(wd)
+			defer func(daemon bool, logFile string) { *daemonFlag, *logFileFlag = daemon, logFile }(*daemonFlag, *logFileFlag)
+			*daemonFlag, *logFileFlag = test.daemon, test.logFile
+
+			f, err := openLogOutput()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantFile == "" {
+				if f != os.Stderr {
+					t.Errorf("the log goes to '%s', want stderr", f.Name())
+				}
+				return
+			}
+			defer f.Close()
+
+			if f.Name() != test.wantFile {
+				t.Errorf("the log goes to '%s', want '%s'", f.Name(), test.wantFile)
+			}
+			// The log is appended to, not truncated
+			if _, err := f.WriteString("first\n"); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			f, err = openLogOutput()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteString("second\n"); err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, test.wantFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "first\nsecond\n" {
+				t.Errorf("the log file holds %q", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+)
+
+// Windows has no way to detach a process from the console. Run the client
+// under a service wrapper like NSSM instead, in which case '--daemon' only
+// makes the client log to a file since there is no console to write to.
+// Stopping the service goes through the normal shutdown handler.
+func daemonize() error {
+	return nil
+}
+
+func processAlive(pid int) bool {
+	// Finding a process fails on Windows if it doesn't exist
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+
+	return true
+}
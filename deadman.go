@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Exit code used when '--max-unhealthy-duration' or '--max-error-rate' is
+// exceeded
+const unhealthyExitCode = 4
+
+// How often the health thresholds are checked
+const healthCheckInterval = time.Second
+
+// The error rate is measured over this window
+const errorRateWindow = time.Minute
+
+// Checks the health of the client against the thresholds and shuts it down
+// with unhealthyExitCode when one of them is exceeded
+func deadMansSwitch(maxUnhealthy time.Duration, maxErrorRate float64) {
+	// Number of unmarshal errors at each check within the window, oldest first
+	var errorCounts []int
+	for now := range time.Tick(healthCheckInterval) {
+		s := stats.snapshot()
+
+		reason := unhealthyReason(s, now, maxUnhealthy)
+		if reason == "" && maxErrorRate > 0 {
+			errorCounts = append(errorCounts, s.unmarshalErrors)
+			if len(errorCounts) > int(errorRateWindow/healthCheckInterval)+1 {
+				errorCounts = errorCounts[1:]
+			}
+			// Only judge the rate once a full window has been seen
+			if len(errorCounts) > int(errorRateWindow/healthCheckInterval) {
+				errors := errorCounts[len(errorCounts)-1] - errorCounts[0]
+				if float64(errors) > maxErrorRate {
+					reason = fmt.Sprintf("%d messages failed to unmarshal in the last %s, more than '--max-error-rate' %g", errors, errorRateWindow, maxErrorRate)
+				}
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		stats.setExitReason(reason)
+		log.Printf("[ERROR] Unhealthy, shutting down: %s. Received %d messages, reconnected %d times, %d messages failed to unmarshal\n",
+			reason, s.messagesReceived, s.reconnects, s.unmarshalErrors)
+		shutdown(currentSubscription(), removeSubscriptionOnExit(), unhealthyExitCode)
+		return
+	}
+}
+
+// Describes why the client has been unhealthy for longer than maxUnhealthy,
+// or returns an empty string if it hasn't. The client is unhealthy while
+// the connection is down and while no messages arrive.
+func unhealthyReason(s statsSnapshot, now time.Time, maxUnhealthy time.Duration) string {
+	if maxUnhealthy <= 0 {
+		return ""
+	}
+
+	if !s.connected {
+		downSince := s.disconnectedAt
+		if downSince.IsZero() {
+			downSince = s.startedAt
+		}
+		if now.Sub(downSince) > maxUnhealthy {
+			return fmt.Sprintf("the connection has been down for %s since %s, longer than '--max-unhealthy-duration' %s",
+				roundDuration(now.Sub(downSince), time.Second), downSince.Format(time.RFC3339), maxUnhealthy)
+		}
+	}
+
+	quietSince := s.lastMessageAt
+	if quietSince.IsZero() {
+		quietSince = s.startedAt
+	}
+	if now.Sub(quietSince) > maxUnhealthy {
+		return fmt.Sprintf("no message has been received for %s since %s, longer than '--max-unhealthy-duration' %s",
+			roundDuration(now.Sub(quietSince), time.Second), quietSince.Format(time.RFC3339), maxUnhealthy)
+	}
+
+	return ""
+}
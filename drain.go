@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var drainTimeoutFlag = flag.Duration("drain-timeout", 30*time.Second, "Max time to wait for the sinks to write their queued messages when draining")
+
+// Set once draining has started, the client doesn't reconnect after that
+var draining struct {
+	sync.Mutex
+	started         bool
+	cancelReconnect context.CancelFunc // Of the reconnect in progress, if any
+	websocketClosed bool               // Draining closed the websocket, or there was none
+}
+
+// Closed by the read loop once the stream ended while draining and every
+// message received before that was handled
+var streamDrained = make(chan struct{})
+
+var drainOnce sync.Once
+
+func isDraining() bool {
+	draining.Lock()
+	defer draining.Unlock()
+
+	return draining.started
+}
+
+// Whether draining has already taken care of the websocket, so that
+// shutting down doesn't close it a second time
+func drainClosedWebsocket() bool {
+	draining.Lock()
+	defer draining.Unlock()
+
+	return draining.websocketClosed
+}
+
+// Returns a context for a reconnect that is cancelled when draining
+// starts, so that draining doesn't wait for a server that can't be
+// reached. The reconnect is over once the returned function is called.
+func reconnectContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	draining.Lock()
+	defer draining.Unlock()
+
+	if draining.started {
+		cancel()
+		return ctx, cancel
+	}
+	draining.cancelReconnect = cancel
+
+	return ctx, func() {
+		draining.Lock()
+		draining.cancelReconnect = nil
+		draining.Unlock()
+		cancel()
+	}
+}
+
+// Drains the client on SIGQUIT
+func setupDrainSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGQUIT)
+
+	go func() {
+		<-sigs
+		drain()
+	}()
+}
+
+// Stops the client so that it can be resumed later: closes the websocket
+// so no more messages arrive, handles the ones already received, lets the
+// sinks write what they have queued, saves the reconnect token and exits
+// with success. The subscription is never deleted.
+func drain() {
+	drainOnce.Do(func() {
+		log.Println("[INFO] Draining, no more messages will be received")
+		drainMessages(*drainTimeoutFlag)
+
+		token := currentReconnectToken().String()
+		if *reconnectTokenFileFlag != "" {
+			latestToken.Lock()
+			stored := latestToken.stored
+			latestToken.Unlock()
+
+			stored.SavedAt = time.Now().UTC()
+			err := writeReconnectTokenFile(*reconnectTokenFileFlag, stored)
+			if err != nil {
+				log.Println("[ERROR] Failed to write reconnect token file. Error: ", err)
+			} else {
+				log.Printf("[INFO] Wrote reconnect token to '%s'\n", *reconnectTokenFileFlag)
+			}
+		}
+		log.Printf("[INFO] Drained, resume with '--reconnect-token=%s'\n", token)
+
+		shutdown(currentSubscription(), false, 0)
+	})
+}
+
+// Ends the message stream and then flushes the sinks, both within timeout.
+// The stream is stopped first so that the messages the library has queued
+// are handled and reach the sinks before they are shut down; what the
+// sinks haven't written when timeout has passed is dropped.
+func drainMessages(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	draining.Lock()
+	draining.started = true
+	reconnecting := draining.cancelReconnect != nil
+	if reconnecting {
+		// There is no connection to close, the reconnect gives up and
+		// that ends the stream
+		draining.cancelReconnect()
+		draining.websocketClosed = true
+	}
+	draining.Unlock()
+
+	if !reconnecting {
+		closeWebsocketForDrain(deadline)
+	}
+
+	select {
+	case <-streamDrained:
+	case <-time.After(time.Until(deadline)):
+		log.Printf("[WARN] The received messages were not handled within %s, the rest are dropped\n", timeout)
+	}
+
+	if messageSinks != nil {
+		messageSinks.shutdown(time.Until(deadline))
+	}
+}
+
+// Closes the current websocket so that the stream ends once the server has
+// answered. A reload in progress finishes first, unless it takes until
+// deadline, so that the connection closed is the current one.
+func closeWebsocketForDrain(deadline time.Time) {
+	locked := make(chan struct{})
+	go func() {
+		reconnectMu.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(time.Until(deadline)):
+		log.Println("[WARN] The websocket could not be closed for draining in time, a reload is still in progress")
+		go func() {
+			<-locked
+			reconnectMu.Unlock()
+		}()
+		return
+	}
+	defer reconnectMu.Unlock()
+
+	stopKeepAlive()
+	err := disconnectWebsocket()
+	if err != nil {
+		log.Println("[ERROR] Failed to close websocket for draining. Error: ", err)
+	}
+	// Closed even if the close handshake failed
+	draining.Lock()
+	draining.websocketClosed = true
+	draining.Unlock()
+}
+
+// Starts draining in the background, the response is sent before the
+// client exits
+func handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST to drain", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("draining\n"))
+	go drain()
+}
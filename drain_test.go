@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Records what happened while draining, in order, from the fake server and
+// the fake sink
+type drainEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (e *drainEvents) add(event string) {
+	e.mu.Lock()
+	e.events = append(e.events, event)
+	e.mu.Unlock()
+}
+
+func (e *drainEvents) list() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]string(nil), e.events...)
+}
+
+// A sink that takes delay to deliver each message
+type slowSink struct {
+	events *drainEvents
+	delay  time.Duration
+}
+
+func (s *slowSink) Start(ctx context.Context) error { return nil }
+
+func (s *slowSink) Deliver(env sinkEnvelope) error {
+	time.Sleep(s.delay)
+	s.events.add("deliver " + string(env.Data))
+	return nil
+}
+
+func (s *slowSink) Flush() error {
+	s.events.add("flush")
+	return nil
+}
+
+func (s *slowSink) Close() error {
+	s.events.add("close")
+	return nil
+}
+
+func drainTestMessage(n int) string {
+	return fmt.Sprintf(`{"channel":"series","payload":{"n":%d}}`, n)
+}
+
+// A push service that sends an init message and then count messages, and
+// records the close frame the client sends
+func newDrainServer(t *testing.T, count int, events *drainEvents) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetCloseHandler(func(code int, text string) error {
+			events.add("close frame")
+			return conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+		})
+		init := fmt.Sprintf(`{"channel": "system", "cmd": "init", "subscriber_id": "%s", "reconnect_token": "%s", "subscription": {"id": "%s"}, "reconnected": false}`, uuid.Must(uuid.NewV4()), testToken, testSubscriptionID)
+		conn.WriteMessage(websocket.TextMessage, []byte(init))
+		for i := 1; i <= count; i++ {
+			conn.WriteMessage(websocket.TextMessage, []byte(drainTestMessage(i)))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// A push service that sends an init message and then drops the
+// connection, refusing every connection after that
+func newRefusingServer(t *testing.T, events *drainEvents) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	var mu sync.Mutex
+	connected := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		first := !connected
+		connected = true
+		mu.Unlock()
+		if !first {
+			events.add("refused")
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		init := fmt.Sprintf(`{"channel": "system", "cmd": "init", "subscriber_id": "%s", "reconnect_token": "%s", "subscription": {"id": "%s"}, "reconnected": false}`, uuid.Must(uuid.NewV4()), testToken, testSubscriptionID)
+		conn.WriteMessage(websocket.TextMessage, []byte(init))
+		conn.Close()
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// Connects to a drain server and starts the read loop with the message
+// handling held up, so that the messages queue in the library. Handling
+// goes on once the returned function is called.
+func startDrainTest(t *testing.T, addr string, count int, sink *slowSink, sinkTimeout time.Duration) (resume func()) {
+	t.Helper()
+	discardLog(t)
+	pipeStdout(t)
+
+	client, err := pushclient.New(pushclient.Config{
+		Addr: addr,
+		Auth: pushclient.NewSecretAuth("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiClient = client
+	recentMessages = newRecentBuffer(0, 0)
+	messageSinks = newSinkManager(count, sinkTimeout)
+	messageSinks.register("slow", sink)
+	if err := messageSinks.start(); err != nil {
+		t.Fatal(err)
+	}
+	streamDrained = make(chan struct{})
+	drained := streamDrained
+
+	pause.mu.Lock()
+	var once sync.Once
+	resume = func() { once.Do(pause.mu.Unlock) }
+	t.Cleanup(func() {
+		// The read loop handles the rest before the globals are reset
+		resume()
+		<-drained
+		apiClient = nil
+		recentMessages = nil
+		messageSinks = nil
+		draining.Lock()
+		draining.started = false
+		draining.websocketClosed = false
+		draining.Unlock()
+	})
+
+	if err := setupPushServiceConnection(context.Background(), uuid.Nil, testSubscriptionID.String()); err != nil {
+		t.Fatal(err)
+	}
+	go messageReadLoop(context.Background())
+
+	return resume
+}
+
+// Draining first ends the stream, then every message received before it
+// reaches the sink, in order, before the sink is flushed and closed
+func TestDrainOrdering(t *testing.T) {
+	const count = 10
+	events := &drainEvents{}
+	resume := startDrainTest(t, newDrainServer(t, count, events), count, &slowSink{events: events, delay: 10 * time.Millisecond}, time.Second)
+
+	done := make(chan struct{})
+	go func() {
+		drainMessages(5 * time.Second)
+		close(done)
+	}()
+	waitForEvent(t, events, "close frame")
+	resume()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("draining didn't finish")
+	}
+
+	want := []string{"close frame"}
+	for i := 1; i <= count; i++ {
+		want = append(want, "deliver "+drainTestMessage(i))
+	}
+	want = append(want, "flush", "close")
+	if got := events.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events\n%q\nwant\n%q", got, want)
+	}
+}
+
+// Draining gives up after the timeout even while messages are still being
+// handled and delivered, the rest are dropped
+func TestDrainTimeout(t *testing.T) {
+	events := &drainEvents{}
+	startDrainTest(t, newDrainServer(t, 3, events), 3, &slowSink{events: events, delay: time.Second}, 50*time.Millisecond)
+
+	start := time.Now()
+	drainMessages(100 * time.Millisecond)
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("draining took %s with a timeout of 100ms", took)
+	}
+
+	got := events.list()
+	if len(got) == 0 || got[0] != "close frame" {
+		t.Fatalf("the stream wasn't ended first, events: %q", got)
+	}
+	for _, event := range got {
+		if strings.HasPrefix(event, "deliver") {
+			t.Errorf("a message was delivered after the timeout, events: %q", got)
+		}
+	}
+}
+
+// Draining while the server can't be reached stops the reconnect loop
+// instead of waiting for it, and ends the stream within the timeout
+func TestDrainWhileReconnecting(t *testing.T) {
+	events := &drainEvents{}
+	resume := startDrainTest(t, newRefusingServer(t, events), 0, &slowSink{events: events}, time.Second)
+	resume()
+	waitForEvent(t, events, "refused")
+	drained := streamDrained
+
+	start := time.Now()
+	drainMessages(time.Second)
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("draining took %s with a timeout of 1s", took)
+	}
+	select {
+	case <-drained:
+	default:
+		t.Error("the stream didn't end when draining")
+	}
+}
+
+func waitForEvent(t *testing.T, events *drainEvents, event string) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		for _, e := range events.list() {
+			if e == event {
+				return
+			}
+		}
+	}
+	t.Fatalf("no %s, events: %q", event, events.list())
+}
+
+// A full drain closes the websocket once and exits with success, shutting
+// down doesn't close it a second time
+func TestDrainClosesWebsocketOnce(t *testing.T) {
+	events := &drainEvents{}
+	resume := startDrainTest(t, newDrainServer(t, 3, events), 3, &slowSink{events: events}, time.Second)
+	logged := captureLog(t)
+	exited := catchExit(t)
+	// Exiting ends the goroutine, after that draining can be started again
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		<-done
+		drainOnce = sync.Once{}
+	})
+
+	go func() {
+		defer close(done)
+		drain()
+	}()
+	waitForEvent(t, events, "close frame")
+	resume()
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Errorf("exited with code %d, want 0", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client didn't exit after draining")
+	}
+
+	closes := 0
+	for _, event := range events.list() {
+		if event == "close frame" {
+			closes++
+		}
+	}
+	if closes != 1 {
+		t.Errorf("the server got %d close frames, want 1", closes)
+	}
+	for _, unwanted := range []string{"[ERROR]", "Disconnected websocket connection"} {
+		if strings.Contains(logged.String(), unwanted) {
+			t.Errorf("the log contains '%s':\n%s", unwanted, logged)
+		}
+	}
+}
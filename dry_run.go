@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	flag "github.com/spf13/pflag"
+)
+
+var dryRunFlag = flag.Bool("dry-run", false, "Only show what would be done with the subscription, without registering or updating it or connecting")
+
+// Checks the subscription the client would use and shows what would be
+// sent to the server, using only read requests. Nothing is registered,
+// updated or deleted and the websocket isn't connected.
+func dryRun(ctx context.Context) error {
+	plan, err := planSubscription(specFromFlags(), *subscriptionIDFlag, *subscriptionNameFlag, *reconnectTokenFlag, *keepSubscription, *subscriptionTTLFlag)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Dry run: %s\n", plan)
+
+	switch plan.source {
+	case planExisting:
+		idOrName := plan.subscription
+		if plan.byName {
+			idOrName, err = resolveSubscriptionName(ctx, plan.subscription)
+			if err != nil {
+				return err
+			}
+		}
+		sub, err := apiClient.FetchSubscription(ctx, idOrName)
+		if err == pushclient.ErrSubscriptionNotFound {
+			return fmt.Errorf("Subscription '%s' not found", plan.subscription)
+		} else if err != nil {
+			return fmt.Errorf("Failed to fetch subscription '%s'. Error: %v", plan.subscription, err)
+		}
+		log.Printf("[INFO] Dry run: would connect to the existing subscription %s\n", sub.ID)
+		return nil
+	case planResume:
+		log.Println("[INFO] Dry run: the reconnect token can only be checked by connecting")
+		return nil
+	}
+
+	spec, err := plan.spec.read()
+	if err != nil {
+		return fmt.Errorf("Could not read subscription spec from file. Error: %v", err)
+	}
+
+	sub, result, changes, err := planEnsureSubscription(ctx, spec, *clearDescriptionFlag, *ownerTagFlag, *forceForeignFlag)
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case pushclient.EnsureCreated:
+		log.Println("[INFO] Dry run: would register a new subscription with:")
+	case pushclient.EnsureUpdated:
+		log.Printf("[INFO] Dry run: would update the existing subscription %s (%s) with:\n", sub.ID, summarizeChanges(changes))
+	case pushclient.EnsureUnchanged:
+		log.Printf("[INFO] Dry run: the existing subscription %s matches the spec, nothing would be sent\n", sub.ID)
+		return nil
+	}
+
+	b, err := json.MarshalIndent(sub, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(b, '\n'))
+
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+)
+
+// Keeps count and max of all added durations, and the most recent samples
+// for estimating quantiles
+type durationStats struct {
+	count   int
+	max     time.Duration
+	total   time.Duration
+	samples sampleRing
+}
+
+func (d *durationStats) add(v time.Duration) {
+	d.count++
+	d.total += v
+	if v > d.max {
+		d.max = v
+	}
+	d.samples.add(int64(v))
+}
+
+func (d *durationStats) avg() time.Duration {
+	if d.count == 0 {
+		return 0
+	}
+
+	return d.total / time.Duration(d.count)
+}
+
+// Returns the q quantile (0 <= q <= 1) of the recent samples
+func (d *durationStats) quantile(q float64) time.Duration {
+	return time.Duration(d.samples.quantile(q))
+}
+
+// Returns a copy that doesn't share the samples with d
+func (d durationStats) clone() durationStats {
+	d.samples = d.samples.clone()
+	return d
+}
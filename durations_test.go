@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+)
+
+func TestDurationStats(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		wantAvg time.Duration
+		wantMax time.Duration
+		wantP50 time.Duration
+		wantP95 time.Duration
+	}{
+		{"empty", nil, 0, 0, 0, 0},
+		{"one sample", []time.Duration{5 * time.Millisecond}, 5 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond},
+		{"unsorted", []time.Duration{3, 1, 4, 1, 5}, 2, 5, 3, 4},
+		{"one slow", append(repeatDuration(time.Millisecond, 99), time.Second), (99*time.Millisecond + time.Second) / 100, time.Second, time.Millisecond, time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var d durationStats
+			for _, v := range test.samples {
+				d.add(v)
+			}
+
+			if d.count != len(test.samples) {
+				t.Errorf("got count %d, want %d", d.count, len(test.samples))
+			}
+			if got := d.avg(); got != test.wantAvg {
+				t.Errorf("got avg %s, want %s", got, test.wantAvg)
+			}
+			if d.max != test.wantMax {
+				t.Errorf("got max %s, want %s", d.max, test.wantMax)
+			}
+			if got := d.quantile(0.5); got != test.wantP50 {
+				t.Errorf("got p50 %s, want %s", got, test.wantP50)
+			}
+			if got := d.quantile(0.95); got != test.wantP95 {
+				t.Errorf("got p95 %s, want %s", got, test.wantP95)
+			}
+		})
+	}
+}
+
+// The quantiles only cover the recent samples, count and max cover all
+func TestDurationStatsRecentSamples(t *testing.T) {
+	var d durationStats
+	d.add(time.Hour)
+	for i := 0; i < sampleRingSize; i++ {
+		d.add(time.Millisecond)
+	}
+
+	if got := d.quantile(1); got != time.Millisecond {
+		t.Errorf("got max of the recent samples %s, want 1ms", got)
+	}
+	if d.max != time.Hour || d.count != sampleRingSize+1 {
+		t.Errorf("got max %s and count %d, want 1h and %d", d.max, d.count, sampleRingSize+1)
+	}
+	if len(d.samples.values) != sampleRingSize {
+		t.Errorf("%d samples are kept, want %d", len(d.samples.values), sampleRingSize)
+	}
+}
+
+// A clone isn't changed by what is added to the original afterwards
+func TestDurationStatsClone(t *testing.T) {
+	var d durationStats
+	for i := 0; i < sampleRingSize; i++ {
+		d.add(time.Millisecond)
+	}
+	c := d.clone()
+	for i := 0; i < sampleRingSize; i++ {
+		d.add(time.Second)
+	}
+
+	if got := c.quantile(1); got != time.Millisecond {
+		t.Errorf("the clone's samples changed, got max %s", got)
+	}
+}
+
+func repeatDuration(v time.Duration, n int) []time.Duration {
+	samples := make([]time.Duration, n)
+	for i := range samples {
+		samples[i] = v
+	}
+
+	return samples
+}
+
+// Adding is done for every message, the quantiles only for the stats
+func BenchmarkDurationStats(b *testing.B) {
+	b.Run("add", func(b *testing.B) {
+		var d durationStats
+		for i := 0; i < b.N; i++ {
+			d.add(time.Duration(i % 1000))
+		}
+	})
+	b.Run("quantile", func(b *testing.B) {
+		var d durationStats
+		for i := 0; i < sampleRingSize; i++ {
+			d.add(time.Duration(i * 7919 % 1000))
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			d.quantile(0.95)
+		}
+	})
+}
+
+// Reads messages the mock server sends as fast as it can through the whole
+// read loop, with the read buffer sizes given by '--ws-read-buffer'. Reports
+// the stall and read wait the stats would show for each size.
+func BenchmarkMessageReadLoop(b *testing.B) {
+	msg := []byte(`{"channel":"series","uuid":"2b8b7e9c-5b0a-4c1e-9d6f-3e2a1b0c9d8e","created":"2026-01-02T15:04:05Z","payload":{"id":1,"title":"Series","games":[1,2,3]}}`)
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	messageOutput = ioutil.Discard
+	defer func() { messageOutput = os.Stdout }()
+	recentMessages = newRecentBuffer(0, 0)
+	defer func() { recentMessages = nil }()
+
+	for _, size := range []int{0, 1024, 16 * 1024, 256 * 1024} {
+		b.Run(fmt.Sprintf("read buffer %d", size), func(b *testing.B) {
+			defer func(size int, addr string) { *wsReadBufferFlag, *addrFlag = size, addr }(*wsReadBufferFlag, *addrFlag)
+			*wsReadBufferFlag, *addrFlag = size, newBlastServer(b, b.N, msg)
+			auth = pushclient.NewSecretAuth("secret")
+			defer func() { auth = nil }()
+
+			client, err := newAPIClientFromFlags()
+			if err != nil {
+				b.Fatal(err)
+			}
+			apiClient = client
+			defer func() { apiClient = nil }()
+			if err := setupPushServiceConnection(context.Background(), uuid.Nil, testSubscriptionID.String()); err != nil {
+				b.Fatal(err)
+			}
+			defer stopKeepAlive()
+
+			stats.mu.Lock()
+			stats.readStalls, stats.readWaits = durationStats{}, durationStats{}
+			stats.mu.Unlock()
+			lastHandledAt = time.Time{}
+			want := stats.snapshot().messagesReceived + b.N
+
+			b.ResetTimer()
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				messageReadLoop(ctx)
+				close(done)
+			}()
+			for stats.snapshot().messagesReceived < want {
+				time.Sleep(100 * time.Microsecond)
+			}
+			b.StopTimer()
+
+			cancel()
+			client.Close()
+			<-done
+
+			s := stats.snapshot()
+			b.ReportMetric(float64(s.readStalls.quantile(0.95).Nanoseconds()), "p95-stall-ns")
+			b.ReportMetric(float64(s.readStalls.max.Nanoseconds()), "max-stall-ns")
+			b.ReportMetric(float64(s.readWaits.quantile(0.95).Nanoseconds()), "p95-wait-ns")
+		})
+	}
+}
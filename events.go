@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var eventLogFlag = flag.String("event-log", "", "Append a JSON record for every connection and subscription lifecycle event to this file")
+
+// Names of the lifecycle events
+const (
+	eventConnectAttempt         = "connect_attempt"
+	eventConnected              = "connected"
+	eventInitReceived           = "init_received"
+	eventDisconnected           = "disconnected"
+	eventBackoff                = "backoff"
+	eventSubscriptionRegistered = "subscription_registered"
+	eventSubscriptionUpdated    = "subscription_updated"
+	eventSubscriptionDeleted    = "subscription_deleted"
+	eventSubscriptionMissing    = "subscription_missing"
+	eventShutdownInitiated      = "shutdown_initiated"
+	eventShutdownCompleted      = "shutdown_completed"
+)
+
+// One line in the event log. Monotonic is the time since the client
+// started, which unlike Time isn't affected by changes to the wall clock.
+type lifecycleEvent struct {
+	Event          string    `json:"event"`
+	Time           time.Time `json:"time"`
+	Monotonic      float64   `json:"monotonic_seconds"`
+	SubscriptionID string    `json:"subscription_id,omitempty"`
+	SubscriberID   string    `json:"subscriber_id,omitempty"`
+	Reconnected    *bool     `json:"reconnected,omitempty"`
+	Code           int       `json:"code,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+	Backoff        string    `json:"backoff,omitempty"`
+	ExitCode       *int      `json:"exit_code,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Appends lifecycle events to the '--event-log' file
+type eventLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Set at startup if '--event-log' is given
+var events *eventLog
+
+func openEventLog(fileName string) (*eventLog, error) {
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open event log. Error: %v", err)
+	}
+
+	return &eventLog{f: f}, nil
+}
+
+// Writes the event with the current time, does nothing if there is no
+// event log
+func emitEvent(e lifecycleEvent) {
+	if events == nil {
+		return
+	}
+
+	e.Time = time.Now().UTC()
+	e.Monotonic = time.Since(stats.startedAt).Seconds()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if events.f != nil {
+		events.f.Write(append(b, '\n'))
+	}
+}
+
+func (l *eventLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+
+	return err
+}
+
+// The 'events' command, only has the 'summarize' subcommand
+func eventsCommand(args []string) error {
+	if len(args) != 2 || args[0] != "summarize" {
+		return fmt.Errorf("Usage: events summarize <event-log-file>")
+	}
+
+	return summarizeEvents(args[1])
+}
+
+// Prints the events in the file as a timeline, followed by the number of
+// each kind of event
+func summarizeEvents(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var out strings.Builder
+	counts := make(map[string]int)
+	var first time.Time
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var e lifecycleEvent
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return fmt.Errorf("Invalid event on line %d. Error: %v", line, err)
+		}
+		if first.IsZero() {
+			first = e.Time
+		}
+		counts[e.Event]++
+
+		fmt.Fprintf(&out, "%s  +%-10s %s%s\n", e.Time.Format("2006-01-02 15:04:05.000"),
+			roundDuration(e.Time.Sub(first), time.Millisecond), e.Event, describeEvent(e))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out.WriteString("\n")
+	for _, name := range names {
+		fmt.Fprintf(&out, "%6d %s\n", counts[name], name)
+	}
+
+	return writeCommandOutput([]byte(out.String()))
+}
+
+func describeEvent(e lifecycleEvent) string {
+	var parts []string
+	if e.SubscriptionID != "" {
+		parts = append(parts, "subscription="+e.SubscriptionID)
+	}
+	if e.SubscriberID != "" {
+		parts = append(parts, "subscriber="+e.SubscriberID)
+	}
+	if e.Reconnected != nil {
+		parts = append(parts, fmt.Sprintf("reconnected=%t", *e.Reconnected))
+	}
+	if e.Code != 0 {
+		parts = append(parts, fmt.Sprintf("code=%d", e.Code))
+	}
+	if e.Reason != "" {
+		parts = append(parts, fmt.Sprintf("reason=%q", e.Reason))
+	}
+	if e.Backoff != "" {
+		parts = append(parts, "backoff="+e.Backoff)
+	}
+	if e.ExitCode != nil {
+		parts = append(parts, fmt.Sprintf("exit_code=%d", *e.ExitCode))
+	}
+	if e.Error != "" {
+		parts = append(parts, fmt.Sprintf("error=%q", e.Error))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return " " + strings.Join(parts, " ")
+}
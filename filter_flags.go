@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var filterFlag = flag.StringArray("filter", nil, "A subscription filter like 'channel=series_updates,game_id=1', instead of '--subscription-file' (repeatable, one filter each)")
+
+// Where the subscription spec comes from, the '--subscription-file' files
+// or the '--filter' options
+type specSource struct {
+	files   []string
+	filters []string
+}
+
+func specFromFlags() specSource {
+	return specSource{files: *subscriptionFilesFlag, filters: *filterFlag}
+}
+
+func (s specSource) given() bool {
+	return len(s.files) > 0 || len(s.filters) > 0
+}
+
+func (s specSource) read() (Subscription, error) {
+	if len(s.filters) > 0 {
+		return subscriptionFromFilterFlags(s.filters)
+	}
+
+	return readSubscriptionSpecs(s.files)
+}
+
+func (s specSource) String() string {
+	if len(s.filters) > 0 {
+		return "the '--filter' options"
+	}
+
+	return "'" + strings.Join(s.files, "' + '") + "'"
+}
+
+// Builds a subscription with one filter per '--filter' option, named with
+// '--subscription-name' and '--subscription-description'
+func subscriptionFromFilterFlags(filters []string) (Subscription, error) {
+	sub := Subscription{
+		Name:        *subscriptionNameFlag,
+		Description: *subscriptionDescriptionFlag,
+		Filters:     []SubscriptionFilter{},
+	}
+	for i, s := range filters {
+		f, err := parseFilterFlag(s)
+		if err != nil {
+			return Subscription{}, fmt.Errorf("Invalid '--filter' %d '%s': %v", i+1, s, err)
+		}
+		sub.Filters = append(sub.Filters, f)
+	}
+
+	return sub, validateSubscription(sub)
+}
+
+// Parses comma separated 'key=value' pairs into a filter, errors give the
+// column of the key or value that is wrong, counted from 1
+func parseFilterFlag(s string) (SubscriptionFilter, error) {
+	var f SubscriptionFilter
+	ids := map[string]*int{
+		"game_id":       &f.GameID,
+		"series_id":     &f.SeriesID,
+		"match_id":      &f.MatchID,
+		"tournament_id": &f.TournamentID,
+		"team_id":       &f.TeamID,
+		"player_id":     &f.PlayerID,
+	}
+
+	seen := make(map[string]bool)
+	column := 1
+	for _, pair := range strings.Split(s, ",") {
+		// Spaces around keys and values are allowed, the columns point
+		// past them
+		keyColumn := column + leadingSpaces(pair)
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return f, fmt.Errorf("expected 'key=value' at column %d", keyColumn)
+		}
+		key, value := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		valueColumn := column + i + 1 + leadingSpaces(pair[i+1:])
+
+		if seen[key] {
+			return f, fmt.Errorf("'%s' given twice at column %d", key, keyColumn)
+		}
+		seen[key] = true
+
+		if value == "" {
+			return f, fmt.Errorf("no value for '%s' at column %d", key, valueColumn)
+		}
+
+		if key == "channel" {
+			f.Channel = value
+		} else if id, ok := ids[key]; ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return f, fmt.Errorf("'%s' must be a positive number, not '%s', at column %d", key, value, valueColumn)
+			}
+			*id = n
+		} else {
+			return f, fmt.Errorf("unknown key '%s' at column %d, must be one of channel, game_id, series_id, match_id, tournament_id, team_id or player_id", key, keyColumn)
+		}
+
+		column += len(pair) + 1
+	}
+
+	return f, nil
+}
+
+func leadingSpaces(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " \t"))
+}
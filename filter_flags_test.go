@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFilterFlag(t *testing.T) {
+	tests := []struct {
+		filter  string
+		want    SubscriptionFilter
+		wantErr string // Start of the error, no error if empty
+	}{
+		{filter: "channel=series,game_id=1", want: SubscriptionFilter{Channel: "series", GameID: 1}},
+		{filter: " channel = series , game_id = 1 ", want: SubscriptionFilter{Channel: "series", GameID: 1}},
+		{filter: "series_id=5,match_id=6,tournament_id=7,team_id=8,player_id=9", want: SubscriptionFilter{SeriesID: 5, MatchID: 6, TournamentID: 7, TeamID: 8, PlayerID: 9}},
+		{filter: "gam_id=1", wantErr: "unknown key 'gam_id' at column 1,"},
+		{filter: "channel=series,gam_id=1", wantErr: "unknown key 'gam_id' at column 16,"},
+		{filter: "channel=series,  gam_id=1", wantErr: "unknown key 'gam_id' at column 18,"},
+		{filter: "=1", wantErr: "unknown key '' at column 1,"},
+		{filter: "channel=series,game_id=lol", wantErr: "'game_id' must be a positive number, not 'lol', at column 24"},
+		{filter: "channel=series,game_id= lol", wantErr: "'game_id' must be a positive number, not 'lol', at column 25"},
+		{filter: "game_id=1.5", wantErr: "'game_id' must be a positive number, not '1.5', at column 9"},
+		{filter: "game_id=-1", wantErr: "'game_id' must be a positive number, not '-1', at column 9"},
+		{filter: "series_id=0", wantErr: "'series_id' must be a positive number, not '0', at column 11"},
+		{filter: "channel=series,series", wantErr: "expected 'key=value' at column 16"},
+		{filter: "channel=series,", wantErr: "expected 'key=value' at column 16"},
+		{filter: "", wantErr: "expected 'key=value' at column 1"},
+		{filter: "channel=series,channel=match", wantErr: "'channel' given twice at column 16"},
+		{filter: "game_id=1,channel=", wantErr: "no value for 'channel' at column 19"},
+	}
+
+	for _, test := range tests {
+		got, err := parseFilterFlag(test.filter)
+		if test.wantErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("'%s' gave the error %v, want '%s'", test.filter, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("'%s' gave the error %v", test.filter, err)
+		} else if got != test.want {
+			t.Errorf("'%s' was parsed into %+v, want %+v", test.filter, got, test.want)
+		}
+	}
+}
+
+// The error says which of the '--filter' options is wrong
+func TestSubscriptionFromFilterFlags(t *testing.T) {
+	defer func(name string) { *subscriptionNameFlag = name }(*subscriptionNameFlag)
+	*subscriptionNameFlag = "dev"
+
+	sub, err := subscriptionFromFilterFlags([]string{"channel=series,game_id=1", "channel=match"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Name != "dev" || len(sub.Filters) != 2 || sub.Filters[1].Channel != "match" {
+		t.Errorf("got the subscription %+v", sub)
+	}
+
+	_, err = subscriptionFromFilterFlags([]string{"channel=series", "channel=match,match_id=x"})
+	want := "Invalid '--filter' 2 'channel=match,match_id=x': 'match_id' must be a positive number, not 'x', at column 24"
+	if err == nil || err.Error() != want {
+		t.Errorf("got the error %v, want '%s'", err, want)
+	}
+}
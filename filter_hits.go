@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Where the IDs a filter can match on are found in the payload of a
+// message, as dot separated paths. The first path that exists is used.
+type filterIDPaths struct {
+	GameID       []string
+	SeriesID     []string
+	MatchID      []string
+	TournamentID []string
+	TeamID       []string
+	PlayerID     []string
+}
+
+// The paths used for channels without an entry in channelIDPaths
+var defaultIDPaths = filterIDPaths{
+	GameID:       []string{"game.id", "game_id", "series.game.id", "match.game.id"},
+	SeriesID:     []string{"series.id", "series_id", "match.series.id", "match.series_id"},
+	MatchID:      []string{"match.id", "match_id"},
+	TournamentID: []string{"tournament.id", "tournament_id", "series.tournament.id", "series.tournament_id", "match.series.tournament_id"},
+	TeamID:       []string{"team.id", "team_id"},
+	PlayerID:     []string{"player.id", "player_id"},
+}
+
+// Channels whose payloads keep the IDs somewhere other than the defaults.
+// The series and match channels send the series or match itself as the
+// payload, so its ID is the top level "id" and the related IDs are nested
+// objects of it. The wrapped paths of the defaults are kept as fallbacks.
+var channelIDPaths = map[string]filterIDPaths{
+	"series":         seriesIDPaths,
+	"series_updates": seriesIDPaths,
+	"match":          matchIDPaths,
+	"match_updates":  matchIDPaths,
+	"player":         playerIDPaths,
+}
+
+var seriesIDPaths = filterIDPaths{
+	GameID:       []string{"game.id", "game_id", "series.game.id"},
+	SeriesID:     []string{"id", "series.id", "series_id"},
+	MatchID:      []string{"match.id", "match_id"},
+	TournamentID: []string{"tournament.id", "tournament_id", "series.tournament.id", "series.tournament_id"},
+	TeamID:       []string{"team.id", "team_id"},
+	PlayerID:     []string{"player.id", "player_id"},
+}
+
+var matchIDPaths = filterIDPaths{
+	GameID:       []string{"game.id", "game_id", "match.game.id", "series.game.id"},
+	SeriesID:     []string{"series.id", "series_id", "match.series.id", "match.series_id"},
+	MatchID:      []string{"id", "match.id", "match_id"},
+	TournamentID: []string{"tournament.id", "tournament_id", "series.tournament.id", "match.series.tournament_id"},
+	TeamID:       []string{"team.id", "team_id"},
+	PlayerID:     []string{"player.id", "player_id"},
+}
+
+var playerIDPaths = filterIDPaths{
+	GameID:       []string{"game.id", "game_id"},
+	SeriesID:     []string{"series.id", "series_id"},
+	MatchID:      []string{"match.id", "match_id"},
+	TournamentID: []string{"tournament.id", "tournament_id"},
+	TeamID:       []string{"team.id", "team_id"},
+	PlayerID:     []string{"id", "player.id", "player_id"},
+}
+
+// Splits a dot separated path of object keys
+func payloadPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// Looks up a value in the payload by a dot separated path of object keys
+func selectPayloadValue(fields map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = fields
+	for _, key := range payloadPath(path) {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// Returns the first of the paths holding an integer ID
+func selectPayloadID(fields map[string]interface{}, paths []string) (int, bool) {
+	for _, path := range paths {
+		v, ok := selectPayloadValue(fields, path)
+		if !ok {
+			continue
+		}
+
+		switch id := v.(type) {
+		case float64:
+			return int(id), true
+		case json.Number:
+			n, err := id.Int64()
+			if err == nil {
+				return int(n), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Whether the message satisfies the filter. An ID the filter asks for
+// must be found in the payload, a message without it is not attributed to
+// the filter.
+func filterMatches(f SubscriptionFilter, msg PushMessage) bool {
+	if f.Channel != "" && f.Channel != msg.Channel {
+		return false
+	}
+
+	paths, ok := channelIDPaths[msg.Channel]
+	if !ok {
+		paths = defaultIDPaths
+	}
+
+	checks := []struct {
+		want  int
+		paths []string
+	}{
+		{f.GameID, paths.GameID},
+		{f.SeriesID, paths.SeriesID},
+		{f.MatchID, paths.MatchID},
+		{f.TournamentID, paths.TournamentID},
+		{f.TeamID, paths.TeamID},
+		{f.PlayerID, paths.PlayerID},
+	}
+	for _, c := range checks {
+		if c.want == 0 {
+			continue
+		}
+		id, ok := selectPayloadID(msg.Payload.Fields, c.paths)
+		if !ok || id != c.want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A short description of the filter used in the summary
+func describeFilter(f SubscriptionFilter) string {
+	parts := []string{"channel=" + f.Channel}
+	if f.GameID != 0 {
+		parts = append(parts, fmt.Sprintf("game_id=%d", f.GameID))
+	}
+	if f.SeriesID != 0 {
+		parts = append(parts, fmt.Sprintf("series_id=%d", f.SeriesID))
+	}
+	if f.MatchID != 0 {
+		parts = append(parts, fmt.Sprintf("match_id=%d", f.MatchID))
+	}
+	if f.TournamentID != 0 {
+		parts = append(parts, fmt.Sprintf("tournament_id=%d", f.TournamentID))
+	}
+	if f.TeamID != 0 {
+		parts = append(parts, fmt.Sprintf("team_id=%d", f.TeamID))
+	}
+	if f.PlayerID != 0 {
+		parts = append(parts, fmt.Sprintf("player_id=%d", f.PlayerID))
+	}
+
+	return strings.Join(parts, " ")
+}
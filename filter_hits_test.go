@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func testPushMessage(t *testing.T, channel string, payload string) PushMessage {
+	t.Helper()
+
+	var msg PushMessage
+	raw := `{"channel": "` + channel + `", "payload": ` + payload + `}`
+	err := json.Unmarshal([]byte(raw), &msg)
+	if err != nil {
+		t.Fatalf("Can't decode test message %s: %v", raw, err)
+	}
+
+	return msg
+}
+
+func TestFilterMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  SubscriptionFilter
+		channel string
+		payload string
+		want    bool
+	}{
+		{"channel only", SubscriptionFilter{Channel: "series"}, "series", `{}`, true},
+		{"other channel", SubscriptionFilter{Channel: "series"}, "match", `{}`, false},
+		{"no channel", SubscriptionFilter{GameID: 1}, "match", `{"game": {"id": 1}}`, true},
+
+		{"series top level id", SubscriptionFilter{Channel: "series", SeriesID: 5}, "series", `{"id": 5}`, true},
+		{"series top level id differs", SubscriptionFilter{Channel: "series", SeriesID: 5}, "series", `{"id": 6}`, false},
+		{"series wrapped id", SubscriptionFilter{Channel: "series", SeriesID: 5}, "series", `{"series": {"id": 5}}`, true},
+		{"series_updates top level id", SubscriptionFilter{Channel: "series_updates", SeriesID: 5}, "series_updates", `{"id": 5}`, true},
+		{"series game and tournament", SubscriptionFilter{Channel: "series", GameID: 1, TournamentID: 7}, "series", `{"id": 5, "game": {"id": 1}, "tournament": {"id": 7}}`, true},
+		{"series missing id", SubscriptionFilter{Channel: "series", SeriesID: 5}, "series", `{"title": "x"}`, false},
+
+		{"match top level id", SubscriptionFilter{Channel: "match", MatchID: 9}, "match", `{"id": 9}`, true},
+		{"match series id", SubscriptionFilter{Channel: "match", SeriesID: 5}, "match", `{"id": 9, "series_id": 5}`, true},
+		{"match top level id isn't the series", SubscriptionFilter{Channel: "match", SeriesID: 9}, "match", `{"id": 9}`, false},
+		{"match_updates top level id", SubscriptionFilter{Channel: "match_updates", MatchID: 9}, "match_updates", `{"id": 9}`, true},
+
+		{"player top level id", SubscriptionFilter{Channel: "player", PlayerID: 3}, "player", `{"id": 3, "team": {"id": 4}}`, true},
+		{"player team", SubscriptionFilter{Channel: "player", TeamID: 4}, "player", `{"id": 3, "team": {"id": 4}}`, true},
+
+		{"default paths", SubscriptionFilter{Channel: "series_odds", SeriesID: 5, MatchID: 9}, "series_odds", `{"series": {"id": 5}, "match_id": 9}`, true},
+		{"default paths ignore the top level id", SubscriptionFilter{Channel: "series_odds", SeriesID: 5}, "series_odds", `{"id": 5}`, false},
+		{"double encoded payload", SubscriptionFilter{Channel: "series", SeriesID: 5}, "series", `"{\"id\": 5}"`, true},
+		{"id is not a number", SubscriptionFilter{Channel: "series", SeriesID: 5}, "series", `{"id": "5"}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := testPushMessage(t, tt.channel, tt.payload)
+			got := filterMatches(tt.filter, msg)
+			if got != tt.want {
+				t.Errorf("filterMatches(%s) on %s %s = %v, want %v", describeFilter(tt.filter), tt.channel, tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalIDFilterChannelPaths(t *testing.T) {
+	discardLog(t)
+
+	f, err := newLocalIDFilter(nil, []int{5}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		channel string
+		payload string
+		want    bool
+	}{
+		{"series", `{"id": 5}`, true},
+		{"series", `{"id": 6}`, false},
+		{"match", `{"id": 6, "series": {"id": 5}}`, true},
+		{"match", `{"id": 5, "series": {"id": 6}}`, false},
+		{"series_odds", `{"id": 6}`, true}, // No series ID found, passed through
+	}
+
+	for _, tt := range tests {
+		msg := testPushMessage(t, tt.channel, tt.payload)
+		got := f.shows(msg)
+		if got != tt.want {
+			t.Errorf("shows() on %s %s = %v, want %v", tt.channel, tt.payload, got, tt.want)
+		}
+	}
+}
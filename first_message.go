@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Exit code used when '--first-message-required' is given and no message
+// arrived in time
+const firstMessageExitCode = 3
+
+// Waits for the first push message after connecting, received is the
+// number of messages received before. If none has arrived when the timeout
+// elapses a report that helps finding out why is logged, and the client
+// exits if '--first-message-required' is given.
+func firstMessageDeadline(timeout time.Duration, config PushServiceConfig, received int) {
+	time.Sleep(timeout)
+
+	s := stats.snapshot()
+	if s.messagesReceived > received {
+		return
+	}
+
+	printFirstMessageReport(timeout, config, s.filters)
+
+	if *firstMessageRequiredFlag {
+		log.Println("[ERROR] No message received before '--first-message-timeout' and '--first-message-required' is given, shutting down")
+		shutdown(currentSubscription(), removeSubscriptionOnExit(), firstMessageExitCode)
+	}
+}
+
+func printFirstMessageReport(timeout time.Duration, config PushServiceConfig, filters []SubscriptionFilter) {
+	channels := make(map[string]bool)
+	for _, c := range config.Channels {
+		channels[c.Name] = true
+	}
+
+	log.Printf("[WARN] No message received within %s of connecting to subscription %s\n", timeout, currentSubscription())
+	if len(filters) == 0 {
+		log.Println("[WARN] The subscription has no filters")
+	}
+	for i, f := range filters {
+		exists := "exists"
+		if f.Channel == "" {
+			exists = "matches all channels"
+		} else if !channels[f.Channel] {
+			exists = "is NOT in the push service config"
+		}
+		log.Printf("[WARN] Filter %d (%s): channel %s\n", i+1, describeFilter(f), exists)
+	}
+	log.Println("[WARN] Common causes: the series or match in a filter has already ended or not started yet, " +
+		"the IDs belong to another game or a game your account doesn't have access to, or the channel name is misspelled")
+}
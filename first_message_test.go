@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFirstMessageDeadline(t *testing.T) {
+	tests := []struct {
+		name       string
+		messageIn  time.Duration // When a message arrives, never if zero
+		required   bool
+		wantReport bool
+		wantExit   bool
+	}{
+		{"timeout", 0, false, true, false},
+		{"timeout with message required", 0, true, true, true},
+		{"message just in time", 70 * time.Millisecond, false, false, false},
+		{"message just in time with message required", 70 * time.Millisecond, true, false, false},
+	}
+
+	filters := []SubscriptionFilter{{Channel: "series", SeriesID: 5}, {Channel: "seris"}, {GameID: 1}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+			useConfigServer(t, `{"channels": ["series", {"name": "match"}]}`)
+			config, err := apiClient.FetchPushServiceConfig(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			stats.setFilters(filters)
+			t.Cleanup(func() { stats.setFilters(nil) })
+			exited := catchExit(t)
+			defer func(required bool) { *firstMessageRequiredFlag = required }(*firstMessageRequiredFlag)
+			*firstMessageRequiredFlag = test.required
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				firstMessageDeadline(100*time.Millisecond, config, stats.snapshot().messagesReceived)
+			}()
+			if test.messageIn > 0 {
+				time.Sleep(test.messageIn)
+				stats.messageReceived()
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the deadline didn't return")
+			}
+
+			report := logged.String()
+			if got := strings.Contains(report, "No message received within 100ms"); got != test.wantReport {
+				t.Fatalf("report logged is %v, want %v:\n%s", got, test.wantReport, report)
+			}
+			if test.wantReport {
+				for _, want := range []string{
+					"Filter 1 (channel=series series_id=5): channel exists",
+					"Filter 2 (channel=seris): channel is NOT in the push service config",
+					"Filter 3 (channel= game_id=1): channel matches all channels",
+					"Common causes",
+				} {
+					if !strings.Contains(report, want) {
+						t.Errorf("the report doesn't contain '%s':\n%s", want, report)
+					}
+				}
+			}
+
+			select {
+			case code := <-exited:
+				if !test.wantExit {
+					t.Errorf("exited with code %d", code)
+				} else if code != firstMessageExitCode {
+					t.Errorf("exited with code %d, want %d", code, firstMessageExitCode)
+				}
+			default:
+				if test.wantExit {
+					t.Error("the client didn't exit")
+				}
+			}
+		})
+	}
+}
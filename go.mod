@@ -3,10 +3,11 @@ module github.com/AbiosGaming/push-api-client
 go 1.15
 
 require (
-	github.com/fatih/color v1.10.0 // indirect
+	github.com/fatih/color v1.10.0
 	github.com/gofrs/uuid v3.3.0+incompatible
 	github.com/gorilla/websocket v1.4.2
 	github.com/hokaccha/go-prettyjson v0.0.0-20190818114111-108c894c2c0e
+	github.com/mattn/go-isatty v0.0.12
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/sys v0.0.0-20201214210602-f9fddec55a1e // indirect
 )
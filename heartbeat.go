@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Payload of the synthetic heartbeat messages written to the route files.
+// They let consumers of the files tell an idle stream from a dead client.
+type heartbeatPayload struct {
+	ClientVersion          string `json:"client_version"`
+	SubscriptionID         string `json:"subscription_id"`
+	ConnectionState        string `json:"connection_state"`
+	MessagesSinceHeartbeat int    `json:"messages_since_last_heartbeat"`
+}
+
+// Periodically sends a heartbeat message to the sinks, which the route
+// sink writes to every route file. The loop
+// keeps running while the websocket is reconnecting so that the degraded
+// connection state is visible to the consumers.
+func sinkHeartbeatLoop(interval time.Duration, channel string) {
+	lastCount := stats.snapshot().messagesReceived
+	for {
+		time.Sleep(interval)
+
+		s := stats.snapshot()
+		state := "connected"
+		if !s.connected {
+			state = "reconnecting"
+		}
+
+		id, _ := uuid.NewV4()
+		msg, err := json.Marshal(struct {
+			Message
+			Created time.Time        `json:"created"`
+			Payload heartbeatPayload `json:"payload"`
+		}{
+			Message: Message{Channel: channel, UUID: id},
+			Created: time.Now().UTC(),
+			Payload: heartbeatPayload{
+				ClientVersion:          version,
+				SubscriptionID:         currentSubscription(),
+				ConnectionState:        state,
+				MessagesSinceHeartbeat: s.messagesReceived - lastCount,
+			},
+		})
+		if err != nil {
+			log.Println("[ERROR] Failed to marshal heartbeat message. Error: ", err)
+			continue
+		}
+		lastCount = s.messagesReceived
+
+		messageSinks.deliver(sinkEnvelope{Channel: channel, Data: msg, Broadcast: true})
+	}
+}
@@ -1,264 +1,104 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
 	uuid "github.com/gofrs/uuid"
 	"github.com/gorilla/websocket"
+	flag "github.com/spf13/pflag"
 )
 
-type WebsocketSetupHTTPError struct {
-	error
-	HttpStatus int
+var headerFlag = flag.StringArray("header", nil, "Add a header to the API requests and the websocket handshake, on the form 'Name: value' (repeatable)")
+
+// Headers that are set by the client itself, for authentication or by the
+// websocket handshake, and can't be given with '--header'
+var reservedHeaders = map[string]bool{
+	"Abios-Secret":             true,
+	"Authorization":            true,
+	"Connection":               true,
+	"Host":                     true,
+	"Upgrade":                  true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Protocol":   true,
+	"Sec-Websocket-Version":    true,
 }
 
-var httpClient = &http.Client{
-	Timeout: time.Second * 10,
-}
-
-func connectToWebsocket(wsURL string, reconnectToken uuid.UUID, subscriptionIDOrName string) (*websocket.Conn, error) {
-	URL := wsURL + "?subscription_id=" + subscriptionIDOrName
-	if reconnectToken != uuid.Nil {
-		URL = URL + "&reconnect_token=" + reconnectToken.String()
-	}
-
-	// Add the auth credentials to the ws connection setup request
-	var h http.Header
-	if *clientV3SecretFlag != "" {
-		// Set the Abios secret as a header in the request
-		h = make(http.Header)
-		h["Abios-Secret"] = []string{*clientV3SecretFlag}
-	} else {
-		accessToken, err := requestAccessToken(*clientV2IDFlag, *clientV2SecretFlag)
-		if err != nil {
-			return nil, fmt.Errorf("Access token request failed. Error: %v", err)
+// Parses the '--header' options. The values are never logged since they
+// often are credentials.
+func parseHeaderFlags(flags []string) (http.Header, error) {
+	h := http.Header{}
+	for n, f := range flags {
+		i := strings.Index(f, ":")
+		name := ""
+		if i > 0 {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(f[:i]))
 		}
-
-		URL = URL + "&access_token=" + accessToken
-	}
-
-	var dialer *websocket.Dialer
-	conn, resp, err := dialer.Dial(URL, h)
-	if err != nil {
-		if resp != nil {
-			return nil, WebsocketSetupHTTPError{HttpStatus: resp.StatusCode, error: err}
-		} else {
-			return nil, err
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("The '--header' option number %d must be on the form 'Name: value'", n+1)
 		}
-	}
 
-	return conn, nil
-}
-
-func fetchPushServiceConfig() ([]byte, error) {
-	req, err := createAuthenticatedRequest(http.MethodGet, "/config", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
-	}
-
-	return respBody, err
-}
-
-func fetchSubscriptions() ([]byte, error) {
-	req, err := createAuthenticatedRequest(http.MethodGet, "/subscription", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
-	}
-
-	return respBody, err
-}
-
-func registerSubscription(sub Subscription) (uuid.UUID, bool, error) {
-	j, _ := json.Marshal(sub)
-
-	req, err := createAuthenticatedRequest(http.MethodPost, "/subscription", bytes.NewBuffer(j))
-	if err != nil {
-		return uuid.Nil, false, err
-	}
-
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return uuid.Nil, false, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return uuid.Nil, false, err
-	}
-
-	// The subscription POST endpoint response have 2 normal status codes:
-	//  * Unprocessable Entity (422)
-	//    This is returned by the server if client tries to register a subscription
-	//    with a name that has already been registered on the server.
-	//  * OK (200)
-	//    If the registration was successful
-	if resp.StatusCode == http.StatusUnprocessableEntity {
-		var existingID uuid.UUID
-
-		// If we get HTTP response code 422 the server has also set
-		// the 'Location' header with the ID of the existing subscription
-		if resp.Header.Get("Location") != "" {
-			existingID, err = uuid.FromString(resp.Header.Get("Location"))
-			if err != nil {
-				// Location header didn't contain a valid UUID
-				return uuid.Nil, true, err
-			}
-
-			return existingID, true, nil
+		if reservedHeaders[name] {
+			return nil, fmt.Errorf("The header '%s' is set by the client itself and can't be given with '--header'", name)
 		}
-
-		// Server didn't set a valid ID in the 'Location' header, this should never happen
-		return uuid.Nil, true, fmt.Errorf("Subscription with name already exists, but failed to retrieve ID")
-	} else if resp.StatusCode != http.StatusOK {
-		return uuid.Nil, false, fmt.Errorf("Unexpected status code: %d. Response message: %s", resp.StatusCode, string(respBody))
-	}
-
-	var s struct {
-		ID uuid.UUID `json:"id"`
+		h.Add(name, strings.TrimSpace(f[i+1:]))
 	}
-	err = json.Unmarshal(respBody, &s)
 
-	return s.ID, false, err
+	return h, nil
 }
 
-func updateSubscription(sub Subscription) (uuid.UUID, bool, error) {
-	endpoint := "/subscription/" + sub.ID.String()
-	j, err := json.Marshal(sub)
-	if err != nil {
-		return uuid.Nil, false, err
-	}
-
-	req, err := createAuthenticatedRequest(http.MethodPut, endpoint, bytes.NewBuffer(j))
-	if err != nil {
-		return uuid.Nil, false, err
-	}
-
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return uuid.Nil, false, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return uuid.Nil, false, err
-	}
-
-	if resp.StatusCode == http.StatusUnprocessableEntity {
-		return uuid.Nil, true, nil
-	} else if resp.StatusCode != http.StatusOK {
-		return uuid.Nil, false, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
-	}
-
-	var s struct {
-		ID uuid.UUID `json:"id"`
-	}
-	err = json.Unmarshal(respBody, &s)
-
-	return s.ID, false, err
+var httpClient = &http.Client{
+	Timeout: time.Second * 10,
 }
 
-func deleteSubscription(subscriptionIDOrName string) error {
-	endpoint := "/subscription/" + subscriptionIDOrName
-	req, err := createAuthenticatedRequest(http.MethodDelete, endpoint, nil)
-	if err != nil {
-		return err
-	}
-
-	req.Header.Add("Content-Type", "application/json")
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
-	}
+// Sends the requests of the library through doAPIRequest, so that they are
+// paced by '--api-rate'
+type pacedHTTPClient struct{}
 
-	return nil
+func (pacedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return doAPIRequest(req)
 }
 
-func createAuthenticatedRequest(method string, endpoint string, body io.Reader) (*http.Request, error) {
-	url := buildHTTPURLFromWSURL(*addrFlag)
-	url = url + endpoint
+// Set at startup from the connection and credential options
+var apiClient *pushclient.Client
+
+func newAPIClientFromFlags() (*pushclient.Client, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.ReadBufferSize = *wsReadBufferFlag
+	dialer.WriteBufferSize = *wsWriteBufferFlag
+	dialer.Subprotocols = *wsSubprotocolFlag
 
-	req, err := http.NewRequest(method, url, body)
+	headers, err := parseHeaderFlags(*headerFlag)
 	if err != nil {
 		return nil, err
 	}
-
-	if *clientV3SecretFlag != "" {
-		err = addV3Auth(req)
-	} else {
-		// Assume v2 auth token is used
-		err = addV2Auth(req)
+	for name := range headers {
+		log.Printf("[DEBUG] Adding header '%s' (value redacted) to all requests\n", name)
 	}
 
-	return req, err
+	return pushclient.New(pushclient.Config{
+		Addr:       *addrFlag,
+		APIAddr:    *apiAddrFlag,
+		Auth:       auth,
+		HTTPClient: pacedHTTPClient{},
+		Dialer:     &dialer,
+		Headers:    headers,
+		Logf:       log.Printf,
+	})
 }
 
-// Adds the required Atlas v3 API secret to the request
-func addV3Auth(req *http.Request) error {
-	// Set the Abios secret as a header in the request
-	req.Header["Abios-Secret"] = []string{*clientV3SecretFlag}
-
-	return nil
-}
-
-// Adds the required v2 API secret to the request
-func addV2Auth(req *http.Request) error {
-	// Create an access token from the client id and secret given on the command line
-	accessToken, err := requestAccessToken(*clientV2IDFlag, *clientV2SecretFlag)
+func connectToWebsocket(ctx context.Context, reconnectToken uuid.UUID, subscriptionIDOrName string) (*websocket.Conn, error) {
+	conn, err := apiClient.Dial(ctx, reconnectToken, subscriptionIDOrName)
 	if err != nil {
-		return fmt.Errorf("Access token request failed. Error: %v", err)
+		return nil, err
 	}
+	logSubprotocol(conn.Subprotocol())
 
-	q := req.URL.Query()
-	q.Add("access_token", accessToken) // Add the access_token to the list of parameters
-	req.URL.RawQuery = q.Encode()      // Encode and assign back to the original query
-
-	return nil
+	return conn, nil
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var onlyGameIDFlag = flag.IntSlice("only-game-id", nil, "Only print and deliver the messages about this game ID (repeatable)")
+var onlySeriesIDFlag = flag.IntSlice("only-series-id", nil, "Only print and deliver the messages about this series ID (repeatable)")
+var onlyMatchIDFlag = flag.IntSlice("only-match-id", nil, "Only print and deliver the messages about this match ID (repeatable)")
+
+// Set at startup if any of the '--only-*-id' options is given
+var idFilter *localIDFilter
+
+// Decides which received messages are printed and delivered to the sinks
+// by the IDs in their payload. The IDs are looked up with the same paths
+// as when messages are attributed to the subscription filters.
+type localIDFilter struct {
+	gameIDs   map[int]bool
+	seriesIDs map[int]bool
+	matchIDs  map[int]bool
+}
+
+func newLocalIDFilter(gameIDs []int, seriesIDs []int, matchIDs []int) (*localIDFilter, error) {
+	options := []struct {
+		name string
+		ids  []int
+	}{
+		{"--only-game-id", gameIDs},
+		{"--only-series-id", seriesIDs},
+		{"--only-match-id", matchIDs},
+	}
+	for _, o := range options {
+		for _, id := range o.ids {
+			if id <= 0 {
+				return nil, fmt.Errorf("The option '%s' must be a positive ID, got %d", o.name, id)
+			}
+		}
+	}
+
+	if len(gameIDs) == 0 && len(seriesIDs) == 0 && len(matchIDs) == 0 {
+		return nil, nil
+	}
+
+	return &localIDFilter{
+		gameIDs:   idSet(gameIDs),
+		seriesIDs: idSet(seriesIDs),
+		matchIDs:  idSet(matchIDs),
+	}, nil
+}
+
+func idSet(ids []int) map[int]bool {
+	set := make(map[int]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return set
+}
+
+// Reports if the message is printed and delivered. A message is left out
+// if one of its IDs is found and isn't among the wanted ones. A message
+// where an ID can't be found is passed through, since the payload differs
+// between channels.
+func (f *localIDFilter) shows(msg PushMessage) bool {
+	paths, ok := channelIDPaths[msg.Channel]
+	if !ok {
+		paths = defaultIDPaths
+	}
+
+	checks := []struct {
+		name  string
+		want  map[int]bool
+		paths []string
+	}{
+		{"game", f.gameIDs, paths.GameID},
+		{"series", f.seriesIDs, paths.SeriesID},
+		{"match", f.matchIDs, paths.MatchID},
+	}
+	var missing []string
+	for _, c := range checks {
+		if len(c.want) == 0 {
+			continue
+		}
+		id, ok := selectPayloadID(msg.Payload.Fields, c.paths)
+		if !ok {
+			missing = append(missing, c.name)
+			continue
+		}
+		if !c.want[id] {
+			return false
+		}
+	}
+
+	if len(missing) > 0 {
+		stats.messageIDsMissing()
+		log.Printf("[DEBUG] Passing through message on channel '%s' without a %s ID to filter on. UUID: %s\n", msg.Channel, strings.Join(missing, " or "), msg.UUID)
+	}
+
+	return true
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	flag "github.com/spf13/pflag"
+)
+
+var pingIntervalFlag = flag.Duration("ping-interval", 0, "How often to ping the server, between 5s and 5m (default the server's hint, or 30s)")
+var pongTimeoutFlag = flag.Duration("pong-timeout", 0, "Warn if the server doesn't answer a ping within this time (default the server's hint, or 10s)")
+var maxFailedPingsFlag = flag.Int("max-failed-pings", 2, "Reconnect after this many pings in a row could not be sent, 0 never does")
+
+// Bounds of '--ping-interval'
+const minPingInterval = 5 * time.Second
+const maxPingInterval = 5 * time.Minute
+
+// Accepts '--keepalive-interval', the old name of '--ping-interval'
+func normalizeFlagName(f *flag.FlagSet, name string) flag.NormalizedName {
+	if name == "keepalive-interval" {
+		name = "ping-interval"
+	}
+
+	return flag.NormalizedName(name)
+}
+
+// The write deadline of a ping is a tenth of the interval, but at least
+// this long
+const minPingWriteTimeout = time.Second
+
+func pingWriteTimeout(interval time.Duration) time.Duration {
+	timeout := interval / 10
+	if timeout < minPingWriteTimeout {
+		timeout = minPingWriteTimeout
+	}
+
+	return timeout
+}
+
+// Used when neither the server nor the command line give a value
+const defaultKeepaliveInterval = 30 * time.Second
+const defaultPongTimeout = 10 * time.Second
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// The keepalive timings in use, set once the client has connected
+type keepaliveSettings struct {
+	mu                sync.Mutex
+	interval          time.Duration
+	pongTimeout       time.Duration
+	reconnectTokenTTL time.Duration // Zero if unknown
+	lastPongAt        time.Time
+}
+
+var keepalive = keepaliveSettings{interval: defaultKeepaliveInterval, pongTimeout: defaultPongTimeout}
+
+// Chooses the timings from the flags, the server hints and the defaults,
+// in that order, and logs them. Overrides that are riskier than what the
+// server asks for are warned about.
+func (k *keepaliveSettings) configure(hints ServerHints) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.interval = defaultKeepaliveInterval
+	if hints.PingInterval > 0 {
+		k.interval = seconds(hints.PingInterval)
+	}
+	if *pingIntervalFlag > 0 {
+		if hints.PingInterval > 0 && *pingIntervalFlag > seconds(hints.PingInterval) {
+			log.Printf("[WARN] '--ping-interval' %s is longer than the %s the server asks for, the server may drop the connection as idle\n", *pingIntervalFlag, seconds(hints.PingInterval))
+		}
+		k.interval = *pingIntervalFlag
+	}
+
+	k.pongTimeout = defaultPongTimeout
+	if hints.PongTimeout > 0 {
+		k.pongTimeout = seconds(hints.PongTimeout)
+	}
+	if *pongTimeoutFlag > 0 {
+		if hints.PongTimeout > 0 && *pongTimeoutFlag < seconds(hints.PongTimeout) {
+			log.Printf("[WARN] '--pong-timeout' %s is shorter than the %s the server may need to answer, expect false warnings\n", *pongTimeoutFlag, seconds(hints.PongTimeout))
+		}
+		k.pongTimeout = *pongTimeoutFlag
+	}
+
+	k.reconnectTokenTTL = seconds(hints.ReconnectTokenTTL)
+
+	ttl := "unknown"
+	if k.reconnectTokenTTL > 0 {
+		ttl = k.reconnectTokenTTL.String()
+	}
+	log.Printf("[INFO] Keepalive interval %s, pong timeout %s, reconnect token valid for %s\n", k.interval, k.pongTimeout, ttl)
+}
+
+// How long the connection may stay silent, pongs included, before it is
+// considered dead: half a ping interval more than the interval, but at
+// least long enough for the pong to arrive
+func (k *keepaliveSettings) readTimeout() time.Duration {
+	interval, pongTimeout := k.timings()
+	timeout := interval * 3 / 2
+	if timeout < interval+pongTimeout {
+		timeout = interval + pongTimeout
+	}
+
+	return timeout
+}
+
+func (k *keepaliveSettings) timings() (time.Duration, time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.interval, k.pongTimeout
+}
+
+func (k *keepaliveSettings) pongReceived() {
+	k.mu.Lock()
+	k.lastPongAt = time.Now()
+	k.mu.Unlock()
+}
+
+func (k *keepaliveSettings) pongSince(t time.Time) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return !k.lastPongAt.Before(t)
+}
+
+// Waits between two pings, replaced by the tests
+var keepAliveWait = sleepContext
+
+// The keep-alive loop of the current connection
+type keepAliveRun struct {
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the loop has returned
+}
+
+var keepAliveMu sync.Mutex
+var currentKeepAlive *keepAliveRun
+
+// Starts pinging conn, after stopping the loop of the previous connection
+func startKeepAlive(ctx context.Context, conn *websocket.Conn) {
+	stopKeepAlive()
+
+	ctx, cancel := context.WithCancel(ctx)
+	run := &keepAliveRun{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(run.done)
+		keepAliveLoop(ctx, conn)
+	}()
+
+	keepAliveMu.Lock()
+	currentKeepAlive = run
+	keepAliveMu.Unlock()
+}
+
+// Stops the keep-alive loop, if one is running, and waits until it has
+// returned so that no ping is written after this
+func stopKeepAlive() {
+	keepAliveMu.Lock()
+	run := currentKeepAlive
+	currentKeepAlive = nil
+	keepAliveMu.Unlock()
+
+	if run != nil {
+		run.cancel()
+		<-run.done
+	}
+}
+
+// The client needs to have a keep-alive loop for two reasons:
+//  1. Since the client does not send any other messages to the server
+//     it will never get a notification if the websocket is closed.
+//     The client only detects a closed websocket when it tries to write
+//     data to it. Sending a ping message ensures this happens.
+//  2. The server (or other network devices on the route to the server)
+//     will close connections that are idle for too long.
+//
+// Pings conn until ctx is done. When '--max-failed-pings' pings in a row
+// couldn't be sent the connection is closed, the read then fails and the
+// read loop reconnects as for any other lost connection.
+func keepAliveLoop(ctx context.Context, conn *websocket.Conn) {
+	failed := 0
+	for {
+		interval, pongTimeout := keepalive.timings()
+		if keepAliveWait(ctx, interval) != nil {
+			return
+		}
+		sentAt := time.Now()
+		err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(pingWriteTimeout(interval)))
+		if err == websocket.ErrCloseSent {
+			// The connection is being closed, pings are no longer needed
+			return
+		} else if err != nil {
+			failed++
+			log.Println("[ERROR] Failed to send Ping message. Error: ", err)
+			if *maxFailedPingsFlag > 0 && failed >= *maxFailedPingsFlag {
+				log.Printf("[WARN] %d pings in a row could not be sent, closing the connection to reconnect\n", failed)
+				conn.Close()
+				return
+			}
+			continue
+		}
+		failed = 0
+
+		time.AfterFunc(pongTimeout, func() {
+			if ctx.Err() == nil && !keepalive.pongSince(sentAt) {
+				log.Printf("[WARN] No pong received from the server within %s of sending a ping, the connection may be dead\n", pongTimeout)
+			}
+		})
+	}
+}
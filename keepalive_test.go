@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Makes the keep-alive loops wait without sleeping and send n pings, the
+// returned channel gets each wait
+func recordWaits(t *testing.T, n int) <-chan time.Duration {
+	waits := make(chan time.Duration, n+1)
+	calls := 0
+	keepAliveWait = func(ctx context.Context, d time.Duration) error {
+		waits <- d
+		calls++
+		if calls > n {
+			<-ctx.Done()
+		}
+		return ctx.Err()
+	}
+	t.Cleanup(func() { keepAliveWait = sleepContext })
+
+	return waits
+}
+
+// Configures the keepalive with the flag and hints until the test ends
+func configureKeepalive(t *testing.T, pingInterval time.Duration, hints ServerHints) {
+	*pingIntervalFlag = pingInterval
+	keepalive.configure(hints)
+	t.Cleanup(func() {
+		*pingIntervalFlag = 0
+		keepalive = keepaliveSettings{interval: defaultKeepaliveInterval, pongTimeout: defaultPongTimeout}
+	})
+}
+
+// Makes the keep-alive loops ping every 100µs until the test ends
+func pingQuickly(t *testing.T) {
+	keepAliveWait = func(ctx context.Context, d time.Duration) error {
+		return sleepContext(ctx, 100*time.Microsecond)
+	}
+	t.Cleanup(func() { keepAliveWait = sleepContext })
+}
+
+// Run with -race: the connection is replaced and the old one closed while
+// its keep-alive loop is pinging, pings must only ever go to the current
+// connection once startKeepAlive has returned
+func TestKeepAliveDuringReconnect(t *testing.T) {
+	discardLog(t)
+	pingQuickly(t)
+	server := newPingServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn := server.dial(t)
+	startKeepAlive(ctx, conn)
+	for i := 0; i < 20; i++ {
+		time.Sleep(2 * time.Millisecond)
+
+		next := server.dial(t)
+		// A ping may be in flight while the server or the network closes
+		// the old connection, before the reconnect
+		go conn.Close()
+		startKeepAlive(ctx, next)
+		conn = next
+	}
+
+	before := server.pingCount()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.pingCount() == before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if server.pingCount() == before {
+		t.Errorf("the current connection isn't pinged")
+	}
+
+	stopKeepAlive()
+	keepAliveMu.Lock()
+	defer keepAliveMu.Unlock()
+	if currentKeepAlive != nil {
+		t.Errorf("a keep-alive loop is still running after stopKeepAlive")
+	}
+}
+
+func TestKeepAliveCadence(t *testing.T) {
+	tests := []struct {
+		name         string
+		pingInterval time.Duration
+		hints        ServerHints
+		want         time.Duration
+	}{
+		{"default", 0, ServerHints{}, defaultKeepaliveInterval},
+		{"server hint", 0, ServerHints{PingInterval: 20}, 20 * time.Second},
+		{"'--ping-interval'", 7 * time.Second, ServerHints{}, 7 * time.Second},
+		{"'--ping-interval' over the server hint", 15 * time.Second, ServerHints{PingInterval: 20}, 15 * time.Second},
+		{"longest", maxPingInterval, ServerHints{}, maxPingInterval},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLog(t)
+			const pings = 5
+			waits := recordWaits(t, pings)
+			configureKeepalive(t, test.pingInterval, test.hints)
+			server := newPingServer(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			startKeepAlive(ctx, server.dial(t))
+			defer stopKeepAlive()
+
+			// The wait before every ping, and the one after the last
+			for i := 0; i <= pings; i++ {
+				if d := <-waits; d != test.want {
+					t.Errorf("wait %d was %s, want %s", i+1, d, test.want)
+				}
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for server.pingCount() < pings && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if got := server.pingCount(); got != pings {
+				t.Errorf("the server got %d pings, want %d", got, pings)
+			}
+		})
+	}
+}
+
+// A new interval, e.g. from the hints of a reconnect, is used from the
+// next ping on
+func TestKeepAliveCadenceChange(t *testing.T) {
+	discardLog(t)
+	// Unbuffered, the loop waits for the test before every ping
+	waits := make(chan time.Duration)
+	keepAliveWait = func(ctx context.Context, d time.Duration) error {
+		select {
+		case waits <- d:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	t.Cleanup(func() { keepAliveWait = sleepContext })
+	configureKeepalive(t, 10*time.Second, ServerHints{})
+	server := newPingServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	startKeepAlive(ctx, server.dial(t))
+	defer stopKeepAlive()
+
+	if d := <-waits; d != 10*time.Second {
+		t.Errorf("the first wait was %s, want 10s", d)
+	}
+	keepalive.mu.Lock()
+	keepalive.interval = 20 * time.Second
+	keepalive.mu.Unlock()
+	// The loop may have read the interval before the change
+	if d := <-waits; d != 10*time.Second && d != 20*time.Second {
+		t.Errorf("the second wait was %s, want 10s or 20s", d)
+	}
+	if d := <-waits; d != 20*time.Second {
+		t.Errorf("the third wait was %s, want 20s", d)
+	}
+}
+
+func TestPingWriteTimeout(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     time.Duration
+	}{
+		{minPingInterval, minPingWriteTimeout},
+		{10 * time.Second, time.Second},
+		{30 * time.Second, 3 * time.Second},
+		{maxPingInterval, 30 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := pingWriteTimeout(test.interval); got != test.want {
+			t.Errorf("pingWriteTimeout(%s): got %s, want %s", test.interval, got, test.want)
+		}
+	}
+}
+
+// Number of goroutines inside keepAliveLoop
+func keepAliveGoroutines() int {
+	buf := make([]byte, 1<<20)
+	n := runtime.Stack(buf, true)
+
+	return strings.Count(string(buf[:n]), ".keepAliveLoop(")
+}
+
+// Waits until n keep-alive goroutines are running, and returns how many
+// there are if that doesn't happen
+func waitForKeepAliveGoroutines(n int) int {
+	deadline := time.Now().Add(5 * time.Second)
+	for keepAliveGoroutines() != n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	return keepAliveGoroutines()
+}
+
+func TestKeepAliveOneLoopAfterReconnects(t *testing.T) {
+	discardLog(t)
+	server := newPingServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	startKeepAlive(ctx, server.dial(t))
+	for i := 1; i <= 3; i++ {
+		startKeepAlive(ctx, server.dial(t))
+		if got := waitForKeepAliveGoroutines(1); got != 1 {
+			t.Fatalf("after reconnect %d %d keep-alive goroutines are running, want 1", i, got)
+		}
+	}
+
+	keepAliveMu.Lock()
+	run := currentKeepAlive
+	keepAliveMu.Unlock()
+	select {
+	case <-run.done:
+		t.Fatal("the loop of the current connection has returned")
+	default:
+	}
+
+	stopKeepAlive()
+	if got := keepAliveGoroutines(); got != 0 {
+		t.Errorf("%d keep-alive goroutines are running after stopKeepAlive, want 0", got)
+	}
+}
+
+// The loop ends with the connection's context, e.g. on shutdown, without
+// stopKeepAlive
+func TestKeepAliveStopsWithContext(t *testing.T) {
+	discardLog(t)
+	server := newPingServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	startKeepAlive(ctx, server.dial(t))
+	keepAliveMu.Lock()
+	run := currentKeepAlive
+	keepAliveMu.Unlock()
+	cancel()
+
+	select {
+	case <-run.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the loop didn't return when the context was cancelled")
+	}
+	stopKeepAlive()
+}
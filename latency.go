@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var latencyWarnFlag = flag.Duration("latency-warn", 0, "Mark messages that arrive more than this long after their 'created' time with the tag 'MSG [SLOW]' and count them, e.g. '2s'")
+var latencyErrorFlag = flag.Duration("latency-error", 0, "Mark messages that arrive more than this long after their 'created' time like '--latency-warn', and exit with code 7 on shutdown if there were any")
+
+const latencyErrorExitCode = 7
+
+// Added to the tag of messages that exceed '--latency-warn' or
+// '--latency-error'
+const slowTag = "[SLOW]"
+
+func validateLatencyFlags() error {
+	if *latencyWarnFlag < 0 {
+		return fmt.Errorf("The option '--latency-warn' can't be negative")
+	}
+	if *latencyErrorFlag < 0 {
+		return fmt.Errorf("The option '--latency-error' can't be negative")
+	}
+
+	return nil
+}
+
+func latencyChecked() bool {
+	return *latencyWarnFlag > 0 || *latencyErrorFlag > 0
+}
+
+// Counts the message if it arrived later than the thresholds allow, and
+// reports if it should be marked as slow. Messages without a 'created'
+// time are counted separately and never marked.
+func checkLatency(msg PushMessage, received time.Time) bool {
+	if !latencyChecked() {
+		return false
+	}
+	if msg.Created.IsZero() {
+		stats.createdTimeMissing()
+		return false
+	}
+
+	latency := received.Sub(msg.Created)
+	warn := *latencyWarnFlag > 0 && latency > *latencyWarnFlag
+	fail := *latencyErrorFlag > 0 && latency > *latencyErrorFlag
+	if warn || fail {
+		stats.latencyViolated(fail)
+	}
+
+	return warn || fail
+}
+
+// Returns the code to exit with, latencyErrorExitCode instead of a clean
+// exit if a message exceeded '--latency-error'
+func latencyExitCode(exitCode int, s statsSnapshot) int {
+	if exitCode != 0 || *latencyErrorFlag == 0 || s.latencyErrors == 0 {
+		return exitCode
+	}
+
+	log.Printf("[ERROR] %d messages arrived more than %s after they were created, exiting with code %d\n", s.latencyErrors, *latencyErrorFlag, latencyErrorExitCode)
+	return latencyErrorExitCode
+}
+
+// Logs the latency counters
+func logLatency(tag string, s statsSnapshot) {
+	if !latencyChecked() {
+		return
+	}
+
+	log.Printf("%s %d latency violations, %d above '--latency-error', %d messages had no 'created' time\n", tag, s.latencyViolations, s.latencyErrors, s.latencyUnknown)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+)
+
+// Messages later than '--latency-error' make the client exit with code 7 on
+// shutdown, unless it already exits with an error of its own
+func TestLatencyErrorExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		latency  time.Duration
+		exitCode int
+		want     int
+	}{
+		{name: "only above --latency-warn", latency: 50 * time.Millisecond, want: 0},
+		{name: "above --latency-error", latency: 2 * time.Second, want: latencyErrorExitCode},
+		{name: "earlier error", latency: 2 * time.Second, exitCode: 1, want: 1},
+	}
+
+	client, err := pushclient.New(pushclient.Config{
+		Addr: "ws://127.0.0.1:1",
+		Auth: pushclient.NewSecretAuth("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiClient = client
+	defer func() { apiClient = nil }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+			exited := catchExit(t)
+			defer func(warn, fail time.Duration) { *latencyWarnFlag, *latencyErrorFlag = warn, fail }(*latencyWarnFlag, *latencyErrorFlag)
+			*latencyWarnFlag, *latencyErrorFlag = 10*time.Millisecond, time.Second
+
+			// Only the messages of this test may count
+			stats.mu.Lock()
+			violations, errors := stats.latencyViolations, stats.latencyErrors
+			stats.latencyViolations, stats.latencyErrors = 0, 0
+			stats.mu.Unlock()
+			defer func() {
+				stats.mu.Lock()
+				stats.latencyViolations, stats.latencyErrors = violations, errors
+				stats.mu.Unlock()
+			}()
+
+			created := time.Now()
+			for i := 0; i < 3; i++ {
+				if !checkLatency(PushMessage{Created: created}, created.Add(test.latency)) {
+					t.Fatalf("message %d with latency %s wasn't marked as slow", i+1, test.latency)
+				}
+			}
+
+			go shutdown("", false, test.exitCode)
+			select {
+			case code := <-exited:
+				if code != test.want {
+					t.Errorf("exited with code %d, want %d", code, test.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the client didn't exit")
+			}
+
+			reported := strings.Contains(logged.String(), "3 messages arrived more than 1s after they were created, exiting with code 7")
+			if reported != (test.want == latencyErrorExitCode) {
+				t.Errorf("the latency errors were reported %v, want %v:\n%s", reported, test.want == latencyErrorExitCode, logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Severity of a log line. The level is derived from the '[LEVEL]' tag that
+// every log line starts with, e.g. '[INFO]' or '[ERROR]'. Lines with other
+// tags, like the message and startup dumps, are treated as info.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+	levelAlways // Never filtered, used for the exit summary
+)
+
+// Lines with a lower level than this are discarded
+var minLogLevel = levelInfo
+
+func parseLogLevel(s string) (logLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return levelDebug, nil
+	case "info":
+		return levelInfo, nil
+	case "warn":
+		return levelWarn, nil
+	case "error":
+		return levelError, nil
+	}
+
+	return levelInfo, fmt.Errorf("Unknown log level '%s', must be one of debug, info, warn or error", s)
+}
+
+// Installed as the output of the standard logger, drops all lines below
+// minLogLevel before they are written to out.
+type levelFilterWriter struct {
+	out io.Writer
+}
+
+func (w levelFilterWriter) Write(p []byte) (int, error) {
+	if lineLevel(p) < minLogLevel {
+		return len(p), nil
+	}
+
+	return w.out.Write(p)
+}
+
+func lineLevel(line []byte) logLevel {
+	// The tag is the first bracketed word after the timestamp
+	start := bytes.IndexByte(line, '[')
+	end := bytes.IndexByte(line, ']')
+	if start < 0 || end < start {
+		return levelInfo
+	}
+
+	switch string(line[start+1 : end]) {
+	case "DEBUG":
+		return levelDebug
+	case "WARN":
+		return levelWarn
+	case "ERROR":
+		return levelError
+	case "SUMMARY":
+		return levelAlways
+	}
+
+	return levelInfo
+}
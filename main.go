@@ -1,170 +1,661 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"sync"
+	"os"
 	"time"
 
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/websocket"
 	flag "github.com/spf13/pflag"
 )
 
 // Command-line options
-var subscriptionFileFlag = flag.String("subscription-file", "", "A file containing the subscription specification")
+var subscriptionFilesFlag = flag.StringArray("subscription-file", nil, "A file containing the subscription specification, repeat to merge the filters of several files into one subscription")
 var subscriptionIDFlag = flag.String("subscription-id", "", "The id of a subscription that has been registered previously")
 var keepSubscription = flag.Bool("keep-subscription", false, "Do not delete subscription on exit if a new one was created")
 var reconnectTokenFlag = flag.String("reconnect-token", "", "Use token to reconnect to previous subscriber state")
-var noPPFlag = flag.Bool("no-pp", false, "Disable colorized pretty-print of JSON data")
-var addrFlag = flag.String("addr", "wss://ws.abiosgaming.com/v0", "ws server address")
+var noPPFlag = flag.Bool("no-pp", false, "Disable colorized pretty-print of JSON data, the same as '--color=never'")
+var addrFlag = flag.String("addr", pushclient.DefaultAddr, "ws server address")
+var apiAddrFlag = flag.String("api-addr", "", "Base URL of the HTTP API, e.g. 'https://gateway.example.com/v0' (default derived from '--addr')")
+var singleLineFlag = flag.Bool("single-line", false, "Print each message as one line of compact JSON (default when output is not a terminal)")
+var logLevelFlag = flag.String("log-level", "info", "Minimum level of log lines to print: debug, info, warn or error")
+var silentFlag = flag.Bool("silent", false, "Only print warnings, errors and the exit summary, no messages or startup dumps")
+var noSummaryFlag = flag.Bool("no-summary", false, "Do not print the summary when the client exits")
+var routeFlag = flag.StringArray("route", nil, "Append messages from a channel to a file, on the form 'channel=path' (repeatable)")
+var routeDefaultFlag = flag.String("route-default", "", "Append messages from channels without a '--route' to this file")
+var routeMaxSizeFlag = flag.Int64("route-max-size", 100*1024*1024, "Rotate a route file when it grows past this many bytes, 0 never rotates")
+var routeMaxFilesFlag = flag.Int("route-max-files", 10, "Number of rotated files to keep of each route file, 0 keeps all")
+var sinkHeartbeatFlag = flag.Duration("sink-heartbeat", 0, "Write a heartbeat message to all route files with this interval, e.g. '30s'")
+var sinkHeartbeatChannelFlag = flag.String("sink-heartbeat-channel", "client-heartbeat", "Channel name of the heartbeat messages")
+var verifySignatureKeyFileFlag = flag.String("verify-signature-key-file", "", "Verify the HMAC signature of every message with the key in this file")
+var signatureRequiredFlag = flag.Bool("signature-required", false, "Treat messages without a signature as errors, needs '--verify-signature-key-file'")
+var statusFileFlag = flag.String("status-file", "", "Keep a JSON document with the connection state in this file, rewritten every few seconds")
+var statsIntervalFlag = flag.Duration("stats-interval", 0, "Log a line with the message and reconnect counters with this interval, e.g. '1m'")
+var statsVerboseFlag = flag.Bool("stats-verbose", false, "Include the message sizes of every channel in the '--stats-interval' lines")
+var gapWarnThresholdFlag = flag.Duration("gap-warn-threshold", 0, "Warn in the stats about channels with a longer gap than this between two messages, e.g. '10m'")
+var groupByPrefixFlag = flag.String("group-by-prefix", "-", "Group the existing subscriptions by the part of the name before this separator")
+var filterNameFlag = flag.String("filter-name", "", "Only show existing subscriptions with names containing this text")
+var wsReadBufferFlag = flag.Int("ws-read-buffer", 0, "Size in bytes of the websocket read buffer, 0 uses the library default")
+var wsWriteBufferFlag = flag.Int("ws-write-buffer", 0, "Size in bytes of the websocket write buffer, 0 uses the library default")
+var apiRateFlag = flag.String("api-rate", "", "Max rate of calls to the HTTP API, e.g. '5/s' or '100/m' (default unlimited)")
+var clearDescriptionFlag = flag.Bool("clear-description", false, "Remove the description of an existing subscription if the spec file has none")
+var quarantineFileFlag = flag.String("quarantine-file", "", "Append messages that fail validation to this file")
+var quarantineMaxSizeFlag = flag.Int64("quarantine-max-size", 10*1024*1024, "Rotate the quarantine file when it grows past this many bytes")
+var firstMessageTimeoutFlag = flag.Duration("first-message-timeout", 0, "Print a diagnostic report if no message has arrived this long after connecting, e.g. '5m'")
+var firstMessageRequiredFlag = flag.Bool("first-message-required", false, "Exit with an error instead of waiting when '--first-message-timeout' elapses")
+var watchSubscriptionFlag = flag.Duration("watch-subscription", 0, "Check for changes to the subscription made by others with this interval, e.g. '1m'")
+var followSubscriptionChangesFlag = flag.Bool("follow-subscription-changes", false, "Reconnect when '--watch-subscription' finds a change, so the new filters take effect")
+var maxUnhealthyDurationFlag = flag.Duration("max-unhealthy-duration", 0, "Exit with code 4 when the connection has been down or no message has arrived for this long, e.g. '15m'")
+var maxErrorRateFlag = flag.Float64("max-error-rate", 0, "Exit with code 4 when more than this many messages per minute fail to unmarshal")
+var maxReconnectAttemptsFlag = flag.Int("max-reconnect-attempts", 0, "Exit with code 6 after this many failed connection attempts in a row, 0 retries forever")
+var subscriptionTTLFlag = flag.Duration("subscription-ttl", 0, "Delete the subscription and exit when this much time has passed, e.g. '2h'")
 
 // Command-line options only useful with v3 authentication
 var clientV3SecretFlag = flag.String("secret", "", "The v3 authentication secret")
+var secretFileFlag = flag.String("secret-file", "", "Read the v3 authentication secret from this file, again on every request and reconnect")
 
 // Command-line options only useful with v2 authentication
-var apiURLFlag = flag.String("access-token-url", "https://api.abiosgaming.com/v2", "URL for the access token creation")
+var apiURLFlag = flag.String("access-token-url", pushclient.DefaultAccessTokenURL, "URL for the access token creation")
 var clientV2IDFlag = flag.String("client-id", "", "Use client id for creating the access token, only for v2 authentication")
 var clientV2SecretFlag = flag.String("client-secret", "", "The v2 authentication secret")
+var clientSecretFileFlag = flag.String("client-secret-file", "", "Read the v2 authentication secret from this file, again for every new access token")
+var v2AuthStyleFlag = flag.String("v2-auth-style", "query", "How the v2 access token is sent: 'query' parameter or 'header' (Authorization: Bearer)")
 
-var subscriptionIDOrName string
-var currReconnectToken uuid.UUID
-var conn *websocket.Conn
+// Set at build time with '-ldflags "-X main.version=..."'
+var version = "dev"
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
+	flag.CommandLine.SetNormalizeFunc(normalizeFlagName)
 	flag.Parse()
 
-	err := validateFlags()
+	if *listThemesFlag {
+		err := validateColorFlag()
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+		setupColor()
+		err = printThemes()
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+		return
+	}
+
+	// Only reads the spec files, no credentials are needed
+	if *printRenderedFlag {
+		err := printRenderedSpecs(*subscriptionFilesFlag)
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+		return
+	}
+
+	// Commands do a single task and exit without connecting a subscriber,
+	// they check the flags they need themselves
+	var err error
+	if flag.NArg() > 0 {
+		err = validateCommonFlags()
+	} else {
+		err = validateFlags()
+	}
+	if err != nil {
+		log.Fatalln("[ERROR] ", err)
+	}
+	currentPhases.mark("flags")
+
+	if *daemonFlag {
+		err = daemonize()
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+	}
+
+	logOutput, err := openLogOutput()
+	if err != nil {
+		log.Fatalln("[ERROR] ", err)
+	}
+
+	// When the output is captured by e.g. journald or a log shipper every
+	// line becomes a separate event, so each message must fit on one line.
+	// '--color=always' asks for the pretty output, e.g. for 'less -R'.
+	singleLineOutput = *singleLineFlag || (!isTerminal(logOutput) && *colorFlag != "always")
+	setupMessageOutput(logOutput)
+
+	ndjsonOutput = *outputFormatFlag == "ndjson" && flag.NArg() == 0
+	csvOutput = *outputFormatFlag == "csv" && flag.NArg() == 0
+	setupColor()
+	setupTheme()
+
+	// The startup dumps go through the logger, so silencing info level
+	// lines also silences them. The messages are not log lines, only
+	// '--silent' hides them, whatever the log level or '-qq'.
+	minLogLevel, _ = parseLogLevel(*logLevelFlag)
+	if *silentFlag {
+		minLogLevel = levelWarn
+	}
+	messagesSilenced = *silentFlag
+	if *quietFlag > 1 && minLogLevel < levelWarn {
+		minLogLevel = levelWarn
+	}
+	log.SetOutput(levelFilterWriter{out: logOutput})
+
+	if *apiRateFlag != "" {
+		interval, _ := parseAPIRate(*apiRateFlag)
+		pacer = newAPIPacer(interval)
+	}
+
+	auth = newAuthProviderFromFlags()
+	apiClient, err = newAPIClientFromFlags()
+	if err != nil {
+		log.Fatalln("[ERROR] ", err)
+	}
+
+	if flag.NArg() > 0 {
+		err = runCommand(flag.Arg(0), flag.Args()[1:])
+		if exitErr, ok := err.(*exitCodeError); ok {
+			if exitErr.err != nil {
+				log.Println("[ERROR] ", exitErr.err)
+			}
+			os.Exit(exitErr.code)
+		} else if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+		return
+	}
+
+	if *dryRunFlag {
+		err = dryRun(context.Background())
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+		return
+	}
+
+	if *eventLogFlag != "" {
+		events, err = openEventLog(*eventLogFlag)
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+	}
+
+	if *pidFileFlag != "" {
+		err = writePidFile(*pidFileFlag)
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+	}
+
+	channelFilter, _ = newLocalChannelFilter(*onlyChannelFlag, *excludeChannelFlag)
+	idFilter, _ = newLocalIDFilter(*onlyGameIDFlag, *onlySeriesIDFlag, *onlyMatchIDFlag)
+
+	messageSinks, err = buildSinks()
 	if err != nil {
 		log.Fatalln("[ERROR] ", err)
 	}
+	if messageSinks != nil {
+		err = messageSinks.start()
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+	}
+
+	pause.max = *pauseBufferFlag
+	recentMessages = newRecentBuffer(*recentMessagesFlag, *recentMaxBytesFlag)
+	if *dumpRecentFlag != "" {
+		err = setupDumpRecentSignal(*dumpRecentFlag)
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+	}
+
+	if *controlAddrFlag != "" {
+		err = startControlServer(*controlAddrFlag)
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+	}
+
+	ordering = newOrderingTracker(*orderingKeysFlag)
+	if *suppressReplayDuplicatesFlag {
+		replayDuplicates = newReplayFilter(*replayWindowFlag, *seenUUIDsFlag)
+	}
 
-	// Let's look at our configuration. The information is only printed
-	// to the terminal for debugging purposes, not used in any other way
-	config, err := fetchPushServiceConfig()
+	if *expectedSeriesFileFlag != "" {
+		ids, err := readExpectedSeries(*expectedSeriesFileFlag)
+		if err != nil {
+			log.Fatalln("[ERROR] Failed to read the expected series. Error: ", err)
+		}
+		expectedSeries = newSeriesCoverage(ids, *seriesSilenceThresholdFlag)
+	}
+
+	if *transformFileFlag != "" {
+		messageTransforms, err = readTransforms(*transformFileFlag)
+		if err != nil {
+			log.Fatalln("[ERROR] Failed to read the transforms. Error: ", err)
+		}
+	}
+
+	if *quarantineFileFlag != "" {
+		messageQuarantine, err = newQuarantine(*quarantineFileFlag, *quarantineMaxSizeFlag)
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+	}
+
+	if *verifySignatureKeyFileFlag != "" {
+		signatureKey, err = readSignatureKey(*verifySignatureKeyFileFlag)
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+	}
+
+	currentPhases.mark("setup")
+
+	// Cancelled on ctrl-c, which stops connecting and reading and lets the
+	// client clean up
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Let's look at our configuration. It is printed for debugging
+	// purposes, and its limits and keep-alive hints are used below
+	config, err := apiClient.FetchConfig(ctx)
 	if err != nil {
 		log.Fatalln("[ERROR] Config request failed. Error: ", err)
 	}
-	printJsonWithTag("PUSH CONFIG", config)
+	currentPhases.mark("config")
+
+	var pushConfig PushServiceConfig
+	err = json.Unmarshal(config, &pushConfig)
+	if err != nil {
+		log.Println("[WARN] Failed to parse the push service config. Error: ", err)
+	}
+	printConfig(config, pushConfig)
+	logUnknownConfigFields(pushConfig)
 
-	// Fetch all subscriptions currently registered with the push service
-	// only printed for debugging purposes, not used in any other way
-	subs, err := fetchSubscriptions()
+	// Fetch all subscriptions currently registered with the push service,
+	// printed for debugging purposes and checked against the limit
+	subs, err := apiClient.FetchSubscriptions(ctx)
 	if err != nil {
 		log.Fatalln("[ERROR] Subscriptions list request failed. Error: ", err)
 	}
+	currentPhases.mark("subscriptions")
+
+	err = printExistingSubscriptions(subs, pushConfig)
+	if err != nil {
+		log.Println("[ERROR] Failed to print existing subscriptions. Error: ", err)
+	}
 
-	printJsonWithTag("EXISTING SUBSCRIPTIONS", subs)
+	plan, err := planSubscription(specFromFlags(), *subscriptionIDFlag, *subscriptionNameFlag, *reconnectTokenFlag, *keepSubscription, *subscriptionTTLFlag)
+	if err != nil {
+		log.Fatalln("[ERROR] ", err)
+	}
+	log.Printf("[INFO] %s\n", plan)
+	if plan.source == planFromFile {
+		warnSubscriptionLimit(pushConfig, subs)
+	}
 
+	// The subscription to connect to, empty with only a reconnect token
+	var subscription string
 	removeSubOnExit := false
-	if *subscriptionIDFlag != "" {
+	if plan.source == planExisting && plan.byName {
+		// '--subscription-name' is looked up in the list of subscriptions
+		subscription, err = resolveSubscriptionName(ctx, plan.subscription)
+		if err != nil {
+			log.Fatalln("[ERROR] ", err)
+		}
+	} else if plan.source == planExisting {
 		// Subscribe to an already existing subscription.
 		// Either uses the subscription id or the subscription name.
-		subscriptionIDOrName = *subscriptionIDFlag
-	} else if *subscriptionFileFlag != "" {
+		subscription = plan.subscription
+	} else if plan.source == planFromFile {
 		// If a subscription spec file has been supplied it will be registered
 		// with the push service. If the subscription has a name and that name
 		// already has been registered the existing subscription is updated
 		// with the content of the supplied file.
 		var existed bool
-		subscriptionIDOrName, existed, err = registerOrUpdateSubscription(*subscriptionFileFlag)
+		subscription, existed, err = registerOrUpdateSubscription(ctx, plan.spec)
 		if err != nil {
 			log.Fatalln("[ERROR] Failed to register or update subscription. Error: ", err)
 		}
+		currentPhases.mark("register")
 
 		// For this test client we'll delete the subscription
 		// when we exit.
 		// Make sure to NOT delete it if the subscription already existed.
 		// And don't delete new subscriptions if the '--keep-subscription' cli flag was used.
-		if !existed && !*keepSubscription {
+		if !existed && plan.deleteIfCreated {
 			removeSubOnExit = true
 		}
 	}
+	// Published before the background loops below start reading them
+	setCurrentSubscription(subscription)
+	setRemoveSubscriptionOnExit(removeSubOnExit)
+
+	// Setup handling of ctrl-c, which cancels the context. The client then
+	// closes the websocket connection and deletes the subscription from the
+	// server if wanted.
+	setupShutdownHandler(cancel)
+	setupDrainSignal()
+	setupPauseSignal()
+
+	if *subscriptionTTLFlag > 0 {
+		if !removeSubOnExit {
+			log.Println("[WARN] The subscription already existed and won't be deleted when '--subscription-ttl' elapses, the client will only exit")
+		}
+		go subscriptionTTLTimer(*subscriptionTTLFlag)
+	}
 
-	// Setup handling of ctrl-c, closes the websocket connection and
-	// deletes the subscription from the server if wanted.
-	setupShutdownHandler(subscriptionIDOrName, removeSubOnExit)
+	if *statusFileFlag != "" {
+		go statusFileLoop(*statusFileFlag)
+	}
+
+	if *statsIntervalFlag > 0 {
+		go statsLoop(*statsIntervalFlag, *statsVerboseFlag)
+	}
+
+	if expectedSeries != nil {
+		go seriesCoverageLoop(expectedSeries, *coverageIntervalFlag)
+	}
+
+	// Started before connecting so that it also covers a server that can't
+	// be reached at startup
+	if *maxUnhealthyDurationFlag > 0 || *maxErrorRateFlag > 0 {
+		go deadMansSwitch(*maxUnhealthyDurationFlag, *maxErrorRateFlag)
+	}
+
+	// Heartbeats are written to the route files, but never printed. They are
+	// started before connecting so a client that can't connect is visible too.
+	if *sinkHeartbeatFlag > 0 {
+		go sinkHeartbeatLoop(*sinkHeartbeatFlag, *sinkHeartbeatChannelFlag)
+	}
 
 	// Parse the reconnect token given on the command line
 	// and initialize the global variable with it
 	reconnectToken, _ := uuid.FromString(*reconnectTokenFlag)
+	if reconnectToken == uuid.Nil && *reconnectTokenFileFlag != "" {
+		reconnectToken = resumeFromReconnectTokenFile(*reconnectTokenFileFlag, subscription)
+	}
 
 	// Now we have an access token and a registered subscription id/name we want to
 	// connect to, the websocket can be created.
 	// This will connect and wait for the init message response from the server
-	conn, err = setupPushServiceConnection(reconnectToken, subscriptionIDOrName)
+	err = setupPushServiceConnection(ctx, reconnectToken, subscription)
 	if err != nil {
+		if ctx.Err() != nil {
+			shutdown(subscription, removeSubOnExit, 0)
+		}
+		exitIfGaveUpConnecting(err)
+		if authErr := explainAuthClose(err, subscription); authErr != nil {
+			log.Fatalf("[ERROR] %v\n", authErr)
+		}
+		if subscription == "" {
+			log.Fatalln("[ERROR] Failed to connect to push service with only a reconnect token, the token may have expired. Use '--subscription-id' or '--subscription-file' to start a new subscriber. Error: ", err)
+		}
 		log.Fatalln("[ERROR] Failed to connect to push service. Error: ", err)
 	}
 
-	// Start a separate process that sends a keep-alive ping now and then.
-	go keepAliveLoop()
+	setCurrentSubscription(subscriptionFromInit(subscription, stats.snapshot()))
+
+	if *verifySubscriptionIntervalFlag > 0 {
+		go verifySubscriptionLoop(ctx, *verifySubscriptionIntervalFlag, specFromFlags())
+	}
+
+	if len(*subscriptionFilesFlag) > 0 {
+		setupReloadSignal(*subscriptionFilesFlag)
+	}
+
+	if *watchSubscriptionFlag > 0 {
+		go watchSubscriptionLoop(ctx, *watchSubscriptionFlag, *followSubscriptionChangesFlag)
+	}
+
+	if *firstMessageTimeoutFlag > 0 {
+		go firstMessageDeadline(*firstMessageTimeoutFlag, pushConfig, stats.snapshot().messagesReceived)
+	}
+
+	// The init message hints take precedence over the ones in the config
+	keepalive.configure(initHints.Or(pushConfig.ServerHints))
+
+	// A connection where neither messages nor pongs arrive is dead, the
+	// read then fails and the client reconnects
+	apiClient.SetReadTimeout(keepalive.readTimeout())
+
+	// Read until ctrl-c cancels the context
+	err = messageReadLoop(ctx)
+	if err != nil {
+		exitIfGaveUpConnecting(err)
+		if authErr := explainAuthClose(err, currentSubscription()); authErr != nil {
+			err = authErr
+		}
+		log.Fatalf("[ERROR] %v\n", err)
+	}
+
+	shutdown(currentSubscription(), removeSubscriptionOnExit(), 0)
+}
 
-	// We start the infinite read loop as a separate go routine to simplify
-	// the reconnect logic.
-	go messageReadLoop()
+// When connecting with only a reconnect token the server tells us which
+// subscription the subscriber belongs to. It is then used for reconnects,
+// the status file and the heartbeats. A subscription given by name is
+// also referred to by its ID from now on, which never needs escaping.
+func subscriptionFromInit(subscription string, s statsSnapshot) string {
+	if subscription == "" {
+		log.Printf("[INFO] Resumed subscriber %s of subscription %s using the reconnect token\n", s.subscriberID, s.subscriptionID)
+		return s.subscriptionID.String()
+	}
+	if _, err := uuid.FromString(subscription); err != nil && s.subscriptionID != uuid.Nil {
+		log.Printf("[INFO] Subscription '%s' has ID %s\n", subscription, s.subscriptionID)
+		return s.subscriptionID.String()
+	}
 
-	// Infinite wait here, use ctrl-c to kill program
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	wg.Wait()
+	return subscription
 }
 
-func setupPushServiceConnection(reconnectToken uuid.UUID, subscriptionIDOrName string) (*websocket.Conn, error) {
+// Shuts down the client, deleting the subscription if wanted, when the
+// subscription's time to live has elapsed. The subscription is looked up
+// then, since it is referred to by ID once connected.
+func subscriptionTTLTimer(ttl time.Duration) {
+	stats.setSubscriptionExpiry(time.Now().Add(ttl))
+	time.Sleep(ttl)
+
+	log.Printf("[INFO] The subscription time to live of %s has elapsed, shutting down\n", ttl)
+	shutdown(currentSubscription(), removeSubscriptionOnExit(), 0)
+}
+
+// Connects the websocket and reads the init message. The connection is
+// owned by apiClient, which also replaces it when reconnecting.
+func setupPushServiceConnection(ctx context.Context, reconnectToken uuid.UUID, subscriptionIDOrName string) error {
 	// Connect the websocket to start receiving events that match
 	// the subscription filters we set up previously
-	conn, err := websocketConnectLoop(reconnectToken, subscriptionIDOrName)
+	conn, err := websocketConnectLoop(ctx, reconnectToken, subscriptionIDOrName)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	currentPhases.mark("dial")
+
+	conn.SetPingHandler(func(appData string) error {
+		return handlePing(conn, appData)
+	})
+	conn.SetPongHandler(func(appData string) error {
+		keepalive.pongReceived()
+		return apiClient.ExtendReadDeadline()
+	})
 
 	// Read the 'init' message from server and handle any websocket setup errors
-	initMsg, err := readInitMessage(conn)
+	prev := stats.snapshot()
+	initMsg, err := apiClient.ReadInitMessage()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read initial message from server. Error: %v", err)
+		if closeErr, ok := err.(*pushclient.ServerCloseError); ok {
+			stats.closedWithReason(closeErr.Code, closeErr.Reason)
+			if closeErr.Code == pushclient.CloseInvalidReconnectToken && prev.subscriberID != uuid.Nil {
+				warnPossibleTakeover(prev.disconnectedAt, "the server rejected the reconnect token")
+			}
+
+			// Retrying with the same token would fail the same way, start
+			// a new subscriber for the subscription instead
+			if closeErr.Code == pushclient.CloseInvalidReconnectToken && reconnectToken != uuid.Nil && subscriptionIDOrName != "" {
+				log.Printf("[WARN] The reconnect token was rejected, it may have expired. Connecting without it, messages sent while disconnected may be lost. Reason: %s\n", pushclient.CloseReason(closeErr.Reason))
+				setCurrentReconnectToken(uuid.Nil)
+				invalidateReconnectTokenFile()
+				if prev.subscriberID != uuid.Nil {
+					warnMissedMessages(prev)
+				}
+				return setupPushServiceConnection(ctx, uuid.Nil, subscriptionIDOrName)
+			}
+		}
+		return fmt.Errorf("Failed to read initial message from server. Error: %w", err)
 	}
+	currentPhases.mark("init")
 
 	// The init message contains a reconnect token, store it in case we need
 	// to reconnect later
 	var m InitResponseMessage
 	err = json.Unmarshal(initMsg, &m)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to unmarshal init response. Error: %v", err)
+		return fmt.Errorf("Failed to unmarshal init response. Error: %v", err)
+	}
+	setCurrentReconnectToken(m.ReconnectToken)
+	reconnectTokenReceived(m)
+	if replayDuplicates != nil {
+		replayDuplicates.initReceived(m.Reconnected)
 	}
-	currReconnectToken = m.ReconnectToken
 
-	printJsonWithTag("INIT MSG", initMsg)
+	// The token is only valid for one subscriber, so if it no longer takes
+	// us back to our subscriber someone else may have used it
+	if reconnectToken != uuid.Nil && !m.Reconnected && prev.subscriberID != uuid.Nil {
+		warnPossibleTakeover(prev.disconnectedAt, "the server started a new subscriber instead of resuming the previous one")
+		warnMissedMessages(prev)
+	}
+	if prev.subscriberID != uuid.Nil && m.SubscriberID != prev.subscriberID {
+		log.Printf("[WARN] Subscriber ID changed from %s to %s after reconnecting\n", prev.subscriberID, m.SubscriberID)
+	}
+	initHints = m.ServerHints
+	emitEvent(lifecycleEvent{Event: eventInitReceived, SubscriptionID: m.Subscription.ID.String(), SubscriberID: m.SubscriberID.String(), Reconnected: &m.Reconnected})
+	stats.setSubscriber(m.SubscriberID, m.Subscription.ID)
+	stats.setFilters(m.Subscription.Filters)
+	stats.setConnected(true)
+	emitEvent(lifecycleEvent{Event: eventConnected, SubscriptionID: m.Subscription.ID.String(), SubscriberID: m.SubscriberID.String()})
 
-	return conn, nil
+	printInitMessage(initMsg, m)
+
+	// Start a separate process that sends a keep-alive ping now and then,
+	// for as long as this connection is used
+	startKeepAlive(ctx, conn)
+
+	return nil
+}
+
+// Logs and counts the messages that were probably sent while disconnected,
+// when the server didn't resume the subscriber and so won't replay them.
+// The number is estimated from the average message rate before the
+// disconnect.
+func warnMissedMessages(prev statsSnapshot) {
+	gap := time.Since(prev.disconnectedAt)
+	estimate := 0
+	if uptime := prev.disconnectedAt.Sub(prev.startedAt); uptime > 0 {
+		estimate = int(float64(prev.messagesReceived) * float64(gap) / float64(uptime))
+	}
+
+	stats.subscriberNotResumed(estimate)
+	log.Printf("[WARN] Messages sent during the %s disconnect were missed since the subscriber wasn't resumed, about %d at the average rate so far\n", roundDuration(gap, time.Millisecond), estimate)
+}
+
+func warnPossibleTakeover(disconnectedAt time.Time, what string) {
+	stats.takeoverSuspected()
+	log.Printf("[WARN] Possible subscriber takeover or reconnect token reuse: %s. Disconnected at %s, reconnected at %s\n",
+		what, disconnectedAt.Format(time.RFC3339Nano), time.Now().Format(time.RFC3339Nano))
+}
+
+// Exit code used when '--max-reconnect-attempts' connection attempts in a
+// row have failed
+const gaveUpConnectingExitCode = 6
+
+// Returned by websocketConnectLoop when '--max-reconnect-attempts' is
+// reached
+type gaveUpConnectingError struct {
+	attempts int
+	err      error // Of the last attempt
 }
 
-func websocketConnectLoop(reconnectToken uuid.UUID, subscriptionIDOrName string) (*websocket.Conn, error) {
+func (e *gaveUpConnectingError) Error() string {
+	return fmt.Sprintf("Gave up connecting after %d failed attempts. Error: %v", e.attempts, e.err)
+}
+
+// Runs the usual cleanup and exits with gaveUpConnectingExitCode if err
+// says that the client gave up connecting
+func exitIfGaveUpConnecting(err error) {
+	var gaveUp *gaveUpConnectingError
+	if errors.As(err, &gaveUp) {
+		log.Println("[ERROR] ", gaveUp)
+		shutdown(currentSubscription(), removeSubscriptionOnExit(), gaveUpConnectingExitCode)
+	}
+}
+
+// How long to wait at least when rate-limited, also when the server's
+// Retry-After header asks for less
+const minRateLimitBackoff = time.Second
+
+// Dials until the websocket is connected. Connection errors are retried,
+// '--max-reconnect-attempts' times in a row if given.
+func websocketConnectLoop(ctx context.Context, reconnectToken uuid.UUID, subscriptionIDOrName string) (*websocket.Conn, error) {
 	var conn *websocket.Conn
+	failedAttempts := 0
 	for {
 		var err error
-		conn, err = connectToWebsocket(*addrFlag, reconnectToken, subscriptionIDOrName)
-		if err != nil {
+		emitEvent(lifecycleEvent{Event: eventConnectAttempt, SubscriptionID: subscriptionIDOrName})
+		conn, err = connectToWebsocket(ctx, reconnectToken, subscriptionIDOrName)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		} else if err != nil {
 			switch v := err.(type) {
-			case *WebsocketSetupHTTPError:
+			case *pushclient.WebsocketSetupHTTPError:
 				if v.HttpStatus == http.StatusUnauthorized {
 					return nil, fmt.Errorf("Failed to authorize client. Error: %v", err)
 				} else if v.HttpStatus == http.StatusTooManyRequests {
-					// Client has been rate-limited, wait a while before trying again
-					backoffSeconds := 30
-					log.Println(fmt.Sprintf("[WARN] Client is rate-limited, retrying in %d seconds. Error: ", backoffSeconds), err)
-					time.Sleep(time.Second * time.Duration(backoffSeconds))
+					failedAttempts++
+					if *maxReconnectAttemptsFlag > 0 && failedAttempts >= *maxReconnectAttemptsFlag {
+						return nil, &gaveUpConnectingError{attempts: failedAttempts, err: err}
+					}
+
+					// Client has been rate-limited, wait as long as the
+					// server asks before trying again
+					backoff, source := v.RetryAfter, "the server's Retry-After header"
+					if !v.HasRetryAfter {
+						backoff, source = pushclient.DefaultRetryAfter, "the default, no usable Retry-After header"
+					} else if backoff < minRateLimitBackoff {
+						backoff, source = minRateLimitBackoff, "the minimum backoff, the server's Retry-After header asked for less"
+					}
+					log.Printf("[WARN] Client is rate-limited, retrying in %s as given by %s. Error: %v\n", backoff, source, err)
+					emitEvent(lifecycleEvent{Event: eventBackoff, Backoff: backoff.String(), Error: err.Error()})
+					err = sleepContext(ctx, backoff)
+					if err != nil {
+						return nil, err
+					}
 				} else {
-					return nil, fmt.Errorf("Websocket connection setup failed. Error: %v", v.error)
+					return nil, fmt.Errorf("Websocket connection setup failed. Error: %v", v)
 				}
 			default:
+				failedAttempts++
+				if *maxReconnectAttemptsFlag > 0 && failedAttempts >= *maxReconnectAttemptsFlag {
+					return nil, &gaveUpConnectingError{attempts: failedAttempts, err: err}
+				}
+
 				// Couldn't connect, try again in a while
 				backoffSeconds := 5
 				log.Println(fmt.Sprintf("[ERROR]: Couldn't connect, retrying in %d seconds. Error:", backoffSeconds), err)
-				time.Sleep(time.Second * time.Duration(backoffSeconds))
+				emitEvent(lifecycleEvent{Event: eventBackoff, Backoff: (time.Duration(backoffSeconds) * time.Second).String(), Error: err.Error()})
+				err = sleepContext(ctx, time.Second*time.Duration(backoffSeconds))
+				if err != nil {
+					return nil, err
+				}
 			}
 		} else {
 			// Connected successfully
@@ -175,137 +666,328 @@ func websocketConnectLoop(reconnectToken uuid.UUID, subscriptionIDOrName string)
 	return conn, nil
 }
 
+// Closes the current websocket so that the read loop reconnects, e.g. to
+// a changed or registered again subscription
 func disconnectWebsocket() error {
-	if conn != nil {
-		err := conn.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(3*time.Second))
-		if err != nil {
-			return fmt.Errorf("Failed to send Close message. Error: %v", err)
-		}
-	}
-
-	return nil
+	return apiClient.Reconnect()
 }
 
-func readInitMessage(conn *websocket.Conn) ([]byte, error) {
-	// The push api server will validate a number of things during websocket
-	// setup, e.g. that the access token is valid, user is authorized etc.
-	// If any validation fails, the server will close the websocket and set
-	// a custom error code.
-	_, message, err := conn.ReadMessage()
-	if closeErr, ok := err.(*websocket.CloseError); ok {
-		var errMsg string
-		switch closeErr.Code {
-		case CloseUnknownSubscriptionID:
-			errMsg = fmt.Sprintf("Subscription ID '%s' is not registered on server", subscriptionIDOrName)
-		case CloseMissingSubscriptionID:
-			errMsg = "Missing subscription ID or name in setup request"
-		case CloseMaxNumSubscribers:
-			errMsg = "The max number of concurrent subscribers for the account has been exceeded"
-		case CloseMaxNumSubscriptions:
-			errMsg = "The max number of registered subscriptions for the account has been exceeded"
-		case CloseInternalError:
-			errMsg = "Unknown server error"
-		default:
-			errMsg = fmt.Sprintf("Server sent unrecognized error code %d", closeErr.Code)
-		}
-
-		return nil, fmt.Errorf("Server closed connection with message: %s", errMsg)
-	} else if err != nil {
-		return nil, err
-	}
-
-	return message, nil
+// Closes the websocket for good, the read loop then ends
+func closeWebsocket() error {
+	return apiClient.Close()
 }
 
+// Hints about the keepalive timings in the latest init message
+var initHints ServerHints
+
+// How many received messages the library keeps while the previous one is
+// being handled
+const receiveBufferSize = 64
+
 // This will read messages from the server and print them to stdout.
-// If the websocket is closed it will automatically re-establish the
-// connection using the reconnect token to ensure no messages were lost
-// during the disconnect.
-func messageReadLoop() {
-	// From here on we will start receiving push events that match our
-	// subscription filters
-	for {
-		_, message, err := conn.ReadMessage()
+// If the websocket is closed the library will automatically re-establish
+// the connection using the reconnect token to ensure no messages were lost
+// during the disconnect. Returns once ctx is cancelled and the websocket
+// has been closed, or with the error when the client can't go on.
+func messageReadLoop(ctx context.Context) error {
+	err := apiClient.Start(ctx, pushclient.StreamOptions{
+		OnDisconnect:     websocketDisconnected,
+		Reconnect:        reconnectPushService,
+		ReceiveBuffer:    receiveBufferSize,
+		ReportReconnects: true,
+	})
+	if err != nil {
+		log.Fatalln("[ERROR] Failed to start reading messages. Error: ", err)
+	}
 
-		// If the websocket is closed we need to reconnect
-		if closeErr, ok := err.(*websocket.CloseError); ok {
-			log.Println("[INFO] Websocket was closed, starting reconnect loop. Reason: ", closeErr)
+	err = receiveLoop(ctx)
 
-			// Reassign the global variable 'conn' with the new websocket handle
-			conn, err = setupPushServiceConnection(currReconnectToken, subscriptionIDOrName)
-			if err != nil {
-				log.Fatalln("[ERROR] Failed to connect to push service. Error: ", err)
-			}
+	// The stream also ends when the server answered the close frame sent
+	// when draining, the client exits once the drain is done
+	if isDraining() {
+		close(streamDrained)
+		select {}
+	}
 
-			// Continue the message read loop
+	return err
+}
+
+// When the previous message was handled, and how long it took. Only used
+// by the receive loop.
+var lastHandledAt time.Time
+var lastHandlingTook time.Duration
+
+// Handles every message received from the server: validates, records,
+// routes and prints it
+func defaultMessageHandler(msg PushMessage) {
+	start := time.Now()
+	if !lastHandledAt.IsZero() {
+		stats.readTimings(lastHandlingTook, start.Sub(lastHandledAt))
+	}
+
+	handleMessage(msg)
+
+	lastHandledAt = time.Now()
+	lastHandlingTook = lastHandledAt.Sub(start)
+}
+
+// Handles the received messages, and those that couldn't be parsed, until
+// the message stream ends. Returns the error that ended it, nil when ctx
+// was cancelled or the client was closed.
+func receiveLoop(ctx context.Context) error {
+	for {
+		msg, err := apiClient.Receive(ctx)
+		if msgErr, ok := err.(*pushclient.MessageError); ok {
+			handleInvalidMessage(msgErr.Message, msgErr)
 			continue
+		} else if err == pushclient.ErrReconnected {
+			// The time spent reconnecting isn't a read wait
+			lastHandledAt = time.Time{}
+			continue
+		} else if err == pushclient.ErrClientClosed || ctx.Err() != nil {
+			return nil
 		} else if err != nil {
 			// Websocket read encountered some other error, we won't try to recover
-			log.Fatalln("[ERROR] Failed to read message. Error: ", err)
+			return err
 		}
 
-		// Sanity check that the JSON can be marshalled into the correct message
-		// format
-		_, err = tryUnmarshalJSONAsPushMessage(message, false)
-		if err != nil {
-			log.Printf("[ERROR] Failed to unmarshal incoming message to message struct. Error: '%s', Message: '%s'\n", err.Error(), message)
+		defaultMessageHandler(msg)
+	}
+}
 
-			// Ignore message and keep reading from websocket
-			continue
-		}
+// Called by the library when the websocket was closed or a read failed,
+// the client reconnects unless it is draining or shutting down
+func websocketDisconnected(err error) bool {
+	stopKeepAlive()
+	if isDraining() || isShuttingDown() {
+		return false
+	}
 
-		printJsonWithTag("MSG", message)
+	if closeErr, ok := err.(*websocket.CloseError); ok {
+		log.Printf("[INFO] Websocket was closed with code %d, starting reconnect loop. Reason: %s\n", closeErr.Code, pushclient.CloseReason(closeErr.Text))
+		stats.closedWithReason(closeErr.Code, closeErr.Text)
+		emitEvent(lifecycleEvent{Event: eventDisconnected, Code: closeErr.Code, Reason: closeErr.Text})
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		log.Printf("[WARN] Nothing received from the server for %s, the connection seems dead, starting reconnect loop\n", keepalive.readTimeout())
+		emitEvent(lifecycleEvent{Event: eventDisconnected, Error: err.Error()})
+	} else {
+		log.Printf("[WARN] Failed to read from websocket, starting reconnect loop. Error: %v\n", err)
+		emitEvent(lifecycleEvent{Event: eventDisconnected, Error: err.Error()})
 	}
+	stats.setConnected(false)
+	currentPhases.restart(time.Now())
+
+	return true
 }
 
-// The client needs to have a keep-alive loop for two reasons:
-//  1. Since the client does not send any other messages to the server
-//     it will never get a notification if the websocket is closed.
-//     The client only detects a closed websocket when it tries to write
-//     data to it. Sending a ping message ensures this happens.
-//  2. The server (or other network devices on the route to the server)
-//     will close connections that are idle for too long.
-func keepAliveLoop() {
-	for {
-		time.Sleep(time.Second * 30)
-		if conn != nil {
-			err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(3*time.Second))
-			if err != nil {
-				log.Println("[ERROR] Failed to send Ping message. Error: ", err)
-				continue
+// Resumes the subscriber after the websocket was closed. Gives up when the
+// client starts draining.
+func reconnectPushService(ctx context.Context) error {
+	ctx, cancel := reconnectContext(ctx)
+	defer cancel()
+
+	reconnectMu.Lock()
+	defer reconnectMu.Unlock()
+
+	err := setupPushServiceConnection(ctx, currentReconnectToken(), currentSubscription())
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		// Draining started after the connection was set up, it is closed
+		// again since draining didn't
+		stopKeepAlive()
+		disconnectWebsocket()
+		return ctx.Err()
+	}
+	stats.reconnected()
+
+	return nil
+}
+
+// Quarantines or logs a message that isn't a valid push message
+func handleInvalidMessage(message []byte, err error) {
+	stats.unmarshalFailed()
+	if messageQuarantine != nil {
+		messageQuarantine.add(message, err)
+		return
+	}
+
+	log.Printf("[ERROR] Failed to unmarshal incoming message to message struct. Error: '%s', Message: '%s'\n", foldLines(err.Error()), foldLines(string(message)))
+}
+
+// Validates a message received from the server and passes it on to the
+// route files and the terminal
+func handleMessage(msg PushMessage) {
+	message := msg.Raw
+
+	if signatureKey != nil {
+		signed, err := verifyMessageSignature(message, signatureKey)
+		if err != nil {
+			stats.signatureFailed()
+			if messageQuarantine != nil {
+				messageQuarantine.add(message, err)
+				return
 			}
+			log.Printf("[ERROR] Invalid message signature, ignoring message. Error: %v, UUID: %s\n", err, msg.UUID)
+			return
+		} else if !signed {
+			stats.unsignedReceived()
+			if *signatureRequiredFlag {
+				log.Printf("[ERROR] Message is not signed, ignoring message. UUID: %s\n", msg.UUID)
+				return
+			}
+		}
+	}
+
+	if replayDuplicates != nil && replayDuplicates.isReplayDuplicate(msg.UUID) {
+		log.Printf("[DEBUG] Suppressed message replayed after reconnect. UUID: %s\n", msg.UUID)
+		// Not printed or delivered, but the recent messages keep everything
+		recentMessages.add(msg.Channel, markReplayDuplicate(message))
+		return
+	}
+
+	stats.messageReceived()
+	firstMessageReceived()
+	stats.messageSize(msg.Channel, len(message))
+	stats.messageArrived(msg.Channel, time.Now())
+	if !*recordTransformedFlag {
+		recentMessages.add(msg.Channel, message)
+	}
+	stats.attributeToFilters(msg)
+	if expectedSeries != nil {
+		expectedSeries.observe(msg, time.Now())
+	}
+	if channelFilter != nil && !channelFilter.shows(msg.Channel) {
+		stats.messageChannelFiltered()
+		return
+	}
+	if idFilter != nil && !idFilter.shows(msg) {
+		stats.messageIDFiltered()
+		return
+	}
+	tag := "MSG"
+	if regression := ordering.observe(msg); regression > 0 {
+		stats.messageOutOfOrder(regression)
+		log.Printf("[DEBUG] Message created %s before the previous message of its channel and game or series. UUID: %s\n", regression, msg.UUID)
+		if *markOutOfOrderFlag {
+			tag = "MSG [OOO]"
 		}
 	}
+	if checkLatency(msg, time.Now()) {
+		tag += " " + slowTag
+	}
+	if msg.Payload.DoubleEncoded {
+		stats.doubleEncodedReceived()
+		normalized, err := normalizePayload(message)
+		if err != nil {
+			log.Printf("[WARN] Failed to normalize double-encoded payload, using message as received. Error: %v, UUID: %s\n", err, msg.UUID)
+		} else {
+			message = normalized
+		}
+	}
+
+	// The sinks only get transformed messages, a message the transforms
+	// fail on is not delivered since it may contain fields to be removed
+	delivered := message
+	if messageTransforms != nil {
+		transformed, err := messageTransforms.apply(message)
+		if err != nil {
+			stats.transformFailed()
+			log.Printf("[ERROR] Failed to transform message, not delivering it to the sinks. Error: %v, UUID: %s\n", err, msg.UUID)
+			delivered = nil
+		} else {
+			stats.messageTransformed(len(message), len(transformed))
+			delivered = transformed
+		}
+	}
+	if *recordTransformedFlag && delivered != nil {
+		recentMessages.add(msg.Channel, delivered)
+	}
+
+	if messageSinks != nil && delivered != nil {
+		messageSinks.deliver(sinkEnvelope{Channel: msg.Channel, Data: delivered})
+	}
+
+	if *displayTransformedFlag && delivered != nil {
+		pause.printMessage(tag, delivered)
+	} else {
+		pause.printMessage(tag, message)
+	}
 }
 
-func registerOrUpdateSubscription(fileName string) (string, bool, error) {
-	// Read subscription specification from file
-	sub, err := readSubscriptionSpec(fileName)
+// Answers a ping from the server with a pong carrying the same payload,
+// like the default handler in the websocket library does, but also keeps
+// track of how many pings the server sends. A ping shows that the server
+// is there, so it extends the read deadline like a pong does.
+func handlePing(conn *websocket.Conn, appData string) error {
+	log.Printf("[DEBUG] Received ping from server (%d bytes payload)\n", len(appData))
+	stats.pingReceived()
+
+	err := apiClient.ExtendReadDeadline()
 	if err != nil {
-		return "", false, fmt.Errorf("Could not read subscription spec from file. Error=%v", err)
+		return err
+	}
+
+	err = conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(3*time.Second))
+	if err == websocket.ErrCloseSent {
+		// The connection is being closed, no need to answer
+		return nil
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		// The pong couldn't be written in time, the server pings again
+		// and the read deadline decides if the connection is dead
+		return nil
 	}
 
-	// Register the subscription specification with the push service
-	subscriptionID, alreadyExists, err := registerSubscription(sub)
+	return err
+}
+
+// Registers the spec, or updates the subscription with its name, and
+// returns the ID and whether the subscription already existed
+func registerOrUpdateSubscription(ctx context.Context, spec specSource) (string, bool, error) {
+	registered, result, err := registerSubscriptionSpec(ctx, spec)
 	if err != nil {
-		return "", false, fmt.Errorf("Subscription registration request failed. Error: %v", err)
+		return "", false, err
 	}
 
-	if alreadyExists {
-		log.Printf("[INFO]: A subscription with name '%s' already exists, updating it.\n", sub.Name)
+	return registered.ID.String(), result != pushclient.EnsureCreated, nil
+}
 
-		sub.ID = subscriptionID
-		_, _, err = updateSubscription(sub)
-		if err != nil {
-			return "", false, fmt.Errorf("Failed to update subscription. Error: %v", err)
-		}
-	} else {
-		if sub.Name != "" {
-			log.Printf("[INFO]: Registered the subscription with name '%s' (ID=%s).\n", sub.Name, subscriptionID)
+// Registers the spec, or updates the subscription with its name, and logs
+// what was done
+func registerSubscriptionSpec(ctx context.Context, spec specSource) (Subscription, pushclient.EnsureResult, error) {
+	// Read subscription specification from file
+	sub, err := spec.read()
+	if err != nil {
+		return Subscription{}, 0, fmt.Errorf("Could not read subscription spec from file. Error=%v", err)
+	}
+
+	send := sub
+	if *ownerTagFlag != "" {
+		send.Description = withOwnerTag(sub.Description, *ownerTagFlag)
+	}
+	registered, existing, result, err := apiClient.EnsureSubscription(ctx, send, ensureOptions(*clearDescriptionFlag, *ownerTagFlag, *forceForeignFlag))
+	if err != nil {
+		return Subscription{}, 0, err
+	}
+
+	switch result {
+	case pushclient.EnsureCreated:
+		emitEvent(lifecycleEvent{Event: eventSubscriptionRegistered, SubscriptionID: registered.ID.String()})
+		if registered.Name != "" {
+			log.Printf("[INFO]: Registered the subscription with name '%s' (ID=%s).\n", registered.Name, registered.ID)
 		} else {
-			log.Printf("[INFO]: Registered the subscription. ID=%s.\n", subscriptionID)
+			log.Printf("[INFO]: Registered the subscription. ID=%s.\n", registered.ID)
+		}
+	case pushclient.EnsureUpdated:
+		emitEvent(lifecycleEvent{Event: eventSubscriptionUpdated, SubscriptionID: registered.ID.String()})
+		log.Printf("[INFO]: A subscription with name '%s' already existed, updated it (%s).\n", registered.Name, summarizeChanges(diffSubscriptions(existing, registered)))
+		if sub.Description == "" && registered.Description != "" {
+			log.Printf("[INFO]: Kept the existing description '%s', use '--clear-description' to remove it.\n", registered.Description)
 		}
+	case pushclient.EnsureUnchanged:
+		log.Printf("[INFO]: A subscription with name '%s' already exists and matches the spec, not updating it.\n", registered.Name)
 	}
 
-	return subscriptionID.String(), alreadyExists, nil
+	return registered, result, nil
 }
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// The subscription echoed in the init message replaces an empty one or a
+// name, an ID given by the user is kept
+func TestSubscriptionFromInit(t *testing.T) {
+	echoed := statsSnapshot{subscriptionID: testSubscriptionID, subscriberID: uuid.Must(uuid.NewV4())}
+	other := "11111111-2222-4333-8444-555555555555"
+	tests := []struct {
+		name         string
+		subscription string
+		snapshot     statsSnapshot
+		want         string
+	}{
+		{"token only", "", echoed, testSubscriptionID.String()},
+		{"name", "dev", echoed, testSubscriptionID.String()},
+		{"name without echoed ID", "dev", statsSnapshot{}, "dev"},
+		{"ID", other, echoed, other},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLog(t)
+			if got := subscriptionFromInit(test.subscription, test.snapshot); got != test.want {
+				t.Errorf("got '%s', want '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+// Sends pings more often than the read timeout but no messages, then one
+// message. The pongs carrying each payload are recorded.
+func newServerPingingServer(t *testing.T, pings int, every time.Duration) (string, <-chan string) {
+	t.Helper()
+
+	pongs := make(chan string, pings)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetPongHandler(func(appData string) error {
+			pongs <- appData
+			return nil
+		})
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for i := 0; i < pings; i++ {
+			time.Sleep(every)
+			payload := fmt.Sprintf("ping %d", i)
+			if err := conn.WriteControl(websocket.PingMessage, []byte(payload), time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"channel": "series"}`))
+		time.Sleep(time.Second)
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http"), pongs
+}
+
+// Pings from the server are answered with their payload, counted and keep
+// the connection alive while no messages arrive
+func TestHandlePing(t *testing.T) {
+	discardLog(t)
+	addr, pongs := newServerPingingServer(t, 5, 40*time.Millisecond)
+
+	client, err := pushclient.New(pushclient.Config{
+		Addr: addr,
+		Auth: pushclient.NewSecretAuth("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiClient = client
+	t.Cleanup(func() {
+		if conn := client.Conn(); conn != nil {
+			conn.Close()
+		}
+		apiClient = nil
+	})
+
+	conn, err := client.Dial(context.Background(), uuid.Nil, testSubscriptionID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetPingHandler(func(appData string) error {
+		return handlePing(conn, appData)
+	})
+	// Shorter than the time until the message, only the pings keep the
+	// read from timing out
+	client.SetReadTimeout(100 * time.Millisecond)
+
+	before := stats.snapshot().pingsReceived
+	message, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("the read failed while the server was pinging: %v", err)
+	}
+	if string(message) != `{"channel": "series"}` {
+		t.Errorf("got message %s", message)
+	}
+
+	if got := stats.snapshot().pingsReceived - before; got != 5 {
+		t.Errorf("counted %d pings, want 5", got)
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case pong := <-pongs:
+			if want := fmt.Sprintf("ping %d", i); pong != want {
+				t.Errorf("pong %d carries '%s', want '%s'", i, pong, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got %d pongs, want 5", i)
+		}
+	}
+}
+
+// The reason text of a close frame is logged, kept in the stats and shown
+// by '/health', an empty one as '(no reason given)'
+func TestCloseReasonRecorded(t *testing.T) {
+	tests := []struct {
+		name       string
+		reason     string
+		wantReason string
+	}{
+		{"with reason", "subscription deleted by admin", "subscription deleted by admin"},
+		{"without reason", "", "(no reason given)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+			t.Cleanup(func() { stats.closedWithReason(0, "") })
+
+			err := &websocket.CloseError{Code: websocket.CloseGoingAway, Text: test.reason}
+			if !websocketDisconnected(err) {
+				t.Fatal("the client doesn't reconnect after the close")
+			}
+
+			want := fmt.Sprintf("Websocket was closed with code %d, starting reconnect loop. Reason: %s", websocket.CloseGoingAway, test.wantReason)
+			if !strings.Contains(logged.String(), want) {
+				t.Errorf("the log doesn't contain '%s':\n%s", want, logged)
+			}
+			s := stats.snapshot()
+			if s.lastCloseCode != websocket.CloseGoingAway || s.lastCloseReason != test.reason {
+				t.Errorf("the stats have close code %d and reason '%s'", s.lastCloseCode, s.lastCloseReason)
+			}
+
+			rec := httptest.NewRecorder()
+			handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+			var health healthResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+				t.Fatal(err)
+			}
+			if health.LastCloseCode != websocket.CloseGoingAway || health.LastCloseReason != test.wantReason {
+				t.Errorf("/health has close code %d and reason '%s'", health.LastCloseCode, health.LastCloseReason)
+			}
+		})
+	}
+}
+
+// Makes shutdown end the calling goroutine where it would have ended the
+// process, and sends the exit code on the returned channel. Shutdown can
+// run again once the test has ended.
+func catchExit(t *testing.T) <-chan int {
+	exited := make(chan int, 1)
+	exitProcess = func(code int) {
+		exited <- code
+		runtime.Goexit()
+	}
+	noSummary := *noSummaryFlag
+	*noSummaryFlag = true
+	t.Cleanup(func() {
+		exitProcess = os.Exit
+		*noSummaryFlag = noSummary
+		if isShuttingDown() {
+			atomic.StoreInt32(&shuttingDown, 0)
+			shutdownMu.Unlock()
+		}
+		reloadMu.Lock()
+		reloadsStopped = false
+		reloadMu.Unlock()
+	})
+
+	return exited
+}
+
+// When the time to live elapses the subscription is deleted on the server
+// and the client exits, the remaining time is shown by '/health' until then
+func TestSubscriptionTTLTimer(t *testing.T) {
+	discardLog(t)
+	server := newRegisteringServer(t, true)
+	useRegisteringServer(t, server)
+	setCurrentSubscription(server.registered.String())
+	setRemoveSubscriptionOnExit(true)
+	exited := catchExit(t)
+	t.Cleanup(func() { stats.setSubscriptionExpiry(time.Time{}) })
+
+	go subscriptionTTLTimer(600 * time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for stats.snapshot().expiresAt.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("the expiry wasn't set")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var health healthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if health.RemainingTTL != "1s" {
+		t.Errorf("/health has remaining TTL '%s', want 1s", health.RemainingTTL)
+	}
+
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Errorf("exited with code %d, want 0", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client didn't exit when the TTL elapsed")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.deleted != server.registered.String() {
+		t.Errorf("deleted subscription '%s', want '%s'", server.deleted, server.registered)
+	}
+}
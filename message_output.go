@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	flag "github.com/spf13/pflag"
+)
+
+var payloadOnlyFlag = flag.Bool("payload-only", false, "Print only the payload of each message, '{}' if it has none")
+var withChannelFlag = flag.Bool("with-channel", false, "Print the channel name before each payload with '--payload-only', in the tag, or in a 'channel' field next to the 'payload' with '--output-format=ndjson'")
+
+// Where the push messages are written. Everything else, including the
+// startup dumps, is logged to stderr or the '--log-file', so that stdout
+// only has the messages.
+var messageOutput io.Writer = os.Stdout
+
+// Set at startup by '--silent', the log level doesn't affect the messages
+var messagesSilenced bool
+
+// Set at startup, when true every message is printed as one line of compact
+// JSON. Decided separately from singleLineOutput, which is for the log.
+var singleLineMessages bool
+
+func setupMessageOutput(logOutput *os.File) {
+	out := os.Stdout
+	// The background process has no stdout, its messages go to the log
+	if *daemonFlag {
+		out = logOutput
+	}
+
+	messageOutput = out
+	singleLineMessages = *singleLineFlag || (!isTerminal(out) && *colorFlag != "always")
+}
+
+// Prints a push message in the chosen output format. The tag is only
+// shown in the pretty format. Called by the output pause only, which
+// makes sure messages are written one at a time.
+func printMessageOutput(tag string, msg []byte, received time.Time) {
+	if messagesSilenced {
+		return
+	}
+
+	v, err := decodeJSON(msg)
+	if err != nil {
+		log.Printf("[ERROR] Failed to unmarshal message. Error: %s, Msg: %s\n", err, foldLines(string(msg)))
+		return
+	}
+	// The latency is counted from the creation time even if only the
+	// payload is printed
+	createdAt := messageCreatedAt(v)
+
+	channel := messageChannel(v)
+	if *payloadOnlyFlag {
+		v = messagePayload(v)
+	}
+
+	var line []byte
+	if csvOutput {
+		b, err := csvLine(v)
+		if err != nil {
+			log.Printf("[ERROR] Failed to write message as csv. Error: %v, Msg: %s\n", err, foldLines(string(msg)))
+			return
+		}
+		line = b
+	} else if ndjsonOutput {
+		b, err := ndjsonLine(msg, v, channel, createdAt, received)
+		if err != nil {
+			log.Printf("[ERROR] Failed to write message as ndjson. Error: %v, Msg: %s\n", err, foldLines(string(msg)))
+			return
+		}
+		line = b
+	} else {
+		if *withChannelFlag {
+			tag = fmt.Sprintf("%s: %s", tag, channel)
+		}
+		if colorOutput && !singleLineMessages {
+			if strings.Contains(tag, slowTag) {
+				tag = color.New(color.FgRed, color.Bold).Sprint(tag)
+			} else {
+				tag = channelTag(tag, channel)
+			}
+		}
+		entry, ok := formatValueWithTag(tag, len(msg), v, createdAt, singleLineMessages)
+		if !ok {
+			return
+		}
+		line = []byte(entry)
+	}
+
+	// One write per message, so that a reader never sees half a message
+	_, err = messageOutput.Write(line)
+	if err != nil {
+		log.Println("[ERROR] Failed to write message. Error:", err)
+	}
+}
+
+// Returns the channel of a decoded message, empty if it has none
+func messageChannel(v interface{}) string {
+	o, _ := v.(map[string]interface{})
+	channel, _ := o["channel"].(string)
+
+	return channel
+}
+
+// Returns the payload of a decoded message. A message without a payload,
+// or with an empty one, has the empty object as payload.
+func messagePayload(v interface{}) interface{} {
+	o, _ := v.(map[string]interface{})
+	payload, ok := o["payload"]
+	if !ok || payload == nil || payload == "" {
+		return map[string]interface{}{}
+	}
+
+	return payload
+}
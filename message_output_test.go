@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// Points stdout at a pipe while the message output is set up, and returns
+// the reading end
+func pipeStdout(t *testing.T) *os.File {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	setupMessageOutput(os.Stderr)
+	os.Stdout = stdout
+	t.Cleanup(func() {
+		r.Close()
+		w.Close()
+		messageOutput = os.Stdout
+		singleLineMessages = false
+	})
+
+	return r
+}
+
+func TestSingleLineOutputToPipe(t *testing.T) {
+	discardLog(t)
+	r := pipeStdout(t)
+	if !singleLineMessages {
+		t.Fatal("a pipe doesn't get single-line output")
+	}
+
+	messages := []string{
+		`{"channel": "series", "payload": {"text": "two\nlines"}}`,
+		"{\n  \"channel\": \"match\",\n  \"payload\": [1,\n 2]\n}",
+		`{"channel": "series", "created": "2020-05-17T12:30:00Z", "payload": "a\r\nb"}`,
+		`"just a string"`,
+	}
+	go func() {
+		for _, m := range messages {
+			printMessageOutput("msg", []byte(m), time.Now())
+		}
+	}()
+
+	scanner := bufio.NewScanner(r)
+	for i, m := range messages {
+		if !scanner.Scan() {
+			t.Fatalf("message %d is missing: %v", i+1, scanner.Err())
+		}
+		var entry outputEnvelope
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %d isn't one message: %v, %q", i+1, err, scanner.Text())
+		}
+		if entry.Tag != "msg" || entry.Bytes != len(m) {
+			t.Errorf("line %d: got tag %q and %d bytes, want \"msg\" and %d", i+1, entry.Tag, entry.Bytes, len(m))
+		}
+	}
+}
+
+func TestSingleLineOutputForced(t *testing.T) {
+	tests := []struct {
+		name       string
+		singleLine bool
+		color      string
+		want       bool
+	}{
+		{"pipe", false, "auto", true},
+		{"pipe with '--single-line'", true, "auto", true},
+		{"pipe with '--color=always'", false, "always", false},
+		{"'--single-line' wins over '--color=always'", true, "always", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*singleLineFlag = test.singleLine
+			*colorFlag = test.color
+			defer func() {
+				*singleLineFlag = false
+				*colorFlag = "auto"
+			}()
+
+			pipeStdout(t)
+			if singleLineMessages != test.want {
+				t.Errorf("got single-line %t, want %t", singleLineMessages, test.want)
+			}
+		})
+	}
+}
+
+func TestFoldLines(t *testing.T) {
+	tests := []struct {
+		singleLine bool
+		s          string
+		want       string
+	}{
+		{true, "a\nb", `a\nb`},
+		{true, "a\r\nb", `a\r\nb`},
+		{true, "no breaks", "no breaks"},
+		{false, "a\nb", "a\nb"},
+	}
+
+	for _, test := range tests {
+		singleLineOutput = test.singleLine
+		if got := foldLines(test.s); got != test.want {
+			t.Errorf("foldLines(%q) with single-line %t: got %q, want %q", test.s, test.singleLine, got, test.want)
+		}
+	}
+	singleLineOutput = false
+}
+
+func TestIsTerminalPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	if isTerminal(w) {
+		t.Errorf("a pipe is reported as a terminal")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var outputFormatFlag = flag.String("output-format", "pretty", "How messages are printed: 'pretty' log entries, 'ndjson' with one compact JSON object per line, or 'csv' with the '--csv-fields' of each message")
+var ndjsonMetadataFlag = flag.Bool("ndjson-metadata", false, "Add 'received_at' and 'latency_ms' fields to the messages printed with '--output-format=ndjson'")
+
+// Set at startup, when true the messages are written as they are, without
+// tag, latency or color
+var ndjsonOutput bool
+
+func validateOutputFormat() error {
+	switch *outputFormatFlag {
+	case "pretty", "ndjson", "csv":
+	default:
+		return fmt.Errorf("Unknown output format '%s', must be 'pretty', 'ndjson' or 'csv'", *outputFormatFlag)
+	}
+
+	if *ndjsonMetadataFlag && *outputFormatFlag != "ndjson" {
+		return fmt.Errorf("The option '--ndjson-metadata' needs '--output-format=ndjson'")
+	}
+	if *daemonFlag && *outputFormatFlag != "pretty" {
+		return fmt.Errorf("The option '--output-format=%s' can't be used with '--daemon', which has no stdout", *outputFormatFlag)
+	}
+
+	if *outputFormatFlag == "csv" {
+		if len(*csvFieldsFlag) == 0 {
+			return fmt.Errorf("The option '--output-format=csv' needs '--csv-fields'")
+		}
+		if *payloadOnlyFlag {
+			return fmt.Errorf("The option '--payload-only' can't be used with '--output-format=csv', select fields of the payload with '--csv-fields=payload...'")
+		}
+	} else if len(*csvFieldsFlag) > 0 {
+		return fmt.Errorf("The option '--csv-fields' needs '--output-format=csv'")
+	}
+
+	if *withChannelFlag && !*payloadOnlyFlag {
+		return fmt.Errorf("The option '--with-channel' needs '--payload-only'")
+	}
+
+	return nil
+}
+
+// Compacts the message to a single line, v is what is printed of it. With
+// '--ndjson-metadata' the receive time and latency are added to what is
+// printed if it is an object, the latency only when the message has a
+// creation time. With '--payload-only' these and the channel of
+// '--with-channel' go next to the payload in an object, so that the payload
+// is printed as the server sent it.
+func ndjsonLine(msg []byte, v interface{}, channel string, createdAt time.Time, received time.Time) ([]byte, error) {
+	// The message is kept as received when nothing is added or left out
+	if !*ndjsonMetadataFlag && !*payloadOnlyFlag {
+		var buf bytes.Buffer
+		err := json.Compact(&buf, msg)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+
+	if *payloadOnlyFlag && (*withChannelFlag || *ndjsonMetadataFlag) {
+		o := map[string]interface{}{"payload": v}
+		if *withChannelFlag {
+			o["channel"] = channel
+		}
+		v = o
+	}
+
+	if o, ok := v.(map[string]interface{}); ok && *ndjsonMetadataFlag {
+		o["received_at"] = received.UTC().Format(time.RFC3339Nano)
+		if !createdAt.IsZero() {
+			o["latency_ms"] = received.Sub(createdAt).Milliseconds()
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNdjsonLine(t *testing.T) {
+	msg := []byte(`{"channel": "series", "created": "2020-05-17T12:30:00Z", "payload": {"id": 1}}`)
+	created := time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC)
+	received := created.Add(250 * time.Millisecond)
+
+	tests := []struct {
+		name        string
+		payloadOnly bool
+		withChannel bool
+		metadata    bool
+		want        string
+	}{
+		{
+			name: "as received",
+			want: `{"channel":"series","created":"2020-05-17T12:30:00Z","payload":{"id":1}}`,
+		},
+		{
+			name:     "metadata",
+			metadata: true,
+			want:     `{"channel":"series","created":"2020-05-17T12:30:00Z","latency_ms":250,"payload":{"id":1},"received_at":"2020-05-17T12:30:00.25Z"}`,
+		},
+		{
+			name:        "payload only",
+			payloadOnly: true,
+			want:        `{"id":1}`,
+		},
+		{
+			name:        "payload with channel",
+			payloadOnly: true,
+			withChannel: true,
+			want:        `{"channel":"series","payload":{"id":1}}`,
+		},
+		{
+			name:        "payload with metadata",
+			payloadOnly: true,
+			metadata:    true,
+			want:        `{"latency_ms":250,"payload":{"id":1},"received_at":"2020-05-17T12:30:00.25Z"}`,
+		},
+		{
+			name:        "payload with channel and metadata",
+			payloadOnly: true,
+			withChannel: true,
+			metadata:    true,
+			want:        `{"channel":"series","latency_ms":250,"payload":{"id":1},"received_at":"2020-05-17T12:30:00.25Z"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(p, c, m bool) { *payloadOnlyFlag, *withChannelFlag, *ndjsonMetadataFlag = p, c, m }(*payloadOnlyFlag, *withChannelFlag, *ndjsonMetadataFlag)
+			*payloadOnlyFlag, *withChannelFlag, *ndjsonMetadataFlag = test.payloadOnly, test.withChannel, test.metadata
+
+			v, err := decodeJSON(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload := v
+			if test.payloadOnly {
+				payload = messagePayload(v)
+			}
+
+			b, err := ndjsonLine(msg, payload, messageChannel(v), messageCreatedAt(v), received)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != test.want+"\n" {
+				t.Errorf("got %s, want %s", b, test.want)
+			}
+			// The payload is printed as the server sent it
+			if p, ok := messagePayload(v).(map[string]interface{}); !ok || len(p) != 1 {
+				t.Errorf("the payload was changed to %v", messagePayload(v))
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var markOutOfOrderFlag = flag.Bool("mark-out-of-order", false, "Print messages created before the previous message of the same channel and game or series with the tag 'MSG [OOO]'")
+var orderingKeysFlag = flag.Int("ordering-keys", 10000, "Number of channel, game and series combinations whose latest 'created' time is remembered for the ordering check")
+
+// The messages of a key are expected to arrive in 'created' order
+type orderingKey struct {
+	channel  string
+	gameID   int
+	seriesID int
+}
+
+type orderingEntry struct {
+	key         orderingKey
+	lastCreated time.Time
+}
+
+// Remembers the latest 'created' time of the most recent keys, forgetting
+// the least recently seen key when full
+type orderingTracker struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // Front is the most recently seen
+	elements map[orderingKey]*list.Element
+}
+
+var ordering = newOrderingTracker(10000)
+
+func newOrderingTracker(capacity int) *orderingTracker {
+	return &orderingTracker{
+		capacity: capacity,
+		order:    list.New(),
+		elements: make(map[orderingKey]*list.Element),
+	}
+}
+
+// Returns how much older the message is than the latest one of its key,
+// zero if it arrived in order. Messages without a 'created' time are
+// always in order.
+func (t *orderingTracker) observe(msg PushMessage) time.Duration {
+	if msg.Created.IsZero() {
+		return 0
+	}
+
+	paths, ok := channelIDPaths[msg.Channel]
+	if !ok {
+		paths = defaultIDPaths
+	}
+	key := orderingKey{channel: msg.Channel}
+	key.gameID, _ = selectPayloadID(msg.Payload.Fields, paths.GameID)
+	key.seriesID, _ = selectPayloadID(msg.Payload.Fields, paths.SeriesID)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.elements[key]; ok {
+		t.order.MoveToFront(e)
+		entry := e.Value.(*orderingEntry)
+		if msg.Created.Before(entry.lastCreated) {
+			return entry.lastCreated.Sub(msg.Created)
+		}
+		entry.lastCreated = msg.Created
+		return 0
+	}
+
+	t.elements[key] = t.order.PushFront(&orderingEntry{key: key, lastCreated: msg.Created})
+	if t.order.Len() > t.capacity {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.elements, oldest.Value.(*orderingEntry).key)
+	}
+
+	return 0
+}
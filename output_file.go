@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var outputFileFlag = flag.String("output-file", "", "Append every received message to this file, one JSON object per line")
+var outputMaxSizeFlag = flag.Int64("output-max-size", 100*1024*1024, "Rotate the '--output-file' when it grows past this many bytes, 0 never rotates")
+var outputMaxFilesFlag = flag.Int("output-max-files", 10, "Number of rotated '--output-file' files to keep, 0 keeps all")
+
+// How often the buffered messages are written to the output file
+const outputFlushInterval = time.Second
+
+// Appends every received message to a rotating file. Messages are buffered
+// and written every outputFlushInterval, and rotation only happens between
+// messages, so a message is never split over two files.
+type outputFileSink struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *rotatingFile
+	w        *bufio.Writer
+	closed   bool
+}
+
+func newOutputFileSink(path string, maxSize int64, maxFiles int) *outputFileSink {
+	return &outputFileSink{path: path, maxSize: maxSize, maxFiles: maxFiles}
+}
+
+func (s *outputFileSink) Start(ctx context.Context) error {
+	f, err := openRotatingFile(s.path, s.maxSize, s.maxFiles)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	s.w = bufio.NewWriter(f)
+
+	go s.flushLoop(ctx)
+
+	return nil
+}
+
+func (s *outputFileSink) flushLoop(ctx context.Context) {
+	t := time.NewTicker(outputFlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			err := s.Flush()
+			if err != nil {
+				log.Println("[ERROR] ", err)
+			}
+		}
+	}
+}
+
+// Writes received messages, synthetic ones like heartbeats are left out
+func (s *outputFileSink) Deliver(env sinkEnvelope) error {
+	if env.Broadcast {
+		return nil
+	}
+
+	// Each message must be exactly one line in the file
+	var line bytes.Buffer
+	err := json.Compact(&line, env.Data)
+	if err != nil {
+		return fmt.Errorf("Failed to compact message. Error: %v", err)
+	}
+	line.WriteByte('\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	// The buffer only ever holds whole lines when it is written, which is
+	// when the file may be rotated. It is also written before the line that
+	// would take the file past the max size, so the line starts a new file.
+	pending := s.file.size + int64(s.w.Buffered()+line.Len())
+	if line.Len() > s.w.Available() || (s.maxSize > 0 && pending > s.maxSize) {
+		err = s.w.Flush()
+		if err != nil {
+			return fmt.Errorf("Failed to write to output file '%s'. Error: %v", s.path, err)
+		}
+	}
+	_, err = s.w.Write(line.Bytes())
+	if err != nil {
+		return fmt.Errorf("Failed to write to output file '%s'. Error: %v", s.path, err)
+	}
+
+	return nil
+}
+
+func (s *outputFileSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	err := s.w.Flush()
+	if err != nil {
+		return fmt.Errorf("Failed to flush output file '%s'. Error: %v", s.path, err)
+	}
+
+	return nil
+}
+
+// Flushes the buffered messages and syncs the file before closing it
+func (s *outputFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.w.Flush()
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		s.file.Close()
+		return fmt.Errorf("Failed to flush output file '%s'. Error: %v", s.path, err)
+	}
+
+	return s.file.Close()
+}
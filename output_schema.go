@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	flag "github.com/spf13/pflag"
+)
+
+// Version of the client's own JSON output formats: the single-line message
+// envelope and the status file. Fields may be added within a version,
+// renaming or removing a field bumps the version.
+const outputVersion = 1
+
+var outputVersionFlag = flag.Int("output-version", outputVersion, "Version of the JSON output formats to write, only 1 exists so far")
+
+// One line of single-line output
+type outputEnvelope struct {
+	V       int         `json:"v"`
+	Tag     string      `json:"tag"`
+	Latency string      `json:"latency,omitempty"`
+	Bytes   int         `json:"bytes"`
+	Data    interface{} `json:"data"`
+}
+
+// The output formats with a published schema
+var outputSchemas = map[string]interface{}{
+	"envelope": outputEnvelope{},
+	"status":   statusFile{},
+}
+
+func validateOutputVersion(v int) error {
+	if v != outputVersion {
+		return fmt.Errorf("Unknown '--output-version' %d, only version %d exists", v, outputVersion)
+	}
+
+	return nil
+}
+
+// The 'output-schema' command, prints the JSON schema of an output format
+func outputSchemaCommand(args []string) error {
+	names := make([]string, 0, len(outputSchemas))
+	for name := range outputSchemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) != 1 {
+		return fmt.Errorf("The output-schema command needs the name of an output format: %s", strings.Join(names, ", "))
+	}
+	v, ok := outputSchemas[args[0]]
+	if !ok {
+		return fmt.Errorf("Unknown output format '%s', must be one of %s", args[0], strings.Join(names, ", "))
+	}
+
+	schema := jsonSchema(reflect.TypeOf(v))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = fmt.Sprintf("push-api-client %s v%d", args[0], outputVersion)
+
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeCommandOutput(append(b, '\n'))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var uuidType = reflect.TypeOf(uuid.UUID{})
+
+// Returns the JSON schema of a type as encoded by encoding/json. Only the
+// kinds used by the output formats are supported.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]interface{}{"anyOf": []interface{}{jsonSchema(t.Elem()), map[string]interface{}{"type": "null"}}}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := []string{}
+		addStructFields(t, properties, &required)
+		return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+	}
+
+	// interface{} and anything else can be any JSON value
+	return map[string]interface{}{}
+}
+
+func addStructFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, options = tag[:i], tag[i+1:]
+		}
+
+		// Fields of embedded structs are encoded as fields of the outer one
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addStructFields(f.Type, properties, required)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		properties[name] = jsonSchema(f.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	goflag "flag"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+var updateGolden = goflag.Bool("update", false, "Rewrite the golden files in testdata with the current output")
+
+// Compares got with testdata/name, or rewrites the file with '-update'
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("the output doesn't match %s, a changed v%d format needs a new output version\ngot:\n%s\nwant:\n%s", path, outputVersion, got, want)
+	}
+}
+
+// Values that change from run to run, replaced before comparing
+var volatileFields = regexp.MustCompile(`("(?:latency|pid|updated_at|subscription_expires_at|subscription_remaining_ttl)": ?)("[^"]*"|\d+)`)
+
+func maskVolatile(b []byte) []byte {
+	return volatileFields.ReplaceAll(b, []byte(`$1"<volatile>"`))
+}
+
+func TestOutputEnvelopeGolden(t *testing.T) {
+	var data interface{}
+	message := `{"channel": "series", "created": "2020-05-17T12:30:00Z", "payload": {"text": "a <b>\nc", "n": 1}}`
+	if err := json.Unmarshal([]byte(message), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []byte
+	line, ok := formatSingleLineWithTag("MSG", len(message), data, time.Time{})
+	if !ok {
+		t.Fatal("the message wasn't formatted")
+	}
+	got = append(got, line...)
+	line, ok = formatSingleLineWithTag("MSG [SLOW]", len(message), data, messageCreatedAt(data))
+	if !ok {
+		t.Fatal("the message wasn't formatted")
+	}
+	got = append(got, line...)
+
+	checkGolden(t, "envelope.golden", maskVolatile(got))
+}
+
+func TestStatusFileGolden(t *testing.T) {
+	setCurrentSubscription("subscription-name")
+	defer setCurrentSubscription("")
+
+	lastMessageAt := time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC)
+	s := statsSnapshot{
+		messagesReceived: 42,
+		lastMessageAt:    lastMessageAt,
+		reconnects:       2,
+		connected:        true,
+		takeoverWarnings: 1,
+		subscriptionID:   uuid.Must(uuid.FromString("7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b")),
+		subscriberID:     uuid.Must(uuid.FromString("b7aebb86-4c20-4967-8f63-1173ea27e621")),
+		expiresAt:        time.Now().Add(time.Hour),
+		filters:          []SubscriptionFilter{{Channel: "series", GameID: 1}, {Channel: "match"}},
+		filterHits:       []int{40, 2},
+	}
+
+	fileName := filepath.Join(t.TempDir(), "status.json")
+	if err := writeStatusFile(fileName, s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "status.golden", maskVolatile(got))
+}
+
+func TestOutputSchemaGolden(t *testing.T) {
+	for _, name := range []string{"envelope", "status"} {
+		t.Run(name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "schema.json")
+			*outFlag = fileName
+			defer func() { *outFlag = "" }()
+
+			if err := outputSchemaCommand([]string{name}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, name+".schema.golden", got)
+		})
+	}
+}
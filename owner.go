@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var ownerTagFlag = flag.String("owner-tag", "", "Mark registered subscriptions as owned by this team with a '[owner:team]' suffix on the description")
+var forceForeignFlag = flag.Bool("force-foreign", false, "Allow deleting or taking over subscriptions owned by someone else than '--owner-tag'")
+var ownerFilterFlag = flag.String("owner", "", "Only show existing subscriptions with this owner tag, '-' shows those without one")
+
+// The owner tag is a suffix on the description, e.g. 'Live odds [owner:odds]',
+// since subscriptions have no other place for metadata
+var ownerTagPattern = regexp.MustCompile(`(^|\s)\[owner:([^\s\[\]]+)\]\s*$`)
+var validOwnerTag = regexp.MustCompile(`^[^\s\[\]]+$`)
+
+// Splits a description into the owner tag and the rest of the text. The
+// owner is empty if the description has no tag.
+func parseOwnerTag(description string) (owner, text string) {
+	m := ownerTagPattern.FindStringSubmatchIndex(description)
+	if m == nil {
+		return "", description
+	}
+
+	return description[m[4]:m[5]], strings.TrimRight(description[:m[0]], " \t\n")
+}
+
+// Returns the description with its owner tag replaced by owner, or removed
+// if owner is empty. Trailing whitespace is dropped with or without a tag,
+// so tagging twice gives the same description.
+func withOwnerTag(description, owner string) string {
+	_, text := parseOwnerTag(description)
+	text = strings.TrimRight(text, " \t\n")
+	if owner == "" {
+		return text
+	}
+	if text == "" {
+		return fmt.Sprintf("[owner:%s]", owner)
+	}
+
+	return fmt.Sprintf("%s [owner:%s]", text, owner)
+}
+
+func subscriptionOwner(sub Subscription) string {
+	owner, _ := parseOwnerTag(sub.Description)
+	return owner
+}
+
+// Returns an error unless the subscription belongs to ownerTag or force is
+// set. A client without an owner tag owns the subscriptions without one.
+func checkSubscriptionOwner(sub Subscription, ownerTag string, force bool, action string) error {
+	owner := subscriptionOwner(sub)
+	if owner == ownerTag || force {
+		return nil
+	}
+
+	if owner == "" {
+		return fmt.Errorf("Refusing to %s subscription %s, it has no owner tag and '--owner-tag' is '%s', use '--force-foreign' to do it anyway", action, sub.ID, ownerTag)
+	}
+
+	return fmt.Errorf("Refusing to %s subscription %s owned by '%s', use '--force-foreign' to do it anyway", action, sub.ID, owner)
+}
+
+// Deletes the subscription if it passes checkSubscriptionOwner, so that a
+// subscription someone else has taken over since it was registered survives
+// the exit of this client
+func deleteOwnedSubscription(ctx context.Context, subscriptionIDOrName string) error {
+	sub, err := apiClient.FetchSubscription(ctx, subscriptionIDOrName)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch the subscription to check its owner. Error: %v", err)
+	}
+
+	err = checkSubscriptionOwner(sub, *ownerTagFlag, *forceForeignFlag, "delete")
+	if err != nil {
+		return err
+	}
+
+	return apiClient.DeleteSubscription(ctx, subscriptionIDOrName)
+}
+
+func filterSubscriptionsByOwner(subs []Subscription, owner string) []Subscription {
+	if owner == "" {
+		return subs
+	}
+	if owner == "-" {
+		owner = ""
+	}
+
+	var filtered []Subscription
+	for _, s := range subs {
+		if subscriptionOwner(s) == owner {
+			filtered = append(filtered, s)
+		}
+	}
+
+	return filtered
+}
+
+func validateOwnerTag(owner string) error {
+	if owner != "" && !validOwnerTag.MatchString(owner) {
+		return fmt.Errorf("The option '--owner-tag' can't contain whitespace or brackets")
+	}
+
+	return nil
+}
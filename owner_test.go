@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestParseOwnerTag(t *testing.T) {
+	tests := []struct {
+		description string
+		wantOwner   string
+		wantText    string
+	}{
+		{"", "", ""},
+		{"[owner:odds]", "odds", ""},
+		{"Live odds [owner:odds]", "odds", "Live odds"},
+		{"Live odds [owner:odds]  \n", "odds", "Live odds"},
+		{"Live odds\t[owner:odds]", "odds", "Live odds"},
+		{"Live odds", "", "Live odds"},
+		{"Live odds[owner:odds]", "", "Live odds[owner:odds]"},
+		{"[owner:odds] Live odds", "", "[owner:odds] Live odds"},
+		{"Moved from [owner:old] [owner:new]", "new", "Moved from [owner:old]"},
+		{"Live odds [owner:]", "", "Live odds [owner:]"},
+		{"Live odds [owner:two words]", "", "Live odds [owner:two words]"},
+		{"Multi\nline text [owner:team-a.b_c]", "team-a.b_c", "Multi\nline text"},
+	}
+
+	for _, test := range tests {
+		owner, text := parseOwnerTag(test.description)
+		if owner != test.wantOwner || text != test.wantText {
+			t.Errorf("parseOwnerTag(%q): got %q and %q, want %q and %q", test.description, owner, text, test.wantOwner, test.wantText)
+		}
+	}
+}
+
+func TestWithOwnerTag(t *testing.T) {
+	tests := []struct {
+		description string
+		owner       string
+		want        string
+	}{
+		{"", "odds", "[owner:odds]"},
+		{"Live odds", "odds", "Live odds [owner:odds]"},
+		{"Live odds  ", "odds", "Live odds [owner:odds]"},
+		{"Live odds  ", "", "Live odds"},
+		{"Live odds [owner:odds]", "odds", "Live odds [owner:odds]"},
+		{"Live odds [owner:other]", "odds", "Live odds [owner:odds]"},
+		{"Live odds [owner:odds]", "", "Live odds"},
+		{"[owner:odds]", "", ""},
+		{"Live odds", "", "Live odds"},
+		{"Text with [brackets] in it", "odds", "Text with [brackets] in it [owner:odds]"},
+	}
+
+	for _, test := range tests {
+		got := withOwnerTag(test.description, test.owner)
+		if got != test.want {
+			t.Errorf("withOwnerTag(%q, %q): got %q, want %q", test.description, test.owner, got, test.want)
+		}
+
+		// Tagging again changes nothing, and the tag reads back
+		if again := withOwnerTag(got, test.owner); again != got {
+			t.Errorf("tagging %q again with %q gave %q", got, test.owner, again)
+		}
+		if owner, _ := parseOwnerTag(got); owner != test.owner {
+			t.Errorf("the owner of %q is %q, want %q", got, owner, test.owner)
+		}
+	}
+}
+
+func TestCheckSubscriptionOwner(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		ownerTag    string
+		force       bool
+		wantErr     string // Part of the error, empty if allowed
+	}{
+		{"own tag", "Live odds [owner:odds]", "odds", false, ""},
+		{"no tags", "Live odds", "", false, ""},
+		{"other owner", "Live odds [owner:other]", "odds", false, "owned by 'other'"},
+		{"other owner, forced", "Live odds [owner:other]", "odds", true, ""},
+		{"untagged subscription", "Live odds", "odds", false, "has no owner tag"},
+		{"tagged subscription, client without tag", "Live odds [owner:other]", "", false, "owned by 'other'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sub := Subscription{ID: uuid.Must(uuid.NewV4()), Description: test.description}
+			err := checkSubscriptionOwner(sub, test.ownerTag, test.force, "delete")
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("got %v, want it allowed", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterSubscriptionsByOwner(t *testing.T) {
+	subs := []Subscription{
+		{Name: "a", Description: "[owner:odds]"},
+		{Name: "b", Description: "Untagged"},
+		{Name: "c", Description: "Other [owner:stats]"},
+		{Name: "d", Description: "Also [owner:odds]"},
+	}
+
+	tests := []struct {
+		owner string
+		want  []string
+	}{
+		{"", []string{"a", "b", "c", "d"}},
+		{"odds", []string{"a", "d"}},
+		{"-", []string{"b"}},
+		{"nobody", nil},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, s := range filterSubscriptionsByOwner(subs, test.owner) {
+			got = append(got, s.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("owner %q: got %q, want %q", test.owner, got, test.want)
+		}
+	}
+}
+
+func TestValidateOwnerTag(t *testing.T) {
+	for _, owner := range []string{"", "odds", "team-a.b_c"} {
+		if err := validateOwnerTag(owner); err != nil {
+			t.Errorf("%q was rejected: %v", owner, err)
+		}
+	}
+	for _, owner := range []string{"two words", "a[b", "a]b", "tab\there"} {
+		if err := validateOwnerTag(owner); err == nil {
+			t.Errorf("%q was accepted", owner)
+		}
+	}
+}
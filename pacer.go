@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token bucket that spaces out calls to the HTTP API so that the client
+// stays below the account's request rate limit. The bucket holds at most
+// one token, which means calls are spread evenly at the configured rate.
+type apiPacer struct {
+	mu       sync.Mutex
+	interval time.Duration // Time to refill one token
+	nextAt   time.Time     // When the next token is available
+
+	// The clock, replaced by the tests
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Set at startup if '--api-rate' is given, nil means no limit
+var pacer *apiPacer
+
+// Parses rates like '5/s' or '100/m' into the interval between calls
+func parseAPIRate(s string) (time.Duration, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("Invalid API rate '%s', must be on the form '5/s' or '100/m'", s)
+	}
+
+	n, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid API rate '%s', the number of calls must be positive", s)
+	}
+
+	var unit time.Duration
+	switch parts[1] {
+	case "s":
+		unit = time.Second
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	default:
+		return 0, fmt.Errorf("Invalid API rate '%s', the unit must be 's', 'm' or 'h'", s)
+	}
+
+	return time.Duration(float64(unit) / n), nil
+}
+
+func newAPIPacer(interval time.Duration) *apiPacer {
+	return &apiPacer{interval: interval, now: time.Now, sleep: sleepContext}
+}
+
+// Blocks until the call may be made, or until the context is done
+func (p *apiPacer) wait(ctx context.Context) error {
+	p.mu.Lock()
+	now := p.now()
+	at := p.nextAt
+	if at.Before(now) {
+		at = now
+	}
+	p.nextAt = at.Add(p.interval)
+	p.mu.Unlock()
+
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	log.Printf("[DEBUG] Waiting %s before the next API call to stay within '--api-rate'\n", roundDuration(delay, time.Millisecond))
+	err := p.sleep(ctx, delay)
+	if err != nil {
+		stats.apiThrottled(p.now().Sub(now))
+		return err
+	}
+	stats.apiThrottled(delay)
+
+	return nil
+}
+
+// Sends a request to the HTTP API, waiting for the pacer first if needed
+func doAPIRequest(req *http.Request) (*http.Response, error) {
+	if pacer != nil {
+		err := pacer.wait(req.Context())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return httpClient.Do(req)
+}
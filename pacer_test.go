@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// A clock that only moves when the pacer sleeps, and remembers the sleeps
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newFakePacer(t *testing.T, interval time.Duration) (*apiPacer, *fakeClock) {
+	discardLog(t)
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	p := newAPIPacer(interval)
+	p.now = func() time.Time { return clock.now }
+	p.sleep = func(ctx context.Context, d time.Duration) error {
+		clock.sleeps = append(clock.sleeps, d)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		clock.now = clock.now.Add(d)
+		return nil
+	}
+
+	return p, clock
+}
+
+func TestAPIPacerSpacing(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		// Time passing on its own before each call
+		idle []time.Duration
+		want []time.Duration
+	}{
+		{
+			"back to back",
+			200 * time.Millisecond,
+			[]time.Duration{0, 0, 0, 0},
+			[]time.Duration{200 * time.Millisecond, 200 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			"slower than the rate",
+			time.Second,
+			[]time.Duration{0, 2 * time.Second, time.Second, 3 * time.Second},
+			nil,
+		},
+		{
+			"part of the interval has passed",
+			time.Second,
+			[]time.Duration{0, 300 * time.Millisecond, 0},
+			[]time.Duration{700 * time.Millisecond, time.Second},
+		},
+		{
+			"a long pause doesn't save up calls",
+			time.Second,
+			[]time.Duration{0, time.Minute, 0, 0},
+			[]time.Duration{time.Second, time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, clock := newFakePacer(t, test.interval)
+			for i, d := range test.idle {
+				clock.now = clock.now.Add(d)
+				if err := p.wait(context.Background()); err != nil {
+					t.Fatalf("call %d: %v", i+1, err)
+				}
+			}
+
+			if len(clock.sleeps) != len(test.want) {
+				t.Fatalf("got the waits %v, want %v", clock.sleeps, test.want)
+			}
+			for i := range test.want {
+				if clock.sleeps[i] != test.want[i] {
+					t.Errorf("got the waits %v, want %v", clock.sleeps, test.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+// Calls waiting at the same time are queued one interval apart
+func TestAPIPacerQueuedCalls(t *testing.T) {
+	p, clock := newFakePacer(t, 100*time.Millisecond)
+	sleeps := make(chan time.Duration, 3)
+	p.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps <- d
+		return nil
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := p.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(sleeps)
+
+	want := 100 * time.Millisecond
+	for d := range sleeps {
+		if d != want {
+			t.Errorf("got a wait of %s, want %s", d, want)
+		}
+		want += 100 * time.Millisecond
+	}
+	if clock.now != time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("the clock moved")
+	}
+}
+
+func TestAPIPacerCancel(t *testing.T) {
+	p, _ := newFakePacer(t, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := p.wait(ctx); err != nil {
+		t.Fatalf("the first call waited: %v", err)
+	}
+	if err := p.wait(ctx); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestParseAPIRate(t *testing.T) {
+	tests := []struct {
+		rate    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"5/s", 200 * time.Millisecond, false},
+		{"100/m", 600 * time.Millisecond, false},
+		{"0.5/s", 2 * time.Second, false},
+		{"60/h", time.Minute, false},
+		{"5", 0, true},
+		{"0/s", 0, true},
+		{"-1/s", 0, true},
+		{"five/s", 0, true},
+		{"5/d", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseAPIRate(test.rate)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("parseAPIRate(%q): got %s and %v, want %s and an error %t", test.rate, got, err, test.want, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var pauseBufferFlag = flag.Int("pause-buffer", 1000, "Number of messages kept for printing on resume while the output is paused")
+
+// Holds back the printing of messages while paused. The websocket is
+// still read and messages still go to the sinks, only the terminal output
+// waits. When more messages than fit in the buffer arrive the oldest ones
+// are skipped.
+type outputPause struct {
+	mu       sync.Mutex
+	paused   bool
+	max      int
+	buffered []taggedMessage
+	skipped  int
+}
+
+var pause = outputPause{max: 1000}
+
+type taggedMessage struct {
+	tag      string
+	message  []byte
+	received time.Time
+}
+
+// Prints the message now, or on resume if paused
+func (p *outputPause) printMessage(tag string, message []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	received := time.Now()
+	if !p.paused {
+		printMessageOutput(tag, message, received)
+		return
+	}
+
+	p.buffered = append(p.buffered, taggedMessage{tag: tag, message: message, received: received})
+	if len(p.buffered) > p.max {
+		p.buffered = p.buffered[1:]
+		p.skipped++
+	}
+}
+
+func (p *outputPause) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused {
+		return
+	}
+	p.paused = true
+	log.Printf("[INFO] Output paused, up to %d messages are kept until it is resumed\n", p.max)
+}
+
+// Prints the messages that arrived while paused, noting how many didn't
+// fit in the buffer
+func (p *outputPause) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		return
+	}
+	p.paused = false
+
+	if p.skipped > 0 {
+		log.Printf("[WARN] Skipped %d messages that arrived while paused, the pause buffer was full\n", p.skipped)
+	}
+	log.Printf("[INFO] Output resumed, printing %d messages that arrived while paused\n", len(p.buffered))
+	for _, m := range p.buffered {
+		printMessageOutput(m.tag, m.message, m.received)
+	}
+	p.buffered = nil
+	p.skipped = 0
+}
+
+func (p *outputPause) toggle() {
+	if p.isPaused() {
+		p.resume()
+	} else {
+		p.pause()
+	}
+}
+
+func (p *outputPause) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.paused
+}
+
+func handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST to pause", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pause.pause()
+	w.Write([]byte("paused\n"))
+}
+
+func handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST to resume", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pause.resume()
+	w.Write([]byte("resumed\n"))
+}
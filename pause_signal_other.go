@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Ctrl-Z pauses the output instead of suspending the client, which would
+// stop reading from the websocket and get it disconnected as a slow
+// consumer. Pressing it again resumes the output.
+func setupPauseSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTSTP)
+
+	go func() {
+		for range sigs {
+			pause.toggle()
+		}
+	}()
+}
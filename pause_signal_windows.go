@@ -0,0 +1,6 @@
+package main
+
+// Windows has no SIGTSTP, the output can be paused with the control
+// endpoint
+func setupPauseSignal() {
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sends the lines written to the pipe on the returned channel
+func pipeLines(r *os.File) <-chan string {
+	lines := make(chan string, 100)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	return lines
+}
+
+// While paused the websocket is still read and every message reaches the
+// sinks, only the printing waits. On resume the messages that fit in the
+// pause buffer are printed in order and the rest are reported as skipped.
+func TestPauseKeepsReading(t *testing.T) {
+	const count = 5
+	discardLog(t)
+	pause.pause()
+	pause.max = 3
+	t.Cleanup(func() {
+		pause.resume()
+		pause.max = 1000
+	})
+
+	events := &drainEvents{}
+	handle := startDrainTest(t, newDrainServer(t, count, events), count, &slowSink{events: events}, time.Second)
+	lines := pipeLines(pipeStdout(t))
+	logged := captureLog(t)
+	handle()
+
+	waitForEvent(t, events, "deliver "+drainTestMessage(count))
+	select {
+	case line := <-lines:
+		t.Fatalf("printed while paused: %s", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	rec := httptest.NewRecorder()
+	handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var health healthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		t.Fatal(err)
+	}
+	if !health.Paused {
+		t.Error("/health doesn't show the output as paused")
+	}
+
+	rec = httptest.NewRecorder()
+	handleResume(rec, httptest.NewRequest(http.MethodPost, "/resume", nil))
+	if rec.Code != http.StatusOK || pause.isPaused() {
+		t.Fatalf("POST /resume answered %d and paused is %v", rec.Code, pause.isPaused())
+	}
+	for n := count - 2; n <= count; n++ {
+		select {
+		case line := <-lines:
+			if want := fmt.Sprintf(`"n":%d`, n); !strings.Contains(line, want) {
+				t.Errorf("printed %s, want the message with %s", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d wasn't printed on resume", n)
+		}
+	}
+	drainMessages(time.Second)
+
+	if !strings.Contains(logged.String(), "Skipped 2 messages that arrived while paused") {
+		t.Errorf("the skipped messages weren't reported:\n%s", logged)
+	}
+}
+
+func TestPauseEndpointsNeedPost(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{"/pause": handlePause, "/resume": handleResume}
+	for path, handler := range handlers {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s answered %d, want %d", path, rec.Code, http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set when the package is initialized, as close to the process start as
+// the client can get
+var processStartedAt = time.Now()
+
+// How long one phase of setting up a connection took
+type phaseTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// Splits the time from the start of the process, or from a disconnect, to
+// the first push message into phases. Each mark closes the phase that ended
+// now. Phases measured on their own, like fetching an access token, are
+// not counted in the phase they happened during.
+type connectionPhases struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	last      time.Time
+	excluded  time.Duration
+	phases    []phaseTiming
+	reconnect bool
+	done      bool // The first message has arrived
+}
+
+// Phases of the current connection, the startup until the first connection
+// has received a message and then the latest reconnect
+var currentPhases = &connectionPhases{startedAt: processStartedAt, last: processStartedAt}
+
+// Starts timing a reconnect after the connection was lost at startedAt
+func (p *connectionPhases) restart(startedAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.startedAt = startedAt
+	p.last = startedAt
+	p.excluded = 0
+	p.phases = nil
+	p.reconnect = true
+	p.done = false
+}
+
+func (p *connectionPhases) mark(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done {
+		return
+	}
+	now := time.Now()
+	p.phases = append(p.phases, phaseTiming{name: name, duration: now.Sub(p.last) - p.excluded})
+	p.last = now
+	p.excluded = 0
+}
+
+// Records a phase that was timed separately
+func (p *connectionPhases) measured(name string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done {
+		return
+	}
+	p.phases = append(p.phases, phaseTiming{name: name, duration: d})
+	p.excluded += d
+}
+
+// Closes the last phase when the first push message arrives. Returns the
+// phases and the total time the first time it is called, nil after that.
+func (p *connectionPhases) firstMessage() ([]phaseTiming, time.Duration, bool) {
+	p.mark("first_message")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done {
+		return nil, 0, false
+	}
+	p.done = true
+
+	return p.phases, p.last.Sub(p.startedAt), p.reconnect
+}
+
+func formatPhases(phases []phaseTiming) string {
+	parts := make([]string, 0, len(phases))
+	for _, phase := range phases {
+		parts = append(parts, fmt.Sprintf("%s %s", phase.name, roundDuration(phase.duration, time.Millisecond)))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Logs the breakdown of the connection setup once the first message has
+// arrived on a new connection
+func firstMessageReceived() {
+	phases, total, reconnect := currentPhases.firstMessage()
+	if phases == nil {
+		return
+	}
+
+	if reconnect {
+		log.Printf("[INFO] Reconnect took %s until the first message: %s\n", roundDuration(total, time.Millisecond), formatPhases(phases))
+	} else {
+		log.Printf("[INFO] Startup took %s until the first message: %s\n", roundDuration(total, time.Millisecond), formatPhases(phases))
+	}
+	stats.connectionPhases(phases, total, reconnect)
+}
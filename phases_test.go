@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// A phase measured on its own, like fetching a token, isn't counted in the
+// phase it happened during, and nothing is recorded after the first message
+func TestConnectionPhasesMeasured(t *testing.T) {
+	start := time.Now().Add(-100 * time.Millisecond)
+	p := &connectionPhases{startedAt: start, last: start}
+	p.measured("token", 60*time.Millisecond)
+	p.mark("config")
+
+	phases, total, reconnect := p.firstMessage()
+	if reconnect {
+		t.Error("the startup is timed as a reconnect")
+	}
+	if len(phases) != 3 || phases[0].name != "token" || phases[1].name != "config" || phases[2].name != "first_message" {
+		t.Fatalf("got phases %s", formatPhases(phases))
+	}
+	if d := phases[1].duration; d < 40*time.Millisecond || d > 60*time.Millisecond {
+		t.Errorf("config took %s, want the 100ms without the 60ms of the token", d)
+	}
+	if total < 100*time.Millisecond {
+		t.Errorf("the total is %s, want at least 100ms", total)
+	}
+
+	p.mark("late")
+	if phases, _, _ := p.firstMessage(); phases != nil {
+		t.Errorf("the phases were returned again: %s", formatPhases(phases))
+	}
+}
+
+// Delays the upgrade, the init message and the first message
+func newSlowSetupServer(t *testing.T, dial, init, first time.Duration) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(dial)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		time.Sleep(init)
+		msg := fmt.Sprintf(`{"channel": "system", "cmd": "init", "subscriber_id": "%s", "reconnect_token": "%s", "subscription": {"id": "%s"}, "reconnected": false}`, uuid.Must(uuid.NewV4()), testToken, testSubscriptionID)
+		conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		time.Sleep(first)
+		conn.WriteMessage(websocket.TextMessage, []byte(drainTestMessage(1)))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// The delays of the server are attributed to the dial, init and first
+// message phases, for the startup and for reconnects
+func TestConnectionPhasesAgainstServer(t *testing.T) {
+	const dial, init, first = 100 * time.Millisecond, 150 * time.Millisecond, 50 * time.Millisecond
+
+	for _, reconnect := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reconnect %v", reconnect), func(t *testing.T) {
+			logged := captureLog(t)
+			client, err := pushclient.New(pushclient.Config{
+				Addr: newSlowSetupServer(t, dial, init, first),
+				Auth: pushclient.NewSecretAuth("secret"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			apiClient = client
+			defer func(p *connectionPhases) { currentPhases = p }(currentPhases)
+			t.Cleanup(func() {
+				stopKeepAlive()
+				if conn := client.Conn(); conn != nil {
+					conn.Close()
+				}
+				apiClient = nil
+				stats.setConnected(false)
+			})
+
+			// The stats of earlier reconnects are kept, only the difference
+			// comes from this one
+			before := stats.snapshot().reconnectPhases
+			now := time.Now()
+			currentPhases = &connectionPhases{startedAt: now, last: now}
+			if reconnect {
+				currentPhases.restart(now)
+			}
+			if err := setupPushServiceConnection(context.Background(), uuid.Nil, testSubscriptionID.String()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.ReadMessage(); err != nil {
+				t.Fatal(err)
+			}
+			firstMessageReceived()
+			stopKeepAlive()
+
+			s := stats.snapshot()
+			got := make(map[string]time.Duration)
+			if reconnect {
+				for name, d := range s.reconnectPhases {
+					got[name] = d.total - before[name].total
+				}
+			} else {
+				for _, phase := range s.startupPhases {
+					got[phase.name] = phase.duration
+				}
+			}
+			want := map[string]time.Duration{"dial": dial, "init": init, "first_message": first}
+			// The phases start and end on the client, a little off the
+			// sleeps on the server
+			for name, d := range want {
+				if got[name] < d-20*time.Millisecond || got[name] > d+100*time.Millisecond {
+					t.Errorf("%s took %s, want about %s", name, got[name], d)
+				}
+			}
+
+			wantLog := "Startup took"
+			if reconnect {
+				wantLog = "Reconnect took"
+			}
+			if !strings.Contains(logged.String(), wantLog) {
+				t.Errorf("the log doesn't contain '%s':\n%s", wantLog, logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package pushclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Creates a request to the HTTP API with the credentials added, endpoint is
+// the path after the base URL, e.g. '/subscription'
+func (c *Client) NewRequest(ctx context.Context, method string, endpoint string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.apiBase+endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range c.config.Headers {
+		req.Header[name] = values
+	}
+	err = c.config.Auth.Apply(req)
+
+	return req, err
+}
+
+// How many times a request answered with 429 Too Many Requests is sent
+// again, and how long to wait before that if the server doesn't say
+const maxRateLimitRetries = 3
+const DefaultRetryAfter = 30 * time.Second
+
+// Sends a request created by NewRequest. A request answered with 429 Too
+// Many Requests is sent again after the time given in the Retry-After
+// header, or DefaultRetryAfter, at most maxRateLimitRetries times. A
+// request answered with 401 Unauthorized is sent once more with a new
+// token if the AuthProvider is a TokenInvalidator.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	refreshed := false
+	for retries := 0; ; {
+		sentAt := time.Now()
+		resp, err := c.config.HTTPClient.Do(req)
+		if err != nil {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			// The body can't be sent again
+			return resp, err
+		}
+
+		tokens, refreshable := c.config.Auth.(TokenInvalidator)
+		if resp.StatusCode == http.StatusUnauthorized && refreshable && !refreshed {
+			refreshed = true
+			resp.Body.Close()
+			c.config.Logf("[WARN] The API rejected the access token, retrying with a new one\n")
+			tokens.InvalidateToken(sentAt)
+		} else if resp.StatusCode == http.StatusTooManyRequests && retries < maxRateLimitRetries {
+			retries++
+			resp.Body.Close()
+
+			wait, ok := retryAfter(resp.Header, time.Now())
+			if ok {
+				c.config.Logf("[WARN] Rate limited by the API, retrying in %s as asked by the Retry-After header\n", wait)
+			} else {
+				wait = DefaultRetryAfter
+				c.config.Logf("[WARN] Rate limited by the API, retrying in %s (default, no usable Retry-After header)\n", wait)
+			}
+
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-req.Context().Done():
+				t.Stop()
+				return nil, req.Context().Err()
+			}
+		} else {
+			return resp, nil
+		}
+
+		// The token may have been renewed meanwhile
+		req = req.Clone(req.Context())
+		err = c.config.Auth.Apply(req)
+		if err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Returns how long the Retry-After header asks the client to wait, given
+// either as a number of seconds or as an HTTP date. ok is false if the
+// header is missing or can't be parsed.
+func retryAfter(h http.Header, now time.Time) (wait time.Duration, ok bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	at, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if at.Before(now) {
+		return 0, true
+	}
+
+	return at.Sub(now), true
+}
+
+// Returns the raw response of the '/config' endpoint
+func (c *Client) FetchConfig(ctx context.Context) ([]byte, error) {
+	req, err := c.NewRequest(ctx, http.MethodGet, "/config", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+
+	return respBody, err
+}
+
+// Returns the parsed response of the '/config' endpoint
+func (c *Client) FetchPushServiceConfig(ctx context.Context) (PushServiceConfig, error) {
+	var config PushServiceConfig
+	b, err := c.FetchConfig(ctx)
+	if err != nil {
+		return config, err
+	}
+
+	err = json.Unmarshal(b, &config)
+
+	return config, err
+}
+
+// Returns the raw list of all registered subscriptions
+func (c *Client) FetchSubscriptions(ctx context.Context) ([]byte, error) {
+	req, err := c.NewRequest(ctx, http.MethodGet, "/subscription", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+
+	return respBody, err
+}
+
+// Returns the API path of a subscription. Names may contain characters
+// like spaces and '/' that must be escaped.
+func subscriptionPath(subscriptionIDOrName string) string {
+	return "/subscription/" + url.PathEscape(subscriptionIDOrName)
+}
+
+func (c *Client) FetchSubscription(ctx context.Context, subscriptionIDOrName string) (Subscription, error) {
+	sub, _, _, err := c.FetchSubscriptionIfChanged(ctx, subscriptionIDOrName, "")
+	return sub, err
+}
+
+// Fetches the subscription unless its ETag is still etag, in which case
+// notModified is true. The ETag of the returned subscription is empty if
+// the server doesn't set one.
+func (c *Client) FetchSubscriptionIfChanged(ctx context.Context, subscriptionIDOrName string, etag string) (sub Subscription, newETag string, notModified bool, err error) {
+	req, err := c.NewRequest(ctx, http.MethodGet, subscriptionPath(subscriptionIDOrName), nil)
+	if err != nil {
+		return Subscription{}, "", false, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return Subscription{}, "", false, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Subscription{}, "", false, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return Subscription{}, etag, true, nil
+	} else if resp.StatusCode == http.StatusNotFound {
+		return Subscription{}, "", false, ErrSubscriptionNotFound
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		return Subscription{}, "", false, ErrAPIRateLimited
+	} else if resp.StatusCode != http.StatusOK {
+		return Subscription{}, "", false, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+
+	err = json.Unmarshal(respBody, &sub)
+
+	return sub, resp.Header.Get("ETag"), false, err
+}
+
+// Registers the subscription and returns its ID. If a subscription with
+// the same name is already registered its ID is returned and alreadyExists
+// is true, the existing subscription is not changed.
+func (c *Client) RegisterSubscription(ctx context.Context, sub Subscription) (id uuid.UUID, alreadyExists bool, err error) {
+	j, _ := json.Marshal(sub)
+
+	req, err := c.NewRequest(ctx, http.MethodPost, "/subscription", bytes.NewBuffer(j))
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	// The subscription POST endpoint response have 2 normal status codes:
+	//  * Unprocessable Entity (422)
+	//    This is returned by the server if client tries to register a subscription
+	//    with a name that has already been registered on the server.
+	//  * OK (200)
+	//    If the registration was successful
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		var existingID uuid.UUID
+
+		// If we get HTTP response code 422 the server has also set
+		// the 'Location' header with the ID of the existing subscription
+		if resp.Header.Get("Location") != "" {
+			existingID, err = uuid.FromString(resp.Header.Get("Location"))
+			if err != nil {
+				// Location header didn't contain a valid UUID
+				return uuid.Nil, true, err
+			}
+
+			return existingID, true, nil
+		}
+
+		// Server didn't set a valid ID in the 'Location' header, this should never happen
+		return uuid.Nil, true, ErrExistingSubscriptionUnknown
+	} else if resp.StatusCode != http.StatusOK {
+		return uuid.Nil, false, fmt.Errorf("Unexpected status code: %d. Response message: %s", resp.StatusCode, string(respBody))
+	}
+
+	var s struct {
+		ID uuid.UUID `json:"id"`
+	}
+	err = json.Unmarshal(respBody, &s)
+
+	return s.ID, false, err
+}
+
+// Replaces the subscription with the ID of sub
+func (c *Client) UpdateSubscription(ctx context.Context, sub Subscription) (uuid.UUID, bool, error) {
+	endpoint := subscriptionPath(sub.ID.String())
+	j, err := json.Marshal(sub)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	req, err := c.NewRequest(ctx, http.MethodPut, endpoint, bytes.NewBuffer(j))
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return uuid.Nil, true, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return uuid.Nil, false, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+
+	var s struct {
+		ID uuid.UUID `json:"id"`
+	}
+	err = json.Unmarshal(respBody, &s)
+
+	return s.ID, false, err
+}
+
+// Deletes the subscription. Returns ErrSubscriptionNotFound if there is no
+// subscription with the ID or name.
+func (c *Client) DeleteSubscription(ctx context.Context, subscriptionIDOrName string) error {
+	endpoint := subscriptionPath(subscriptionIDOrName)
+	req, err := c.NewRequest(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrSubscriptionNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
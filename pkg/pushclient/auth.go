@@ -0,0 +1,262 @@
+package pushclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Adds the credentials to the requests sent to the push service. Apply is
+// used for the HTTP API, WebsocketHeaders and QueryParams for the websocket
+// handshake. Providers that use short-lived tokens refresh them themselves,
+// with the context of the request.
+type AuthProvider interface {
+	Apply(req *http.Request) error
+	WebsocketHeaders(ctx context.Context) (http.Header, error)
+	QueryParams(ctx context.Context) (url.Values, error)
+}
+
+// Implemented by providers with short-lived tokens. Requests rejected with
+// 401 Unauthorized are sent once more after InvalidateToken, with the time
+// the rejected request was sent.
+type TokenInvalidator interface {
+	InvalidateToken(rejectedAt time.Time)
+}
+
+// Atlas v3 authentication, the secret is sent in a header
+func NewSecretAuth(secret string) AuthProvider {
+	return &v3SecretAuth{secret: secret}
+}
+
+type v3SecretAuth struct {
+	secret string
+}
+
+func (a *v3SecretAuth) Apply(req *http.Request) error {
+	req.Header["Abios-Secret"] = []string{a.secret}
+
+	return nil
+}
+
+func (a *v3SecretAuth) WebsocketHeaders(ctx context.Context) (http.Header, error) {
+	return http.Header{"Abios-Secret": []string{a.secret}}, nil
+}
+
+func (a *v3SecretAuth) QueryParams(ctx context.Context) (url.Values, error) {
+	return nil, nil
+}
+
+// v2 authentication with the access token in the 'access_token' parameter
+func NewV2QueryAuth(tokens *V2TokenSource) AuthProvider {
+	return &v2QueryAuth{tokens: tokens}
+}
+
+type v2QueryAuth struct {
+	tokens *V2TokenSource
+}
+
+func (a *v2QueryAuth) Apply(req *http.Request) error {
+	q, err := a.QueryParams(req.Context())
+	if err != nil {
+		return err
+	}
+
+	values := req.URL.Query()
+	for k, v := range q {
+		values[k] = v
+	}
+	req.URL.RawQuery = values.Encode()
+
+	return nil
+}
+
+func (a *v2QueryAuth) InvalidateToken(rejectedAt time.Time) {
+	a.tokens.Invalidate(rejectedAt)
+}
+
+func (a *v2QueryAuth) WebsocketHeaders(ctx context.Context) (http.Header, error) {
+	return nil, nil
+}
+
+func (a *v2QueryAuth) QueryParams(ctx context.Context) (url.Values, error) {
+	token, err := a.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return url.Values{"access_token": []string{token}}, nil
+}
+
+// v2 authentication with the access token in an 'Authorization: Bearer'
+// header, for gateways that don't accept tokens in the URL
+func NewV2HeaderAuth(tokens *V2TokenSource) AuthProvider {
+	return &v2HeaderAuth{tokens: tokens}
+}
+
+type v2HeaderAuth struct {
+	tokens *V2TokenSource
+}
+
+func (a *v2HeaderAuth) Apply(req *http.Request) error {
+	h, err := a.WebsocketHeaders(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", h.Get("Authorization"))
+
+	return nil
+}
+
+func (a *v2HeaderAuth) InvalidateToken(rejectedAt time.Time) {
+	a.tokens.Invalidate(rejectedAt)
+}
+
+func (a *v2HeaderAuth) WebsocketHeaders(ctx context.Context) (http.Header, error) {
+	token, err := a.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Header{"Authorization": []string{"Bearer " + token}}, nil
+}
+
+func (a *v2HeaderAuth) QueryParams(ctx context.Context) (url.Values, error) {
+	return nil, nil
+}
+
+// Base URL of the v2 access token endpoint
+const DefaultAccessTokenURL = "https://api.abiosgaming.com/v2"
+
+// Tokens are renewed this long before they expire, or halfway through
+// their lifetime if they are valid for less than twice this
+const v2TokenExpiryMargin = 60 * time.Second
+
+// Creates v2 access tokens from the client id and secret, reusing a token
+// until it is about to expire. Safe for concurrent use, only one request
+// for a new token is sent at a time.
+type V2TokenSource struct {
+	// Base URL of the access token endpoint, DefaultAccessTokenURL if empty
+	URL          string
+	ClientID     string
+	ClientSecret string
+	// Called for the client secret before every access token request
+	// instead of using ClientSecret, if set, so that a rotated secret is
+	// picked up. Optional.
+	LoadClientSecret func() (string, error)
+	// Called with the duration of every access token request, optional
+	OnTokenRequest func(took time.Duration)
+
+	mu          sync.Mutex
+	accessToken string
+	issuedAt    time.Time
+	expiresAt   time.Time // When the token is renewed, before it expires. Zero if it doesn't expire.
+}
+
+// Returns a valid access token, requesting a new one if needed
+func (s *V2TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && (s.expiresAt.IsZero() || time.Now().Before(s.expiresAt)) {
+		return s.accessToken, nil
+	}
+
+	baseURL := s.URL
+	if baseURL == "" {
+		baseURL = DefaultAccessTokenURL
+	}
+
+	secret := s.ClientSecret
+	if s.LoadClientSecret != nil {
+		var err error
+		secret, err = s.LoadClientSecret()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	requestedAt := time.Now()
+	token, expiresIn, err := requestAccessToken(ctx, baseURL, s.ClientID, secret)
+	if err != nil {
+		return "", fmt.Errorf("Access token request failed. Error: %v", err)
+	}
+	if s.OnTokenRequest != nil {
+		s.OnTokenRequest(time.Since(requestedAt))
+	}
+
+	margin := v2TokenExpiryMargin
+	if expiresIn < 2*margin {
+		margin = expiresIn / 2
+	}
+	s.accessToken = token
+	s.issuedAt = time.Now()
+	s.expiresAt = s.issuedAt.Add(expiresIn - margin)
+	if expiresIn <= 0 {
+		// The server didn't say how long the token is valid, it is used
+		// until a request is rejected with it
+		s.expiresAt = time.Time{}
+	}
+
+	return token, nil
+}
+
+// Makes the next Token request a new token, unless the current one was
+// issued after rejectedAt. Requests that were rejected at the same time
+// then cause only one new token.
+func (s *V2TokenSource) Invalidate(rejectedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.issuedAt.Before(rejectedAt) {
+		s.accessToken = ""
+	}
+}
+
+// Creates a v2 access token, returns it together with how long it is valid
+func requestAccessToken(ctx context.Context, baseURL string, clientID string, clientSecret string) (string, time.Duration, error) {
+	URL := baseURL + "/oauth/access_token"
+	form := url.Values{}
+	form.Add("client_id", clientID)
+	form.Add("client_secret", clientSecret)
+	form.Add("grant_type", "client_credentials")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("Failed to read response body. Error: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	}
+
+	var authResponse struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+	err = json.Unmarshal(respBody, &authResponse)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return authResponse.AccessToken, time.Duration(authResponse.ExpiresIn) * time.Second, nil
+}
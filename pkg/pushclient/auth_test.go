@@ -0,0 +1,422 @@
+package pushclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Hands out the access tokens 'token-1', 'token-2' and so on, valid for
+// expiresIn seconds, to the client 'id' with the secret 'client-secret'
+type tokenServer struct {
+	url       string
+	expiresIn int
+
+	mu       sync.Mutex
+	requests int
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	t.Helper()
+
+	s := &tokenServer{expiresIn: expiresIn}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/access_token" || r.Method != "POST" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.PostFormValue("client_id") != "id" || r.PostFormValue("client_secret") != "client-secret" || r.PostFormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		s.mu.Lock()
+		s.requests++
+		n := s.requests
+		s.mu.Unlock()
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": %d, "token_type": "bearer"}`, n, s.expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	s.url = server.URL
+
+	return s
+}
+
+func (s *tokenServer) tokenRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+}
+
+func (s *tokenServer) tokens() *V2TokenSource {
+	return &V2TokenSource{URL: s.url, ClientID: "id", ClientSecret: "client-secret"}
+}
+
+// The credentials found in a request, as '<where>:<value>'
+func receivedCredentials(r *http.Request) []string {
+	var found []string
+	if v := r.Header.Get("Abios-Secret"); v != "" {
+		found = append(found, "header Abios-Secret:"+v)
+	}
+	if v := r.Header.Get("Authorization"); v != "" {
+		found = append(found, "header Authorization:"+v)
+	}
+	if v := r.URL.Query().Get("access_token"); v != "" {
+		found = append(found, "query access_token:"+v)
+	}
+
+	return found
+}
+
+// A push service that notes the credentials of every HTTP request and
+// websocket handshake, and rejects those without the accepted ones
+type credentialServer struct {
+	url string
+
+	mu       sync.Mutex
+	accepted string
+	received [][]string
+}
+
+func newCredentialServer(t *testing.T, accepted string) *credentialServer {
+	t.Helper()
+
+	s := &credentialServer{accepted: accepted}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		found := receivedCredentials(r)
+		s.mu.Lock()
+		s.received = append(s.received, found)
+		ok := len(found) == 1 && found[0] == s.accepted
+		s.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !websocket.IsWebSocketUpgrade(r) {
+			w.Write([]byte("[]"))
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	t.Cleanup(server.Close)
+	s.url = "ws" + strings.TrimPrefix(server.URL, "http")
+
+	return s
+}
+
+func (s *credentialServer) accept(credentials string) {
+	s.mu.Lock()
+	s.accepted = credentials
+	s.mu.Unlock()
+}
+
+// The credentials of every request so far, one request per line
+func (s *credentialServer) requests() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []string
+	for _, found := range s.received {
+		lines = append(lines, strings.Join(found, ", "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func newAuthClient(t *testing.T, addr string, auth AuthProvider) *Client {
+	t.Helper()
+
+	c, err := New(Config{Addr: addr, Auth: auth})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// Sends one HTTP API request and one websocket handshake
+func callAPIAndDial(t *testing.T, c *Client) {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, err := c.FetchSubscriptions(ctx); err != nil {
+		t.Fatalf("the API request failed: %v", err)
+	}
+	conn, err := c.Dial(ctx, uuid.Nil, "sub")
+	if err != nil {
+		t.Fatalf("the handshake failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestAuthProviders(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     func(tokens *V2TokenSource) AuthProvider
+		accepted string
+	}{
+		{
+			"v3 secret",
+			func(*V2TokenSource) AuthProvider { return NewSecretAuth("secret") },
+			"header Abios-Secret:secret",
+		},
+		{
+			"v2 query",
+			func(tokens *V2TokenSource) AuthProvider { return NewV2QueryAuth(tokens) },
+			"query access_token:token-1",
+		},
+		{
+			"v2 header",
+			func(tokens *V2TokenSource) AuthProvider { return NewV2HeaderAuth(tokens) },
+			"header Authorization:Bearer token-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer := newTokenServer(t, 3600)
+			server := newCredentialServer(t, test.accepted)
+			c := newAuthClient(t, server.url, test.auth(tokenServer.tokens()))
+
+			callAPIAndDial(t, c)
+			callAPIAndDial(t, c)
+
+			want := strings.Repeat(test.accepted+"\n", 3) + test.accepted
+			if got := server.requests(); got != want {
+				t.Errorf("the server got the credentials\n%s\nwant\n%s", got, want)
+			}
+			// The token is reused by all four requests
+			if got := tokenServer.tokenRequests(); got > 1 {
+				t.Errorf("%d access tokens were requested, want at most 1", got)
+			}
+		})
+	}
+}
+
+// A rejected token is replaced once, by the HTTP API and the handshake alike
+func TestAuthProvidersRenewRejectedToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   func(tokens *V2TokenSource) AuthProvider
+		format string
+	}{
+		{"v2 query", func(tokens *V2TokenSource) AuthProvider { return NewV2QueryAuth(tokens) }, "query access_token:token-%d"},
+		{"v2 header", func(tokens *V2TokenSource) AuthProvider { return NewV2HeaderAuth(tokens) }, "header Authorization:Bearer token-%d"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer := newTokenServer(t, 3600)
+			server := newCredentialServer(t, fmt.Sprintf(test.format, 2))
+			c := newAuthClient(t, server.url, test.auth(tokenServer.tokens()))
+			ctx := context.Background()
+
+			if _, err := c.FetchSubscriptions(ctx); err != nil {
+				t.Fatalf("the API request failed: %v", err)
+			}
+			server.accept(fmt.Sprintf(test.format, 3))
+			conn, err := c.Dial(ctx, uuid.Nil, "sub")
+			if err != nil {
+				t.Fatalf("the handshake failed: %v", err)
+			}
+			conn.Close()
+
+			want := strings.Join([]string{
+				fmt.Sprintf(test.format, 1),
+				fmt.Sprintf(test.format, 2),
+				fmt.Sprintf(test.format, 2),
+				fmt.Sprintf(test.format, 3),
+			}, "\n")
+			if got := server.requests(); got != want {
+				t.Errorf("the server got the credentials\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+// The secret auth has no token to renew, the 401 is returned as it is
+func TestSecretAuthRejected(t *testing.T) {
+	server := newCredentialServer(t, "header Abios-Secret:other")
+	c := newAuthClient(t, server.url, NewSecretAuth("secret"))
+
+	_, err := c.Dial(context.Background(), uuid.Nil, "sub")
+	setupErr, ok := err.(*WebsocketSetupHTTPError)
+	if !ok || setupErr.HttpStatus != http.StatusUnauthorized {
+		t.Fatalf("got %v, want a 401 setup error", err)
+	}
+	if got := server.requests(); got != "header Abios-Secret:secret" {
+		t.Errorf("the server got the credentials %q, want one handshake", got)
+	}
+}
+
+func TestV2TokenSourceExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+		// Time passing between the two Token calls, as if the token was
+		// issued that long ago
+		age  time.Duration
+		want string
+	}{
+		{"fresh", 3600, 0, "token-1"},
+		{"within the margin", 3600, 3600*time.Second - v2TokenExpiryMargin + time.Second, "token-2"},
+		{"short-lived, before half its lifetime", 10, 4 * time.Second, "token-1"},
+		{"short-lived, after half its lifetime", 10, 6 * time.Second, "token-2"},
+		{"without expiry", 0, 365 * 24 * time.Hour, "token-1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer := newTokenServer(t, test.expiresIn)
+			tokens := tokenServer.tokens()
+			ctx := context.Background()
+
+			if _, err := tokens.Token(ctx); err != nil {
+				t.Fatal(err)
+			}
+			tokens.mu.Lock()
+			tokens.issuedAt = tokens.issuedAt.Add(-test.age)
+			if !tokens.expiresAt.IsZero() {
+				tokens.expiresAt = tokens.expiresAt.Add(-test.age)
+			}
+			tokens.mu.Unlock()
+
+			got, err := tokens.Token(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// A token without expiry is still replaced once it is rejected, but only
+// by requests sent after it was issued
+func TestV2TokenSourceInvalidate(t *testing.T) {
+	tokenServer := newTokenServer(t, 0)
+	tokens := tokenServer.tokens()
+	ctx := context.Background()
+
+	rejectedBefore := time.Now().Add(-time.Second)
+	if _, err := tokens.Token(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tokens.Invalidate(rejectedBefore)
+	if got, _ := tokens.Token(ctx); got != "token-1" {
+		t.Errorf("a rejection from before the token replaced it, got %q", got)
+	}
+
+	tokens.Invalidate(time.Now().Add(time.Second))
+	if got, _ := tokens.Token(ctx); got != "token-2" {
+		t.Errorf("got %q after the rejection, want %q", got, "token-2")
+	}
+}
+
+func TestV2TokenSourceWrongSecret(t *testing.T) {
+	tokenServer := newTokenServer(t, 3600)
+	tokens := tokenServer.tokens()
+	tokens.LoadClientSecret = func() (string, error) { return "rotated", nil }
+
+	if _, err := tokens.Token(context.Background()); err == nil {
+		t.Errorf("a token was issued for the wrong secret")
+	}
+}
+
+// A push service for a whole startup, answering the config, subscription
+// and websocket requests that carry the accepted credentials
+func newStartupServer(t *testing.T, accepted string) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		found := receivedCredentials(r)
+		if len(found) != 1 || found[0] != accepted {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case websocket.IsWebSocketUpgrade(r):
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			init := fmt.Sprintf(`{"channel":"system","cmd":"init","reconnect_token":"%s","reconnected":false}`, testReconnectToken)
+			conn.WriteMessage(websocket.TextMessage, []byte(init))
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		case strings.HasSuffix(r.URL.Path, "/config"):
+			w.Write([]byte("{}"))
+		case r.Method == http.MethodGet:
+			w.Write([]byte("[]"))
+		case r.Method == http.MethodPost:
+			fmt.Fprintf(w, `{"id": "%s"}`, uuid.Must(uuid.NewV4()))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// The calls of a client's startup and exit share one access token
+func TestV2TokenReusedForStartup(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     func(tokens *V2TokenSource) AuthProvider
+		accepted string
+	}{
+		{"v2 query", func(tokens *V2TokenSource) AuthProvider { return NewV2QueryAuth(tokens) }, "query access_token:token-1"},
+		{"v2 header", func(tokens *V2TokenSource) AuthProvider { return NewV2HeaderAuth(tokens) }, "header Authorization:Bearer token-1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenServer := newTokenServer(t, 3600)
+			c := newAuthClient(t, newStartupServer(t, test.accepted), test.auth(tokenServer.tokens()))
+			ctx := context.Background()
+
+			if _, err := c.FetchConfig(ctx); err != nil {
+				t.Fatalf("fetching the config failed: %v", err)
+			}
+			if _, err := c.FetchSubscriptions(ctx); err != nil {
+				t.Fatalf("fetching the subscriptions failed: %v", err)
+			}
+			id, _, err := c.RegisterSubscription(ctx, Subscription{Name: "sub"})
+			if err != nil {
+				t.Fatalf("registering the subscription failed: %v", err)
+			}
+			if _, err := c.Connect(ctx, id.String(), uuid.Nil); err != nil {
+				t.Fatalf("connecting failed: %v", err)
+			}
+			c.Conn().Close()
+			if err := c.DeleteSubscription(ctx, id.String()); err != nil {
+				t.Fatalf("deleting the subscription failed: %v", err)
+			}
+
+			if got := tokenServer.tokenRequests(); got != 1 {
+				t.Errorf("%d access tokens were requested, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package pushclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// The address of the push service used when Config.Addr is empty
+const DefaultAddr = "wss://ws.abiosgaming.com/v0"
+
+// Sends HTTP requests, satisfied by *http.Client. Lets the caller add e.g.
+// rate limiting around the requests to the HTTP API.
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Settings of a Client, only Auth is required
+type Config struct {
+	// Websocket address of the push service, DefaultAddr if empty
+	Addr string
+	// Base URL of the HTTP API, derived from Addr if empty
+	APIAddr string
+	// Adds the credentials to the requests, see NewSecretAuth and
+	// NewV2QueryAuth
+	Auth AuthProvider
+	// Used for the HTTP API, a client with a 10 second timeout if nil
+	HTTPClient HTTPDoer
+	// Used for the websocket connection, websocket.DefaultDialer if nil
+	Dialer *websocket.Dialer
+	// Added to every HTTP API request and to the websocket handshake, e.g.
+	// for a gateway in front of the push service. The headers set by Auth
+	// take precedence. Optional.
+	Headers http.Header
+	// Called with a line about unexpected frames from the server, the line
+	// starts with a level tag like '[WARN]'. Optional.
+	Logf func(format string, v ...interface{})
+}
+
+// A client of the push service. The HTTP API methods can be used at any
+// time, Connect sets up the websocket that ReadMessage reads from, or that
+// Start delivers on Messages.
+type Client struct {
+	config  Config
+	apiBase string
+
+	mu             sync.Mutex
+	conn           *websocket.Conn
+	closeReceived  chan struct{} // Closed when the server's close frame has been read from conn
+	subscription   string        // ID or name of the subscription of the latest connection
+	reconnectToken uuid.UUID     // From the init message of the latest connection
+	decode         FrameDecoder
+	pending        [][]byte      // Push messages that arrived before the init message
+	readTimeout    time.Duration // See SetReadTimeout
+
+	started    bool // Start has been called
+	messages   chan PushMessage
+	errors     chan error
+	queue      chan received // For Receive, nil unless Start was given a ReceiveBuffer
+	reconnects bool          // See StreamOptions.ReportReconnects
+	closed     chan struct{} // Closed by the first Close
+	closeOnce  sync.Once
+	writers    []*messageWriter   // See AddWriter
+	handlers   map[string]Handler // By channel, see On
+	anyHandler Handler
+}
+
+func New(config Config) (*Client, error) {
+	if config.Auth == nil {
+		return nil, fmt.Errorf("The config has no Auth")
+	}
+	if config.Addr == "" {
+		config.Addr = DefaultAddr
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: time.Second * 10}
+	}
+	if config.Dialer == nil {
+		config.Dialer = websocket.DefaultDialer
+	}
+	if config.Logf == nil {
+		config.Logf = func(string, ...interface{}) {}
+	}
+
+	u, err := url.Parse(config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid websocket address '%s'. Error: %v", config.Addr, err)
+	}
+
+	c := &Client{
+		config:   config,
+		decode:   decodeJSONFrame,
+		messages: make(chan PushMessage),
+		errors:   make(chan error),
+		closed:   make(chan struct{}),
+	}
+	c.apiBase = strings.TrimSuffix(config.APIAddr, "/")
+	if c.apiBase == "" {
+		c.apiBase = buildHTTPURLFromWSURL(u)
+	}
+
+	return c, nil
+}
+
+func buildHTTPURLFromWSURL(u *url.URL) string {
+	h := *u
+	if u.Scheme == "wss" {
+		h.Scheme = "https"
+	} else {
+		h.Scheme = "http"
+	}
+
+	return h.String()
+}
+
+// The base URL of the HTTP API
+func (c *Client) APIAddr() string {
+	return c.apiBase
+}
+
+func (c *Client) Config() Config {
+	return c.config
+}
+
+// The websocket of the latest Connect, nil before connecting
+func (c *Client) Conn() *websocket.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn
+}
+
+// Closes the websocket with the close handshake: a close frame with
+// websocket.CloseNormalClosure is sent, and the network connection is closed
+// once the server's close frame has been read or after closeTimeout. The
+// server's answer is read by Start, or by the caller's ReadMessage loop.
+// Closing a websocket that is already closing is not an error. Receive
+// returns ErrClientClosed from then on.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+
+	return c.closeConn()
+}
+
+// Closes the current websocket like Close, but only the websocket: the
+// stream started by Start reconnects as for any other lost connection, and
+// Receive goes on with ErrReconnected, if reported, and the messages of the
+// new connection. Used to make a changed subscription take effect.
+func (c *Client) Reconnect() error {
+	return c.closeConn()
+}
+
+// Does the close handshake on the current websocket
+func (c *Client) closeConn() error {
+	c.mu.Lock()
+	conn, closeReceived := c.conn, c.closeReceived
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, closeReason)
+	err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeTimeout))
+	if err == websocket.ErrCloseSent {
+		return nil
+	} else if err != nil {
+		conn.Close()
+		return fmt.Errorf("Failed to send Close message. Error: %v", err)
+	}
+
+	select {
+	case <-closeReceived:
+	case <-time.After(closeTimeout):
+		c.config.Logf("[WARN] The server did not answer the close frame within %s, closing the connection anyway\n", closeTimeout)
+	}
+
+	return conn.Close()
+}
+
+// Answers a close frame from the server with the same code, like the
+// default handler of the websocket library, and notes that it arrived. The
+// answer is written during the ReadMessage that returns the close error,
+// before the caller reconnects or shuts down.
+func closeHandler(conn *websocket.Conn, closeReceived chan struct{}) func(code int, text string) error {
+	return func(code int, text string) error {
+		close(closeReceived)
+
+		message := []byte{}
+		if code != websocket.CloseNoStatusReceived {
+			message = websocket.FormatCloseMessage(code, "")
+		}
+		err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeTimeout))
+		if err != nil && err != websocket.ErrCloseSent {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// Makes ReadMessage fail when nothing has been received for d, so that a
+// connection where the server stopped responding is noticed and, with
+// Start, replaced. Every message extends the deadline, a pong handler
+// should extend it with ExtendReadDeadline. Zero, the default, waits
+// forever.
+func (c *Client) SetReadTimeout(d time.Duration) {
+	c.mu.Lock()
+	c.readTimeout = d
+	c.mu.Unlock()
+}
+
+// Moves the read deadline of the current websocket to the read timeout
+// from now, does nothing without a read timeout
+func (c *Client) ExtendReadDeadline() error {
+	c.mu.Lock()
+	conn, timeout := c.conn, c.readTimeout
+	c.mu.Unlock()
+
+	if conn == nil || timeout <= 0 {
+		return nil
+	}
+
+	return conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// Sends a ping on the current websocket, failing if it can't be written
+// within writeTimeout. Safe to call while the stream reconnects, the ping
+// then goes to the old connection and fails, or to the new one.
+// ErrNotConnected is returned before Connect.
+func (c *Client) Ping(writeTimeout time.Duration) error {
+	conn := c.Conn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	return conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(writeTimeout))
+}
@@ -0,0 +1,351 @@
+package pushclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Run with -race: pings are sent while the stream replaces the connection,
+// they must go to the old or the new connection and never panic
+func TestPingDuringReconnect(t *testing.T) {
+	const connections = 10
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {
+		conn.WriteMessage(websocket.TextMessage, testMessage(n))
+		if n < connections {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Fails while the connection is being replaced
+				c.Ping(time.Second)
+				c.ExtendReadDeadline()
+			}
+		}()
+	}
+
+	for n := 1; n <= connections; n++ {
+		m, err := c.Receive(ctx)
+		if err != nil {
+			t.Fatalf("message %d: %v", n, err)
+		}
+		if got := testMessageNumber(t, m); got != n {
+			t.Fatalf("got the message of connection %d, want %d", got, n)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if err := c.Ping(time.Second); err != nil {
+		t.Errorf("ping on the last connection failed: %v", err)
+	}
+}
+
+func TestPingBeforeConnect(t *testing.T) {
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {})
+
+	if err := c.Ping(time.Second); err != ErrNotConnected {
+		t.Errorf("got %v, want %v", err, ErrNotConnected)
+	}
+}
+
+// The close frame the test server got from the client
+type receivedClose struct {
+	code int
+	text string
+}
+
+// A push service that sends the init message, calls serve and then reads
+// until the client closes the connection. The close frame from the client
+// is put on the returned channel, or a code of -1 if the connection ended
+// without one.
+func newCloseServer(t *testing.T, serve func(conn *websocket.Conn)) (*Client, <-chan receivedClose) {
+	t.Helper()
+
+	closes := make(chan receivedClose, 1)
+	upgrader := websocket.Upgrader{}
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		init := `{"channel":"system","cmd":"init","reconnect_token":"` + testReconnectToken + `"}`
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(init)); err != nil {
+			return
+		}
+		serve(conn)
+
+		for {
+			_, _, err := conn.ReadMessage()
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				closes <- receivedClose{code: closeErr.Code, text: closeErr.Text}
+				return
+			} else if err != nil {
+				closes <- receivedClose{code: -1}
+				return
+			}
+		}
+	}))
+
+	return c, closes
+}
+
+func waitForClose(t *testing.T, closes <-chan receivedClose) receivedClose {
+	t.Helper()
+
+	select {
+	case got := <-closes:
+		return got
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server got no close frame")
+		return receivedClose{}
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	tests := []struct {
+		name string
+		// Reads the connection like the library user would
+		read func(t *testing.T, c *Client, ctx context.Context)
+	}{
+		{"with Start", func(t *testing.T, c *Client, ctx context.Context) {
+			if err := c.Start(ctx, StreamOptions{ReceiveBuffer: 1}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"with a ReadMessage loop", func(t *testing.T, c *Client, ctx context.Context) {
+			go func() {
+				for {
+					if _, err := c.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, closes := newCloseServer(t, func(conn *websocket.Conn) {})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if _, err := c.Connect(ctx, "test", uuid.Nil); err != nil {
+				t.Fatal(err)
+			}
+			test.read(t, c, ctx)
+
+			started := time.Now()
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// The server's answer was read, Close didn't wait for the timeout
+			if took := time.Since(started); took >= closeTimeout {
+				t.Errorf("Close took %s", took)
+			}
+
+			got := waitForClose(t, closes)
+			if want := (receivedClose{websocket.CloseNormalClosure, closeReason}); got != want {
+				t.Errorf("the server got the close frame %+v, want %+v", got, want)
+			}
+			if err := c.Close(); err != nil {
+				t.Errorf("closing again failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestCloseNotConnected(t *testing.T) {
+	c, _ := newCloseServer(t, func(conn *websocket.Conn) {})
+
+	if err := c.Close(); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+}
+
+// A close frame from the server is answered with its code before
+// ReadMessage returns, i.e. before the caller reconnects
+func TestServerCloseEchoed(t *testing.T) {
+	tests := []struct {
+		name    string
+		message []byte
+		want    int
+	}{
+		{"going away", websocket.FormatCloseMessage(websocket.CloseGoingAway, "restarting"), websocket.CloseGoingAway},
+		{"service restart", websocket.FormatCloseMessage(websocket.CloseServiceRestart, ""), websocket.CloseServiceRestart},
+		{"application code", websocket.FormatCloseMessage(CloseInvalidReconnectToken, "expired"), CloseInvalidReconnectToken},
+		{"no status", []byte{}, websocket.CloseNoStatusReceived},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, closes := newCloseServer(t, func(conn *websocket.Conn) {
+				conn.WriteControl(websocket.CloseMessage, test.message, time.Now().Add(time.Second))
+			})
+			if _, err := c.Connect(context.Background(), "test", uuid.Nil); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := c.ReadMessage()
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != test.want {
+				t.Fatalf("got %v, want a close error with code %d", err, test.want)
+			}
+			// The answer has been written already
+			err = c.Conn().WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			if err != websocket.ErrCloseSent {
+				t.Errorf("the close frame wasn't answered during ReadMessage, writing one got %v", err)
+			}
+
+			if got := waitForClose(t, closes); got.code != test.want {
+				t.Errorf("the server got the close code %d, want %d", got.code, test.want)
+			}
+			if err := c.Close(); err != nil {
+				t.Errorf("Close after the server's close failed: %v", err)
+			}
+		})
+	}
+}
+
+// With Start, the answer is written before OnDisconnect decides about
+// reconnecting
+func TestServerCloseEchoedBeforeOnDisconnect(t *testing.T) {
+	c, closes := newCloseServer(t, func(conn *websocket.Conn) {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := c.Connect(ctx, "test", uuid.Nil); err != nil {
+		t.Fatal(err)
+	}
+
+	answered := make(chan error, 1)
+	err := c.Start(ctx, StreamOptions{
+		ReceiveBuffer: 1,
+		OnDisconnect: func(err error) bool {
+			answered <- c.Conn().WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(time.Second))
+			return false
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-answered; err != websocket.ErrCloseSent {
+		t.Errorf("the close frame wasn't answered before OnDisconnect, writing one got %v", err)
+	}
+	if got := waitForClose(t, closes); got.code != websocket.CloseGoingAway {
+		t.Errorf("the server got the close code %d, want %d", got.code, websocket.CloseGoingAway)
+	}
+}
+
+// Without APIAddr the HTTP API is derived from the websocket address,
+// otherwise APIAddr is used as given, whatever the websocket's scheme
+func TestAPIAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		apiAddr string
+		want    string
+	}{
+		{"derived from wss", "wss://push.example.com/v0", "", "https://push.example.com/v0"},
+		{"derived from ws", "ws://localhost:8080/v0", "", "http://localhost:8080/v0"},
+		{"https API with wss", "wss://push.example.com/v0", "https://gateway.example.com/api", "https://gateway.example.com/api"},
+		{"https API with ws", "ws://localhost:8080/v0", "https://gateway.example.com/api/", "https://gateway.example.com/api"},
+		{"http API with wss", "wss://push.example.com/v0", "http://localhost:9090", "http://localhost:9090"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := New(Config{Addr: test.addr, APIAddr: test.apiAddr, Auth: NewSecretAuth("secret")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.APIAddr(); got != test.want {
+				t.Errorf("got API address '%s', want '%s'", got, test.want)
+			}
+		})
+	}
+}
+
+// With APIAddr the requests go to the API server and the websocket to the
+// push server
+func TestAPIAddrSplit(t *testing.T) {
+	var apiPaths []string
+	var mu sync.Mutex
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		apiPaths = append(apiPaths, r.URL.Path)
+		mu.Unlock()
+		w.Write([]byte(`{"channels": ["series"]}`))
+	}))
+	t.Cleanup(api.Close)
+
+	dialed := make(chan string, 1)
+	upgrader := websocket.Upgrader{}
+	push := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			t.Errorf("the push server got an API request for %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		dialed <- r.URL.Path
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	t.Cleanup(push.Close)
+
+	c, err := New(Config{
+		Addr:    "ws" + strings.TrimPrefix(push.URL, "http") + "/v0",
+		APIAddr: api.URL + "/gateway",
+		Auth:    NewSecretAuth("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.FetchConfig(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Dial(context.Background(), uuid.Nil, "sub"); err != nil {
+		t.Fatal(err)
+	}
+	c.Conn().Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(apiPaths) != 1 || apiPaths[0] != "/gateway/config" {
+		t.Errorf("the API server got requests for %q, want /gateway/config", apiPaths)
+	}
+	if got := <-dialed; got != "/v0" {
+		t.Errorf("the websocket was dialed on '%s', want /v0", got)
+	}
+}
@@ -0,0 +1,110 @@
+package pushclient
+
+import (
+	"context"
+	"fmt"
+)
+
+// What EnsureSubscription had to do to make the server match the spec
+type EnsureResult int
+
+const (
+	EnsureCreated   EnsureResult = iota // No subscription had the name, the spec was registered
+	EnsureUpdated                       // The subscription with the name was replaced by the spec
+	EnsureUnchanged                     // The subscription with the name already matched the spec
+)
+
+func (r EnsureResult) String() string {
+	switch r {
+	case EnsureCreated:
+		return "created"
+	case EnsureUpdated:
+		return "updated"
+	case EnsureUnchanged:
+		return "unchanged"
+	}
+
+	return fmt.Sprintf("EnsureResult(%d)", int(r))
+}
+
+// Settings of EnsureSubscription, all optional
+type EnsureOptions struct {
+	// Called with the subscription that has the name of the spec before it
+	// is changed. An error leaves it alone and is returned.
+	CheckExisting func(existing Subscription) error
+	// Returns what to send in place of the spec when a subscription with
+	// the name exists, e.g. to keep fields the spec leaves empty
+	Merge func(existing Subscription, sub Subscription) Subscription
+}
+
+// Makes sure a subscription matching the spec is registered. The spec is
+// registered if no subscription with the same name exists. Otherwise the
+// existing subscription is fetched and only updated if it differs from the
+// spec, see SameSubscription. Returns the subscription as registered, and
+// the existing one as it was before unless it was created.
+func (c *Client) EnsureSubscription(ctx context.Context, sub Subscription, options EnsureOptions) (registered Subscription, existing Subscription, result EnsureResult, err error) {
+	subscriptionID, alreadyExists, err := c.RegisterSubscription(ctx, sub)
+	if err != nil {
+		return Subscription{}, Subscription{}, 0, fmt.Errorf("Subscription registration request failed. Error: %w", err)
+	}
+
+	sub.ID = subscriptionID
+	if !alreadyExists {
+		return sub, Subscription{}, EnsureCreated, nil
+	}
+
+	existing, err = c.FetchSubscription(ctx, subscriptionID.String())
+	if err != nil {
+		return Subscription{}, Subscription{}, 0, fmt.Errorf("Failed to fetch existing subscription. Error: %v", err)
+	}
+
+	if options.CheckExisting != nil {
+		err = options.CheckExisting(existing)
+		if err != nil {
+			return Subscription{}, Subscription{}, 0, err
+		}
+	}
+
+	if options.Merge != nil {
+		sub = options.Merge(existing, sub)
+		sub.ID = subscriptionID
+	}
+	if SameSubscription(existing, sub) {
+		return existing, existing, EnsureUnchanged, nil
+	}
+
+	_, _, err = c.UpdateSubscription(ctx, sub)
+	if err != nil {
+		return Subscription{}, Subscription{}, 0, fmt.Errorf("Failed to update subscription. Error: %v", err)
+	}
+
+	return sub, existing, EnsureUpdated, nil
+}
+
+// Reports if two subscriptions are the same apart from the read-only ID.
+// The filters are compared as sets, so their order and duplicates don't
+// matter.
+func SameSubscription(a Subscription, b Subscription) bool {
+	if a.Name != b.Name || a.Description != b.Description {
+		return false
+	}
+
+	filtersA := make(map[SubscriptionFilter]bool)
+	for _, f := range a.Filters {
+		filtersA[f] = true
+	}
+	filtersB := make(map[SubscriptionFilter]bool)
+	for _, f := range b.Filters {
+		filtersB[f] = true
+	}
+	if len(filtersA) != len(filtersB) {
+		return false
+	}
+	for f := range filtersA {
+		if !filtersB[f] {
+			return false
+		}
+	}
+
+	return true
+}
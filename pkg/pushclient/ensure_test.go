@@ -0,0 +1,178 @@
+package pushclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+// A fake '/subscription' API holding at most one subscription, counts the
+// updates it got
+type fakeSubscriptionAPI struct {
+	existing *Subscription
+	updates  int
+}
+
+func (f *fakeSubscriptionAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/subscription":
+		var sub Subscription
+		json.NewDecoder(r.Body).Decode(&sub)
+		if f.existing != nil && f.existing.Name == sub.Name {
+			w.Header().Set("Location", f.existing.ID.String())
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": "11111111-2222-4333-8444-555555555555"})
+	case r.Method == http.MethodGet && f.existing != nil && r.URL.Path == "/subscription/"+f.existing.ID.String():
+		json.NewEncoder(w).Encode(f.existing)
+	case r.Method == http.MethodPut && f.existing != nil && r.URL.Path == "/subscription/"+f.existing.ID.String():
+		f.updates++
+		json.NewEncoder(w).Encode(map[string]string{"id": f.existing.ID.String()})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestClient(t *testing.T, h http.Handler) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	c, err := New(Config{
+		Addr: "ws" + strings.TrimPrefix(server.URL, "http"),
+		Auth: NewSecretAuth("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestEnsureSubscription(t *testing.T) {
+	existing := Subscription{
+		ID:          uuid.Must(uuid.FromString("7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b")),
+		Name:        "dev",
+		Description: "on the server",
+		Filters:     []SubscriptionFilter{{Channel: "series"}, {Channel: "match"}},
+	}
+	keepDescription := func(existing Subscription, sub Subscription) Subscription {
+		if sub.Description == "" {
+			sub.Description = existing.Description
+		}
+		return sub
+	}
+
+	tests := []struct {
+		name        string
+		existing    *Subscription
+		spec        Subscription
+		options     EnsureOptions
+		want        EnsureResult
+		wantUpdates int
+		wantErr     bool
+	}{
+		{
+			name: "created",
+			spec: Subscription{Name: "dev", Filters: []SubscriptionFilter{{Channel: "series"}}},
+			want: EnsureCreated,
+		},
+		{
+			name:     "unchanged with the filters in another order",
+			existing: &existing,
+			spec:     Subscription{Name: "dev", Description: "on the server", Filters: []SubscriptionFilter{{Channel: "match"}, {Channel: "series"}}},
+			want:     EnsureUnchanged,
+		},
+		{
+			name:        "updated",
+			existing:    &existing,
+			spec:        Subscription{Name: "dev", Description: "on the server", Filters: []SubscriptionFilter{{Channel: "series"}}},
+			want:        EnsureUpdated,
+			wantUpdates: 1,
+		},
+		{
+			name:     "unchanged after merging",
+			existing: &existing,
+			spec:     Subscription{Name: "dev", Filters: []SubscriptionFilter{{Channel: "series"}, {Channel: "match"}}},
+			options:  EnsureOptions{Merge: keepDescription},
+			want:     EnsureUnchanged,
+		},
+		{
+			name:     "existing refused",
+			existing: &existing,
+			spec:     Subscription{Name: "dev"},
+			options: EnsureOptions{CheckExisting: func(Subscription) error {
+				return errors.New("not ours")
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeSubscriptionAPI{existing: tt.existing}
+			c := newTestClient(t, api)
+
+			registered, _, result, err := c.EnsureSubscription(context.Background(), tt.spec, tt.options)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				if api.updates != 0 {
+					t.Errorf("the refused subscription was updated")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if result != tt.want {
+				t.Errorf("result is %s, want %s", result, tt.want)
+			}
+			if api.updates != tt.wantUpdates {
+				t.Errorf("%d updates, want %d", api.updates, tt.wantUpdates)
+			}
+			if registered.ID == uuid.Nil {
+				t.Errorf("the registered subscription has no ID")
+			}
+		})
+	}
+}
+
+func TestSameSubscription(t *testing.T) {
+	series := SubscriptionFilter{Channel: "series"}
+	match := SubscriptionFilter{Channel: "match", GameID: 1}
+	base := Subscription{Name: "dev", Description: "local", Filters: []SubscriptionFilter{series, match}}
+	tests := []struct {
+		name string
+		b    Subscription
+		want bool
+	}{
+		{"same", base, true},
+		{"with an ID", Subscription{ID: uuid.Must(uuid.NewV4()), Name: "dev", Description: "local", Filters: []SubscriptionFilter{series, match}}, true},
+		{"reordered", Subscription{Name: "dev", Description: "local", Filters: []SubscriptionFilter{match, series}}, true},
+		{"duplicates", Subscription{Name: "dev", Description: "local", Filters: []SubscriptionFilter{series, match, match}}, true},
+		{"other name", Subscription{Name: "prod", Description: "local", Filters: []SubscriptionFilter{series, match}}, false},
+		{"other description", Subscription{Name: "dev", Filters: []SubscriptionFilter{series, match}}, false},
+		{"missing filter", Subscription{Name: "dev", Description: "local", Filters: []SubscriptionFilter{series, series}}, false},
+		{"other id field", Subscription{Name: "dev", Description: "local", Filters: []SubscriptionFilter{series, {Channel: "match", GameID: 2}}}, false},
+		{"no filters", Subscription{Name: "dev", Description: "local"}, false},
+	}
+
+	for _, test := range tests {
+		if got := SameSubscription(base, test.b); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+		if got := SameSubscription(test.b, base); got != test.want {
+			t.Errorf("%s swapped: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
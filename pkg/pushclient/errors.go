@@ -0,0 +1,86 @@
+package pushclient
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Custom status codes sent by the server for the 'close' command.
+// The websocket standard (RFC6455) allocates the
+// 4000-4999 range to application specific status codes.
+const (
+	CloseMissingSecret         = 4000 // Missing access token in ws setup request
+	CloseInvalidSecret         = 4001 // Invalid access token in ws setup request
+	CloseNotAuthorized         = 4002 // Client account does not have access to the push API
+	CloseMaxNumSubscribers     = 4003 // Max number of concurrent subscribers connected for client id
+	CloseMaxNumSubscriptions   = 4004 // Max number of registered subscriptions exist for client id
+	CloseInvalidReconnectToken = 4005 // Invalid reconnect token in ws setup request
+	CloseMissingSubscriptionID = 4006 // Missing subscription id in ws setup request
+	CloseUnknownSubscriptionID = 4007 // The supplied subscriber id in ws setup request does not exist in server
+	CloseInternalError         = 4500 // Unspecified error due to problem in server
+)
+
+// Reports if a close code means the credentials weren't accepted, in which
+// case connecting again with the same credentials fails the same way
+func IsAuthCloseCode(code int) bool {
+	switch code {
+	case CloseMissingSecret, CloseInvalidSecret, CloseNotAuthorized:
+		return true
+	}
+
+	return false
+}
+
+// Returned when the websocket handshake fails with an HTTP response
+type WebsocketSetupHTTPError struct {
+	error
+	HttpStatus int
+	// From the Retry-After header of a 429 response. Zero if the header is
+	// missing or can't be parsed, and also if the time it gives has already
+	// passed, HasRetryAfter tells them apart.
+	RetryAfter    time.Duration
+	HasRetryAfter bool
+}
+
+// Returned when the server closes the websocket during setup, Code is one of
+// the custom close codes and Reason is the text the server sent with it
+type ServerCloseError struct {
+	Code    int
+	Reason  string
+	Message string // Explanation of the close code
+}
+
+func (e *ServerCloseError) Error() string {
+	return fmt.Sprintf("Server closed connection with message: %s. Reason: %s", e.Message, CloseReason(e.Reason))
+}
+
+// Returned when the push service has no subscription with the given id or name
+var ErrSubscriptionNotFound = errors.New("Subscription not found")
+
+// Returned when registering a subscription whose name is taken and the
+// server doesn't say which subscription has it
+var ErrExistingSubscriptionUnknown = errors.New("Subscription with name already exists, but failed to retrieve ID")
+
+// Returned when the HTTP API answers with 429 Too Many Requests
+var ErrAPIRateLimited = errors.New("Rate limited by the API")
+
+// Returned when writing to the websocket before Connect
+var ErrNotConnected = errors.New("Not connected")
+
+// Returned by Receive once the client has been closed or the stream has
+// ended
+var ErrClientClosed = errors.New("The client has been closed")
+
+// Returned by Receive after the subscriber was resumed on a new
+// connection, if StreamOptions.ReportReconnects is set
+var ErrReconnected = errors.New("Reconnected to the push service")
+
+// Returns the reason of a close frame for logging, servers often send none
+func CloseReason(text string) string {
+	if text == "" {
+		return "(no reason given)"
+	}
+
+	return text
+}
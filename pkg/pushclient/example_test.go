@@ -0,0 +1,86 @@
+package pushclient_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+)
+
+// Compresses the messages into a file until the stream ends
+func ExampleClient_Stream() {
+	ctx := context.Background()
+	client, err := pushclient.New(pushclient.Config{Auth: pushclient.NewSecretAuth(os.Getenv("ABIOS_SECRET"))})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := client.Connect(ctx, "my-subscription", uuid.Nil); err != nil {
+		log.Fatal(err)
+	}
+	err = client.Start(ctx, pushclient.StreamOptions{ReceiveBuffer: 100})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Create("messages.jsonl.gz")
+	if err != nil {
+		log.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	_, err = io.Copy(gz, client.Stream(ctx))
+	if err != nil {
+		log.Println(err)
+	}
+	gz.Close()
+	f.Close()
+}
+
+// Writes the messages to a file and to stdout while they are received
+func ExampleClient_AddWriter() {
+	ctx := context.Background()
+	client, err := pushclient.New(pushclient.Config{Auth: pushclient.NewSecretAuth(os.Getenv("ABIOS_SECRET"))})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Create("messages.jsonl")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	fileDone, err := client.AddWriter(f, pushclient.WriterOptions{
+		QueueSize: 1000,
+		OnDrop: func(dropped int) {
+			log.Printf("The file can't keep up, %d messages dropped\n", dropped)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	stdoutDone, err := client.AddWriter(os.Stdout, pushclient.WriterOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := client.Connect(ctx, "my-subscription", uuid.Nil); err != nil {
+		log.Fatal(err)
+	}
+	err = client.Start(ctx, pushclient.StreamOptions{ReceiveBuffer: 100})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		_, err := client.Receive(ctx)
+		if err == pushclient.ErrClientClosed {
+			break
+		} else if _, ok := err.(*pushclient.MessageError); !ok && err != nil {
+			log.Println(err)
+		}
+	}
+	<-fileDone
+	<-stdoutDone
+}
@@ -0,0 +1,55 @@
+package pushclient
+
+// Handles a push message. An error is logged with the message UUID and
+// the stream goes on with the next message.
+type Handler func(m PushMessage) error
+
+// Registers the handler of the messages on a channel, replacing any
+// previous one. With a handler registered Start calls the handlers instead
+// of sending the messages on Messages. Must be called before Start.
+func (c *Client) On(channel string, h Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.handlers == nil {
+		c.handlers = map[string]Handler{}
+	}
+	c.handlers[channel] = h
+}
+
+// Registers the handler of the messages on channels without a handler of
+// their own. Must be called before Start.
+func (c *Client) OnAny(h Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.anyHandler = h
+}
+
+func (c *Client) hasHandlers() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.handlers) > 0 || c.anyHandler != nil
+}
+
+// Calls the handler of the message's channel, or the catch-all handler.
+// Messages without either are dropped.
+func (c *Client) dispatch(m PushMessage) {
+	c.mu.Lock()
+	h, ok := c.handlers[m.Channel]
+	if !ok {
+		h = c.anyHandler
+	}
+	c.mu.Unlock()
+
+	if h == nil {
+		c.config.Logf("[DEBUG] No handler for channel '%s', dropping message. UUID: %s\n", m.Channel, m.UUID)
+		return
+	}
+
+	err := h(m)
+	if err != nil {
+		c.config.Logf("[ERROR] Handler for channel '%s' failed. Error: %v, UUID: %s\n", m.Channel, err, m.UUID)
+	}
+}
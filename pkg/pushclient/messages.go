@@ -0,0 +1,184 @@
+// Package pushclient is a client for the Abios push API. It registers
+// subscriptions with the HTTP API and receives the matching push messages
+// over a websocket.
+//
+//	client, err := pushclient.New(pushclient.Config{Auth: pushclient.NewSecretAuth(secret)})
+//	...
+//	init, err := client.Connect(ctx, "my-subscription", uuid.Nil)
+//	...
+//	err = client.Start(ctx, pushclient.StreamOptions{})
+//	go func() {
+//		for err := range client.Errors() {
+//			...
+//		}
+//	}()
+//	for message := range client.Messages() {
+//		...
+//	}
+package pushclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Base for all messages published to end-consumers
+type Message struct {
+	Channel string    `json:"channel"`
+	UUID    uuid.UUID `json:"uuid"`
+}
+
+type PushMessage struct {
+	Message
+	Created time.Time `json:"created"`
+	Payload Payload   `json:"payload"`
+	// The message as received, set on the messages delivered by Start
+	Raw []byte `json:"-"`
+}
+
+// The payload of a push message. Some messages are sent with the payload
+// encoded a second time as a JSON string, which is decoded transparently.
+type Payload struct {
+	Fields        map[string]interface{}
+	DoubleEncoded bool // The payload was given as a string containing a JSON object
+}
+
+func (p *Payload) UnmarshalJSON(b []byte) error {
+	p.DoubleEncoded = false
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("\"")) {
+		var s string
+		err := json.Unmarshal(b, &s)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal([]byte(s), &p.Fields)
+		if err != nil {
+			return fmt.Errorf("Payload is a string but does not contain a JSON object. Error: %v", err)
+		}
+		p.DoubleEncoded = true
+		return nil
+	}
+
+	return json.Unmarshal(b, &p.Fields)
+}
+
+// Always encodes the payload as an object, no matter how it was received
+func (p Payload) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Fields)
+}
+
+// Base for messages sent on the 'system' channel
+type SystemMessage struct {
+	Message
+	Cmd string `json:"cmd"`
+}
+
+// The 'init' system message
+type InitResponseMessage struct {
+	SystemMessage
+	SubscriberID   uuid.UUID    `json:"subscriber_id"`
+	ReconnectToken uuid.UUID    `json:"reconnect_token"`
+	Subscription   Subscription `json:"subscription"`
+	Reconnected    bool         `json:"reconnected"`
+	ServerHints
+}
+
+type Subscription struct {
+	ID          uuid.UUID            `json:"id"`                    // Read-only, can't be set by the client when creating a subscription
+	Description string               `json:"description,omitempty"` // Optional description of the subscription
+	Name        string               `json:"name,omitempty"`        // Optional when creating a subscription
+	Filters     []SubscriptionFilter `json:"filters"`
+}
+
+// Response from the push service '/config' endpoint. The fields the client
+// doesn't know are kept in Extra.
+type PushServiceConfig struct {
+	Channels []ConfigChannel `json:"channels"`
+	ServerHints
+
+	// Limits of the account, zero if the server doesn't say
+	MaxSubscribers   int `json:"max_subscribers,omitempty"`   // Subscribers connected at the same time
+	MaxSubscriptions int `json:"max_subscriptions,omitempty"` // Registered subscriptions
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// The fields of PushServiceConfig that aren't kept in Extra
+var knownConfigFields = []string{"channels", "ping_interval", "pong_timeout", "reconnect_token_ttl", "max_subscribers", "max_subscriptions"}
+
+func (c *PushServiceConfig) UnmarshalJSON(b []byte) error {
+	// Type alias prevents infinite recursion into this method
+	type config PushServiceConfig
+	err := json.Unmarshal(b, (*config)(c))
+	if err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		return err
+	}
+	for _, name := range knownConfigFields {
+		delete(fields, name)
+	}
+	c.Extra = nil
+	if len(fields) > 0 {
+		c.Extra = fields
+	}
+
+	return nil
+}
+
+// A channel in the push service config, either given by name only or as
+// an object with a 'name' field
+type ConfigChannel struct {
+	Name string `json:"name"`
+}
+
+func (c *ConfigChannel) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("\"")) {
+		return json.Unmarshal(b, &c.Name)
+	}
+
+	// Type alias prevents infinite recursion into this method
+	type channel ConfigChannel
+	return json.Unmarshal(b, (*channel)(c))
+}
+
+type SubscriptionFilter struct {
+	Channel      string `json:"channel,omitempty"`
+	GameID       int    `json:"game_id,omitempty"`
+	SeriesID     int    `json:"series_id,omitempty"`
+	MatchID      int    `json:"match_id,omitempty"`
+	TournamentID int    `json:"tournament_id,omitempty"`
+	TeamID       int    `json:"team_id,omitempty"`
+	PlayerID     int    `json:"player_id,omitempty"`
+}
+
+// Timing expectations the server may include in the config and the init
+// message, all in seconds. Zero means that the server didn't give a hint.
+type ServerHints struct {
+	PingInterval      int `json:"ping_interval,omitempty"`       // How often the server wants clients to ping
+	PongTimeout       int `json:"pong_timeout,omitempty"`        // How quickly the server answers pings
+	ReconnectTokenTTL int `json:"reconnect_token_ttl,omitempty"` // How long a reconnect token stays valid
+}
+
+// Returns the hints in h, with the ones missing taken from fallback
+func (h ServerHints) Or(fallback ServerHints) ServerHints {
+	if h.PingInterval == 0 {
+		h.PingInterval = fallback.PingInterval
+	}
+	if h.PongTimeout == 0 {
+		h.PongTimeout = fallback.PongTimeout
+	}
+	if h.ReconnectTokenTTL == 0 {
+		h.ReconnectTokenTTL = fallback.ReconnectTokenTTL
+	}
+
+	return h
+}
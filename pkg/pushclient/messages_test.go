@@ -0,0 +1,206 @@
+package pushclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// A '/config' response as sent by the push service, with fields the client
+// doesn't use
+const capturedConfig = `{
+  "channels": [
+    "series",
+    {"name": "match", "description": "Match updates"},
+    {"name": "system"}
+  ],
+  "ping_interval": 20,
+  "pong_timeout": 5,
+  "reconnect_token_ttl": 30,
+  "max_subscribers": 3,
+  "max_subscriptions": 25,
+  "region": "eu-west-1",
+  "features": {"replay": true, "compression": ["permessage-deflate"]},
+  "server_version": 2.5
+}`
+
+func TestParsePushServiceConfig(t *testing.T) {
+	var config PushServiceConfig
+	if err := json.Unmarshal([]byte(capturedConfig), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	wantChannels := []ConfigChannel{{Name: "series"}, {Name: "match"}, {Name: "system"}}
+	if !reflect.DeepEqual(config.Channels, wantChannels) {
+		t.Errorf("got the channels %v, want %v", config.Channels, wantChannels)
+	}
+	if want := (ServerHints{PingInterval: 20, PongTimeout: 5, ReconnectTokenTTL: 30}); config.ServerHints != want {
+		t.Errorf("got the hints %+v, want %+v", config.ServerHints, want)
+	}
+	if config.MaxSubscribers != 3 || config.MaxSubscriptions != 25 {
+		t.Errorf("got the limits %d and %d, want 3 and 25", config.MaxSubscribers, config.MaxSubscriptions)
+	}
+
+	// The unknown fields are kept as they were sent
+	wantExtra := map[string]string{
+		"region":         `"eu-west-1"`,
+		"features":       `{"replay": true, "compression": ["permessage-deflate"]}`,
+		"server_version": `2.5`,
+	}
+	if len(config.Extra) != len(wantExtra) {
+		t.Errorf("got the extra fields %v, want %v", config.Extra, wantExtra)
+	}
+	for name, want := range wantExtra {
+		if got := string(config.Extra[name]); got != want {
+			t.Errorf("extra field %s: got %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestParsePushServiceConfigVariants(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    PushServiceConfig
+		wantErr bool
+	}{
+		{"empty", `{}`, PushServiceConfig{}, false},
+		{"only channels", `{"channels": ["series"]}`, PushServiceConfig{Channels: []ConfigChannel{{Name: "series"}}}, false},
+		{"no limits", `{"channels": [], "ping_interval": 10}`, PushServiceConfig{Channels: []ConfigChannel{}, ServerHints: ServerHints{PingInterval: 10}}, false},
+		{"only max_subscribers", `{"max_subscribers": 1}`, PushServiceConfig{MaxSubscribers: 1}, false},
+		{"null extra field", `{"beta": null}`, PushServiceConfig{Extra: map[string]json.RawMessage{"beta": json.RawMessage("null")}}, false},
+		{"limit as a string", `{"max_subscriptions": "25"}`, PushServiceConfig{}, true},
+		{"channel as a number", `{"channels": [1]}`, PushServiceConfig{}, true},
+		{"not an object", `[]`, PushServiceConfig{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got PushServiceConfig
+			err := json.Unmarshal([]byte(test.config), &got)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// Extra is reset when a config is parsed into one that already has some
+func TestParsePushServiceConfigAgain(t *testing.T) {
+	var config PushServiceConfig
+	if err := json.Unmarshal([]byte(capturedConfig), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"channels": ["series"]}`), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Extra != nil {
+		t.Errorf("got the extra fields %v from the first config", config.Extra)
+	}
+}
+
+func TestFetchPushServiceConfig(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config" || r.Header.Get("Abios-Secret") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(capturedConfig))
+	}))
+
+	config, err := c.FetchPushServiceConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxSubscriptions != 25 || config.PingInterval != 20 || string(config.Extra["region"]) != `"eu-west-1"` {
+		t.Errorf("got %+v", config)
+	}
+}
+
+func TestServerHintsOr(t *testing.T) {
+	config := ServerHints{PingInterval: 20, PongTimeout: 5, ReconnectTokenTTL: 30}
+	tests := []struct {
+		init ServerHints
+		want ServerHints
+	}{
+		{ServerHints{}, config},
+		{ServerHints{PingInterval: 10}, ServerHints{PingInterval: 10, PongTimeout: 5, ReconnectTokenTTL: 30}},
+		{ServerHints{PingInterval: 10, PongTimeout: 2, ReconnectTokenTTL: 60}, ServerHints{PingInterval: 10, PongTimeout: 2, ReconnectTokenTTL: 60}},
+	}
+
+	for _, test := range tests {
+		if got := test.init.Or(config); got != test.want {
+			t.Errorf("%+v.Or(%+v): got %+v, want %+v", test.init, config, got, test.want)
+		}
+	}
+}
+
+func TestPayloadUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name              string
+		message           string
+		wantFields        map[string]interface{}
+		wantDoubleEncoded bool
+		wantErr           bool
+	}{
+		{"object", `{"payload": {"id": 1, "name": "a"}}`, map[string]interface{}{"id": 1.0, "name": "a"}, false, false},
+		{"empty object", `{"payload": {}}`, map[string]interface{}{}, false, false},
+		{"double-encoded object", `{"payload": "{\"id\": 1, \"name\": \"a\"}"}`, map[string]interface{}{"id": 1.0, "name": "a"}, true, false},
+		{"double-encoded with spaces", `{"payload":  "{\"id\": 1}" }`, map[string]interface{}{"id": 1.0}, true, false},
+		{"null", `{"payload": null}`, nil, false, false},
+		{"string without JSON", `{"payload": "not json"}`, nil, false, true},
+		{"string with an array", `{"payload": "[1, 2]"}`, nil, false, true},
+		{"string with a truncated object", `{"payload": "{\"id\": 1"}`, nil, false, true},
+		{"array", `{"payload": [1, 2]}`, nil, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var m PushMessage
+			err := json.Unmarshal([]byte(test.message), &m)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("got payload %v, want an error", m.Payload.Fields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.Payload.Fields, test.wantFields) {
+				t.Errorf("got fields %v, want %v", m.Payload.Fields, test.wantFields)
+			}
+			if m.Payload.DoubleEncoded != test.wantDoubleEncoded {
+				t.Errorf("got double-encoded %t, want %t", m.Payload.DoubleEncoded, test.wantDoubleEncoded)
+			}
+		})
+	}
+}
+
+// A double-encoded payload is encoded as an object again
+func TestPayloadMarshalJSON(t *testing.T) {
+	var p Payload
+	if err := json.Unmarshal([]byte(`"{\"id\": 1}"`), &p); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"id":1}` {
+		t.Errorf("got %s, want %s", got, `{"id":1}`)
+	}
+}
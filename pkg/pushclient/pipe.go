@@ -0,0 +1,193 @@
+package pushclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// How many messages may wait for a writer added with AddWriter if
+// WriterOptions.QueueSize is zero
+const defaultWriterQueueSize = 100
+
+// Settings of AddWriter, all optional
+type WriterOptions struct {
+	// How many messages may wait to be written, 100 if zero. When the queue
+	// is full a message is dropped instead of holding up the stream.
+	QueueSize int
+	// Called with how many messages have been dropped so far when one was
+	// dropped because the queue was full. Called by the stream, so it must
+	// not block.
+	OnDrop func(dropped int)
+	// Called when a write failed, the writer gets no more messages after
+	// that
+	OnError func(err error)
+}
+
+// A writer added with AddWriter, written to by its own goroutine
+type messageWriter struct {
+	w       io.Writer
+	options WriterOptions
+	lines   chan []byte
+	dropped int
+	failed  int32 // Set once a write failed
+	done    chan struct{}
+}
+
+// Writes every message of the stream started by Start to w, one compact
+// JSON object per line, e.g. to gzip.NewWriter(file) or to the stdin of
+// another process. Each writer has its own goroutine and queue, so a slow
+// writer loses messages instead of slowing down the stream or the other
+// writers, see WriterOptions. Must be called before Start. The returned
+// channel is closed once the stream has ended and everything queued has
+// been written, w is not closed.
+func (c *Client) AddWriter(w io.Writer, options WriterOptions) (<-chan struct{}, error) {
+	if options.QueueSize <= 0 {
+		options.QueueSize = defaultWriterQueueSize
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.started {
+		return nil, fmt.Errorf("Writers must be added before Start")
+	}
+
+	mw := &messageWriter{
+		w:       w,
+		options: options,
+		lines:   make(chan []byte, options.QueueSize),
+		done:    make(chan struct{}),
+	}
+	c.writers = append(c.writers, mw)
+	go mw.run()
+
+	return mw.done, nil
+}
+
+func (mw *messageWriter) run() {
+	defer close(mw.done)
+
+	for line := range mw.lines {
+		if atomic.LoadInt32(&mw.failed) == 1 {
+			continue
+		}
+
+		_, err := mw.w.Write(line)
+		if err != nil {
+			atomic.StoreInt32(&mw.failed, 1)
+			if mw.options.OnError != nil {
+				mw.options.OnError(err)
+			}
+		}
+	}
+}
+
+// Queues the message for every writer, dropping it for those whose queue
+// is full. Only called by the stream, the writers don't change after Start.
+func (c *Client) writeToWriters(message []byte) {
+	if len(c.writers) == 0 {
+		return
+	}
+
+	line, err := messageLine(message)
+	if err != nil {
+		c.config.Logf("[WARN] Not writing a message that is not valid JSON to the writers. Error: %v\n", err)
+		return
+	}
+
+	for _, mw := range c.writers {
+		if atomic.LoadInt32(&mw.failed) == 1 {
+			continue
+		}
+
+		select {
+		case mw.lines <- line:
+		default:
+			mw.dropped++
+			if mw.options.OnDrop != nil {
+				mw.options.OnDrop(mw.dropped)
+			}
+		}
+	}
+}
+
+// Lets the writers finish what is queued, called when the stream ends
+func (c *Client) closeWriters() {
+	for _, mw := range c.writers {
+		close(mw.lines)
+	}
+}
+
+// Returns the message as one line of compact JSON, ending with a newline
+func messageLine(message []byte) ([]byte, error) {
+	var line bytes.Buffer
+	err := json.Compact(&line, message)
+	if err != nil {
+		return nil, err
+	}
+	line.WriteByte('\n')
+
+	return line.Bytes(), nil
+}
+
+// Returns the messages of the stream started by Start with a
+// ReceiveBuffer as a reader, one compact JSON object per line, e.g. for
+// io.Copy into gzip.NewWriter(file). Read waits for the next message and
+// returns io.EOF once ctx is done or the client or the reader has been
+// closed, and the error that ended the stream if there was one. Messages
+// that are not valid JSON are left out. The reader takes the messages
+// from Receive, so only one of them should be used, and a slow reader is
+// handled with StreamOptions.MaxReceiveWait.
+func (c *Client) Stream(ctx context.Context) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &messageReader{c: c, ctx: ctx, cancel: cancel}
+}
+
+type messageReader struct {
+	c      *Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	line   []byte // The rest of the current message
+	err    error  // Returned once the messages have been read
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	for len(r.line) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		message, err := r.c.ReceiveRaw(r.ctx)
+		if err == ErrReconnected {
+			continue
+		} else if err == ErrClientClosed || err == context.Canceled || err == context.DeadlineExceeded {
+			r.err = io.EOF
+			continue
+		} else if err != nil {
+			r.err = err
+			continue
+		}
+
+		r.line, err = messageLine(message)
+		if err != nil {
+			r.c.config.Logf("[WARN] Leaving out a message that is not valid JSON from the stream. Error: %v\n", err)
+		}
+	}
+
+	n := copy(p, r.line)
+	r.line = r.line[n:]
+
+	return n, nil
+}
+
+// Makes Read return io.EOF, the client goes on
+func (r *messageReader) Close() error {
+	r.cancel()
+
+	return nil
+}
@@ -0,0 +1,280 @@
+package pushclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Sends the messages, then closes the connection for authorization
+// reasons, which ends the stream
+func sendAndEnd(messages ...[]byte) func(conn *websocket.Conn, n int) {
+	return func(conn *websocket.Conn, n int) {
+		for _, m := range messages {
+			if conn.WriteMessage(websocket.TextMessage, m) != nil {
+				return
+			}
+		}
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(CloseInvalidSecret, ""), time.Now().Add(time.Second))
+	}
+}
+
+// Lines of the messages made by testMessage, as compact JSON
+func testLines(numbers ...int) string {
+	var lines []string
+	for _, n := range numbers {
+		line, _ := messageLine(testMessage(n))
+		lines = append(lines, string(line))
+	}
+
+	return strings.Join(lines, "")
+}
+
+// Reports if err is the stream ending with the close of sendAndEnd
+func isEndOfTestStream(err error) bool {
+	var closeErr *websocket.CloseError
+
+	return errors.As(err, &closeErr) && closeErr.Code == CloseInvalidSecret
+}
+
+func TestStreamIntoBuffer(t *testing.T) {
+	c := newTestPushServer(t, sendAndEnd(testMessage(1), []byte("{\n  \"channel\": \"series\",\n  \"payload\": {\"n\": 2}\n}"), testMessage(3)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(c.Stream(ctx))
+	if !isEndOfTestStream(err) {
+		t.Fatalf("the stream ended with %v", err)
+	}
+
+	want := testLines(1) + `{"channel":"series","payload":{"n":2}}` + "\n" + testLines(3)
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestStreamIntoGzip(t *testing.T) {
+	c := newTestPushServer(t, sendAndEnd(testMessage(1), testMessage(2)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := io.Copy(gz, c.Stream(ctx))
+	if !isEndOfTestStream(err) {
+		t.Fatalf("the stream ended with %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := gzip.NewReader(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != testLines(1, 2) {
+		t.Errorf("got %q, want %q", b, testLines(1, 2))
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	stream := c.Stream(ctx)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		stream.Close()
+	}()
+	if _, err := stream.Read(make([]byte, 10)); err != io.EOF {
+		t.Fatalf("got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestStreamMaxReceiveWait(t *testing.T) {
+	sent := make(chan struct{})
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {
+		for i := 1; i <= 5; i++ {
+			conn.WriteMessage(websocket.TextMessage, testMessage(i))
+		}
+		close(sent)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var dropped int32
+	startReceiving(t, c, ctx, StreamOptions{
+		ReceiveBuffer:  1,
+		MaxReceiveWait: 10 * time.Millisecond,
+		OnDrop: func(m PushMessage) {
+			atomic.AddInt32(&dropped, 1)
+		},
+	})
+
+	// Nobody reads, the buffer holds the first message and the rest are
+	// dropped after waiting for room
+	<-sent
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&dropped) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&dropped); got != 4 {
+		t.Fatalf("%d messages were dropped, want 4", got)
+	}
+
+	m, err := c.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testMessageNumber(t, m); got != 1 {
+		t.Errorf("got message %d, want 1", got)
+	}
+}
+
+func TestAddWriter(t *testing.T) {
+	c := newTestPushServer(t, sendAndEnd(testMessage(1), []byte("not json"), testMessage(2)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var plain, compressed bytes.Buffer
+	plainDone, err := c.AddWriter(&plain, WriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(&compressed)
+	gzDone, err := c.AddWriter(gz, WriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startReceiving(t, c, ctx, StreamOptions{ReceiveBuffer: 10})
+
+	if _, err := c.AddWriter(ioutil.Discard, WriterOptions{}); err == nil {
+		t.Errorf("a writer could be added after Start")
+	}
+
+	<-plainDone
+	<-gzDone
+	if plain.String() != testLines(1, 2) {
+		t.Errorf("got %q, want %q", plain.String(), testLines(1, 2))
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != testLines(1, 2) {
+		t.Errorf("got %q from gzip, want %q", b, testLines(1, 2))
+	}
+}
+
+// Blocks every write until released
+type blockedWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	lines   int
+}
+
+func (w *blockedWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	w.lines++
+	w.mu.Unlock()
+
+	return len(p), nil
+}
+
+func TestAddWriterSlowWriter(t *testing.T) {
+	const sent = 6
+	var messages [][]byte
+	for i := 1; i <= sent; i++ {
+		messages = append(messages, testMessage(i))
+	}
+	c := newTestPushServer(t, sendAndEnd(messages...))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := &blockedWriter{release: make(chan struct{})}
+	var dropped int32
+	done, err := c.AddWriter(w, WriterOptions{
+		QueueSize: 1,
+		OnDrop: func(n int) {
+			atomic.StoreInt32(&dropped, int32(n))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startReceiving(t, c, ctx, StreamOptions{ReceiveBuffer: 10})
+
+	// The stream isn't held up by the writer
+	for i := 1; i <= sent; i++ {
+		if _, err := c.Receive(ctx); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+
+	close(w.release)
+	<-done
+
+	// One message is being written and one is queued when the others
+	// arrive, or only one is queued if the writer hadn't started yet
+	got := int(atomic.LoadInt32(&dropped))
+	if got < sent-2 || got+w.lines != sent {
+		t.Errorf("%d messages were dropped and %d written, want at least %d dropped and %d in total", got, w.lines, sent-2, sent)
+	}
+}
+
+// Fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAddWriterError(t *testing.T) {
+	c := newTestPushServer(t, sendAndEnd(testMessage(1), testMessage(2), testMessage(3)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var failures int32
+	done, err := c.AddWriter(failingWriter{}, WriterOptions{
+		OnError: func(err error) {
+			atomic.AddInt32(&failures, 1)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startReceiving(t, c, ctx, StreamOptions{ReceiveBuffer: 10})
+
+	<-done
+	if got := atomic.LoadInt32(&failures); got != 1 {
+		t.Errorf("OnError was called %d times, want once", got)
+	}
+}
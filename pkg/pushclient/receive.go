@@ -0,0 +1,109 @@
+package pushclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// What the stream hands to Receive: a message, or an error. A
+// *MessageError comes with the raw message.
+type received struct {
+	message PushMessage
+	err     error
+}
+
+// Waits until there is room for r in the queue, reports if it was queued
+// before ctx was done
+func (c *Client) enqueue(ctx context.Context, queue chan received, r received) bool {
+	select {
+	case queue <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Like enqueue, but gives up when there is no room within maxWait, unless
+// it is zero
+func (c *Client) enqueueWithin(ctx context.Context, queue chan received, r received, maxWait time.Duration) bool {
+	if maxWait <= 0 {
+		return c.enqueue(ctx, queue, r)
+	}
+
+	t := time.NewTimer(maxWait)
+	defer t.Stop()
+
+	select {
+	case queue <- r:
+		return true
+	case <-t.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Returns the next message of the stream started by Start with a
+// ReceiveBuffer, waiting until one arrives or ctx is done, in which case
+// ctx.Err() is returned. A deadline on ctx limits how long a single call
+// waits.
+//
+// Messages are returned in the order they were received, also across
+// reconnects. A message that could not be parsed is returned in its place
+// as a *MessageError, and the next call goes on with the next message.
+// While the connection is resumed Receive just waits, or returns
+// ErrReconnected once it has been with StreamOptions.ReportReconnects.
+// When the stream ends with an error that error is returned, after that
+// and once Close has been called ErrClientClosed is returned. Messages that
+// are still buffered when Close is called are not returned.
+func (c *Client) Receive(ctx context.Context) (PushMessage, error) {
+	r, err := c.receive(ctx)
+	if err != nil {
+		return PushMessage{}, err
+	}
+
+	return r.message, r.err
+}
+
+// Like Receive, but returns the message as it was received. A message
+// that could not be parsed is returned without an error.
+func (c *Client) ReceiveRaw(ctx context.Context) ([]byte, error) {
+	r, err := c.receive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := r.err.(*MessageError); ok || r.err == nil {
+		return r.message.Raw, nil
+	}
+
+	return nil, r.err
+}
+
+func (c *Client) receive(ctx context.Context) (received, error) {
+	c.mu.Lock()
+	queue := c.queue
+	c.mu.Unlock()
+
+	// Nothing is returned after Close, even if it is still buffered
+	select {
+	case <-c.closed:
+		return received{}, ErrClientClosed
+	default:
+	}
+	if queue == nil {
+		return received{}, fmt.Errorf("The stream has not been started with a ReceiveBuffer")
+	}
+
+	select {
+	case r, ok := <-queue:
+		if !ok {
+			return received{}, ErrClientClosed
+		}
+		return r, nil
+	case <-c.closed:
+		return received{}, ErrClientClosed
+	case <-ctx.Done():
+		return received{}, ctx.Err()
+	}
+}
@@ -0,0 +1,275 @@
+package pushclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Connects to the test server and starts the stream for Receive
+func startReceiving(t *testing.T, c *Client, ctx context.Context, options StreamOptions) {
+	t.Helper()
+
+	if _, err := c.Connect(ctx, "test", uuid.Nil); err != nil {
+		t.Fatal(err)
+	}
+	if options.ReceiveBuffer == 0 {
+		options.ReceiveBuffer = 8
+	}
+	if err := c.Start(ctx, options); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReceiveBurst(t *testing.T) {
+	const burst = 500
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {
+		for i := 1; i <= burst; i++ {
+			if conn.WriteMessage(websocket.TextMessage, testMessage(i)) != nil {
+				return
+			}
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	// The buffer is much smaller than the burst, nothing may be lost or
+	// reordered while the stream waits for room
+	for i := 1; i <= burst; i++ {
+		m, err := c.Receive(ctx)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if got := testMessageNumber(t, m); got != i {
+			t.Fatalf("got message %d, want %d", got, i)
+		}
+	}
+}
+
+func TestReceiveTimeout(t *testing.T) {
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	callCtx, callCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer callCancel()
+	start := time.Now()
+	_, err := c.Receive(callCtx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Receive returned after %s", took)
+	}
+
+	// The stream goes on after a call timed out
+	_, err = c.ReceiveRaw(callCtx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestReceiveCancel(t *testing.T) {
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	callCtx, callCancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		callCancel()
+	}()
+	_, err := c.Receive(callCtx)
+	if err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReceiveAfterClose(t *testing.T) {
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {
+		conn.WriteMessage(websocket.TextMessage, testMessage(1))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	if _, err := c.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A Receive that is waiting returns when the client is closed
+	done := make(chan error)
+	go func() {
+		_, err := c.Receive(ctx)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != ErrClientClosed {
+		t.Fatalf("got %v, want %v", err, ErrClientClosed)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.ReceiveRaw(ctx); err != ErrClientClosed {
+			t.Fatalf("got %v, want %v", err, ErrClientClosed)
+		}
+	}
+}
+
+func TestReceiveInvalidMessageInOrder(t *testing.T) {
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {
+		conn.WriteMessage(websocket.TextMessage, testMessage(1))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"channel":`))
+		conn.WriteMessage(websocket.TextMessage, testMessage(2))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	m, err := c.Receive(ctx)
+	if err != nil || testMessageNumber(t, m) != 1 {
+		t.Fatalf("got %s, %v, want message 1", m.Raw, err)
+	}
+	raw, err := c.ReceiveRaw(ctx)
+	if err != nil || string(raw) != `{"channel":` {
+		t.Fatalf("got %q, %v, want the invalid message", raw, err)
+	}
+	m, err = c.Receive(ctx)
+	if err != nil || testMessageNumber(t, m) != 2 {
+		t.Fatalf("got %s, %v, want message 2", m.Raw, err)
+	}
+}
+
+func TestReceiveInvalidMessageError(t *testing.T) {
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {
+		conn.WriteMessage(websocket.TextMessage, []byte(`not json`))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{})
+
+	_, err := c.Receive(ctx)
+	msgErr, ok := err.(*MessageError)
+	if !ok {
+		t.Fatalf("got %v, want a *MessageError", err)
+	}
+	if string(msgErr.Message) != "not json" {
+		t.Errorf("the error has the message %q", msgErr.Message)
+	}
+}
+
+func TestReceiveAcrossReconnect(t *testing.T) {
+	tests := []struct {
+		name             string
+		reportReconnects bool
+	}{
+		{"reconnects not reported", false},
+		{"reconnects reported", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestPushServer(t, func(conn *websocket.Conn, n int) {
+				if n == 1 {
+					conn.WriteMessage(websocket.TextMessage, testMessage(1))
+					conn.WriteMessage(websocket.TextMessage, testMessage(2))
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+					return
+				}
+				conn.WriteMessage(websocket.TextMessage, testMessage(3))
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			startReceiving(t, c, ctx, StreamOptions{ReportReconnects: tt.reportReconnects})
+
+			want := []int{1, 2, 3}
+			if tt.reportReconnects {
+				want = []int{1, 2, 0, 3}
+			}
+			for _, n := range want {
+				m, err := c.Receive(ctx)
+				if n == 0 {
+					if err != ErrReconnected {
+						t.Fatalf("got %v, want %v", err, ErrReconnected)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := testMessageNumber(t, m); got != n {
+					t.Fatalf("got message %d, want %d", got, n)
+				}
+			}
+		})
+	}
+}
+
+// Reconnect drops the connection without closing the client, Receive
+// reports the reconnect and goes on with the new connection
+func TestReceiveAfterReconnect(t *testing.T) {
+	const connections = 3
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {
+		conn.WriteMessage(websocket.TextMessage, testMessage(2*n-1))
+		conn.WriteMessage(websocket.TextMessage, testMessage(2*n))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startReceiving(t, c, ctx, StreamOptions{ReportReconnects: true})
+
+	next := 1
+	for n := 1; n <= connections; n++ {
+		if n > 1 {
+			m, err := c.Receive(ctx)
+			if err != ErrReconnected {
+				t.Fatalf("got %v and %s, want %v", err, m.Raw, ErrReconnected)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			m, err := c.Receive(ctx)
+			if err != nil {
+				t.Fatalf("message %d: %v", next, err)
+			}
+			if got := testMessageNumber(t, m); got != next {
+				t.Fatalf("got message %d, want %d", got, next)
+			}
+			next++
+		}
+		if n < connections {
+			if err := c.Reconnect(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Receive(ctx); err != ErrClientClosed {
+		t.Errorf("got %v after Close, want %v", err, ErrClientClosed)
+	}
+}
+
+func TestReceiveWithoutReceiveBuffer(t *testing.T) {
+	c := newTestPushServer(t, func(conn *websocket.Conn, n int) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := c.Connect(ctx, "test", uuid.Nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(ctx, StreamOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Receive(ctx); err == nil {
+		t.Fatal("no error")
+	}
+}
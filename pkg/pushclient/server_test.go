@@ -0,0 +1,67 @@
+package pushclient
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+const testReconnectToken = "6a3da4b5-c0c8-4d0a-9f4a-0a0a0a0a0c01"
+
+// A push service for the tests. Every connection gets an init message,
+// then serve is called with the number of the connection, starting at 1.
+// Frames from the client are read once serve returns, which answers the
+// close frame of Close.
+func newTestPushServer(t *testing.T, serve func(conn *websocket.Conn, n int)) *Client {
+	t.Helper()
+
+	var mu sync.Mutex
+	connections := 0
+	upgrader := websocket.Upgrader{}
+
+	return newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		mu.Lock()
+		connections++
+		n := connections
+		mu.Unlock()
+
+		init := fmt.Sprintf(`{"channel":"system","cmd":"init","reconnect_token":"%s","reconnected":%t}`, testReconnectToken, n > 1)
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(init)); err != nil {
+			return
+		}
+
+		serve(conn, n)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+// A push message on the series channel with n in the payload
+func testMessage(n int) []byte {
+	return []byte(fmt.Sprintf(`{"channel":"series","created":"2026-01-02T03:04:05Z","payload":{"n":%d}}`, n))
+}
+
+// The n in the payload of a message made by testMessage
+func testMessageNumber(t *testing.T, m PushMessage) int {
+	t.Helper()
+
+	n, ok := m.Payload.Fields["n"].(float64)
+	if !ok {
+		t.Fatalf("message %s has no number", m.Raw)
+	}
+
+	return int(n)
+}
@@ -0,0 +1,259 @@
+package pushclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// How long the default reconnect waits before trying again when the server
+// can't be reached
+const reconnectRetryInterval = 5 * time.Second
+
+// How long the server has to answer the close frame sent by Close
+const closeTimeout = 3 * time.Second
+
+// Sent with the close frame by Close
+const closeReason = "Client closing"
+
+// A message that could not be parsed as a push message. It is sent on
+// Errors and the stream goes on with the next message.
+type MessageError struct {
+	Message []byte // The message as received
+	Err     error
+}
+
+func (e *MessageError) Error() string {
+	return fmt.Sprintf("Error when unmarshalling incoming json. Error:%v, JSON:%s", e.Err, string(e.Message))
+}
+
+// Settings of Start, all optional
+type StreamOptions struct {
+	// Called when the connection was lost, before reconnecting. err is a
+	// *websocket.CloseError when the server closed it, otherwise the read
+	// error. Returning false ends the stream instead.
+	OnDisconnect func(err error) bool
+	// Sets up a new connection after the previous one was lost.
+	// By default the subscriber is resumed with the reconnect token of the
+	// previous connection, retrying while the server can't be reached. An
+	// error ends the stream.
+	Reconnect func(ctx context.Context) error
+	// Makes the stream deliver to Receive and ReceiveRaw instead of
+	// Messages and Errors, keeping up to this many messages that haven't
+	// been received yet. The stream stops reading while the buffer is
+	// full. Zero uses Messages and Errors. Can't be used with handlers.
+	ReceiveBuffer int
+	// Makes Receive return ErrReconnected once after the subscriber was
+	// resumed on a new connection, only used with ReceiveBuffer
+	ReportReconnects bool
+	// With ReceiveBuffer, how long the stream waits for room when the
+	// buffer is full. The message is then dropped and OnDrop is called, so
+	// that a slow consumer of Receive or Stream can't hold up the
+	// websocket for long. Zero waits as long as it takes.
+	MaxReceiveWait time.Duration
+	// Called with a message dropped after MaxReceiveWait, optional
+	OnDrop func(m PushMessage)
+}
+
+// Push messages of the stream started by Start, unless handlers have been
+// registered with On or OnAny. The channel is closed when the stream ends,
+// reconnects are not visible on it.
+func (c *Client) Messages() <-chan PushMessage {
+	return c.messages
+}
+
+// Errors of the stream started by Start: a *MessageError for every message
+// that could not be parsed, and the error that ended the stream, if any.
+// The channel is closed together with Messages. Both channels have to be
+// read from, the stream waits until an error has been received.
+func (c *Client) Errors() <-chan error {
+	return c.errors
+}
+
+// Delivers the messages of the connection set up by Connect on Messages,
+// or to the registered handlers, until ctx is done or the connection can't
+// be resumed. When the connection is lost the subscriber is resumed with
+// the reconnect token, unless the server closed it for authorization
+// reasons. When ctx is done the connection is
+// closed and both channels are closed without an error.
+func (c *Client) Start(ctx context.Context, options StreamOptions) error {
+	c.mu.Lock()
+	if c.conn == nil {
+		c.mu.Unlock()
+		return ErrNotConnected
+	}
+	if c.started {
+		c.mu.Unlock()
+		return fmt.Errorf("The stream has already been started")
+	}
+	if options.ReceiveBuffer > 0 {
+		if len(c.handlers) > 0 || c.anyHandler != nil {
+			c.mu.Unlock()
+			return fmt.Errorf("A ReceiveBuffer can't be used together with handlers")
+		}
+		c.queue = make(chan received, options.ReceiveBuffer)
+		c.reconnects = options.ReportReconnects
+	}
+	c.started = true
+	c.mu.Unlock()
+
+	if options.Reconnect == nil {
+		options.Reconnect = c.reconnect
+	}
+
+	// A read only returns once the connection is closed. The stream reads
+	// the server's answer to the close frame, or the connection is closed
+	// by Close if the server doesn't answer.
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+
+	go c.stream(ctx, options)
+
+	return nil
+}
+
+func (c *Client) stream(ctx context.Context, options StreamOptions) {
+	defer close(c.messages)
+	defer close(c.errors)
+
+	dispatch := c.hasHandlers()
+	c.mu.Lock()
+	queue, reconnects := c.queue, c.reconnects
+	c.mu.Unlock()
+	if queue != nil {
+		defer close(queue)
+	}
+	defer c.closeWriters()
+
+	for {
+		message, err := c.ReadMessage()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			if isPermanentReadError(err) {
+				c.sendError(ctx, fmt.Errorf("Failed to read message. Error: %w", err))
+				return
+			}
+			if options.OnDisconnect != nil && !options.OnDisconnect(err) {
+				return
+			}
+
+			err = options.Reconnect(ctx)
+			if err != nil {
+				c.sendError(ctx, fmt.Errorf("Failed to reconnect to push service. Error: %w", err))
+				return
+			}
+			if queue != nil && reconnects {
+				c.enqueue(ctx, queue, received{err: ErrReconnected})
+			}
+			continue
+		}
+
+		var m PushMessage
+		err = json.Unmarshal(message, &m)
+		if err != nil {
+			c.sendError(ctx, &MessageError{Message: message, Err: err})
+			continue
+		}
+		m.Raw = message
+		c.writeToWriters(message)
+
+		if dispatch {
+			c.dispatch(m)
+			continue
+		}
+		if queue != nil {
+			queued := c.enqueueWithin(ctx, queue, received{message: m}, options.MaxReceiveWait)
+			if !queued && ctx.Err() == nil && options.OnDrop != nil {
+				options.OnDrop(m)
+			}
+			continue
+		}
+
+		select {
+		case c.messages <- m:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reports if a read error means that resuming the subscriber can't work,
+// i.e. the server closed the connection since the credentials are no
+// longer accepted. Other errors, like a closed or reset connection, are
+// recovered from by reconnecting.
+func isPermanentReadError(err error) bool {
+	closeErr, ok := err.(*websocket.CloseError)
+
+	return ok && IsAuthCloseCode(closeErr.Code)
+}
+
+// Sends the error on Errors, or to Receive with a ReceiveBuffer
+func (c *Client) sendError(ctx context.Context, err error) {
+	c.mu.Lock()
+	queue := c.queue
+	c.mu.Unlock()
+
+	if queue != nil {
+		r := received{err: err}
+		if msgErr, ok := err.(*MessageError); ok {
+			r.message.Raw = msgErr.Message
+		}
+		c.enqueue(ctx, queue, r)
+		return
+	}
+
+	select {
+	case c.errors <- err:
+	case <-ctx.Done():
+	}
+}
+
+// Resumes the subscriber of the previous connection, retrying until the
+// server can be reached. When the reconnect token is rejected a new
+// subscriber is started for the subscription. Other errors the server gives
+// a reason for are returned.
+func (c *Client) reconnect(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		subscription, reconnectToken := c.subscription, c.reconnectToken
+		c.mu.Unlock()
+
+		_, err := c.Dial(ctx, reconnectToken, subscription)
+		if err == nil {
+			_, err = c.ReadInitMessage()
+		}
+		switch v := err.(type) {
+		case nil:
+			return nil
+		case *ServerCloseError:
+			if v.Code == CloseInvalidReconnectToken && reconnectToken != uuid.Nil && subscription != "" {
+				// Messages sent while disconnected are lost with a new
+				// subscriber, but the stream can go on
+				c.config.Logf("[WARN] The reconnect token was rejected, connecting without it. Messages sent while disconnected may be lost. Reason: %s\n", CloseReason(v.Reason))
+				c.mu.Lock()
+				c.reconnectToken = uuid.Nil
+				c.mu.Unlock()
+				continue
+			}
+			return err
+		case *WebsocketSetupHTTPError:
+			return err
+		}
+
+		c.config.Logf("[WARN] Couldn't reconnect, retrying in %s. Error: %v\n", reconnectRetryInterval, err)
+		select {
+		case <-time.After(reconnectRetryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
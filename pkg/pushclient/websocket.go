@@ -0,0 +1,318 @@
+package pushclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// The init message is normally the first frame from the server, but other
+// frames may arrive before it. Frames are read until the init message
+// arrives, at most this many frames and for at most initMessageTimeout.
+const maxFramesBeforeInit = 10
+
+var initMessageTimeout = 30 * time.Second
+
+// Turns a websocket frame into the JSON encoded message. The decoder is
+// chosen by the subprotocol the server selected, so other encodings can be
+// added without touching the message handling.
+type FrameDecoder func(frame []byte) ([]byte, error)
+
+func decodeJSONFrame(frame []byte) ([]byte, error) {
+	return frame, nil
+}
+
+// Decoders of the known subprotocols. No subprotocol means JSON.
+var frameDecoders = map[string]FrameDecoder{
+	"":     decodeJSONFrame,
+	"json": decodeJSONFrame,
+}
+
+// Returns the decoder of a subprotocol, ok is false for protocols without
+// one in which case the JSON decoder is returned
+func DecoderFor(protocol string) (decoder FrameDecoder, ok bool) {
+	decoder, ok = frameDecoders[protocol]
+	if !ok {
+		return decodeJSONFrame, false
+	}
+
+	return decoder, true
+}
+
+// Builds the URL of the websocket connection setup request. Parameters
+// that are empty are left out, so the client can connect with only a
+// reconnect token. Any parameters needed for authentication are added too.
+func buildWebsocketURL(wsURL string, reconnectToken uuid.UUID, subscriptionIDOrName string, authParams url.Values) (string, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return "", fmt.Errorf("Invalid websocket address '%s'. Error: %v", wsURL, err)
+	}
+
+	q := u.Query()
+	if subscriptionIDOrName != "" {
+		q.Set("subscription_id", subscriptionIDOrName)
+	}
+	if reconnectToken != uuid.Nil {
+		q.Set("reconnect_token", reconnectToken.String())
+	}
+	for k, v := range authParams {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Opens the websocket for the subscription, or for the subscriber of the
+// reconnect token. Either may be empty but not both. The connection is
+// returned before the init message has been read, see ReadInitMessage.
+// A handshake rejected with 401 Unauthorized is tried once more with a new
+// token if the AuthProvider is a TokenInvalidator.
+func (c *Client) Dial(ctx context.Context, reconnectToken uuid.UUID, subscriptionIDOrName string) (*websocket.Conn, error) {
+	sentAt := time.Now()
+	conn, err := c.dial(ctx, reconnectToken, subscriptionIDOrName)
+	setupErr, ok := err.(*WebsocketSetupHTTPError)
+	if !ok || setupErr.HttpStatus != http.StatusUnauthorized {
+		return conn, err
+	}
+	tokens, ok := c.config.Auth.(TokenInvalidator)
+	if !ok {
+		return conn, err
+	}
+
+	c.config.Logf("[WARN] The websocket handshake was rejected with 401, retrying with a new access token\n")
+	tokens.InvalidateToken(sentAt)
+
+	return c.dial(ctx, reconnectToken, subscriptionIDOrName)
+}
+
+func (c *Client) dial(ctx context.Context, reconnectToken uuid.UUID, subscriptionIDOrName string) (*websocket.Conn, error) {
+	// Add the auth credentials to the ws connection setup request
+	h, err := c.config.Auth.WebsocketHeaders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	params, err := c.config.Auth.QueryParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.config.Headers) > 0 {
+		merged := http.Header{}
+		for name, values := range c.config.Headers {
+			merged[name] = values
+		}
+		for name, values := range h {
+			merged[name] = values
+		}
+		h = merged
+	}
+
+	URL, err := buildWebsocketURL(c.config.Addr, reconnectToken, subscriptionIDOrName, params)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, resp, err := c.config.Dialer.DialContext(ctx, URL, h)
+	if err != nil {
+		if resp != nil {
+			setupErr := &WebsocketSetupHTTPError{HttpStatus: resp.StatusCode, error: err}
+			if resp.StatusCode == http.StatusTooManyRequests {
+				setupErr.RetryAfter, setupErr.HasRetryAfter = retryAfter(resp.Header, time.Now())
+			}
+			return nil, setupErr
+		} else {
+			return nil, err
+		}
+	}
+
+	decode, ok := DecoderFor(conn.Subprotocol())
+	if !ok {
+		c.config.Logf("[WARN] No decoder for subprotocol '%s', decoding messages as JSON\n", conn.Subprotocol())
+	}
+	closeReceived := make(chan struct{})
+	conn.SetCloseHandler(closeHandler(conn, closeReceived))
+
+	c.mu.Lock()
+	previous := c.conn
+	c.conn = conn
+	c.closeReceived = closeReceived
+	c.subscription = subscriptionIDOrName
+	c.decode = decode
+	c.pending = nil
+	c.mu.Unlock()
+
+	// The previous connection was closed by the server or by Close, only
+	// the network connection is left
+	if previous != nil {
+		previous.Close()
+	}
+
+	return conn, nil
+}
+
+// Reads frames from the connection opened by Dial until the init message
+// arrives and returns it. Push messages that arrive before it are kept,
+// ReadMessage returns them first.
+func (c *Client) ReadInitMessage() ([]byte, error) {
+	conn := c.Conn()
+	err := conn.SetReadDeadline(time.Now().Add(initMessageTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < maxFramesBeforeInit; i++ {
+		message, err := c.readSetupFrame(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		var m SystemMessage
+		err = json.Unmarshal(message, &m)
+		if err != nil {
+			c.config.Logf("[WARN] Ignoring unparseable frame received before the init message. Error: %v\n", err)
+			continue
+		}
+
+		if m.Channel == "system" {
+			if m.Cmd == "init" {
+				var init InitResponseMessage
+				if err := json.Unmarshal(message, &init); err == nil {
+					c.mu.Lock()
+					c.reconnectToken = init.ReconnectToken
+					c.mu.Unlock()
+				}
+				return message, conn.SetReadDeadline(time.Time{})
+			}
+
+			c.config.Logf("[DEBUG] Ignoring system message '%s' received before the init message\n", m.Cmd)
+			continue
+		}
+
+		// Push messages are delivered once the connection is set up
+		c.mu.Lock()
+		c.pending = append(c.pending, message)
+		c.mu.Unlock()
+	}
+
+	return nil, fmt.Errorf("No init message among the first %d frames from the server", maxFramesBeforeInit)
+}
+
+func (c *Client) readSetupFrame(conn *websocket.Conn) ([]byte, error) {
+	// Set by Dial, which may run again on another goroutine, e.g. for the
+	// reconnect of the stream
+	c.mu.Lock()
+	subscription, decode := c.subscription, c.decode
+	c.mu.Unlock()
+
+	// The push api server will validate a number of things during websocket
+	// setup, e.g. that the access token is valid, user is authorized etc.
+	// If any validation fails, the server will close the websocket and set
+	// a custom error code.
+	_, message, err := conn.ReadMessage()
+	if closeErr, ok := err.(*websocket.CloseError); ok {
+		var errMsg string
+		switch closeErr.Code {
+		case CloseMissingSecret:
+			errMsg = "No access token or secret was sent in the setup request"
+		case CloseInvalidSecret:
+			errMsg = "The access token or secret is not valid"
+		case CloseNotAuthorized:
+			errMsg = "The account does not have access to the push API"
+		case CloseUnknownSubscriptionID:
+			errMsg = fmt.Sprintf("Subscription ID '%s' is not registered on server", subscription)
+		case CloseMissingSubscriptionID:
+			errMsg = "Missing subscription ID or name in setup request"
+		case CloseMaxNumSubscribers:
+			errMsg = "The max number of concurrent subscribers for the account has been exceeded"
+		case CloseMaxNumSubscriptions:
+			errMsg = "The max number of registered subscriptions for the account has been exceeded"
+		case CloseInvalidReconnectToken:
+			errMsg = "The reconnect token is invalid or has expired"
+		case CloseInternalError:
+			errMsg = "Unknown server error"
+		default:
+			errMsg = fmt.Sprintf("Server sent unrecognized error code %d", closeErr.Code)
+		}
+
+		return nil, &ServerCloseError{Code: closeErr.Code, Reason: closeErr.Text, Message: errMsg}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return decode(message)
+}
+
+// Dials and waits for the init message. Either the subscription or the
+// reconnect token may be empty but not both.
+func (c *Client) Connect(ctx context.Context, subscriptionIDOrName string, reconnectToken uuid.UUID) (InitResponseMessage, error) {
+	_, err := c.Dial(ctx, reconnectToken, subscriptionIDOrName)
+	if err != nil {
+		return InitResponseMessage{}, err
+	}
+
+	initMsg, err := c.ReadInitMessage()
+	if err != nil {
+		return InitResponseMessage{}, fmt.Errorf("Failed to read initial message from server. Error: %v", err)
+	}
+
+	var m InitResponseMessage
+	err = json.Unmarshal(initMsg, &m)
+	if err != nil {
+		return InitResponseMessage{}, fmt.Errorf("Failed to unmarshal init response. Error: %v", err)
+	}
+
+	return m, nil
+}
+
+// Returns the push messages that arrived before the init message and
+// forgets them
+func (c *Client) TakePending() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending := c.pending
+	c.pending = nil
+
+	return pending
+}
+
+// Returns the next message of the current connection, decoded to JSON.
+// Messages that arrived before the init message are returned first. A
+// *websocket.CloseError means the server closed the connection, and the
+// subscriber can be resumed by connecting with the reconnect token. With a
+// read timeout a net.Error with Timeout() true is returned when nothing
+// arrived in time, the connection can't be used after that.
+func (c *Client) ReadMessage() ([]byte, error) {
+	c.mu.Lock()
+	if len(c.pending) > 0 {
+		message := c.pending[0]
+		c.pending = c.pending[1:]
+		c.mu.Unlock()
+		return message, nil
+	}
+	conn, decode, timeout := c.conn, c.decode, c.readTimeout
+	c.mu.Unlock()
+
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+	if timeout > 0 {
+		err := conn.SetReadDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(message)
+}
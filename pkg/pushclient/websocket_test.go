@@ -0,0 +1,370 @@
+package pushclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Only the parameters that are given are in the URL, so a reconnect token
+// alone or a subscription alone make a valid setup request
+func TestBuildWebsocketURL(t *testing.T) {
+	token := uuid.Must(uuid.FromString("6a3da4b5-c0c8-4d0a-9f4a-0a0a0a0a0c01"))
+	id := "7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b"
+	tests := []struct {
+		name         string
+		token        uuid.UUID
+		subscription string
+		authParams   url.Values
+		want         string
+	}{
+		{"token only", token, "", nil, "wss://push.test/v1?reconnect_token=" + token.String()},
+		{"ID only", uuid.Nil, id, nil, "wss://push.test/v1?subscription_id=" + id},
+		{"name only", uuid.Nil, "dev", nil, "wss://push.test/v1?subscription_id=dev"},
+		{"token and ID", token, id, nil, "wss://push.test/v1?reconnect_token=" + token.String() + "&subscription_id=" + id},
+		{"token and name", token, "dev", nil, "wss://push.test/v1?reconnect_token=" + token.String() + "&subscription_id=dev"},
+		{"neither", uuid.Nil, "", nil, "wss://push.test/v1"},
+		{"auth parameters", uuid.Nil, "dev", url.Values{"access_token": {"secret"}}, "wss://push.test/v1?access_token=secret&subscription_id=dev"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := buildWebsocketURL("wss://push.test/v1", test.token, test.subscription, test.authParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// Parameters already in the address are kept
+func TestBuildWebsocketURLKeepsQuery(t *testing.T) {
+	got, err := buildWebsocketURL("wss://push.test/v1?region=eu", uuid.Nil, "dev", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "wss://push.test/v1?region=eu&subscription_id=dev"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestBuildWebsocketURLInvalidAddress(t *testing.T) {
+	if _, err := buildWebsocketURL("wss://push test:port/v1", uuid.Nil, "dev", nil); err == nil {
+		t.Error("no error for an invalid address")
+	}
+}
+
+const testInit = `{"channel":"system","cmd":"init","reconnect_token":"` + testReconnectToken + `","reconnected":false}`
+
+// A push service that sends the frames and then reads until the client
+// closes the connection
+func newSetupServer(t *testing.T, frames ...string) *Client {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	return newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for _, frame := range frames {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+				return
+			}
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func dialTestServer(t *testing.T, c *Client) {
+	t.Helper()
+
+	if _, err := c.Dial(context.Background(), uuid.Nil, "sub"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Conn().Close() })
+}
+
+// Push messages before the init message are returned first by ReadMessage,
+// in the order they arrived, other frames before it are skipped
+func TestReadInitMessageAfterOtherFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []string
+	}{
+		{"init first", []string{testInit, string(testMessage(1)), string(testMessage(2))}},
+		{"push messages first", []string{string(testMessage(1)), string(testMessage(2)), testInit}},
+		{
+			"interleaved",
+			[]string{
+				`{"channel":"system","cmd":"keepalive"}`,
+				string(testMessage(1)),
+				"not json",
+				testInit,
+				string(testMessage(2)),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newSetupServer(t, test.frames...)
+			dialTestServer(t, c)
+
+			init, err := c.ReadInitMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(init) != testInit {
+				t.Errorf("got init message %s", init)
+			}
+			c.mu.Lock()
+			token := c.reconnectToken
+			c.mu.Unlock()
+			if got := token.String(); got != testReconnectToken {
+				t.Errorf("got reconnect token %s, want the one of the init message", got)
+			}
+
+			for n := 1; n <= 2; n++ {
+				message, err := c.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var m PushMessage
+				if err := json.Unmarshal(message, &m); err != nil {
+					t.Fatal(err)
+				}
+				if got := testMessageNumber(t, m); got != n {
+					t.Errorf("got message %d, want %d", got, n)
+				}
+			}
+		})
+	}
+}
+
+func TestReadInitMessageFrameLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		before  int // Push messages before the init message
+		wantErr bool
+	}{
+		{"just below the limit", maxFramesBeforeInit - 1, false},
+		{"at the limit", maxFramesBeforeInit, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var frames []string
+			for i := 1; i <= test.before; i++ {
+				frames = append(frames, string(testMessage(i)))
+			}
+			c := newSetupServer(t, append(frames, testInit)...)
+			dialTestServer(t, c)
+
+			_, err := c.ReadInitMessage()
+			if test.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "No init message among the first") {
+					t.Errorf("got %v, want the frame limit error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(c.TakePending()); got != test.before {
+				t.Errorf("%d push messages are pending, want %d", got, test.before)
+			}
+		})
+	}
+}
+
+func TestReadInitMessageTimeout(t *testing.T) {
+	timeout := initMessageTimeout
+	initMessageTimeout = 50 * time.Millisecond
+	defer func() { initMessageTimeout = timeout }()
+
+	c := newSetupServer(t, string(testMessage(1)))
+	dialTestServer(t, c)
+
+	start := time.Now()
+	_, err := c.ReadInitMessage()
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("gave up after %s, want about %s", took, initMessageTimeout)
+	}
+}
+
+// A client offering the subprotocols to a server that supports the given
+// ones, the server sends one push message. The lines logged by the client
+// are collected in logged.
+func newSubprotocolClient(t *testing.T, offered []string, supported []string, logged *[]string) (c *Client, requested <-chan []string) {
+	t.Helper()
+
+	offers := make(chan []string, 1)
+	upgrader := websocket.Upgrader{Subprotocols: supported}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offers <- websocket.Subprotocols(r)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, testMessage(1))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	var mu sync.Mutex
+	c, err := New(Config{
+		Addr:   "ws" + strings.TrimPrefix(server.URL, "http"),
+		Auth:   NewSecretAuth("secret"),
+		Dialer: &websocket.Dialer{Subprotocols: offered},
+		Logf: func(format string, v ...interface{}) {
+			mu.Lock()
+			*logged = append(*logged, fmt.Sprintf(format, v...))
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, offers
+}
+
+func TestDialSubprotocol(t *testing.T) {
+	tests := []struct {
+		name      string
+		offered   []string
+		supported []string
+		want      string
+		wantWarn  bool
+	}{
+		{"none offered", nil, []string{"json"}, "", false},
+		{"echoed", []string{"json"}, []string{"json"}, "json", false},
+		{"server's preference of several", []string{"compact", "json"}, []string{"json", "compact"}, "json", false},
+		{"ignored by the server", []string{"json"}, nil, "", false},
+		{"unknown selected", []string{"compact"}, []string{"compact"}, "compact", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var logged []string
+			c, requested := newSubprotocolClient(t, test.offered, test.supported, &logged)
+
+			conn, err := c.Dial(context.Background(), uuid.Nil, "sub")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			if got := <-requested; !reflect.DeepEqual(got, test.offered) {
+				t.Errorf("the server was offered %q, want %q", got, test.offered)
+			}
+			if got := conn.Subprotocol(); got != test.want {
+				t.Errorf("got subprotocol %q, want %q", got, test.want)
+			}
+
+			warned := len(logged) == 1 && strings.HasPrefix(logged[0], "[WARN] No decoder for subprotocol")
+			if warned != test.wantWarn || (!test.wantWarn && len(logged) > 0) {
+				t.Errorf("got log lines %q, want a warning %t", logged, test.wantWarn)
+			}
+
+			// Frames of every protocol are decoded as JSON for now
+			message, err := c.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(message) != string(testMessage(1)) {
+				t.Errorf("got message %s, want %s", message, testMessage(1))
+			}
+		})
+	}
+}
+
+// A close frame from the server during setup is returned with its code,
+// the explanation of the code and the reason text the server sent
+func TestReadInitMessageCloseReason(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        int
+		reason      string
+		wantMessage string
+		wantError   string
+	}{
+		{
+			"with reason",
+			CloseInvalidSecret, "token expired",
+			"The access token or secret is not valid",
+			"Server closed connection with message: The access token or secret is not valid. Reason: token expired",
+		},
+		{
+			"without reason",
+			CloseMaxNumSubscribers, "",
+			"The max number of concurrent subscribers for the account has been exceeded",
+			"Reason: (no reason given)",
+		},
+		{
+			"unknown code",
+			4999, "subscription deleted by admin",
+			"Server sent unrecognized error code 4999",
+			"Reason: subscription deleted by admin",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upgrader := websocket.Upgrader{}
+			c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				message := websocket.FormatCloseMessage(test.code, test.reason)
+				conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+				conn.ReadMessage()
+			}))
+			dialTestServer(t, c)
+
+			_, err := c.ReadInitMessage()
+			closeErr, ok := err.(*ServerCloseError)
+			if !ok {
+				t.Fatalf("got error %v, want a *ServerCloseError", err)
+			}
+			if closeErr.Code != test.code || closeErr.Reason != test.reason || closeErr.Message != test.wantMessage {
+				t.Errorf("got %+v, want code %d, reason '%s' and message '%s'", *closeErr, test.code, test.reason, test.wantMessage)
+			}
+			if !strings.Contains(err.Error(), test.wantError) {
+				t.Errorf("the error '%v' doesn't contain '%s'", err, test.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofrs/uuid"
+)
+
+// How often a warning about quarantined messages is logged at most
+const quarantineReportInterval = time.Minute
+
+// Number of rotated quarantine files to keep
+const quarantineMaxFiles = 5
+
+// Appends messages that failed validation to a file, together with the
+// error and information about the connection, so they can be reported
+type quarantine struct {
+	mu          sync.Mutex
+	file        *rotatingFile
+	sinceReport int
+	lastReport  time.Time
+}
+
+// Set at startup if '--quarantine-file' is given
+var messageQuarantine *quarantine
+
+// One line in the quarantine file. The message is kept as a string if it is
+// valid UTF-8 and base64 encoded otherwise.
+type quarantineRecord struct {
+	QuarantinedAt  time.Time `json:"quarantined_at"`
+	Error          string    `json:"error"`
+	Addr           string    `json:"addr"`
+	SubscriptionID string    `json:"subscription_id"`
+	SubscriberID   uuid.UUID `json:"subscriber_id"`
+	Message        *string   `json:"message,omitempty"`
+	MessageBase64  string    `json:"message_base64,omitempty"`
+}
+
+func newQuarantine(fileName string, maxSize int64) (*quarantine, error) {
+	f, err := openRotatingFile(fileName, maxSize, quarantineMaxFiles)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open quarantine file. Error: %v", err)
+	}
+
+	return &quarantine{file: f, lastReport: time.Now()}, nil
+}
+
+func (q *quarantine) add(msg []byte, reason error) {
+	stats.messageQuarantined()
+
+	s := stats.snapshot()
+	record := quarantineRecord{
+		QuarantinedAt:  time.Now().UTC(),
+		Error:          reason.Error(),
+		Addr:           *addrFlag,
+		SubscriptionID: currentSubscription(),
+		SubscriberID:   s.subscriberID,
+	}
+	if utf8.Valid(msg) {
+		m := string(msg)
+		record.Message = &m
+	} else {
+		record.MessageBase64 = base64.StdEncoding.EncodeToString(msg)
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Println("[ERROR] Failed to marshal quarantine record. Error: ", err)
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err = q.file.Write(append(line, '\n'))
+	if err != nil {
+		log.Println("[ERROR] Failed to write to quarantine file. Error: ", err)
+	}
+
+	// Summarize instead of logging every message
+	q.sinceReport++
+	if time.Since(q.lastReport) >= quarantineReportInterval {
+		q.report()
+	}
+}
+
+func (q *quarantine) report() {
+	if q.sinceReport > 0 {
+		log.Printf("[WARN] %d messages were quarantined in the last %s\n", q.sinceReport, roundDuration(time.Since(q.lastReport), time.Second))
+	}
+	q.sinceReport = 0
+	q.lastReport = time.Now()
+}
+
+func (q *quarantine) close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.report()
+	return q.file.Close()
+}
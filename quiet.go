@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	flag "github.com/spf13/pflag"
+)
+
+var quietFlag = flag.CountP("quiet", "q", "Log a summary line instead of the config, existing subscriptions and init message dumps, given twice ('-qq') also hides the info log lines")
+
+// Prints the push service config fetched at startup, or only a summary of
+// it with '--quiet'
+func printConfig(config []byte, parsed PushServiceConfig) {
+	if *quietFlag == 0 {
+		printJsonWithTag("PUSH CONFIG", config)
+		return
+	}
+
+	if parsed.MaxSubscriptions > 0 {
+		log.Printf("[INFO] Push service config has %s and allows %d subscriptions\n", countChannels(len(parsed.Channels)), parsed.MaxSubscriptions)
+	} else {
+		log.Printf("[INFO] Push service config has %s\n", countChannels(len(parsed.Channels)))
+	}
+}
+
+// Prints the subscriptions registered at startup, or only their number
+// with '--quiet'
+func printExistingSubscriptions(subsJSON []byte, config PushServiceConfig) error {
+	if *quietFlag == 0 {
+		return printSubscriptions("EXISTING SUBSCRIPTIONS", subsJSON)
+	}
+
+	var subs []Subscription
+	err := json.Unmarshal(subsJSON, &subs)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal subscriptions. Error: %v", err)
+	}
+	if config.MaxSubscriptions > 0 {
+		log.Printf("[INFO] %d existing subscriptions, limit %d\n", len(subs), config.MaxSubscriptions)
+	} else {
+		log.Printf("[INFO] %d existing subscriptions\n", len(subs))
+	}
+
+	return nil
+}
+
+// Prints the init message of a new connection, or only who we are
+// connected as with '--quiet'
+func printInitMessage(initMsg []byte, m InitResponseMessage) {
+	if *quietFlag == 0 {
+		printJsonWithTag("INIT MSG", initMsg)
+		return
+	}
+
+	state := "new subscriber"
+	if m.Reconnected {
+		state = "resumed subscriber"
+	}
+	log.Printf("[INFO] Connected as %s %s of subscription %s ('%s') with %s\n", state, m.SubscriberID, m.Subscription.ID, m.Subscription.Name, countFilters(len(m.Subscription.Filters)))
+}
+
+func countChannels(n int) string {
+	if n == 1 {
+		return "1 channel"
+	}
+
+	return fmt.Sprintf("%d channels", n)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	flag "github.com/spf13/pflag"
+)
+
+var recentMessagesFlag = flag.Int("recent-messages", 200, "Number of recent messages kept in memory for '/recent' and '--dump-recent'")
+var recentMaxBytesFlag = flag.Int("recent-max-bytes", 8*1024*1024, "Max total size in bytes of the recent messages kept in memory")
+var dumpRecentFlag = flag.String("dump-recent", "", "Write the recent messages to this file when the client gets SIGUSR1")
+
+type recentMessage struct {
+	channel string
+	data    []byte
+}
+
+// The last received messages, limited both by count and by their total
+// size. The oldest messages are evicted first. A single message larger
+// than the size limit is not kept at all.
+type recentBuffer struct {
+	mu       sync.Mutex
+	maxCount int
+	maxBytes int
+	messages []recentMessage // Oldest first
+	size     int
+}
+
+// Always set, but keeps nothing if '--recent-messages' is 0
+var recentMessages *recentBuffer
+
+func newRecentBuffer(maxCount int, maxBytes int) *recentBuffer {
+	return &recentBuffer{maxCount: maxCount, maxBytes: maxBytes}
+}
+
+func (b *recentBuffer) add(channel string, msg []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxCount == 0 || len(msg) > b.maxBytes {
+		return
+	}
+
+	// Copy the message since the caller may reuse the slice
+	data := append([]byte(nil), msg...)
+	b.messages = append(b.messages, recentMessage{channel: channel, data: data})
+	b.size += len(data)
+
+	evict := 0
+	for len(b.messages)-evict > b.maxCount || b.size > b.maxBytes {
+		b.size -= len(b.messages[evict].data)
+		evict++
+	}
+	if evict > 0 {
+		// Copy to a new slice so the evicted messages can be freed
+		b.messages = append([]recentMessage(nil), b.messages[evict:]...)
+	}
+}
+
+// Returns at most count of the most recent messages, oldest first. An
+// empty channel matches all messages and a count of 0 means no limit.
+func (b *recentBuffer) recent(count int, channel string) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var msgs [][]byte
+	for i := len(b.messages) - 1; i >= 0 && (count == 0 || len(msgs) < count); i-- {
+		if channel == "" || b.messages[i].channel == channel {
+			msgs = append(msgs, b.messages[i].data)
+		}
+	}
+
+	// Reverse to have the oldest first
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+
+	return msgs
+}
+
+// Writes the messages as one line of compact JSON each
+func writeNDJSON(w io.Writer, msgs [][]byte) error {
+	bw := bufio.NewWriter(w)
+	for _, msg := range msgs {
+		var line bytes.Buffer
+		err := json.Compact(&line, msg)
+		if err != nil {
+			return fmt.Errorf("Failed to compact message. Error: %v", err)
+		}
+		line.WriteByte('\n')
+
+		_, err = bw.Write(line.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Writes all recent messages to the file given by '--dump-recent',
+// replacing its contents
+func dumpRecentMessages(fileName string) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		log.Println("[ERROR] Failed to create recent messages file. Error: ", err)
+		return
+	}
+
+	msgs := recentMessages.recent(0, "")
+	err = writeNDJSON(f, msgs)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		log.Println("[ERROR] Failed to write recent messages file. Error: ", err)
+		return
+	}
+
+	log.Printf("[INFO] Wrote %d recent messages to '%s'\n", len(msgs), fileName)
+}
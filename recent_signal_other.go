@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Dumps the recent messages to the file every time the client gets SIGUSR1
+func setupDumpRecentSignal(fileName string) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+
+	go func() {
+		for range sigs {
+			dumpRecentMessages(fileName)
+		}
+	}()
+
+	return nil
+}
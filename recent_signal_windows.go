@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Windows has no SIGUSR1, use the '/recent' endpoint of '--control-addr'
+// instead
+func setupDumpRecentSignal(fileName string) error {
+	return fmt.Errorf("The option '--dump-recent' is not supported on Windows, use '--control-addr' and '/recent' instead")
+}
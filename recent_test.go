@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type recentAdd struct {
+	channel string
+	msg     string
+}
+
+func recentStrings(msgs [][]byte) []string {
+	got := []string{}
+	for _, m := range msgs {
+		got = append(got, string(m))
+	}
+
+	return got
+}
+
+func TestRecentBufferEviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxCount int
+		maxBytes int
+		adds     []recentAdd
+		want     []string
+	}{
+		{
+			"within both limits",
+			3, 100,
+			[]recentAdd{{"a", "1"}, {"a", "2"}},
+			[]string{"1", "2"},
+		},
+		{
+			"by count",
+			2, 100,
+			[]recentAdd{{"a", "1"}, {"a", "2"}, {"a", "3"}},
+			[]string{"2", "3"},
+		},
+		{
+			"by bytes",
+			10, 5,
+			[]recentAdd{{"a", "11"}, {"a", "22"}, {"a", "33"}, {"a", "44"}},
+			[]string{"33", "44"},
+		},
+		{
+			"several evicted by one large message",
+			10, 6,
+			[]recentAdd{{"a", "1"}, {"a", "2"}, {"a", "3"}, {"a", "55555"}},
+			[]string{"3", "55555"},
+		},
+		{
+			"exactly the size limit",
+			10, 4,
+			[]recentAdd{{"a", "1"}, {"a", "4444"}},
+			[]string{"4444"},
+		},
+		{
+			"larger than the size limit",
+			10, 4,
+			[]recentAdd{{"a", "1"}, {"a", "55555"}, {"a", "2"}},
+			[]string{"1", "2"},
+		},
+		{
+			"nothing kept",
+			0, 100,
+			[]recentAdd{{"a", "1"}},
+			[]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newRecentBuffer(test.maxCount, test.maxBytes)
+			for _, add := range test.adds {
+				b.add(add.channel, []byte(add.msg))
+			}
+
+			if got := recentStrings(b.recent(0, "")); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			size := 0
+			for _, m := range b.messages {
+				size += len(m.data)
+			}
+			if b.size != size {
+				t.Errorf("the size is %d, the messages add up to %d", b.size, size)
+			}
+		})
+	}
+}
+
+func TestRecentBufferRecent(t *testing.T) {
+	b := newRecentBuffer(10, 100)
+	for _, add := range []recentAdd{{"series", "s1"}, {"match", "m1"}, {"series", "s2"}, {"match", "m2"}, {"series", "s3"}} {
+		b.add(add.channel, []byte(add.msg))
+	}
+
+	tests := []struct {
+		count   int
+		channel string
+		want    []string
+	}{
+		{0, "", []string{"s1", "m1", "s2", "m2", "s3"}},
+		{2, "", []string{"m2", "s3"}},
+		{0, "series", []string{"s1", "s2", "s3"}},
+		{2, "match", []string{"m1", "m2"}},
+		{1, "match", []string{"m2"}},
+		{10, "series", []string{"s1", "s2", "s3"}},
+		{0, "unknown", []string{}},
+	}
+
+	for _, test := range tests {
+		if got := recentStrings(b.recent(test.count, test.channel)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("recent(%d, %q): got %q, want %q", test.count, test.channel, got, test.want)
+		}
+	}
+}
+
+// The buffer keeps its own copy, the caller may reuse the slice
+func TestRecentBufferCopies(t *testing.T) {
+	b := newRecentBuffer(10, 100)
+	msg := []byte("abc")
+	b.add("a", msg)
+	copy(msg, "xyz")
+
+	if got := b.recent(0, ""); !bytes.Equal(got[0], []byte("abc")) {
+		t.Errorf("got %q, want the message as added", got[0])
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	var out bytes.Buffer
+	err := writeNDJSON(&out, [][]byte{[]byte("{\n  \"a\": 1\n}"), []byte(`[1, 2]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"a\":1}\n[1,2]\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	if err := writeNDJSON(&out, [][]byte{[]byte("not json")}); err == nil {
+		t.Error("invalid JSON was written")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	flag "github.com/spf13/pflag"
+)
+
+var reconnectTokenFileFlag = flag.String("reconnect-token-file", "", "Keep the latest reconnect token in this file and resume with it on startup, unless '--reconnect-token' is given")
+
+// The document written to the '--reconnect-token-file'
+type storedReconnectToken struct {
+	ReconnectToken   uuid.UUID `json:"reconnect_token"`
+	SubscriptionID   uuid.UUID `json:"subscription_id"`
+	SubscriptionName string    `json:"subscription_name,omitempty"`
+	SavedAt          time.Time `json:"saved_at"`
+}
+
+// The token of the latest init message, written again when draining
+var latestToken struct {
+	sync.Mutex
+	stored storedReconnectToken
+}
+
+// Reports if the token belongs to the subscription with the given ID or
+// name
+func (t storedReconnectToken) belongsTo(subscriptionIDOrName string) bool {
+	return subscriptionIDOrName == t.SubscriptionID.String() ||
+		(t.SubscriptionName != "" && subscriptionIDOrName == t.SubscriptionName)
+}
+
+// Remembers the token of an init message and writes it to the
+// '--reconnect-token-file', if given
+func reconnectTokenReceived(m InitResponseMessage) {
+	t := storedReconnectToken{
+		ReconnectToken:   m.ReconnectToken,
+		SubscriptionID:   m.Subscription.ID,
+		SubscriptionName: m.Subscription.Name,
+		SavedAt:          time.Now().UTC(),
+	}
+
+	latestToken.Lock()
+	latestToken.stored = t
+	latestToken.Unlock()
+
+	if *reconnectTokenFileFlag != "" {
+		err := writeReconnectTokenFile(*reconnectTokenFileFlag, t)
+		if err != nil {
+			log.Println("[WARN] Failed to write reconnect token file. Error: ", err)
+		}
+	}
+}
+
+// Writes the file atomically, so that a crash never leaves a partial token
+// behind
+func writeReconnectTokenFile(fileName string, t storedReconnectToken) error {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(fileName, append(b, '\n'))
+}
+
+// Reads the file written by writeReconnectTokenFile. Files with only the
+// token, as written when draining by earlier versions, are accepted too.
+func readReconnectTokenFile(fileName string) (storedReconnectToken, error) {
+	var t storedReconnectToken
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return t, err
+	}
+
+	token, err := uuid.FromString(strings.TrimSpace(string(b)))
+	if err == nil {
+		t.ReconnectToken = token
+		return t, nil
+	}
+
+	err = json.Unmarshal(b, &t)
+	if err != nil {
+		return t, fmt.Errorf("Invalid reconnect token file '%s'. Error: %v", fileName, err)
+	}
+
+	return t, nil
+}
+
+// Returns the stored token to resume the subscriber of the subscription
+// with, or uuid.Nil when there is none or it belongs to another
+// subscription
+func resumeFromReconnectTokenFile(fileName string, subscriptionIDOrName string) uuid.UUID {
+	t, err := readReconnectTokenFile(fileName)
+	if os.IsNotExist(err) {
+		return uuid.Nil
+	} else if err != nil {
+		log.Println("[WARN] Not resuming with the stored reconnect token. Error: ", err)
+		return uuid.Nil
+	}
+
+	if t.SubscriptionID != uuid.Nil && !t.belongsTo(subscriptionIDOrName) {
+		log.Printf("[WARN] Not resuming with the reconnect token in '%s', it belongs to subscription %s and not '%s'\n", fileName, t.SubscriptionID, subscriptionIDOrName)
+		return uuid.Nil
+	}
+
+	if t.SavedAt.IsZero() {
+		log.Printf("[INFO] Resuming with the reconnect token in '%s'\n", fileName)
+	} else {
+		log.Printf("[INFO] Resuming with the reconnect token in '%s', saved %s ago\n", fileName, roundDuration(time.Since(t.SavedAt), time.Second))
+	}
+
+	return t.ReconnectToken
+}
+
+// Removes the '--reconnect-token-file' once the server has rejected its
+// token, so that it isn't used again after a restart
+func invalidateReconnectTokenFile() {
+	if *reconnectTokenFileFlag == "" {
+		return
+	}
+
+	err := os.Remove(*reconnectTokenFileFlag)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("[WARN] Failed to remove the rejected reconnect token file. Error: ", err)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+var testSubscriptionID = uuid.Must(uuid.FromString("7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b"))
+var testToken = uuid.Must(uuid.FromString("6a3da4b5-c0c8-4d0a-9f4a-0a0a0a0a0c01"))
+
+// Points '--reconnect-token-file' at a file in a new directory until the
+// test ends
+func useReconnectTokenFile(t *testing.T) string {
+	fileName := filepath.Join(t.TempDir(), "token.json")
+	*reconnectTokenFileFlag = fileName
+	t.Cleanup(func() { *reconnectTokenFileFlag = "" })
+
+	return fileName
+}
+
+func TestReconnectTokenFileRoundTrip(t *testing.T) {
+	fileName := useReconnectTokenFile(t)
+	stored := storedReconnectToken{
+		ReconnectToken:   testToken,
+		SubscriptionID:   testSubscriptionID,
+		SubscriptionName: "dev",
+		SavedAt:          time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC),
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := writeReconnectTokenFile(fileName, stored); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readReconnectTokenFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != stored {
+			t.Errorf("got %+v, want %+v", got, stored)
+		}
+		stored.ReconnectToken = uuid.Must(uuid.NewV4())
+	}
+
+	// The temporary files are renamed or removed
+	files, err := ioutil.ReadDir(filepath.Dir(fileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "token.json" {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Errorf("the directory has %q, want only the token file", names)
+	}
+}
+
+// The new token replaces the file instead of being written into it, so a
+// reader never sees a partial token
+func TestReconnectTokenFileReplacedAtomically(t *testing.T) {
+	fileName := useReconnectTokenFile(t)
+	old := storedReconnectToken{ReconnectToken: testToken, SubscriptionID: testSubscriptionID}
+	if err := writeReconnectTokenFile(fileName, old); err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := writeReconnectTokenFile(fileName, storedReconnectToken{ReconnectToken: uuid.Must(uuid.NewV4())}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file opened before the write still has the old token
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(before) {
+		t.Errorf("the old file was changed to %q", b)
+	}
+}
+
+func TestWriteReconnectTokenFileMissingDirectory(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "missing", "token.json")
+
+	if err := writeReconnectTokenFile(fileName, storedReconnectToken{ReconnectToken: testToken}); err == nil {
+		t.Errorf("the file was written to a missing directory")
+	}
+}
+
+func TestResumeFromReconnectTokenFile(t *testing.T) {
+	stored := fmt.Sprintf(`{"reconnect_token": "%s", "subscription_id": "%s", "subscription_name": "dev", "saved_at": "2020-05-17T12:30:00Z"}`, testToken, testSubscriptionID)
+	tests := []struct {
+		name         string
+		content      string // No file if empty
+		subscription string
+		want         uuid.UUID
+	}{
+		{"no file", "", "dev", uuid.Nil},
+		{"by ID", stored, testSubscriptionID.String(), testToken},
+		{"by name", stored, "dev", testToken},
+		{"other subscription", stored, "prod", uuid.Nil},
+		{"only the token", testToken.String() + "\n", "prod", testToken},
+		{"without subscription", fmt.Sprintf(`{"reconnect_token": "%s"}`, testToken), "prod", testToken},
+		{"truncated", stored[:30], "dev", uuid.Nil},
+		{"empty", "\n", "dev", uuid.Nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLog(t)
+			fileName := useReconnectTokenFile(t)
+			if test.content != "" {
+				if err := ioutil.WriteFile(fileName, []byte(test.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := resumeFromReconnectTokenFile(fileName, test.subscription); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// A push service that rejects the reconnect token stale with
+// CloseInvalidReconnectToken, and otherwise starts a new subscriber with
+// the reconnect token fresh
+func newTokenCheckingServer(t *testing.T, stale uuid.UUID, fresh uuid.UUID) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if r.URL.Query().Get("reconnect_token") == stale.String() {
+			message := websocket.FormatCloseMessage(pushclient.CloseInvalidReconnectToken, "expired")
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		} else {
+			init := fmt.Sprintf(`{"channel": "system", "cmd": "init", "subscriber_id": "%s", "reconnect_token": "%s", "subscription": {"id": "%s", "name": "dev"}, "reconnected": false}`, uuid.Must(uuid.NewV4()), fresh, testSubscriptionID)
+			conn.WriteMessage(websocket.TextMessage, []byte(init))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// A stale token from the file is rejected, the file is removed and then
+// written again with the token of the new subscriber
+func TestStaleReconnectTokenFile(t *testing.T) {
+	discardLog(t)
+	fileName := useReconnectTokenFile(t)
+	stale := testToken
+	fresh := uuid.Must(uuid.NewV4())
+	stored := storedReconnectToken{ReconnectToken: stale, SubscriptionID: testSubscriptionID, SubscriptionName: "dev"}
+	if err := writeReconnectTokenFile(fileName, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := pushclient.New(pushclient.Config{
+		Addr: newTokenCheckingServer(t, stale, fresh),
+		Auth: pushclient.NewSecretAuth("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiClient = client
+	t.Cleanup(func() {
+		stopKeepAlive()
+		// Nothing reads the server's answer to a close handshake
+		if conn := client.Conn(); conn != nil {
+			conn.Close()
+		}
+		apiClient = nil
+		setCurrentReconnectToken(uuid.Nil)
+	})
+
+	token := resumeFromReconnectTokenFile(fileName, "dev")
+	if token != stale {
+		t.Fatalf("resuming with %s, want %s", token, stale)
+	}
+	if err := setupPushServiceConnection(context.Background(), token, "dev"); err != nil {
+		t.Fatal(err)
+	}
+
+	if currentReconnectToken() != fresh {
+		t.Errorf("the current token is %s, want %s", currentReconnectToken(), fresh)
+	}
+	got, err := readReconnectTokenFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReconnectToken != fresh || got.SubscriptionID != testSubscriptionID {
+		t.Errorf("the file has %+v, want the token %s of subscription %s", got, fresh, testSubscriptionID)
+	}
+}
+
+func TestInvalidateReconnectTokenFile(t *testing.T) {
+	discardLog(t)
+	fileName := useReconnectTokenFile(t)
+	if err := writeReconnectTokenFile(fileName, storedReconnectToken{ReconnectToken: testToken}); err != nil {
+		t.Fatal(err)
+	}
+
+	invalidateReconnectTokenFile()
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Errorf("the rejected token file still exists: %v", err)
+	}
+	// Already removed, e.g. by a second rejection
+	invalidateReconnectTokenFile()
+}
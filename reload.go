@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Held while reconnecting, a reload waits until the subscriber is
+// connected again
+var reconnectMu sync.Mutex
+
+// Held while a reload updates the subscription, so that shutdown never
+// deletes a subscription that is being updated
+var reloadMu sync.Mutex
+
+// Set by shutdown, no reload updates the subscription after that
+var reloadsStopped bool
+
+// Updates the subscription with the '--subscription-file' specs every time
+// the client gets SIGHUP, without reconnecting
+func setupReloadSignal(fileNames []string) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	go func() {
+		for range sigs {
+			log.Println("[INFO] Reloading the subscription spec")
+			err := reloadSubscription(context.Background(), fileNames)
+			if err != nil {
+				log.Println("[ERROR] Failed to reload the subscription spec, keeping the subscription as it is. Error: ", err)
+			}
+		}
+	}()
+}
+
+// Reads the spec files again and updates the subscription the client is
+// connected to if they changed it. The description and owner tag are
+// handled as when registering.
+func reloadSubscription(ctx context.Context, fileNames []string) error {
+	spec, err := readSubscriptionSpecs(fileNames)
+	if err != nil {
+		return fmt.Errorf("Could not read subscription spec from file. Error: %v", err)
+	}
+	if *ownerTagFlag != "" {
+		spec.Description = withOwnerTag(spec.Description, *ownerTagFlag)
+	}
+
+	reconnectMu.Lock()
+	defer reconnectMu.Unlock()
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if reloadsStopped {
+		return fmt.Errorf("The client is shutting down")
+	}
+
+	subscription := currentSubscription()
+	existing, err := apiClient.FetchSubscription(ctx, subscription)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch subscription %s. Error: %v", subscription, err)
+	}
+
+	err = checkSubscriptionOwner(existing, *ownerTagFlag, *forceForeignFlag, "update")
+	if err != nil {
+		return err
+	}
+
+	spec.ID = existing.ID
+	spec = keepExistingDescription(existing, spec, *clearDescriptionFlag, *ownerTagFlag)
+	changes := diffSubscriptions(existing, spec)
+	if len(changes) == 0 {
+		log.Printf("[INFO] Subscription %s already matches the spec, not updating it\n", existing.ID)
+		return nil
+	}
+
+	_, nameTaken, err := apiClient.UpdateSubscription(ctx, spec)
+	if err != nil {
+		return err
+	} else if nameTaken {
+		return fmt.Errorf("Another subscription is already named '%s'", spec.Name)
+	}
+
+	// Messages are attributed to the new filters from now on
+	stats.setFilters(spec.Filters)
+	emitEvent(lifecycleEvent{Event: eventSubscriptionUpdated, SubscriptionID: existing.ID.String()})
+	log.Printf("[INFO] Updated subscription %s (%s)\n", existing.ID, summarizeChanges(changes))
+
+	return nil
+}
+
+// Waits for a reload in progress and makes sure no more are done
+func stopReloads() {
+	reloadMu.Lock()
+	reloadsStopped = true
+	reloadMu.Unlock()
+}
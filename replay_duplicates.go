@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	flag "github.com/spf13/pflag"
+)
+
+var suppressReplayDuplicatesFlag = flag.Bool("suppress-replay-duplicates", false, "Drop messages replayed by the server after a reconnect that were already received")
+var replayWindowFlag = flag.Duration("replay-window", time.Minute, "How long after a reconnect messages are checked for duplicates")
+var seenUUIDsFlag = flag.Int("seen-uuids", 10000, "Number of message UUIDs remembered for finding duplicates")
+
+// Remembers the UUIDs of the most recent messages, forgetting the least
+// recently seen when full
+type uuidLRU struct {
+	capacity int
+	order    *list.List // Front is the most recently seen
+	elements map[uuid.UUID]*list.Element
+}
+
+func newUUIDLRU(capacity int) *uuidLRU {
+	return &uuidLRU{
+		capacity: capacity,
+		order:    list.New(),
+		elements: make(map[uuid.UUID]*list.Element),
+	}
+}
+
+// Adds the UUID and returns whether it had already been seen
+func (l *uuidLRU) add(id uuid.UUID) bool {
+	if e, ok := l.elements[id]; ok {
+		l.order.MoveToFront(e)
+		return true
+	}
+
+	l.elements[id] = l.order.PushFront(id)
+	if l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.elements, oldest.Value.(uuid.UUID))
+	}
+
+	return false
+}
+
+// Drops messages the server replays after a reconnect with a token if
+// they were already received before the connection was lost. Messages are
+// only checked during a window after each reconnect, a duplicate at any
+// other time is passed on as usual.
+type replayFilter struct {
+	mu         sync.Mutex
+	seen       *uuidLRU
+	window     time.Duration
+	windowEnd  time.Time
+	suppressed int // Duplicates dropped in the current window
+	fresh      int // Messages not seen before that arrived in the current window
+}
+
+// Set at startup if '--suppress-replay-duplicates' is given
+var replayDuplicates *replayFilter
+
+func newReplayFilter(window time.Duration, capacity int) *replayFilter {
+	return &replayFilter{seen: newUUIDLRU(capacity), window: window}
+}
+
+// Forgets all UUIDs
+func (l *uuidLRU) reset() {
+	l.order.Init()
+	l.elements = make(map[uuid.UUID]*list.Element)
+}
+
+// Called for every init message. The server only replays messages to a
+// resumed subscriber, so a window is started. A new subscriber gets no
+// replay, the UUIDs seen by the previous one are forgotten.
+func (f *replayFilter) initReceived(resumed bool) {
+	if resumed {
+		f.reconnected()
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seen.reset()
+	f.windowEnd = time.Time{}
+}
+
+// Starts a new window after the subscriber has been resumed. A report is
+// logged when the window ends.
+func (f *replayFilter) reconnected() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if time.Now().Before(f.windowEnd) {
+		// Still in the window of the previous reconnect, extend it and
+		// report once at the end
+		f.windowEnd = time.Now().Add(f.window)
+		return
+	}
+
+	f.windowEnd = time.Now().Add(f.window)
+	f.suppressed = 0
+	f.fresh = 0
+	go f.reportWhenDone()
+}
+
+func (f *replayFilter) reportWhenDone() {
+	for {
+		f.mu.Lock()
+		remaining := time.Until(f.windowEnd)
+		if remaining <= 0 {
+			log.Printf("[INFO] Replay window after reconnect ended, %d replayed duplicates were suppressed and %d new messages arrived\n", f.suppressed, f.fresh)
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+
+		time.Sleep(remaining)
+	}
+}
+
+// Remembers the message and returns true if it should be dropped
+func (f *replayFilter) isReplayDuplicate(id uuid.UUID) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	seen := f.seen.add(id)
+	if !time.Now().Before(f.windowEnd) {
+		return false
+	}
+
+	if seen {
+		f.suppressed++
+		stats.replayDuplicateSuppressed()
+		return true
+	}
+	f.fresh++
+
+	return false
+}
+
+// Adds "replay_duplicate": true to the message, so that a suppressed
+// duplicate can be told apart in the recent messages. The message is
+// returned unchanged if it isn't a JSON object.
+func markReplayDuplicate(msg []byte) []byte {
+	trimmed := bytes.TrimLeft(msg, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return msg
+	}
+
+	marked := []byte(`{"replay_duplicate":true`)
+	if rest := bytes.TrimLeft(trimmed[1:], " \t\r\n"); len(rest) > 0 && rest[0] != '}' {
+		marked = append(marked, ',')
+	}
+
+	return append(marked, trimmed[1:]...)
+}
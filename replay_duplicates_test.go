@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkReplayDuplicate(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{`{"channel":"series","payload":{}}`, `{"replay_duplicate":true,"channel":"series","payload":{}}`},
+		{"\n  { \"channel\": \"series\" }", `{"replay_duplicate":true, "channel": "series" }`},
+		{`{}`, `{"replay_duplicate":true}`},
+		{`{ }`, `{"replay_duplicate":true }`},
+		{`[1, 2]`, `[1, 2]`},
+	}
+
+	for _, test := range tests {
+		got := string(markReplayDuplicate([]byte(test.msg)))
+		if got != test.want {
+			t.Errorf("marked %q as %q, want %q", test.msg, got, test.want)
+		}
+		if !json.Valid([]byte(got)) {
+			t.Errorf("marked %q isn't valid JSON: %q", test.msg, got)
+		}
+	}
+}
+
+// A duplicate replayed in the window isn't printed, but the recent messages
+// keep it with the marker after the message received first
+func TestReplayDuplicateRecorded(t *testing.T) {
+	discardLog(t)
+	lines := pipeLines(pipeStdout(t))
+	recentMessages = newRecentBuffer(10, 1024*1024)
+	replayDuplicates = newReplayFilter(time.Minute, 10)
+	t.Cleanup(func() {
+		recentMessages = nil
+		replayDuplicates = nil
+	})
+
+	raw := `{"channel": "series", "uuid": "2b8b7e9c-5b0a-4c1e-9d6f-3e2a1b0c9d8e", "payload": {"n": 1}}`
+	var msg PushMessage
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		t.Fatal(err)
+	}
+	msg.Raw = []byte(raw)
+
+	handleMessage(msg)
+	replayDuplicates.windowEnd = time.Now().Add(time.Minute)
+	handleMessage(msg)
+
+	select {
+	case line := <-lines:
+		if strings.Contains(line, "replay_duplicate") {
+			t.Errorf("printed the duplicate: %s", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first message wasn't printed")
+	}
+	select {
+	case line := <-lines:
+		t.Errorf("printed the duplicate: %s", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	recent := recentMessages.recent(0, "")
+	if len(recent) != 2 {
+		t.Fatalf("kept %d recent messages, want the message and its duplicate", len(recent))
+	}
+	if strings.Contains(string(recent[0]), "replay_duplicate") {
+		t.Errorf("the first message is marked as a duplicate: %s", recent[0])
+	}
+	var marked struct {
+		ReplayDuplicate bool `json:"replay_duplicate"`
+		Channel         string
+	}
+	if err := json.Unmarshal(recent[1], &marked); err != nil {
+		t.Fatal(err)
+	}
+	if !marked.ReplayDuplicate || marked.Channel != "series" {
+		t.Errorf("the duplicate is kept as %s, want it marked", recent[1])
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Layout of the timestamp appended to the name of rotated files, sorts in
+// chronological order
+const rotationTimeLayout = "20060102T150405.000000"
+
+// An append-only file that is rotated when it grows past a max size. The
+// current file is renamed to '<path>.<timestamp>' and a new file is opened.
+// Not safe for concurrent use.
+type rotatingFile struct {
+	path     string
+	maxSize  int64 // Rotate when the file would grow past this, 0 never rotates
+	maxFiles int   // Number of rotated files to keep, 0 keeps all
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to open '%s'. Error: %v", r.path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = info.Size()
+
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	// Make sure everything is on disk before the file is renamed
+	err := r.f.Sync()
+	if err != nil {
+		return err
+	}
+	err = r.f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(r.path, r.path+"."+time.Now().UTC().Format(rotationTimeLayout))
+	if err != nil {
+		return fmt.Errorf("Failed to rotate '%s'. Error: %v", r.path, err)
+	}
+
+	err = r.removeOldFiles()
+	if err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) removeOldFiles() error {
+	if r.maxFiles <= 0 {
+		return nil
+	}
+
+	rotated, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(rotated)
+
+	for len(rotated) > r.maxFiles {
+		err = os.Remove(rotated[0])
+		if err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+
+	return nil
+}
+
+func (r *rotatingFile) Sync() error {
+	return r.f.Sync()
+}
+
+func (r *rotatingFile) Close() error {
+	return r.f.Close()
+}
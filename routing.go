@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the buffered messages are written to the route files, a
+// variable so that tests don't have to wait for it
+var routeFlushInterval = time.Second
+
+// Appends received messages (one JSON object per line) to files chosen by
+// the channel of the message. Files are opened the first time a message is
+// routed to them and several channels can share the same file. Each file
+// is rotated on its own when it grows past maxSize, between messages.
+// Like the output file, the messages are buffered and written every
+// routeFlushInterval and on shutdown.
+type channelRouter struct {
+	mu          sync.Mutex
+	routes      map[string]string // Channel name -> file path
+	defaultPath string            // Used for channels without a route, optional
+	maxSize     int64
+	maxFiles    int
+	files       map[string]*routeFile
+	unrouted    int // Messages not written since their channel had no route
+	closed      bool
+}
+
+// An opened route file and the buffer in front of it
+type routeFile struct {
+	file *rotatingFile
+	w    *bufio.Writer
+}
+
+func newChannelRouter(routes map[string]string, defaultPath string, maxSize int64, maxFiles int) *channelRouter {
+	return &channelRouter{
+		routes:      routes,
+		defaultPath: defaultPath,
+		maxSize:     maxSize,
+		maxFiles:    maxFiles,
+		files:       make(map[string]*routeFile),
+	}
+}
+
+// Parses the values of the '--route channel=path' options
+func parseRoutes(values []string) (map[string]string, error) {
+	routes := make(map[string]string)
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid route '%s', must be on the form 'channel=path'", v)
+		}
+
+		if _, ok := routes[parts[0]]; ok {
+			return nil, fmt.Errorf("Channel '%s' has more than one route", parts[0])
+		}
+		routes[parts[0]] = parts[1]
+	}
+
+	return routes, nil
+}
+
+func (r *channelRouter) Start(ctx context.Context) error {
+	go r.flushLoop(ctx)
+
+	return nil
+}
+
+func (r *channelRouter) flushLoop(ctx context.Context) {
+	t := time.NewTicker(routeFlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			err := r.Flush()
+			if err != nil {
+				log.Println("[ERROR] ", err)
+			}
+		}
+	}
+}
+
+// Writes received messages to the file of their channel and broadcast
+// messages to all files
+func (r *channelRouter) Deliver(env sinkEnvelope) error {
+	if env.Broadcast {
+		return r.broadcast(env.Data)
+	}
+
+	return r.write(env.Channel, env.Data)
+}
+
+func (r *channelRouter) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	for path, f := range r.files {
+		err := f.w.Flush()
+		if err != nil {
+			return fmt.Errorf("Failed to flush route file '%s'. Error: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *channelRouter) write(channel string, msg []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	path, ok := r.routes[channel]
+	if !ok {
+		if r.defaultPath == "" {
+			r.unrouted++
+			return nil
+		}
+		path = r.defaultPath
+	}
+
+	return r.writeLine(path, msg)
+}
+
+// Writes the message to every route file, no matter its channel
+func (r *channelRouter) broadcast(msg []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	paths := make(map[string]bool)
+	for _, path := range r.routes {
+		paths[path] = true
+	}
+	if r.defaultPath != "" {
+		paths[r.defaultPath] = true
+	}
+
+	for path := range paths {
+		err := r.writeLine(path, msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *channelRouter) writeLine(path string, msg []byte) error {
+	f, err := r.open(path)
+	if err != nil {
+		return err
+	}
+
+	// Each message must be exactly one line in the file
+	var line bytes.Buffer
+	err = json.Compact(&line, msg)
+	if err != nil {
+		return fmt.Errorf("Failed to compact message. Error: %v", err)
+	}
+	line.WriteByte('\n')
+
+	// Like for the output file, the buffer is written before the line that
+	// would take the file past the max size, so that rotation happens
+	// between whole lines
+	pending := f.file.size + int64(f.w.Buffered()+line.Len())
+	if line.Len() > f.w.Available() || (r.maxSize > 0 && pending > r.maxSize) {
+		err = f.w.Flush()
+		if err != nil {
+			return fmt.Errorf("Failed to write message to '%s'. Error: %v", path, err)
+		}
+	}
+	_, err = f.w.Write(line.Bytes())
+	if err != nil {
+		return fmt.Errorf("Failed to write message to '%s'. Error: %v", path, err)
+	}
+
+	return nil
+}
+
+func (r *channelRouter) open(path string) (*routeFile, error) {
+	if f, ok := r.files[path]; ok {
+		return f, nil
+	}
+
+	file, err := openRotatingFile(path, r.maxSize, r.maxFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &routeFile{file: file, w: bufio.NewWriter(file)}
+	r.files[path] = f
+
+	return f, nil
+}
+
+// Flushes and closes all files that have been opened
+func (r *channelRouter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var firstErr error
+	for path, f := range r.files {
+		err := f.w.Flush()
+		if err == nil {
+			err = f.file.Close()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Failed to flush route file '%s'. Error: %v", path, err)
+		}
+	}
+	r.closed = true
+
+	if r.unrouted > 0 {
+		log.Printf("[INFO] %d messages on channels without a route were not written to any file\n", r.unrouted)
+	}
+
+	return firstErr
+}
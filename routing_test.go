@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the lines of a file, without the line breaks
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return lines
+}
+
+func TestChannelRouterRoutes(t *testing.T) {
+	tests := []struct {
+		name        string
+		routes      map[string]string
+		defaultPath string
+		want        map[string][]string // File name -> lines
+		unrouted    int
+	}{
+		{
+			name:   "channel routes",
+			routes: map[string]string{"series": "series.jsonl", "match": "match.jsonl"},
+			want: map[string][]string{
+				"series.jsonl": {`{"ch":"series","n":1}`, `{"ch":"series","n":3}`},
+				"match.jsonl":  {`{"ch":"match","n":2}`},
+			},
+			unrouted: 1,
+		},
+		{
+			name:        "default route",
+			routes:      map[string]string{"series": "series.jsonl"},
+			defaultPath: "other.jsonl",
+			want: map[string][]string{
+				"series.jsonl": {`{"ch":"series","n":1}`, `{"ch":"series","n":3}`},
+				"other.jsonl":  {`{"ch":"match","n":2}`, `{"ch":"system","n":4}`},
+			},
+		},
+		{
+			name:   "shared file",
+			routes: map[string]string{"series": "all.jsonl", "match": "all.jsonl"},
+			want: map[string][]string{
+				"all.jsonl": {`{"ch":"series","n":1}`, `{"ch":"match","n":2}`, `{"ch":"series","n":3}`},
+			},
+			unrouted: 1,
+		},
+	}
+
+	messages := []sinkEnvelope{
+		{Channel: "series", Data: []byte(`{"ch": "series", "n": 1}`)},
+		{Channel: "match", Data: []byte("{\n  \"ch\": \"match\",\n  \"n\": 2\n}")},
+		{Channel: "series", Data: []byte(`{"ch":"series","n":3}`)},
+		{Channel: "system", Data: []byte(`{"ch":"system","n":4}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+			dir := t.TempDir()
+			routes := make(map[string]string)
+			for channel, name := range tt.routes {
+				routes[channel] = filepath.Join(dir, name)
+			}
+			defaultPath := ""
+			if tt.defaultPath != "" {
+				defaultPath = filepath.Join(dir, tt.defaultPath)
+			}
+
+			r := newChannelRouter(routes, defaultPath, 0, 0)
+			for _, env := range messages {
+				if err := r.Deliver(env); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.want {
+				got := readLines(t, filepath.Join(dir, name))
+				if strings.Join(got, "\n") != strings.Join(want, "\n") {
+					t.Errorf("%s has lines %q, want %q", name, got, want)
+				}
+			}
+			if r.unrouted != tt.unrouted {
+				t.Errorf("unrouted is %d, want %d", r.unrouted, tt.unrouted)
+			}
+			report := fmt.Sprintf("%d messages on channels without a route were not written to any file", tt.unrouted)
+			if got := strings.Contains(logged.String(), report); got != (tt.unrouted > 0) {
+				t.Errorf("the unrouted messages were reported %v, want %v:\n%s", got, tt.unrouted > 0, logged)
+			}
+		})
+	}
+}
+
+func TestChannelRouterRotatesEachRoute(t *testing.T) {
+	discardLog(t)
+	dir := t.TempDir()
+	series := filepath.Join(dir, "series.jsonl")
+	match := filepath.Join(dir, "match.jsonl")
+
+	// Every line is 11 bytes, so each file holds 3 lines before rotating
+	r := newChannelRouter(map[string]string{"series": series, "match": match}, "", 33, 0)
+	for i := 0; i < 7; i++ {
+		if err := r.Deliver(sinkEnvelope{Channel: "series", Data: []byte(`{"n":"s1"}`)}); err != nil {
+			t.Fatal(err)
+		}
+		// Flushing between messages must not split lines over files
+		if err := r.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Deliver(sinkEnvelope{Channel: "match", Data: []byte(`{"n":"m1"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := filepath.Glob(series + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("series.jsonl was rotated %d times, want 2", len(rotated))
+	}
+	for _, path := range append(rotated, series) {
+		for _, line := range readLines(t, path) {
+			if line != `{"n":"s1"}` {
+				t.Errorf("%s has the split line %q", path, line)
+			}
+		}
+	}
+	if got := readLines(t, series); len(got) != 1 {
+		t.Errorf("series.jsonl has %d lines after rotating, want 1", len(got))
+	}
+
+	rotated, err = filepath.Glob(match + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 0 {
+		t.Errorf("match.jsonl was rotated %d times, want 0", len(rotated))
+	}
+}
+
+func TestChannelRouterKeepsMaxFiles(t *testing.T) {
+	discardLog(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "series.jsonl")
+
+	r := newChannelRouter(map[string]string{"series": path}, "", 10, 2)
+	for i := 0; i < 6; i++ {
+		if err := r.Deliver(sinkEnvelope{Channel: "series", Data: []byte(`{"n":"s1"}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Errorf("%d rotated files were kept, want 2", len(rotated))
+	}
+}
+
+func TestChannelRouterFlushesPeriodically(t *testing.T) {
+	discardLog(t)
+	defer func(d time.Duration) { routeFlushInterval = d }(routeFlushInterval)
+	routeFlushInterval = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "series.jsonl")
+	r := newChannelRouter(map[string]string{"series": path}, "", 0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := r.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Deliver(sinkEnvelope{Channel: "series", Data: []byte(`{"n": 1}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The line must reach the file without a flush on close
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) == "{\"n\":1}\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Route file holds %q after 2s, want the buffered line", b)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Flushing after the close must not write to the closed file
+	if err := r.Flush(); err != nil {
+		t.Errorf("Flush after Close: %v", err)
+	}
+}
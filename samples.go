@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sort"
+)
+
+// Number of recent samples kept for computing quantiles
+const sampleRingSize = 1024
+
+// The most recent samples, for estimating quantiles without growing memory
+// on long runs. Used for both durations and sizes.
+type sampleRing struct {
+	values []int64 // Ring buffer, next is the oldest once it is full
+	next   int
+}
+
+func (r *sampleRing) add(v int64) {
+	if len(r.values) < sampleRingSize {
+		r.values = append(r.values, v)
+	} else {
+		r.values[r.next] = v
+	}
+	r.next = (r.next + 1) % sampleRingSize
+}
+
+// Returns the q quantile (0 <= q <= 1) of the samples, 0 if there are none
+func (r *sampleRing) quantile(q float64) int64 {
+	if len(r.values) == 0 {
+		return 0
+	}
+
+	sorted := make([]int64, len(r.values))
+	copy(sorted, r.values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// Returns a copy that doesn't share the samples with r
+func (r sampleRing) clone() sampleRing {
+	r.values = append([]int64(nil), r.values...)
+	return r
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var expectedSeriesFileFlag = flag.String("expected-series-file", "", "File with the series IDs that should be sending data, one per line or a JSON array")
+var seriesSilenceThresholdFlag = flag.Duration("series-silence-threshold", 10*time.Minute, "Warn when an expected series has sent no message for this long")
+var coverageIntervalFlag = flag.Duration("coverage-interval", time.Minute, "Log how many expected series are active with this interval, 0 disables it")
+
+// How often the expected series are checked for silence
+const seriesSilenceCheckInterval = time.Second
+
+// Tracks when the series in the '--expected-series-file' last sent a
+// message. Nil when no file is given.
+var expectedSeries *seriesCoverage
+
+type seriesCoverage struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	threshold time.Duration
+	expected  []int // Sorted
+	series    map[int]*seriesActivity
+}
+
+type seriesActivity struct {
+	lastMessageAt time.Time
+	messages      int
+	quiet         bool // A warning has been logged since the last message
+}
+
+func newSeriesCoverage(expected []int, threshold time.Duration) *seriesCoverage {
+	c := &seriesCoverage{
+		startedAt: time.Now(),
+		threshold: threshold,
+		series:    make(map[int]*seriesActivity, len(expected)),
+	}
+	for _, id := range expected {
+		if _, ok := c.series[id]; !ok {
+			c.series[id] = &seriesActivity{}
+			c.expected = append(c.expected, id)
+		}
+	}
+	sort.Ints(c.expected)
+
+	return c
+}
+
+// Reads series IDs from a JSON array of numbers, or from lines with one ID
+// each. Empty lines and lines starting with '#' are skipped.
+func readExpectedSeries(fileName string) ([]int, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		err = json.Unmarshal(b, &ids)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse the series IDs as a JSON array. Error: %v", err)
+		}
+	} else {
+		for i, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			id, err := strconv.Atoi(line)
+			if err != nil {
+				return nil, fmt.Errorf("Line %d is not a series ID: '%s'", i+1, line)
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("The file contains no series IDs")
+	}
+
+	return ids, nil
+}
+
+// Records a message for its series, using the same payload paths as the
+// filter attribution. Messages without a series or for a series that isn't
+// expected are ignored.
+func (c *seriesCoverage) observe(msg PushMessage, at time.Time) {
+	paths, ok := channelIDPaths[msg.Channel]
+	if !ok {
+		paths = defaultIDPaths
+	}
+	id, ok := selectPayloadID(msg.Payload.Fields, paths.SeriesID)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[id]
+	if !ok {
+		return
+	}
+	if s.quiet {
+		log.Printf("[INFO] Expected series %d is sending messages again\n", id)
+		s.quiet = false
+	}
+	s.lastMessageAt = at
+	s.messages++
+}
+
+// Logs a warning the first time an expected series has been silent for
+// longer than the threshold. A series that never sent a message is silent
+// since the client started.
+func (c *seriesCoverage) checkSilence(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range c.expected {
+		s := c.series[id]
+		if s.quiet {
+			continue
+		}
+
+		if s.lastMessageAt.IsZero() {
+			if now.Sub(c.startedAt) > c.threshold {
+				log.Printf("[WARN] Expected series %d has not sent any message in the %s since the client started\n", id, roundDuration(now.Sub(c.startedAt), time.Second))
+				s.quiet = true
+			}
+		} else if now.Sub(s.lastMessageAt) > c.threshold {
+			log.Printf("[WARN] Expected series %d has been silent for %s, last message at %s\n", id, roundDuration(now.Sub(s.lastMessageAt), time.Second), s.lastMessageAt.Format(time.RFC3339))
+			s.quiet = true
+		}
+	}
+}
+
+// Counts the expected series that sent a message within the threshold, the
+// ones that have gone quiet and the ones that never sent anything
+func (c *seriesCoverage) counts(now time.Time) (active, quiet, neverSeen int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range c.expected {
+		s := c.series[id]
+		switch {
+		case s.lastMessageAt.IsZero():
+			neverSeen++
+		case now.Sub(s.lastMessageAt) > c.threshold:
+			quiet++
+		default:
+			active++
+		}
+	}
+
+	return active, quiet, neverSeen
+}
+
+func (c *seriesCoverage) logCoverage(tag string, now time.Time) {
+	active, quiet, neverSeen := c.counts(now)
+	log.Printf("%s %d expected series: %d active, %d quiet, %d never seen\n", tag, len(c.expected), active, quiet, neverSeen)
+}
+
+func (c *seriesCoverage) printSummary() {
+	now := time.Now()
+	c.logCoverage("[SUMMARY]", now)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range c.expected {
+		s := c.series[id]
+		if s.lastMessageAt.IsZero() {
+			log.Printf("[SUMMARY] Series %d: never seen\n", id)
+			continue
+		}
+		log.Printf("[SUMMARY] Series %d: %d messages, last %s ago\n", id, s.messages, roundDuration(now.Sub(s.lastMessageAt), time.Second))
+	}
+}
+
+func seriesCoverageLoop(c *seriesCoverage, coverageInterval time.Duration) {
+	lastCoverage := time.Now()
+	for now := range time.Tick(seriesSilenceCheckInterval) {
+		c.checkSilence(now)
+
+		if coverageInterval > 0 && now.Sub(lastCoverage) >= coverageInterval {
+			c.logCoverage("[COVERAGE]", now)
+			lastCoverage = now
+		}
+	}
+}
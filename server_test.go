@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// A websocket server that answers pings and counts them, and reads until
+// the client closes the connection
+type pingServer struct {
+	url   string
+	pings int32
+}
+
+func newPingServer(t *testing.T) *pingServer {
+	t.Helper()
+
+	s := &pingServer{}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetPingHandler(func(appData string) error {
+			atomic.AddInt32(&s.pings, 1)
+			return conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	s.url = "ws" + strings.TrimPrefix(server.URL, "http")
+
+	return s
+}
+
+// Opens a new connection to the server, closed when the test ends
+func (s *pingServer) dial(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(s.url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func (s *pingServer) pingCount() int {
+	return int(atomic.LoadInt32(&s.pings))
+}
+
+// Hides the log lines of the code under test until the test ends
+func discardLog(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// Collects the log lines of the code under test until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	return &buf
+}
+
+// A push service that sends the init message and then the given message
+// count times as fast as it can, and reads until the client closes the
+// connection
+func newBlastServer(tb testing.TB, count int, msg []byte) string {
+	tb.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		init := fmt.Sprintf(`{"channel": "system", "cmd": "init", "subscriber_id": "%s", "reconnect_token": "%s", "subscription": {"id": "%s"}, "reconnected": false}`, uuid.Must(uuid.NewV4()), testToken, testSubscriptionID)
+		conn.WriteMessage(websocket.TextMessage, []byte(init))
+		prepared, err := websocket.NewPreparedMessage(websocket.TextMessage, msg)
+		if err != nil {
+			return
+		}
+		for i := 0; i < count; i++ {
+			if err := conn.WritePreparedMessage(prepared); err != nil {
+				return
+			}
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	tb.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
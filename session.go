@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/gofrs/uuid"
+)
+
+// The subscription the client reads from, the reconnect token of its
+// latest init message, and whether the subscription is deleted on exit.
+// Set at startup and changed by reconnects and by the verify loop, while
+// the background loops read them.
+var session struct {
+	sync.Mutex
+	subscriptionIDOrName string
+	reconnectToken       uuid.UUID
+	removeOnExit         bool
+}
+
+func currentSubscription() string {
+	session.Lock()
+	defer session.Unlock()
+
+	return session.subscriptionIDOrName
+}
+
+func setCurrentSubscription(idOrName string) {
+	session.Lock()
+	session.subscriptionIDOrName = idOrName
+	session.Unlock()
+}
+
+func currentReconnectToken() uuid.UUID {
+	session.Lock()
+	defer session.Unlock()
+
+	return session.reconnectToken
+}
+
+func setCurrentReconnectToken(token uuid.UUID) {
+	session.Lock()
+	session.reconnectToken = token
+	session.Unlock()
+}
+
+// Reports if the subscription is deleted when the client exits, i.e. the
+// client registered it and '--keep-subscription' wasn't given
+func removeSubscriptionOnExit() bool {
+	session.Lock()
+	defer session.Unlock()
+
+	return session.removeOnExit
+}
+
+func setRemoveSubscriptionOnExit(remove bool) {
+	session.Lock()
+	session.removeOnExit = remove
+	session.Unlock()
+}
+
+// Moves the client to another subscription, e.g. one registered again
+// after it was deleted. Its reconnect token is unknown until the client
+// has connected to it.
+func switchSubscription(idOrName string, removeOnExit bool) {
+	session.Lock()
+	session.subscriptionIDOrName = idOrName
+	session.reconnectToken = uuid.Nil
+	session.removeOnExit = removeOnExit
+	session.Unlock()
+}
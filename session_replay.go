@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var replayJitterFlag = flag.Duration("replay-jitter", 0, "replay: add a random extra delay of up to this much before each message, e.g. '200ms'")
+var replayReorderFlag = flag.String("replay-reorder", "", "replay: swap a message with one of the next 'window' messages with this probability, on the form 'probability,window', e.g. '0.1,5'")
+var replayDropFlag = flag.Float64("replay-drop", 0, "replay: skip messages with this probability and log their UUIDs, e.g. '0.05'")
+var replaySeedFlag = flag.Int64("replay-seed", 0, "replay: seed of the jitter, reordering and dropping, to degrade a replay the same way again (random if not given)")
+
+// A message read from a recorded session
+type replayMessage struct {
+	line    int // Line in the recording, for the log
+	uuid    string
+	created time.Time
+	data    []byte
+}
+
+// How a replay is degraded, from the '--replay-...' options
+type replayOptions struct {
+	jitter        time.Duration
+	reorderProb   float64
+	reorderWindow int
+	dropProb      float64
+}
+
+// A message to write, after waiting for wait
+type replayStep struct {
+	msg  replayMessage
+	wait time.Duration
+}
+
+// What the degraded conditions did to a replay, logged when it ends
+type replayReport struct {
+	recorded  int
+	replayed  int
+	delayed   int
+	reordered int
+	dropped   int
+}
+
+// Replays a recorded session: the messages in a file written with '--route'
+// or '--route-default' are written to stdout, or the file of '--out', one
+// per line and paced by the differences of their 'created' times. Jitter,
+// reordering and dropping of messages can be added to test consumers under
+// degraded conditions.
+func replayCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("The replay command needs exactly one recorded session file")
+	}
+
+	opts, err := replayOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+
+	msgs, err := readReplayMessages(args[0])
+	if err != nil {
+		return err
+	}
+
+	seed := *replaySeedFlag
+	if !flag.CommandLine.Changed("replay-seed") {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("[INFO] Replaying %d messages from '%s' with '--replay-seed=%d'\n", len(msgs), args[0], seed)
+
+	out := io.Writer(os.Stdout)
+	if *outFlag != "" {
+		f, err := os.Create(*outFlag)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	steps, report := planReplay(msgs, opts, rand.New(rand.NewSource(seed)))
+	for _, step := range steps {
+		time.Sleep(step.wait)
+
+		_, err := out.Write(append(step.msg.data, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[SUMMARY] Replayed %d of %d messages: %d delayed, %d reordered, %d dropped\n",
+		report.replayed, report.recorded, report.delayed, report.reordered, report.dropped)
+
+	return nil
+}
+
+func replayOptionsFromFlags() (replayOptions, error) {
+	opts := replayOptions{jitter: *replayJitterFlag, dropProb: *replayDropFlag}
+	if opts.jitter < 0 {
+		return opts, fmt.Errorf("The option '--replay-jitter' can't be negative")
+	}
+	if opts.dropProb < 0 || opts.dropProb > 1 {
+		return opts, fmt.Errorf("The option '--replay-drop' must be a probability between 0 and 1")
+	}
+
+	if *replayReorderFlag != "" {
+		prob, window, err := parseReplayReorder(*replayReorderFlag)
+		if err != nil {
+			return opts, err
+		}
+		opts.reorderProb, opts.reorderWindow = prob, window
+	}
+
+	return opts, nil
+}
+
+// Parses the value of '--replay-reorder probability,window'
+func parseReplayReorder(s string) (float64, int, error) {
+	invalid := fmt.Errorf("Invalid '--replay-reorder' value '%s', must be on the form 'probability,window', e.g. '0.1,5'", s)
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, invalid
+	}
+	prob, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || prob < 0 || prob > 1 {
+		return 0, 0, invalid
+	}
+	window, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || window < 1 {
+		return 0, 0, invalid
+	}
+
+	return prob, window, nil
+}
+
+// Reads the messages of a recorded session, one JSON object per line
+func readReplayMessages(fileName string) ([]replayMessage, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var msgs []replayMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var m struct {
+			UUID    string    `json:"uuid"`
+			Created time.Time `json:"created"`
+		}
+		err := json.Unmarshal(scanner.Bytes(), &m)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid message on line %d of '%s'. Error: %v", line, fileName, err)
+		}
+
+		data := make([]byte, len(scanner.Bytes()))
+		copy(data, scanner.Bytes())
+		msgs = append(msgs, replayMessage{line: line, uuid: m.UUID, created: m.Created, data: data})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// Decides which messages are dropped, the order of the others and how long
+// to wait before each. Messages keep the time slots of the recording when
+// they are reordered, so only the jitter makes the replay take longer. The
+// same rng seed gives the same plan.
+func planReplay(msgs []replayMessage, opts replayOptions, rng *rand.Rand) ([]replayStep, replayReport) {
+	report := replayReport{recorded: len(msgs)}
+
+	var kept []replayMessage
+	for _, m := range msgs {
+		if opts.dropProb > 0 && rng.Float64() < opts.dropProb {
+			log.Printf("[INFO] Dropped message %s from line %d\n", m.uuid, m.line)
+			report.dropped++
+			continue
+		}
+		kept = append(kept, m)
+	}
+
+	// The slot of every message, as an offset from the first one. Messages
+	// without a creation time share the slot of the one before.
+	slots := make([]time.Duration, len(kept))
+	var first time.Time
+	for i, m := range kept {
+		if i > 0 {
+			slots[i] = slots[i-1]
+		}
+		if m.created.IsZero() {
+			continue
+		}
+		if first.IsZero() {
+			first = m.created
+		}
+		if offset := m.created.Sub(first); offset > slots[i] {
+			slots[i] = offset
+		}
+	}
+
+	order := make([]replayMessage, len(kept))
+	copy(order, kept)
+	if opts.reorderProb > 0 {
+		for i := range order {
+			if rng.Float64() >= opts.reorderProb {
+				continue
+			}
+			j := i + 1 + rng.Intn(opts.reorderWindow)
+			if j < len(order) {
+				order[i], order[j] = order[j], order[i]
+			}
+		}
+	}
+
+	steps := make([]replayStep, len(order))
+	for i, m := range order {
+		if m.line != kept[i].line {
+			report.reordered++
+		}
+
+		wait := slots[i]
+		if i > 0 {
+			wait -= slots[i-1]
+		}
+		if opts.jitter > 0 {
+			extra := time.Duration(rng.Int63n(int64(opts.jitter) + 1))
+			if extra > 0 {
+				report.delayed++
+			}
+			wait += extra
+		}
+
+		steps[i] = replayStep{msg: m, wait: wait}
+	}
+	report.replayed = len(steps)
+
+	return steps, report
+}
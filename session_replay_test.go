@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns count messages created 10ms apart
+func recordedMessages(count int) []replayMessage {
+	start := time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC)
+
+	msgs := make([]replayMessage, count)
+	for i := range msgs {
+		id := fmt.Sprintf("00000000-0000-0000-0000-%012d", i+1)
+		created := start.Add(time.Duration(i) * 10 * time.Millisecond)
+		msgs[i] = replayMessage{
+			line:    i + 1,
+			uuid:    id,
+			created: created,
+			data:    []byte(fmt.Sprintf(`{"channel":"series","uuid":"%s","created":"%s","payload":{"n":%d}}`, id, created.Format(time.RFC3339Nano), i+1)),
+		}
+	}
+
+	return msgs
+}
+
+func stepLines(steps []replayStep) []int {
+	lines := make([]int, len(steps))
+	for i, step := range steps {
+		lines[i] = step.msg.line
+	}
+
+	return lines
+}
+
+func TestParseReplayReorder(t *testing.T) {
+	tests := []struct {
+		value   string
+		prob    float64
+		window  int
+		wantErr bool
+	}{
+		{value: "0.1,5", prob: 0.1, window: 5},
+		{value: "1, 1", prob: 1, window: 1},
+		{value: "0,3", prob: 0, window: 3},
+		{value: "0.1", wantErr: true},
+		{value: "0.1,5,2", wantErr: true},
+		{value: "1.5,5", wantErr: true},
+		{value: "-0.1,5", wantErr: true},
+		{value: "0.1,0", wantErr: true},
+		{value: "often,5", wantErr: true},
+	}
+
+	for _, test := range tests {
+		prob, window, err := parseReplayReorder(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("'%s' gave no error", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("'%s' gave the error: %v", test.value, err)
+		} else if prob != test.prob || window != test.window {
+			t.Errorf("'%s' gave %v,%d, want %v,%d", test.value, prob, window, test.prob, test.window)
+		}
+	}
+}
+
+func TestPlanReplay(t *testing.T) {
+	logged := captureLog(t)
+	msgs := recordedMessages(10)
+
+	t.Run("as recorded", func(t *testing.T) {
+		steps, report := planReplay(msgs, replayOptions{}, rand.New(rand.NewSource(1)))
+		if got := stepLines(steps); !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}) {
+			t.Errorf("the messages are replayed in the order %v", got)
+		}
+		for i, step := range steps {
+			want := 10 * time.Millisecond
+			if i == 0 {
+				want = 0
+			}
+			if step.wait != want {
+				t.Errorf("message %d waits %s, want %s", i+1, step.wait, want)
+			}
+		}
+		if want := (replayReport{recorded: 10, replayed: 10}); report != want {
+			t.Errorf("got the report %+v, want %+v", report, want)
+		}
+	})
+
+	t.Run("drop all", func(t *testing.T) {
+		logged.Reset()
+		steps, report := planReplay(msgs, replayOptions{dropProb: 1}, rand.New(rand.NewSource(1)))
+		if len(steps) != 0 {
+			t.Errorf("%d messages are replayed, want none", len(steps))
+		}
+		if want := (replayReport{recorded: 10, dropped: 10}); report != want {
+			t.Errorf("got the report %+v, want %+v", report, want)
+		}
+		for _, m := range msgs {
+			if !strings.Contains(logged.String(), fmt.Sprintf("Dropped message %s from line %d", m.uuid, m.line)) {
+				t.Errorf("the drop of message %s isn't logged:\n%s", m.uuid, logged)
+			}
+		}
+	})
+
+	t.Run("reorder all", func(t *testing.T) {
+		// Every message is swapped with the next, which moves the first one
+		// to the end
+		steps, report := planReplay(msgs, replayOptions{reorderProb: 1, reorderWindow: 1}, rand.New(rand.NewSource(1)))
+		if got := stepLines(steps); !reflect.DeepEqual(got, []int{2, 3, 4, 5, 6, 7, 8, 9, 10, 1}) {
+			t.Errorf("the messages are replayed in the order %v", got)
+		}
+		if want := (replayReport{recorded: 10, replayed: 10, reordered: 10}); report != want {
+			t.Errorf("got the report %+v, want %+v", report, want)
+		}
+		// The messages take the time slots of the ones they replace
+		for i, step := range steps[1:] {
+			if step.wait != 10*time.Millisecond {
+				t.Errorf("message %d waits %s, want 10ms", i+2, step.wait)
+			}
+		}
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		steps, report := planReplay(msgs, replayOptions{jitter: 5 * time.Millisecond}, rand.New(rand.NewSource(1)))
+		delayed := 0
+		for i, step := range steps {
+			base := 10 * time.Millisecond
+			if i == 0 {
+				base = 0
+			}
+			if step.wait < base || step.wait > base+5*time.Millisecond {
+				t.Errorf("message %d waits %s, want between %s and %s", i+1, step.wait, base, base+5*time.Millisecond)
+			}
+			if step.wait > base {
+				delayed++
+			}
+		}
+		if delayed == 0 || report.delayed != delayed {
+			t.Errorf("the report has %d messages delayed, %d were", report.delayed, delayed)
+		}
+	})
+
+	t.Run("same seed", func(t *testing.T) {
+		opts := replayOptions{jitter: 5 * time.Millisecond, reorderProb: 0.3, reorderWindow: 3, dropProb: 0.2}
+		first, firstReport := planReplay(msgs, opts, rand.New(rand.NewSource(42)))
+		again, againReport := planReplay(msgs, opts, rand.New(rand.NewSource(42)))
+		if !reflect.DeepEqual(first, again) || firstReport != againReport {
+			t.Errorf("the same seed gave different replays: %+v and %+v", firstReport, againReport)
+		}
+		if firstReport.replayed+firstReport.dropped != 10 {
+			t.Errorf("the report doesn't add up to the 10 recorded messages: %+v", firstReport)
+		}
+	})
+}
+
+func TestReplayCommand(t *testing.T) {
+	logged := captureLog(t)
+	dir := t.TempDir()
+	recording := filepath.Join(dir, "series.jsonl")
+	msgs := recordedMessages(3)
+	var lines []string
+	for _, m := range msgs {
+		lines = append(lines, string(m.data))
+	}
+	if err := ioutil.WriteFile(recording, []byte(strings.Join(lines, "\n\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(out, reorder string) { *outFlag, *replayReorderFlag = out, reorder }(*outFlag, *replayReorderFlag)
+	*outFlag, *replayReorderFlag = filepath.Join(dir, "replayed.jsonl"), "1,1"
+
+	if err := replayCommand([]string{recording}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readLines(t, *outFlag)
+	want := []string{lines[1], lines[2], lines[0]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed the lines\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !strings.Contains(logged.String(), "Replayed 3 of 3 messages: 0 delayed, 3 reordered, 0 dropped") {
+		t.Errorf("the summary is missing:\n%s", logged)
+	}
+}
+
+func TestReplayCommandErrors(t *testing.T) {
+	discardLog(t)
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.jsonl")
+	if err := ioutil.WriteFile(invalid, []byte("{\"uuid\": \"a\"}\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		args   []string
+		drop   float64
+		jitter time.Duration
+		want   string
+	}{
+		{name: "no file", want: "needs exactly one recorded session file"},
+		{name: "invalid line", args: []string{invalid}, want: "Invalid message on line 2"},
+		{name: "drop above 1", args: []string{invalid}, drop: 1.5, want: "'--replay-drop' must be a probability"},
+		{name: "negative jitter", args: []string{invalid}, jitter: -time.Second, want: "'--replay-jitter' can't be negative"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(drop float64, jitter time.Duration) { *replayDropFlag, *replayJitterFlag = drop, jitter }(*replayDropFlag, *replayJitterFlag)
+			*replayDropFlag, *replayJitterFlag = test.drop, test.jitter
+
+			err := replayCommand(test.args)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got the error %v, want one containing \"%s\"", err, test.want)
+			}
+		})
+	}
+}
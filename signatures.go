@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Name of the envelope field holding the message signature
+const signatureField = "signature"
+
+// Set at startup when '--verify-signature-key-file' is given
+var signatureKey []byte
+
+func readSignatureKey(fileName string) ([]byte, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read signature key file '%s'. Error: %v", fileName, err)
+	}
+
+	key := bytes.TrimSpace(b)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("Signature key file '%s' is empty", fileName)
+	}
+
+	return key, nil
+}
+
+// Checks the HMAC-SHA256 signature in the 'signature' field of the message
+// envelope. The signature is the hex encoded HMAC of the canonical form of
+// the message, which is the message without the 'signature' field encoded
+// as compact JSON with the object keys sorted and numbers kept exactly as
+// they were received.
+//
+// Returns false (and no error) if the message isn't signed.
+func verifyMessageSignature(msg []byte, key []byte) (bool, error) {
+	envelope, err := decodeEnvelope(msg)
+	if err != nil {
+		return false, err
+	}
+
+	raw, ok := envelope[signatureField]
+	if !ok {
+		return false, nil
+	}
+	delete(envelope, signatureField)
+
+	sig, ok := raw.(string)
+	if !ok {
+		return true, fmt.Errorf("The signature field is not a string")
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return true, fmt.Errorf("The signature is not hex encoded. Error: %v", err)
+	}
+
+	expected, err := messageHMAC(envelope, key)
+	if err != nil {
+		return true, err
+	}
+	if !hmac.Equal(got, expected) {
+		return true, fmt.Errorf("Signature mismatch")
+	}
+
+	return true, nil
+}
+
+// Adds a valid signature to the message, used to create signed test messages
+func signMessage(msg []byte, key []byte) ([]byte, error) {
+	envelope, err := decodeEnvelope(msg)
+	if err != nil {
+		return nil, err
+	}
+	delete(envelope, signatureField)
+
+	mac, err := messageHMAC(envelope, key)
+	if err != nil {
+		return nil, err
+	}
+	envelope[signatureField] = hex.EncodeToString(mac)
+
+	return canonicalJSON(envelope)
+}
+
+func decodeEnvelope(msg []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+
+	var envelope map[string]interface{}
+	err := dec.Decode(&envelope)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode message envelope. Error: %v", err)
+	}
+
+	return envelope, nil
+}
+
+func messageHMAC(envelope map[string]interface{}, key []byte) ([]byte, error) {
+	b, err := canonicalJSON(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+
+	return mac.Sum(nil), nil
+}
+
+// The JSON encoder sorts map keys, so re-encoding the decoded message gives
+// the same bytes no matter the key order or whitespace it was sent with
+func canonicalJSON(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
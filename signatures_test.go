@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testSignatureKey = []byte("secret key")
+
+func mustSign(t *testing.T, msg string, key []byte) string {
+	t.Helper()
+
+	signed, err := signMessage([]byte(msg), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(signed)
+}
+
+func TestVerifyMessageSignature(t *testing.T) {
+	msg := `{"channel": "series", "uuid": "0b7e4f3e-3ad8-4c5b-9c8e-4f4f4f4f4f4f", "payload": {"id": 12345678901234567890, "title": "<b>"}}`
+	signed := mustSign(t, msg, testSignatureKey)
+	sig := signed[strings.Index(signed, `"signature":"`)+len(`"signature":"`):]
+	sig = sig[:strings.Index(sig, `"`)]
+
+	tests := []struct {
+		name       string
+		msg        string
+		wantSigned bool
+		wantErr    string
+	}{
+		{"signed", signed, true, ""},
+		{"other key order and whitespace", `{"payload":{"title":"<b>","id":12345678901234567890},"signature":"` + sig + `","uuid":"0b7e4f3e-3ad8-4c5b-9c8e-4f4f4f4f4f4f","channel":"series"}`, true, ""},
+		{"not signed", msg, false, ""},
+		{"payload changed", strings.Replace(signed, "12345678901234567890", "12345678901234567891", 1), true, "Signature mismatch"},
+		{"signed with another key", mustSign(t, msg, []byte("other key")), true, "Signature mismatch"},
+		{"not hex", `{"channel": "series", "signature": "xyz"}`, true, "not hex encoded"},
+		{"not a string", `{"channel": "series", "signature": 1}`, true, "not a string"},
+		{"not an object", `[1, 2]`, false, "Failed to decode message envelope"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signed, err := verifyMessageSignature([]byte(test.msg), testSignatureKey)
+			if signed != test.wantSigned {
+				t.Errorf("signed is %v, want %v", signed, test.wantSigned)
+			}
+			if test.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("got error %v, want one containing '%s'", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// Signing a signed message replaces the signature instead of signing it
+func TestSignMessageTwice(t *testing.T) {
+	once := mustSign(t, `{"channel": "series"}`, testSignatureKey)
+	twice := mustSign(t, once, testSignatureKey)
+	if once != twice {
+		t.Errorf("signing again gave %s, want %s", twice, once)
+	}
+}
+
+func TestReadSignatureKey(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr string
+	}{
+		{"trimmed", "  secret key\n", "secret key", ""},
+		{"empty", " \n", "", "is empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.name)
+			if err := ioutil.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := readSignatureKey(path)
+			if string(key) != test.want {
+				t.Errorf("got key '%s', want '%s'", key, test.want)
+			}
+			if test.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("got error %v, want one containing '%s'", err, test.wantErr)
+			}
+		})
+	}
+
+	if _, err := readSignatureKey(filepath.Join(dir, "missing")); err == nil {
+		t.Error("a missing key file gave no error")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var sinkQueueSizeFlag = flag.Int("sink-queue-size", 1000, "Max number of messages waiting to be delivered to each sink before new ones are dropped")
+var sinkTimeoutFlag = flag.Duration("sink-timeout", 5*time.Second, "Max time each sink may spend flushing and closing on shutdown")
+
+// A message handed to the sinks
+type sinkEnvelope struct {
+	Channel   string
+	Data      []byte
+	Broadcast bool // Not a received message, e.g. a heartbeat, sinks decide where it goes
+}
+
+// A destination for received messages. Deliver is only called from one
+// goroutine at a time, so a sink doesn't need its own locking for it.
+type Sink interface {
+	Start(ctx context.Context) error
+	Deliver(env sinkEnvelope) error
+	Flush() error
+	Close() error
+}
+
+// A sink together with its queue and the goroutine draining it
+type managedSink struct {
+	name  string
+	sink  Sink
+	queue chan sinkEnvelope
+	done  chan struct{}
+
+	// Set when the sink didn't drain its queue in time on shutdown, the
+	// rest of the queue is then dropped
+	abandoned int32
+}
+
+// Fans out every message to all registered sinks. Each sink has its own
+// bounded queue, so a slow or failing sink can't hold up the others or the
+// websocket read loop; messages are dropped for a sink whose queue is full.
+type sinkManager struct {
+	mu        sync.Mutex
+	sinks     []*managedSink
+	queueSize int
+	timeout   time.Duration
+	stopped   bool
+	cancel    context.CancelFunc
+}
+
+// Set at startup if any sink was configured
+var messageSinks *sinkManager
+
+func newSinkManager(queueSize int, timeout time.Duration) *sinkManager {
+	return &sinkManager{queueSize: queueSize, timeout: timeout}
+}
+
+// Adds a sink, must be done before start
+func (m *sinkManager) register(name string, sink Sink) {
+	m.sinks = append(m.sinks, &managedSink{
+		name:  name,
+		sink:  sink,
+		queue: make(chan sinkEnvelope, m.queueSize),
+		done:  make(chan struct{}),
+	})
+}
+
+// Starts all sinks and their delivery goroutines. If any sink fails to
+// start the ones already started are closed again.
+func (m *sinkManager) start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	for i, s := range m.sinks {
+		err := s.sink.Start(ctx)
+		if err != nil {
+			cancel()
+			for _, started := range m.sinks[:i] {
+				started.sink.Close()
+			}
+			return fmt.Errorf("Failed to start sink '%s'. Error: %v", s.name, err)
+		}
+	}
+
+	for _, s := range m.sinks {
+		go m.deliverLoop(s)
+	}
+
+	return nil
+}
+
+func (m *sinkManager) deliverLoop(s *managedSink) {
+	defer close(s.done)
+
+	for env := range s.queue {
+		if atomic.LoadInt32(&s.abandoned) == 1 {
+			stats.sinkDropped(s.name)
+			continue
+		}
+
+		err := s.sink.Deliver(env)
+		if err != nil {
+			stats.sinkFailed(s.name)
+			log.Printf("[ERROR] Sink '%s' failed to deliver message. Error: %v\n", s.name, err)
+			continue
+		}
+		stats.sinkDelivered(s.name)
+	}
+}
+
+// Queues the message for every sink, never blocks
+func (m *sinkManager) deliver(env sinkEnvelope) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stopped {
+		return
+	}
+
+	for _, s := range m.sinks {
+		select {
+		case s.queue <- env:
+		default:
+			stats.sinkDropped(s.name)
+		}
+	}
+}
+
+// Stops accepting messages, lets the sinks drain their queues for at most
+// drainTimeout and then flushes all sinks before closing all of them.
+// Messages still queued when drainTimeout has passed are dropped. Flushing
+// and closing are limited by the timeout for each sink, a sink that
+// doesn't finish in time is skipped.
+func (m *sinkManager) shutdown(drainTimeout time.Duration) {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return
+	}
+	m.stopped = true
+	for _, s := range m.sinks {
+		close(s.queue)
+	}
+	m.mu.Unlock()
+
+	deadline := time.After(drainTimeout)
+	expired := false
+	for _, s := range m.sinks {
+		if !expired {
+			select {
+			case <-s.done:
+				continue
+			case <-deadline:
+				expired = true
+			}
+		}
+
+		select {
+		case <-s.done:
+		default:
+			atomic.StoreInt32(&s.abandoned, 1)
+			log.Printf("[WARN] Sink '%s' did not drain its queue within %s, dropping the remaining messages\n", s.name, drainTimeout)
+		}
+	}
+
+	for _, s := range m.sinks {
+		err := m.withTimeout(s.sink.Flush)
+		if err != nil {
+			log.Printf("[ERROR] Failed to flush sink '%s'. Error: %v\n", s.name, err)
+		}
+	}
+
+	if m.cancel != nil {
+		m.cancel()
+	}
+
+	for _, s := range m.sinks {
+		err := m.withTimeout(s.sink.Close)
+		if err != nil {
+			log.Printf("[ERROR] Failed to close sink '%s'. Error: %v\n", s.name, err)
+		}
+	}
+}
+
+func (m *sinkManager) withTimeout(f func() error) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- f()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(m.timeout):
+		return fmt.Errorf("Timed out after %s", m.timeout)
+	}
+}
+
+// Creates the sinks asked for on the command line, returns nil if there
+// are none
+func buildSinks() (*sinkManager, error) {
+	m := newSinkManager(*sinkQueueSizeFlag, *sinkTimeoutFlag)
+
+	if len(*routeFlag) > 0 || *routeDefaultFlag != "" {
+		routes, err := parseRoutes(*routeFlag)
+		if err != nil {
+			return nil, err
+		}
+		m.register("route", newChannelRouter(routes, *routeDefaultFlag, *routeMaxSizeFlag, *routeMaxFilesFlag))
+	}
+
+	if *outputFileFlag != "" {
+		m.register("output-file", newOutputFileSink(*outputFileFlag, *outputMaxSizeFlag, *outputMaxFilesFlag))
+	}
+
+	if len(m.sinks) == 0 {
+		return nil, nil
+	}
+
+	return m, nil
+}
+
+// Checks that the sink options can be used together and that no sink
+// writes to a file the client uses for something else
+func validateSinkFlags() error {
+	if *sinkQueueSizeFlag < 1 {
+		return fmt.Errorf("The option '--sink-queue-size' must be at least 1")
+	}
+	if *sinkTimeoutFlag <= 0 {
+		return fmt.Errorf("The option '--sink-timeout' must be positive")
+	}
+	if *outputMaxSizeFlag < 0 {
+		return fmt.Errorf("The option '--output-max-size' can't be negative")
+	}
+	if *outputMaxFilesFlag < 0 {
+		return fmt.Errorf("The option '--output-max-files' can't be negative")
+	}
+	if *routeMaxSizeFlag < 0 {
+		return fmt.Errorf("The option '--route-max-size' can't be negative")
+	}
+	if *routeMaxFilesFlag < 0 {
+		return fmt.Errorf("The option '--route-max-files' can't be negative")
+	}
+
+	routes, err := parseRoutes(*routeFlag)
+	if err != nil {
+		return err
+	}
+
+	otherFiles := map[string]string{
+		*quarantineFileFlag: "--quarantine-file",
+		*statusFileFlag:     "--status-file",
+		*pidFileFlag:        "--pid-file",
+		*logFileFlag:        "--log-file",
+		*dumpRecentFlag:     "--dump-recent",
+		*eventLogFlag:       "--event-log",
+	}
+	paths := []string{*routeDefaultFlag}
+	for _, path := range routes {
+		paths = append(paths, path)
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if option, ok := otherFiles[path]; ok {
+			return fmt.Errorf("The route file '%s' is also used by '%s'", path, option)
+		}
+	}
+
+	if option, ok := otherFiles[*outputFileFlag]; ok && *outputFileFlag != "" {
+		return fmt.Errorf("The output file '%s' is also used by '%s'", *outputFileFlag, option)
+	}
+	for _, path := range paths {
+		if path != "" && path == *outputFileFlag {
+			return fmt.Errorf("The output file '%s' is also a route file", path)
+		}
+	}
+
+	return nil
+}
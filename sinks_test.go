@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A sink for the tests. Deliver waits for release if it is set, and fails
+// if fail is set. Flush and Close wait for hang if it is set.
+type fakeSink struct {
+	release chan struct{}
+	started chan struct{} // Gets a value every time Deliver is called
+	hang    chan struct{}
+	fail    bool
+
+	mu        sync.Mutex
+	delivered []string
+	flushed   bool
+	closed    bool
+}
+
+func (s *fakeSink) Start(ctx context.Context) error { return nil }
+
+func (s *fakeSink) Deliver(env sinkEnvelope) error {
+	if s.started != nil {
+		s.started <- struct{}{}
+	}
+	if s.release != nil {
+		<-s.release
+	}
+	if s.fail {
+		return errors.New("failed")
+	}
+
+	s.mu.Lock()
+	s.delivered = append(s.delivered, string(env.Data))
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *fakeSink) Flush() error {
+	if s.hang != nil {
+		<-s.hang
+	}
+	s.mu.Lock()
+	s.flushed = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	if s.hang != nil {
+		<-s.hang
+	}
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *fakeSink) deliveredCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.delivered)
+}
+
+// Numbers the sink names, see sinkName
+var sinkNames int32
+
+// A name for the sink that no other test run uses, so that its counters
+// in the global stats are its own. The address of the sink isn't enough,
+// the address of a sink from an earlier run can be reused.
+func sinkName(what string) string {
+	return fmt.Sprintf("%s-%d", what, atomic.AddInt32(&sinkNames, 1))
+}
+
+func sinkStats(name string) sinkCounters {
+	return stats.snapshot().sinks[name]
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		if done() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// A slow and a failing sink don't hold up the others, messages for the
+// slow one are dropped and counted once its queue is full
+func TestSinkManagerIsolation(t *testing.T) {
+	discardLog(t)
+	fast := &fakeSink{}
+	slow := &fakeSink{release: make(chan struct{}), started: make(chan struct{}, 1)}
+	failing := &fakeSink{fail: true}
+	fastName, slowName, failingName := sinkName("fast"), sinkName("slow"), sinkName("failing")
+
+	const queueSize, count = 3, 10
+	m := newSinkManager(queueSize, time.Second)
+	m.register(fastName, fast)
+	m.register(slowName, slow)
+	m.register(failingName, failing)
+	if err := m.start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The slow sink holds on to the first message, then its queue fills
+	// up. The fast sink keeps up with the messages as they come.
+	for i := 1; i <= count; i++ {
+		m.deliver(sinkEnvelope{Channel: "series", Data: []byte(strconv.Itoa(i))})
+		if i == 1 {
+			<-slow.started
+		}
+		waitFor(t, "the fast sink", func() bool { return fast.deliveredCount() == i })
+	}
+
+	waitFor(t, "the failing sink", func() bool { return sinkStats(failingName).failed == count })
+	if got := sinkStats(slowName).dropped; got != count-1-queueSize {
+		t.Errorf("%d messages were dropped for the slow sink, want %d", got, count-1-queueSize)
+	}
+	if got := sinkStats(fastName); got.delivered != count || got.dropped != 0 || got.failed != 0 {
+		t.Errorf("got counters %+v for the fast sink, want %d delivered", got, count)
+	}
+
+	// The queued messages are delivered on shutdown
+	go func() {
+		for range slow.started {
+		}
+	}()
+	close(slow.release)
+	m.shutdown(time.Second)
+	close(slow.started)
+	if got := slow.deliveredCount(); got != 1+queueSize {
+		t.Errorf("the slow sink got %d messages, want %d", got, 1+queueSize)
+	}
+	for name, s := range map[string]*fakeSink{fastName: fast, slowName: slow, failingName: failing} {
+		if !s.flushed || !s.closed {
+			t.Errorf("sink %s: flushed %t, closed %t", name, s.flushed, s.closed)
+		}
+	}
+}
+
+// A sink that doesn't drain its queue in time has the rest dropped, and
+// one that hangs when flushing or closing doesn't keep the others from it
+func TestSinkManagerShutdownTimeout(t *testing.T) {
+	discardLog(t)
+	slow := &fakeSink{release: make(chan struct{}), started: make(chan struct{}, 5)}
+	hanging := &fakeSink{hang: make(chan struct{})}
+	other := &fakeSink{}
+	slowName := sinkName("slow")
+	defer close(hanging.hang)
+
+	m := newSinkManager(10, 50*time.Millisecond)
+	m.register(slowName, slow)
+	m.register(sinkName("hanging"), hanging)
+	m.register(sinkName("other"), other)
+	if err := m.start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		m.deliver(sinkEnvelope{Channel: "series", Data: []byte("m")})
+	}
+	<-slow.started
+
+	start := time.Now()
+	m.shutdown(50 * time.Millisecond)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("shutdown took %s", took)
+	}
+	if !other.flushed || !other.closed {
+		t.Errorf("the other sink was flushed %t and closed %t, want both", other.flushed, other.closed)
+	}
+
+	// The message being delivered completes, the four queued are dropped
+	close(slow.release)
+	waitFor(t, "the slow sink's queue", func() bool { return sinkStats(slowName).dropped == 4 })
+	if got := slow.deliveredCount(); got != 1 {
+		t.Errorf("the slow sink got %d messages after the timeout, want 1", got)
+	}
+
+	// Nothing is queued after shutdown
+	m.deliver(sinkEnvelope{Channel: "series", Data: []byte("late")})
+	if got := other.deliveredCount(); got != 5 {
+		t.Errorf("the other sink got %d messages, want 5", got)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Keeps count, min, max and total of all added message sizes in bytes, and
+// the most recent sizes for estimating quantiles
+type sizeStats struct {
+	count   int
+	min     int
+	max     int
+	total   int64
+	samples sampleRing
+}
+
+func (s *sizeStats) add(size int) {
+	if s.count == 0 || size < s.min {
+		s.min = size
+	}
+	if size > s.max {
+		s.max = size
+	}
+	s.count++
+	s.total += int64(size)
+	s.samples.add(int64(size))
+}
+
+func (s *sizeStats) avg() int {
+	if s.count == 0 {
+		return 0
+	}
+
+	return int(s.total / int64(s.count))
+}
+
+// Returns the q quantile (0 <= q <= 1) of the recent sizes
+func (s *sizeStats) quantile(q float64) int {
+	return int(s.samples.quantile(q))
+}
+
+// Returns a copy that doesn't share the samples with s
+func (s sizeStats) clone() sizeStats {
+	s.samples = s.samples.clone()
+	return s
+}
+
+func (s *sizeStats) String() string {
+	return fmt.Sprintf("%d messages, %d bytes in total, min %d, avg %d, p95 %d, max %d bytes",
+		s.count, s.total, s.min, s.avg(), s.quantile(0.95), s.max)
+}
+
+// Returns the channel names in sorted order
+func sortedChannels(sizes map[string]sizeStats) []string {
+	channels := make([]string, 0, len(sizes))
+	for c := range sizes {
+		channels = append(channels, c)
+	}
+	sort.Strings(channels)
+
+	return channels
+}
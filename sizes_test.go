@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSizeStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		sizes     []int
+		wantMin   int
+		wantAvg   int
+		wantP95   int
+		wantMax   int
+		wantTotal int64
+	}{
+		{"empty", nil, 0, 0, 0, 0, 0},
+		{"one message", []int{120}, 120, 120, 120, 120, 120},
+		{"unsorted", []int{300, 100, 200}, 100, 200, 200, 300, 600},
+		{"zero bytes", []int{0, 10}, 0, 5, 0, 10, 10},
+		{"one large", append(repeatSize(100, 99), 10000), 100, 199, 100, 10000, 19900},
+		{"p95 of 1 to 100", countingSizes(100), 1, 50, 95, 100, 5050},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var s sizeStats
+			for _, size := range test.sizes {
+				s.add(size)
+			}
+
+			got := []int64{int64(s.min), int64(s.avg()), int64(s.quantile(0.95)), int64(s.max), s.total}
+			want := []int64{int64(test.wantMin), int64(test.wantAvg), int64(test.wantP95), int64(test.wantMax), test.wantTotal}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got min, avg, p95, max and total %v, want %v", got, want)
+			}
+			if s.count != len(test.sizes) {
+				t.Errorf("got count %d, want %d", s.count, len(test.sizes))
+			}
+		})
+	}
+}
+
+// The minimum covers all sizes, the quantiles only the recent ones
+func TestSizeStatsRecentSamples(t *testing.T) {
+	var s sizeStats
+	s.add(1)
+	for i := 0; i < sampleRingSize; i++ {
+		s.add(500)
+	}
+
+	if s.min != 1 {
+		t.Errorf("got min %d, want 1", s.min)
+	}
+	if got := s.quantile(0); got != 500 {
+		t.Errorf("got the smallest recent size %d, want 500", got)
+	}
+}
+
+func TestSizeStatsString(t *testing.T) {
+	var s sizeStats
+	for _, size := range []int{100, 200, 300} {
+		s.add(size)
+	}
+
+	want := "3 messages, 600 bytes in total, min 100, avg 200, p95 200, max 300 bytes"
+	if got := s.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func repeatSize(size int, n int) []int {
+	sizes := make([]int, n)
+	for i := range sizes {
+		sizes[i] = size
+	}
+
+	return sizes
+}
+
+// The sizes 1 to n
+func countingSizes(n int) []int {
+	sizes := make([]int, n)
+	for i := range sizes {
+		sizes[i] = i + 1
+	}
+
+	return sizes
+}
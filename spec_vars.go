@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var specVarFlag = flag.StringArray("var", nil, "Value for a '${NAME}' placeholder in subscription files, as 'NAME=value' (repeatable), takes precedence over the environment variable")
+var printRenderedFlag = flag.Bool("print-rendered", false, "Print the '--subscription-file' specs with the placeholders expanded and exit without registering them")
+
+// Placeholders are only recognized with braces, so that a '$' elsewhere in
+// a spec, e.g. in a description, is left alone
+var specPlaceholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Parses the '--var' options into a map from name to value
+func parseSpecVars(flags []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, f := range flags {
+		i := strings.Index(f, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid option '--var=%s', must be 'NAME=value'", f)
+		}
+		name := f[:i]
+		if !specPlaceholder.MatchString("${" + name + "}") {
+			return nil, fmt.Errorf("Invalid variable name '%s' in '--var', must be letters, digits and '_'", name)
+		}
+		vars[name] = f[i+1:]
+	}
+
+	return vars, nil
+}
+
+// Replaces the '${NAME}' placeholders in a spec with the '--var' values or
+// environment variables. The text is replaced before the JSON is parsed,
+// so a placeholder outside quotes becomes a number. Fails with the names of
+// all the variables that aren't set.
+func expandSpecVars(b []byte) ([]byte, error) {
+	vars, err := parseSpecVars(*specVarFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[string]bool)
+	expanded := specPlaceholder.ReplaceAllFunc(b, func(placeholder []byte) []byte {
+		name := string(specPlaceholder.FindSubmatch(placeholder)[1])
+		if v, ok := vars[name]; ok {
+			return []byte(v)
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			return []byte(v)
+		}
+
+		missing[name] = true
+		return placeholder
+	})
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Variables not set for the placeholders: %s, give them with '--var' or the environment", strings.Join(names, ", "))
+	}
+
+	return expanded, nil
+}
+
+// Writes the spec files with the placeholders expanded, for
+// '--print-rendered'
+func printRenderedSpecs(fileNames []string) error {
+	if len(fileNames) == 0 {
+		return fmt.Errorf("The option '--print-rendered' needs '--subscription-file'")
+	}
+
+	for _, fileName := range fileNames {
+		b, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		b, err = expandSpecVars(b)
+		if err != nil {
+			return fmt.Errorf("'%s': %v", fileName, err)
+		}
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			b = append(b, '\n')
+		}
+
+		_, err = os.Stdout.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
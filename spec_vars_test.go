@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandSpecVars(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		vars        []string
+		want        Subscription
+		wantErrPart string // Part of the error, no error if empty
+	}{
+		{
+			name: "numeric position",
+			spec: `{"name": "${ENV_NAME}", "filters": [{"channel": "series", "game_id": ${GAME_ID}, "series_id": ${SERIES_ID}}]}`,
+			vars: []string{"GAME_ID=1", "SERIES_ID=42", "ENV_NAME=staging"},
+			want: Subscription{Name: "staging", Filters: []SubscriptionFilter{{Channel: "series", GameID: 1, SeriesID: 42}}},
+		},
+		{
+			name: "from the environment",
+			spec: `{"name": "dev", "filters": [{"channel": "series", "game_id": ${PUSH_TEST_GAME_ID}}]}`,
+			want: Subscription{Name: "dev", Filters: []SubscriptionFilter{{Channel: "series", GameID: 7}}},
+		},
+		{
+			name: "--var before the environment",
+			spec: `{"name": "dev", "filters": [{"channel": "series", "game_id": ${PUSH_TEST_GAME_ID}}]}`,
+			vars: []string{"PUSH_TEST_GAME_ID=3"},
+			want: Subscription{Name: "dev", Filters: []SubscriptionFilter{{Channel: "series", GameID: 3}}},
+		},
+		{
+			name: "dollar without braces",
+			spec: `{"name": "dev", "description": "Costs $5 a $DAY", "filters": [{"channel": "series"}]}`,
+			want: Subscription{Name: "dev", Description: "Costs $5 a $DAY", Filters: []SubscriptionFilter{{Channel: "series"}}},
+		},
+		{
+			name:        "missing variables",
+			spec:        `{"name": "${ENV_NAME}", "filters": [{"channel": "series", "game_id": ${GAME_ID}, "series_id": ${SERIES_ID}}]}`,
+			vars:        []string{"SERIES_ID=42"},
+			wantErrPart: "Variables not set for the placeholders: ENV_NAME, GAME_ID,",
+		},
+		{
+			name:        "number position given text",
+			spec:        `{"name": "dev", "filters": [{"channel": "series", "game_id": ${GAME_ID}}]}`,
+			vars:        []string{"GAME_ID=lol"},
+			wantErrPart: "invalid character",
+		},
+		{
+			name:        "invalid --var",
+			spec:        `{"name": "dev", "filters": [{"channel": "series"}]}`,
+			vars:        []string{"GAME_ID"},
+			wantErrPart: "must be 'NAME=value'",
+		},
+	}
+
+	os.Setenv("PUSH_TEST_GAME_ID", "7")
+	defer os.Unsetenv("PUSH_TEST_GAME_ID")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(vars []string) { *specVarFlag = vars }(*specVarFlag)
+			*specVarFlag = test.vars
+
+			var got Subscription
+			b, err := expandSpecVars([]byte(test.spec))
+			if err == nil {
+				got, err = parseSubscriptionSpec(b)
+			}
+			if test.wantErrPart != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErrPart) {
+					t.Fatalf("got error %v, want one containing '%s'", err, test.wantErrPart)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expanded to %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
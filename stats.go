@@ -0,0 +1,582 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+)
+
+// Counters collected over the lifetime of the client
+type clientStats struct {
+	mu sync.Mutex
+	statsSnapshot
+}
+
+// The values of the counters at one point in time, copied so that they can
+// be used without holding the lock
+type statsSnapshot struct {
+	startedAt         time.Time
+	messagesReceived  int
+	lastMessageAt     time.Time
+	reconnects        int
+	connected         bool
+	disconnectedAt    time.Time // When the latest connection was lost
+	takeoverWarnings  int       // Reconnects that suggest someone else used our subscriber
+	notResumed        int       // Reconnects where the server started a new subscriber
+	estimatedMissed   int       // Messages probably sent during those disconnects
+	subscriptionID    uuid.UUID
+	subscriberID      uuid.UUID
+	pingsReceived     int
+	lastCloseCode     int
+	lastCloseReason   string
+	signatureErrors   int
+	unsignedMessages  int
+	quarantined       int
+	expiresAt         time.Time     // When the '--subscription-ttl' elapses
+	readStalls        durationStats // Time spent processing a message before reading the next
+	readWaits         durationStats // Time ReadMessage blocked waiting for a message
+	bufferedReads     int           // Reads that returned at once since data was already buffered
+	apiThrottledFor   time.Duration // Time spent waiting for the '--api-rate' pacer
+	doubleEncoded     int           // Messages with the payload encoded as a JSON string
+	channelFiltered   int           // Messages not printed or delivered because of '--only-channel' or '--exclude-channel'
+	idFiltered        int           // Messages not printed or delivered because of the '--only-*-id' options
+	idsMissing        int           // Messages passed through since an ID to filter on wasn't found
+	latencyViolations int           // Messages that arrived later than '--latency-warn' or '--latency-error' allows
+	latencyErrors     int           // Messages that arrived later than '--latency-error' allows
+	latencyUnknown    int           // Messages without a 'created' time to check the latency of
+	sinks             map[string]sinkCounters
+	replayDuplicates  int                  // Messages replayed after a reconnect that had already been received
+	outOfOrder        int                  // Messages created before the previous message of their key
+	maxRegression     time.Duration        // How much older than the previous message the worst of them was
+	filters           []SubscriptionFilter // Filters of the active subscription
+	filterHits        []int                // Messages attributed to each of the filters
+	ambiguousHits     int                  // Messages that matched more than one filter
+	unattributed      int                  // Messages that didn't match any filter
+	channelSizes      map[string]sizeStats // Sizes of the received messages, as sent by the server
+	channelArrivals   map[string]channelArrivals
+	startupPhases     []phaseTiming // Time from the process start to the first message
+	startupTook       time.Duration
+	reconnectPhases   map[string]durationStats // Time from a disconnect to the first message, by phase
+	reconnectOrder    []string                 // Names of the reconnect phases in the order they happen
+	reconnectsTook    durationStats
+	unmarshalErrors   int    // Messages that couldn't be unmarshalled as a push message
+	exitReason        string // Why the client shut itself down, if it did
+	transformed       int    // Messages passed through the '--transform-file'
+	transformedFrom   int    // Size in bytes of those messages before the transforms
+	transformedTo     int    // and after them
+	transformErrors   int
+	renameCollisions  int // Renames skipped since the new key already existed
+}
+
+// Outcome of the messages handed to one sink
+type sinkCounters struct {
+	delivered int
+	failed    int
+	dropped   int // Not delivered since the queue of the sink was full
+}
+
+var stats = clientStats{statsSnapshot: statsSnapshot{startedAt: time.Now()}}
+
+func (s *clientStats) snapshot() statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.statsSnapshot
+	c.readStalls = s.readStalls.clone()
+	c.readWaits = s.readWaits.clone()
+	c.filters = append([]SubscriptionFilter(nil), s.filters...)
+	c.filterHits = append([]int(nil), s.filterHits...)
+	c.channelSizes = make(map[string]sizeStats, len(s.channelSizes))
+	for channel, sizes := range s.channelSizes {
+		c.channelSizes[channel] = sizes.clone()
+	}
+	c.channelArrivals = make(map[string]channelArrivals, len(s.channelArrivals))
+	for channel, arrivals := range s.channelArrivals {
+		c.channelArrivals[channel] = arrivals.clone()
+	}
+	c.startupPhases = append([]phaseTiming(nil), s.startupPhases...)
+	c.reconnectOrder = append([]string(nil), s.reconnectOrder...)
+	c.reconnectsTook = s.reconnectsTook.clone()
+	c.reconnectPhases = make(map[string]durationStats, len(s.reconnectPhases))
+	for name, d := range s.reconnectPhases {
+		c.reconnectPhases[name] = d.clone()
+	}
+	c.sinks = make(map[string]sinkCounters, len(s.sinks))
+	for name, counters := range s.sinks {
+		c.sinks[name] = counters
+	}
+
+	return c
+}
+
+func (s *clientStats) messageReceived() {
+	s.mu.Lock()
+	s.messagesReceived++
+	s.lastMessageAt = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *clientStats) setConnected(connected bool) {
+	s.mu.Lock()
+	s.connected = connected
+	if !connected {
+		s.disconnectedAt = time.Now()
+	}
+	s.mu.Unlock()
+}
+
+// Remembers which subscriber and subscription the server says the client is
+// connected to, as given in the init message
+func (s *clientStats) setSubscriber(subscriberID uuid.UUID, subscriptionID uuid.UUID) {
+	s.mu.Lock()
+	s.subscriberID = subscriberID
+	s.subscriptionID = subscriptionID
+	s.mu.Unlock()
+}
+
+// Records the size of a received message in bytes
+func (s *clientStats) messageSize(channel string, size int) {
+	s.mu.Lock()
+	if s.channelSizes == nil {
+		s.channelSizes = make(map[string]sizeStats)
+	}
+	sizes := s.channelSizes[channel]
+	sizes.add(size)
+	s.channelSizes[channel] = sizes
+	s.mu.Unlock()
+}
+
+// Records when a message on the channel arrived
+func (s *clientStats) messageArrived(channel string, at time.Time) {
+	s.mu.Lock()
+	if s.channelArrivals == nil {
+		s.channelArrivals = make(map[string]channelArrivals)
+	}
+	arrivals := s.channelArrivals[channel]
+	arrivals.add(at)
+	s.channelArrivals[channel] = arrivals
+	s.mu.Unlock()
+}
+
+// Sets the filters messages are attributed to. The hit counters are kept
+// as long as the filters stay the same, e.g. when reconnecting.
+func (s *clientStats) setFilters(filters []SubscriptionFilter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sameFilters(filters, s.filters) {
+		return
+	}
+	s.filters = append([]SubscriptionFilter(nil), filters...)
+	s.filterHits = make([]int, len(filters))
+	s.ambiguousHits = 0
+	s.unattributed = 0
+}
+
+func sameFilters(a []SubscriptionFilter, b []SubscriptionFilter) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Counts the message for every filter it satisfies
+func (s *clientStats) attributeToFilters(msg PushMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := 0
+	for i, f := range s.filters {
+		if filterMatches(f, msg) {
+			s.filterHits[i]++
+			matched++
+		}
+	}
+	if matched == 0 {
+		s.unattributed++
+	} else if matched > 1 {
+		s.ambiguousHits++
+	}
+}
+
+func (s *clientStats) takeoverSuspected() {
+	s.mu.Lock()
+	s.takeoverWarnings++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) subscriberNotResumed(estimatedMissed int) {
+	s.mu.Lock()
+	s.notResumed++
+	s.estimatedMissed += estimatedMissed
+	s.mu.Unlock()
+}
+
+func (s *clientStats) setSubscriptionExpiry(at time.Time) {
+	s.mu.Lock()
+	s.expiresAt = at
+	s.mu.Unlock()
+}
+
+func (s *clientStats) pingReceived() {
+	s.mu.Lock()
+	s.pingsReceived++
+	s.mu.Unlock()
+}
+
+// Remembers the latest close frame sent by the server
+func (s *clientStats) closedWithReason(code int, reason string) {
+	s.mu.Lock()
+	s.lastCloseCode = code
+	s.lastCloseReason = reason
+	s.mu.Unlock()
+}
+
+func (s *clientStats) messageQuarantined() {
+	s.mu.Lock()
+	s.quarantined++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) signatureFailed() {
+	s.mu.Lock()
+	s.signatureErrors++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) doubleEncodedReceived() {
+	s.mu.Lock()
+	s.doubleEncoded++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) messageChannelFiltered() {
+	s.mu.Lock()
+	s.channelFiltered++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) messageIDFiltered() {
+	s.mu.Lock()
+	s.idFiltered++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) messageIDsMissing() {
+	s.mu.Lock()
+	s.idsMissing++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) latencyViolated(aboveError bool) {
+	s.mu.Lock()
+	s.latencyViolations++
+	if aboveError {
+		s.latencyErrors++
+	}
+	s.mu.Unlock()
+}
+
+func (s *clientStats) createdTimeMissing() {
+	s.mu.Lock()
+	s.latencyUnknown++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) sinkDelivered(name string) {
+	s.updateSink(name, func(c *sinkCounters) { c.delivered++ })
+}
+
+func (s *clientStats) sinkFailed(name string) {
+	s.updateSink(name, func(c *sinkCounters) { c.failed++ })
+}
+
+func (s *clientStats) sinkDropped(name string) {
+	s.updateSink(name, func(c *sinkCounters) { c.dropped++ })
+}
+
+func (s *clientStats) updateSink(name string, update func(c *sinkCounters)) {
+	s.mu.Lock()
+	if s.sinks == nil {
+		s.sinks = make(map[string]sinkCounters)
+	}
+	c := s.sinks[name]
+	update(&c)
+	s.sinks[name] = c
+	s.mu.Unlock()
+}
+
+func (s *clientStats) replayDuplicateSuppressed() {
+	s.mu.Lock()
+	s.replayDuplicates++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) messageOutOfOrder(regression time.Duration) {
+	s.mu.Lock()
+	s.outOfOrder++
+	if regression > s.maxRegression {
+		s.maxRegression = regression
+	}
+	s.mu.Unlock()
+}
+
+func (s *clientStats) unsignedReceived() {
+	s.mu.Lock()
+	s.unsignedMessages++
+	s.mu.Unlock()
+}
+
+// Reads returning faster than this are assumed to not have waited for data
+const bufferedReadThreshold = 50 * time.Microsecond
+
+// Records how long the read loop spent processing the previous message
+// and how long the following read then blocked
+func (s *clientStats) readTimings(stall time.Duration, wait time.Duration) {
+	s.mu.Lock()
+	s.readStalls.add(stall)
+	s.readWaits.add(wait)
+	if wait < bufferedReadThreshold {
+		s.bufferedReads++
+	}
+	s.mu.Unlock()
+}
+
+func (s *clientStats) apiThrottled(d time.Duration) {
+	s.mu.Lock()
+	s.apiThrottledFor += d
+	s.mu.Unlock()
+}
+
+func (s *clientStats) connectionPhases(phases []phaseTiming, took time.Duration, reconnect bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !reconnect {
+		s.startupPhases = phases
+		s.startupTook = took
+		return
+	}
+
+	if s.reconnectPhases == nil {
+		s.reconnectPhases = make(map[string]durationStats)
+	}
+	s.reconnectsTook.add(took)
+	for _, phase := range phases {
+		d, ok := s.reconnectPhases[phase.name]
+		if !ok {
+			s.reconnectOrder = append(s.reconnectOrder, phase.name)
+		}
+		d.add(phase.duration)
+		s.reconnectPhases[phase.name] = d
+	}
+}
+
+func (s *clientStats) unmarshalFailed() {
+	s.mu.Lock()
+	s.unmarshalErrors++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) messageTransformed(from int, to int) {
+	s.mu.Lock()
+	s.transformed++
+	s.transformedFrom += from
+	s.transformedTo += to
+	s.mu.Unlock()
+}
+
+func (s *clientStats) transformFailed() {
+	s.mu.Lock()
+	s.transformErrors++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) transformCollision() {
+	s.mu.Lock()
+	s.renameCollisions++
+	s.mu.Unlock()
+}
+
+func (s *clientStats) setExitReason(reason string) {
+	s.mu.Lock()
+	s.exitReason = reason
+	s.mu.Unlock()
+}
+
+func (s *clientStats) reconnected() {
+	s.mu.Lock()
+	s.reconnects++
+	s.mu.Unlock()
+}
+
+// Logs a line with the main counters with the given interval. With verbose
+// the message sizes of every channel are logged too.
+func statsLoop(interval time.Duration, verbose bool) {
+	for {
+		time.Sleep(interval)
+
+		s := stats.snapshot()
+		state := "connected"
+		if !s.connected {
+			state = "reconnecting"
+		}
+		log.Printf("[STATS] %s, received %d messages and %d pings, reconnected %d times, %d messages out of order (at most %s)\n",
+			state, s.messagesReceived, s.pingsReceived, s.reconnects, s.outOfOrder, s.maxRegression)
+		if replayDuplicates != nil {
+			log.Printf("[STATS] %d messages replayed after reconnects were suppressed as duplicates\n", s.replayDuplicates)
+		}
+		if !s.expiresAt.IsZero() {
+			log.Printf("[STATS] The '--subscription-ttl' elapses in %s\n", roundDuration(time.Until(s.expiresAt), time.Second))
+		}
+		logLocalFilters("[STATS]", s)
+		logLatency("[STATS]", s)
+
+		if verbose {
+			for _, channel := range sortedChannels(s.channelSizes) {
+				sizes := s.channelSizes[channel]
+				log.Printf("[STATS] Channel '%s': %s\n", channel, sizes.String())
+			}
+			logChannelArrivals("[STATS]", s.channelArrivals)
+		}
+	}
+}
+
+// Logs the gaps between messages and the largest burst of every channel,
+// warning about channels with a gap longer than '--gap-warn-threshold'
+func logChannelArrivals(tag string, arrivals map[string]channelArrivals) {
+	channels := make([]string, 0, len(arrivals))
+	for channel := range arrivals {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	for _, channel := range channels {
+		a := arrivals[channel]
+		if a.gaps.count == 0 {
+			continue
+		}
+		log.Printf("%s Channel '%s': gap between messages p50 %s, p95 %s, max %s, at most %d messages within %s\n",
+			tag, channel, a.gaps.quantile(0.5), a.gaps.quantile(0.95), a.gaps.max, a.maxBurst, burstWindow)
+		if *gapWarnThresholdFlag > 0 && a.gaps.max > *gapWarnThresholdFlag {
+			log.Printf("[WARN] Channel '%s' had a gap of %s between messages, longer than '--gap-warn-threshold' %s\n", channel, a.gaps.max, *gapWarnThresholdFlag)
+		}
+	}
+}
+
+// Logs the summary of the run, printed when the client exits
+func (s *clientStats) printSummary() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uptime := roundDuration(time.Since(s.startedAt), time.Second)
+	log.Printf("[SUMMARY] Ran for %s, received %d messages and %d pings, reconnected %d times\n", uptime, s.messagesReceived, s.pingsReceived, s.reconnects)
+	if s.startupPhases != nil {
+		log.Printf("[SUMMARY] Startup took %s until the first message: %s\n", roundDuration(s.startupTook, time.Millisecond), formatPhases(s.startupPhases))
+	}
+	if s.reconnectsTook.count > 0 {
+		avgs := make([]phaseTiming, 0, len(s.reconnectOrder))
+		for _, name := range s.reconnectOrder {
+			d := s.reconnectPhases[name]
+			avgs = append(avgs, phaseTiming{name: name, duration: d.avg()})
+		}
+		log.Printf("[SUMMARY] %d reconnects took avg %s, max %s until the first message. Avg by phase: %s\n",
+			s.reconnectsTook.count, roundDuration(s.reconnectsTook.avg(), time.Millisecond), roundDuration(s.reconnectsTook.max, time.Millisecond), formatPhases(avgs))
+	}
+	if messageQuarantine != nil {
+		log.Printf("[SUMMARY] %d messages were quarantined\n", s.quarantined)
+	}
+	for _, channel := range sortedChannels(s.channelSizes) {
+		sizes := s.channelSizes[channel]
+		log.Printf("[SUMMARY] Channel '%s': %s\n", channel, sizes.String())
+	}
+	logChannelArrivals("[SUMMARY]", s.channelArrivals)
+	if expectedSeries != nil {
+		expectedSeries.printSummary()
+	}
+	for i, f := range s.filters {
+		log.Printf("[SUMMARY] Filter %d (%s) matched %d messages\n", i+1, describeFilter(f), s.filterHits[i])
+	}
+	if len(s.filters) > 0 {
+		if s.ambiguousHits > 0 {
+			log.Printf("[SUMMARY] %d messages matched more than one filter and were counted for each of them\n", s.ambiguousHits)
+		}
+		if s.unattributed > 0 {
+			log.Printf("[SUMMARY] %d messages could not be attributed to any filter\n", s.unattributed)
+		}
+		for i, f := range s.filters {
+			if s.filterHits[i] == 0 {
+				log.Printf("[WARN] Filter %d (%s) did not match any messages, check its IDs\n", i+1, describeFilter(f))
+			}
+		}
+	}
+	if replayDuplicates != nil {
+		log.Printf("[SUMMARY] %d messages replayed after reconnects were suppressed as duplicates\n", s.replayDuplicates)
+	}
+	if s.outOfOrder > 0 {
+		log.Printf("[SUMMARY] %d messages arrived out of 'created' order, at most %s older than the previous message of their channel and game or series\n", s.outOfOrder, s.maxRegression)
+	}
+	if messageTransforms != nil {
+		log.Printf("[SUMMARY] Transformed %d messages from %d to %d bytes, %d failed and %d renames were skipped since the new key existed\n",
+			s.transformed, s.transformedFrom, s.transformedTo, s.transformErrors, s.renameCollisions)
+	}
+	if s.doubleEncoded > 0 {
+		log.Printf("[SUMMARY] %d messages had a double-encoded payload\n", s.doubleEncoded)
+	}
+	logLocalFilters("[SUMMARY]", s.statsSnapshot)
+	logLatency("[SUMMARY]", s.statsSnapshot)
+	sinkNames := make([]string, 0, len(s.sinks))
+	for name := range s.sinks {
+		sinkNames = append(sinkNames, name)
+	}
+	sort.Strings(sinkNames)
+	for _, name := range sinkNames {
+		c := s.sinks[name]
+		log.Printf("[SUMMARY] Sink '%s' delivered %d messages, %d failed and %d were dropped\n", name, c.delivered, c.failed, c.dropped)
+	}
+	if s.takeoverWarnings > 0 {
+		log.Printf("[SUMMARY] %d reconnects indicated a possible subscriber takeover or reconnect token reuse\n", s.takeoverWarnings)
+	}
+	if s.notResumed > 0 {
+		log.Printf("[SUMMARY] %d reconnects didn't resume the subscriber, about %d messages were missed\n", s.notResumed, s.estimatedMissed)
+	}
+	if signatureKey != nil {
+		log.Printf("[SUMMARY] %d messages had an invalid signature, %d were not signed\n", s.signatureErrors, s.unsignedMessages)
+	}
+	if s.readStalls.count > 0 {
+		log.Printf("[SUMMARY] Read loop processing stall p95 %s, max %s. Read wait p95 %s, max %s, %d of %d reads had data already buffered\n",
+			s.readStalls.quantile(0.95), s.readStalls.max, s.readWaits.quantile(0.95), s.readWaits.max, s.bufferedReads, s.readWaits.count)
+	}
+	if s.apiThrottledFor > 0 {
+		log.Printf("[SUMMARY] Spent %s throttled by '--api-rate'\n", roundDuration(s.apiThrottledFor, time.Millisecond))
+	}
+	if s.lastCloseCode != 0 {
+		log.Printf("[SUMMARY] Last close from server had code %d. Reason: %s\n", s.lastCloseCode, pushclient.CloseReason(s.lastCloseReason))
+	}
+	if s.unmarshalErrors > 0 {
+		log.Printf("[SUMMARY] %d messages failed to unmarshal\n", s.unmarshalErrors)
+	}
+	if s.exitReason != "" {
+		log.Printf("[SUMMARY] Shut down as unhealthy: %s\n", s.exitReason)
+	}
+}
+
+// Logs how many messages the client side filters left out
+func logLocalFilters(tag string, s statsSnapshot) {
+	if channelFilter != nil {
+		log.Printf("%s %d messages were not printed or delivered because of '--only-channel' or '--exclude-channel'\n", tag, s.channelFiltered)
+	}
+	if idFilter != nil {
+		log.Printf("%s %d messages were not printed or delivered because of their game, series or match ID, %d were passed through since an ID wasn't found\n", tag, s.idFiltered, s.idsMissing)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// How often the status file is rewritten. A status file that is older than
+// this means that the client has stopped.
+const statusFileInterval = 5 * time.Second
+
+// The document written to the '--status-file'
+type statusFile struct {
+	V                 int          `json:"v"` // The output format version
+	PID               int          `json:"pid"`
+	Version           string       `json:"version"`
+	ConnectionState   string       `json:"connection_state"`
+	SubscriptionID    string       `json:"subscription_id"`
+	SubscriberID      uuid.UUID    `json:"subscriber_id"`
+	LastMessageAt     *time.Time   `json:"last_message_at"`
+	Reconnects        int          `json:"reconnects"`
+	MessagesReceived  int          `json:"messages_received"`
+	TakeoverWarnings  int          `json:"takeover_warnings"`
+	Paused            bool         `json:"paused"`
+	ExpiresAt         *time.Time   `json:"subscription_expires_at,omitempty"`
+	RemainingTTL      string       `json:"subscription_remaining_ttl,omitempty"`
+	FilterHits        []filterHits `json:"filter_hits,omitempty"`
+	LatencyViolations *int         `json:"latency_violations,omitempty"` // Only with '--latency-warn' or '--latency-error'
+	UpdatedAt         time.Time    `json:"updated_at"`
+}
+
+// Number of messages attributed to one filter of the subscription
+type filterHits struct {
+	Filter SubscriptionFilter `json:"filter"`
+	Hits   int                `json:"hits"`
+}
+
+func statusFileLoop(fileName string) {
+	for {
+		err := writeStatusFile(fileName, stats.snapshot())
+		if err != nil {
+			log.Println("[ERROR] Failed to write status file. Error: ", err)
+		}
+
+		time.Sleep(statusFileInterval)
+	}
+}
+
+// Writes the status to a temporary file which is then renamed, so that
+// readers never see a partially written file
+func writeStatusFile(fileName string, s statsSnapshot) error {
+	status := statusFile{
+		V:                *outputVersionFlag,
+		PID:              os.Getpid(),
+		Version:          version,
+		ConnectionState:  "connecting",
+		SubscriptionID:   currentSubscription(),
+		SubscriberID:     s.subscriberID,
+		Reconnects:       s.reconnects,
+		MessagesReceived: s.messagesReceived,
+		TakeoverWarnings: s.takeoverWarnings,
+		Paused:           pause.isPaused(),
+		UpdatedAt:        time.Now().UTC(),
+	}
+	if s.connected {
+		status.ConnectionState = "connected"
+	} else if s.subscriberID != uuid.Nil {
+		status.ConnectionState = "reconnecting"
+	}
+	if s.subscriptionID != uuid.Nil {
+		status.SubscriptionID = s.subscriptionID.String()
+	}
+	if !s.lastMessageAt.IsZero() {
+		t := s.lastMessageAt.UTC()
+		status.LastMessageAt = &t
+	}
+
+	if latencyChecked() {
+		status.LatencyViolations = &s.latencyViolations
+	}
+
+	for i, f := range s.filters {
+		status.FilterHits = append(status.FilterHits, filterHits{Filter: f, Hits: s.filterHits[i]})
+	}
+
+	if !s.expiresAt.IsZero() {
+		t := s.expiresAt.UTC()
+		status.ExpiresAt = &t
+		status.RemainingTTL = roundDuration(time.Until(s.expiresAt), time.Second).String()
+	}
+
+	b, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(fileName, b)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"log"
+
+	flag "github.com/spf13/pflag"
+)
+
+var wsSubprotocolFlag = flag.StringArray("ws-subprotocol", nil, "Websocket subprotocol to offer the server, in order of preference (repeatable)")
+
+// Logs the subprotocol the server selected. The library decodes the frames
+// by it, and warns when it falls back to JSON for a protocol it doesn't
+// know.
+func logSubprotocol(protocol string) {
+	if protocol == "" && len(*wsSubprotocolFlag) > 0 {
+		log.Println("[INFO] The server did not select any of the offered subprotocols, decoding messages as JSON")
+	} else if protocol != "" {
+		log.Printf("[INFO] The server selected subprotocol '%s'\n", protocol)
+	}
+}
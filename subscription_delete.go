@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	flag "github.com/spf13/pflag"
+)
+
+var yesFlag = flag.Bool("yes", false, "delete, prune: don't ask for confirmation before deleting")
+var pruneAllFlag = flag.Bool("all", false, "prune: delete every registered subscription, export: export every registered subscription")
+var pruneMatchFlag = flag.String("match", "", "prune: only delete subscriptions with a name matching this glob pattern, e.g. 'dev-*'")
+
+// Exit codes of the delete command
+const (
+	deleteExitDeleted  = 0
+	deleteExitFailed   = 1
+	deleteExitNotFound = 2
+)
+
+// Deletes the subscriptions with the given IDs or names after asking for
+// confirmation, unless '--yes' is given. Exits with 2 if any of them
+// doesn't exist and the rest were deleted, and with 1 on other errors.
+func deleteCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("The delete command needs at least one subscription id or name")
+	}
+	if !*yesFlag && !isTerminal(os.Stdin) {
+		return fmt.Errorf("The delete command can't ask for confirmation without a terminal, use '--yes' to delete without it")
+	}
+
+	err := validateCredentialFlags()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	b, err := apiClient.FetchSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("Subscriptions list request failed. Error: %v", err)
+	}
+
+	var registered []Subscription
+	err = json.Unmarshal(b, &registered)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal subscriptions. Error: %v", err)
+	}
+
+	notFound := 0
+	failed := 0
+
+	// Everything is checked before asking, so that nothing is deleted when
+	// one of the identifiers turns out to be wrong
+	var subs []Subscription
+	for _, idOrName := range args {
+		// Not even '--yes' deletes a subscription that may not be the one
+		// that was meant
+		if matches := subscriptionsMatching(registered, idOrName); len(matches) > 1 {
+			log.Printf("[ERROR] '%s' could mean any of %s, give the full ID of the subscription to delete\n", idOrName, describeSubscriptions(matches))
+			failed++
+			continue
+		}
+
+		sub, err := apiClient.FetchSubscription(ctx, idOrName)
+		if err == pushclient.ErrSubscriptionNotFound {
+			log.Printf("[ERROR] Subscription '%s' not found\n", idOrName)
+			notFound++
+			continue
+		} else if err != nil {
+			log.Printf("[ERROR] Failed to fetch subscription '%s'. Error: %v\n", idOrName, err)
+			failed++
+			continue
+		}
+
+		err = checkSubscriptionOwner(sub, *ownerTagFlag, *forceForeignFlag, "delete")
+		if err != nil {
+			log.Println("[ERROR] ", err)
+			failed++
+			continue
+		}
+
+		subs = append(subs, sub)
+	}
+
+	if len(subs) > 0 && !*yesFlag {
+		subs = confirmDelete(os.Stdin, os.Stderr, subs)
+		if len(subs) == 0 {
+			log.Println("[INFO] Nothing deleted")
+		}
+	}
+
+	for _, sub := range subs {
+		err := apiClient.DeleteSubscription(ctx, sub.ID.String())
+		if err == pushclient.ErrSubscriptionNotFound {
+			log.Printf("[ERROR] Subscription %s was deleted by someone else\n", sub.ID)
+			notFound++
+			continue
+		} else if err != nil {
+			log.Printf("[ERROR] Failed to delete subscription %s. Error: %v\n", sub.ID, err)
+			failed++
+			continue
+		}
+
+		log.Printf("[INFO] Deleted subscription %s '%s'\n", sub.ID, sub.Name)
+	}
+
+	if failed > 0 {
+		return &exitCodeError{code: deleteExitFailed}
+	} else if notFound > 0 {
+		return &exitCodeError{code: deleteExitNotFound}
+	}
+
+	return nil
+}
+
+// Deletes every registered subscription, or those with a name matching
+// '--match', after asking for confirmation unless '--yes' is given.
+// Subscriptions owned by someone else than '--owner-tag' are skipped.
+// Continues past failed deletes and exits with 1 if there were any.
+func pruneCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("The prune command takes no arguments")
+	}
+	if !*pruneAllFlag && *pruneMatchFlag == "" {
+		return fmt.Errorf("The prune command needs the option '--all' or '--match'")
+	}
+	if *pruneAllFlag && *pruneMatchFlag != "" {
+		return fmt.Errorf("The options '--all' and '--match' can't be used together")
+	}
+	if _, err := path.Match(*pruneMatchFlag, ""); err != nil {
+		return fmt.Errorf("Invalid pattern '%s' for '--match'. Error: %v", *pruneMatchFlag, err)
+	}
+	if !*yesFlag && !isTerminal(os.Stdin) {
+		return fmt.Errorf("The prune command can't ask for confirmation without a terminal, use '--yes' to delete without it")
+	}
+
+	err := validateCredentialFlags()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	b, err := apiClient.FetchSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("Subscriptions list request failed. Error: %v", err)
+	}
+
+	var registered []Subscription
+	err = json.Unmarshal(b, &registered)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal subscriptions. Error: %v", err)
+	}
+
+	var subs []Subscription
+	skipped := 0
+	for _, sub := range registered {
+		if *pruneMatchFlag != "" {
+			if ok, _ := path.Match(*pruneMatchFlag, sub.Name); !ok {
+				continue
+			}
+		}
+
+		err := checkSubscriptionOwner(sub, *ownerTagFlag, *forceForeignFlag, "delete")
+		if err != nil {
+			log.Printf("[WARN] %v\n", err)
+			skipped++
+			continue
+		}
+
+		subs = append(subs, sub)
+	}
+
+	if len(subs) == 0 {
+		log.Printf("[INFO] None of the %d registered subscriptions would be deleted\n", len(registered))
+		return nil
+	}
+	if !*yesFlag {
+		subs = confirmDelete(os.Stdin, os.Stderr, subs)
+		if len(subs) == 0 {
+			log.Println("[INFO] Nothing deleted")
+			return nil
+		}
+	}
+
+	deleted := 0
+	for _, sub := range subs {
+		err := apiClient.DeleteSubscription(ctx, sub.ID.String())
+		if err == pushclient.ErrSubscriptionNotFound {
+			// Someone else got there first, it is gone either way
+			log.Printf("[INFO] Subscription %s '%s' was already deleted\n", sub.ID, sub.Name)
+			deleted++
+			continue
+		} else if err != nil {
+			log.Printf("[ERROR] Failed to delete subscription %s '%s'. Error: %v\n", sub.ID, sub.Name, err)
+			continue
+		}
+
+		log.Printf("[INFO] Deleted subscription %s '%s'\n", sub.ID, sub.Name)
+		deleted++
+	}
+
+	failed := len(subs) - deleted
+	log.Printf("[SUMMARY] Deleted %d subscriptions, %d failed, %d skipped\n", deleted, failed, skipped)
+	if failed > 0 {
+		return &exitCodeError{code: deleteExitFailed}
+	}
+
+	return nil
+}
+
+// Names and IDs look alike in a terminal, so an ID prefix of at least this
+// length that equals a name makes the name ambiguous
+const minAmbiguousIDPrefix = 4
+
+// Returns the registered subscriptions an identifier could mean: the one
+// with it as name, and the ones whose ID is or starts with it
+func subscriptionsMatching(registered []Subscription, idOrName string) []Subscription {
+	prefix := strings.ToLower(idOrName)
+
+	var matches []Subscription
+	for _, sub := range registered {
+		id := sub.ID.String()
+		if sub.Name == idOrName || id == prefix || (len(prefix) >= minAmbiguousIDPrefix && strings.HasPrefix(id, prefix)) {
+			matches = append(matches, sub)
+		}
+	}
+
+	return matches
+}
+
+// Lists the subscriptions on one line, for the ambiguity error
+func describeSubscriptions(subs []Subscription) string {
+	parts := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		parts = append(parts, fmt.Sprintf("%s '%s'", sub.ID, sub.Name))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Shows the name, ID and filters of each subscription and asks to type its
+// name to delete it, or its ID if it has no name. The answer must match
+// exactly apart from surrounding spaces, so "yes" or a differently cased
+// name don't confirm. A wrong answer only skips that subscription, at the
+// end of the input all that are left are skipped. Returns the subscriptions
+// that were confirmed.
+func confirmDelete(in io.Reader, out io.Writer, subs []Subscription) []Subscription {
+	r := bufio.NewReader(in)
+
+	var confirmed []Subscription
+	for _, sub := range subs {
+		fmt.Fprintf(out, "Subscription '%s'\n  ID: %s\n", sub.Name, sub.ID)
+		if len(sub.Filters) == 0 {
+			fmt.Fprintf(out, "  Filters: none\n")
+		}
+		for _, f := range sub.Filters {
+			fmt.Fprintf(out, "  Filter: %s\n", describeFilter(f))
+		}
+
+		want := sub.Name
+		if want == "" {
+			want = sub.ID.String()
+		}
+		fmt.Fprintf(out, "Type '%s' to delete it: ", want)
+
+		answer, err := r.ReadString('\n')
+		if strings.TrimSpace(answer) != want {
+			fmt.Fprintf(out, "Not deleting '%s'\n", want)
+			if err != nil {
+				return confirmed
+			}
+			continue
+		}
+		confirmed = append(confirmed, sub)
+	}
+
+	return confirmed
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestConfirmDelete(t *testing.T) {
+	dev := Subscription{
+		ID:      uuid.Must(uuid.FromString("7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b")),
+		Name:    "dev",
+		Filters: []SubscriptionFilter{{Channel: "series", GameID: 1}},
+	}
+	unnamed := Subscription{
+		ID: uuid.Must(uuid.FromString("0d1e2f3a-4b5c-4d6e-8f9a-0b1c2d3e4f5a")),
+	}
+
+	tests := []struct {
+		name  string
+		subs  []Subscription
+		input string
+		want  []Subscription
+	}{
+		{"name typed", []Subscription{dev}, "dev\n", []Subscription{dev}},
+		{"name with spaces", []Subscription{dev}, "  dev  \n", []Subscription{dev}},
+		{"yes is not the name", []Subscription{dev}, "yes\n", nil},
+		{"ID instead of name", []Subscription{dev}, dev.ID.String() + "\n", nil},
+		{"wrong case", []Subscription{dev}, "DEV\n", nil},
+		{"no input", []Subscription{dev}, "", nil},
+		{"unnamed needs the ID", []Subscription{unnamed}, unnamed.ID.String() + "\n", []Subscription{unnamed}},
+		{"each confirmed separately", []Subscription{dev, unnamed}, "nope\n" + unnamed.ID.String() + "\n", []Subscription{unnamed}},
+		{"input ends early", []Subscription{dev, unnamed}, "dev", []Subscription{dev}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			got := confirmDelete(strings.NewReader(tt.input), &out, tt.subs)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("confirmed %d subscriptions, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i].ID {
+					t.Errorf("confirmed %s, want %s", got[i].ID, tt.want[i].ID)
+				}
+			}
+		})
+	}
+}
+
+func TestConfirmDeleteShowsSubscription(t *testing.T) {
+	sub := Subscription{
+		ID:      uuid.Must(uuid.FromString("7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b")),
+		Name:    "dev",
+		Filters: []SubscriptionFilter{{Channel: "series", GameID: 1}, {Channel: "match"}},
+	}
+
+	var out bytes.Buffer
+	confirmDelete(strings.NewReader("\n"), &out, []Subscription{sub})
+
+	for _, want := range []string{"'dev'", sub.ID.String(), "channel=series game_id=1", "channel=match", "Type 'dev'"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("prompt %q doesn't show %q", out.String(), want)
+		}
+	}
+}
+
+func TestSubscriptionsMatching(t *testing.T) {
+	a := Subscription{ID: uuid.Must(uuid.FromString("cafe0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b")), Name: "prod"}
+	b := Subscription{ID: uuid.Must(uuid.FromString("0d1e2f3a-4b5c-4d6e-8f9a-0b1c2d3e4f5a")), Name: "cafe"}
+	c := Subscription{ID: uuid.Must(uuid.FromString("abc12f3a-4b5c-4d6e-8f9a-0b1c2d3e4f5a")), Name: "abc"}
+	d := Subscription{ID: uuid.Must(uuid.FromString("1a2b3c4d-4b5c-4d6e-8f9a-0b1c2d3e4f5a")), Name: a.ID.String()}
+	registered := []Subscription{a, b, c, d}
+
+	tests := []struct {
+		idOrName string
+		want     int
+	}{
+		{"prod", 1},
+		{"unknown", 0},
+		{"cafe", 2},        // b's name and the start of a's ID
+		{"CAFE0B1E", 1},    // Only a's ID, names are case sensitive
+		{"abc", 1},         // c's name and the start of its own ID
+		{"1a2", 0},         // Too short to be taken for an ID prefix
+		{"0d1e2f3a", 1},    // Only an ID prefix
+		{b.ID.String(), 1}, // A full ID
+		{a.ID.String(), 2}, // a's ID and d's name
+	}
+
+	for _, tt := range tests {
+		if got := subscriptionsMatching(registered, tt.idOrName); len(got) != tt.want {
+			t.Errorf("'%s' matches %d subscriptions, want %d", tt.idOrName, len(got), tt.want)
+		}
+	}
+}
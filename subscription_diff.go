@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// A difference between two versions of a subscription. Filter changes are
+// given as added or removed filters, other fields as changed values.
+type subscriptionChange struct {
+	Op     string              `json:"op"`              // "add", "remove" or "change"
+	Field  string              `json:"field"`           // "filters", "name" or "description"
+	Filter *SubscriptionFilter `json:"value,omitempty"` // The added or removed filter
+	From   string              `json:"from,omitempty"`  // Old value of a changed field
+	To     string              `json:"to,omitempty"`    // New value of a changed field
+}
+
+// Compares two subscriptions semantically. The read-only ID is ignored and
+// the filters are compared as sets, so their order and duplicates don't
+// matter. Returns the changes needed to turn from into to.
+func diffSubscriptions(from Subscription, to Subscription) []subscriptionChange {
+	var changes []subscriptionChange
+	if from.Name != to.Name {
+		changes = append(changes, subscriptionChange{Op: "change", Field: "name", From: from.Name, To: to.Name})
+	}
+	if from.Description != to.Description {
+		changes = append(changes, subscriptionChange{Op: "change", Field: "description", From: from.Description, To: to.Description})
+	}
+
+	fromFilters := filterSet(from.Filters)
+	toFilters := filterSet(to.Filters)
+	for _, f := range sortedFilters(fromFilters) {
+		if !toFilters[f] {
+			f := f
+			changes = append(changes, subscriptionChange{Op: "remove", Field: "filters", Filter: &f})
+		}
+	}
+	for _, f := range sortedFilters(toFilters) {
+		if !fromFilters[f] {
+			f := f
+			changes = append(changes, subscriptionChange{Op: "add", Field: "filters", Filter: &f})
+		}
+	}
+
+	return changes
+}
+
+// Summarizes the changes for a log line, e.g. '2 filters added, 1 removed,
+// description changed'
+func summarizeChanges(changes []subscriptionChange) string {
+	added, removed := 0, 0
+	var parts, changed []string
+	for _, c := range changes {
+		switch {
+		case c.Op == "add":
+			added++
+		case c.Op == "remove":
+			removed++
+		default:
+			changed = append(changed, c.Field)
+		}
+	}
+
+	if added > 0 || removed > 0 {
+		switch {
+		case added > 0 && removed > 0:
+			parts = append(parts, fmt.Sprintf("%s added, %d removed", countFilters(added), removed))
+		case added > 0:
+			parts = append(parts, fmt.Sprintf("%s added", countFilters(added)))
+		default:
+			parts = append(parts, fmt.Sprintf("%s removed", countFilters(removed)))
+		}
+	}
+	for _, field := range changed {
+		parts = append(parts, field+" changed")
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func countFilters(n int) string {
+	if n == 1 {
+		return "1 filter"
+	}
+
+	return fmt.Sprintf("%d filters", n)
+}
+
+func filterSet(filters []SubscriptionFilter) map[SubscriptionFilter]bool {
+	set := make(map[SubscriptionFilter]bool)
+	for _, f := range filters {
+		set[f] = true
+	}
+
+	return set
+}
+
+// Returns the filters in a stable order, for deterministic output
+func sortedFilters(set map[SubscriptionFilter]bool) []SubscriptionFilter {
+	filters := make([]SubscriptionFilter, 0, len(set))
+	for f := range set {
+		filters = append(filters, f)
+	}
+	sort.Slice(filters, func(i, j int) bool {
+		a, b := filters[i], filters[j]
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		if a.GameID != b.GameID {
+			return a.GameID < b.GameID
+		}
+		if a.SeriesID != b.SeriesID {
+			return a.SeriesID < b.SeriesID
+		}
+		if a.MatchID != b.MatchID {
+			return a.MatchID < b.MatchID
+		}
+		if a.TournamentID != b.TournamentID {
+			return a.TournamentID < b.TournamentID
+		}
+		if a.TeamID != b.TeamID {
+			return a.TeamID < b.TeamID
+		}
+		return a.PlayerID < b.PlayerID
+	})
+
+	return filters
+}
+
+// Writes the differences in a unified diff like format, with removed lines
+// in red and added lines in green when the output is a terminal
+func writeSubscriptionDiff(w io.Writer, fromLabel string, toLabel string, from Subscription, changes []subscriptionChange) {
+	removed := color.New(color.FgRed).SprintFunc()
+	added := color.New(color.FgGreen).SprintFunc()
+
+	fmt.Fprintln(w, removed("--- "+fromLabel))
+	fmt.Fprintln(w, added("+++ "+toLabel))
+
+	changedFields := make(map[string]bool)
+	for _, c := range changes {
+		if c.Op == "change" {
+			changedFields[c.Field] = true
+			fmt.Fprintln(w, removed(fmt.Sprintf("-%s: %s", c.Field, c.From)))
+			fmt.Fprintln(w, added(fmt.Sprintf("+%s: %s", c.Field, c.To)))
+		}
+	}
+	if !changedFields["name"] {
+		fmt.Fprintf(w, " name: %s\n", from.Name)
+	}
+
+	fmt.Fprintln(w, " filters:")
+	removedFilters := make(map[SubscriptionFilter]bool)
+	for _, c := range changes {
+		if c.Op == "remove" {
+			removedFilters[*c.Filter] = true
+		}
+	}
+	for _, f := range sortedFilters(filterSet(from.Filters)) {
+		b, _ := json.Marshal(f)
+		if removedFilters[f] {
+			fmt.Fprintln(w, removed("-  "+string(b)))
+		} else {
+			fmt.Fprintln(w, "   "+string(b))
+		}
+	}
+	for _, c := range changes {
+		if c.Op == "add" {
+			b, _ := json.Marshal(c.Filter)
+			fmt.Fprintln(w, added("+  "+string(b)))
+		}
+	}
+}
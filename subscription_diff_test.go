@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/fatih/color"
+	"github.com/gofrs/uuid"
+)
+
+// Describes the changes as '<op> <field>' with the filter or the values
+func describeChanges(changes []subscriptionChange) []string {
+	var got []string
+	for _, c := range changes {
+		if c.Filter != nil {
+			got = append(got, c.Op+" "+describeFilter(*c.Filter))
+		} else {
+			got = append(got, c.Op+" "+c.Field+": "+c.From+" -> "+c.To)
+		}
+	}
+
+	return got
+}
+
+func TestDiffSubscriptions(t *testing.T) {
+	series := SubscriptionFilter{Channel: "series"}
+	match := SubscriptionFilter{Channel: "match"}
+	game1 := SubscriptionFilter{Channel: "series", GameID: 1}
+	game2 := SubscriptionFilter{Channel: "series", GameID: 2}
+	team := SubscriptionFilter{Channel: "series", TeamID: 5}
+	base := Subscription{
+		ID:          uuid.Must(uuid.FromString("7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b")),
+		Name:        "dev",
+		Description: "local",
+		Filters:     []SubscriptionFilter{series, match},
+	}
+	with := func(change func(s *Subscription)) Subscription {
+		s := base
+		s.Filters = append([]SubscriptionFilter(nil), base.Filters...)
+		change(&s)
+		return s
+	}
+
+	tests := []struct {
+		name string
+		to   Subscription
+		want []string
+	}{
+		{"same", with(func(s *Subscription) {}), nil},
+		{"other ID", with(func(s *Subscription) { s.ID = uuid.Nil }), nil},
+		{"reordered filters", with(func(s *Subscription) { s.Filters = []SubscriptionFilter{match, series} }), nil},
+		{"duplicated filters", with(func(s *Subscription) { s.Filters = []SubscriptionFilter{series, match, series} }), nil},
+		{"name", with(func(s *Subscription) { s.Name = "prod" }), []string{"change name: dev -> prod"}},
+		{"description removed", with(func(s *Subscription) { s.Description = "" }), []string{"change description: local -> "}},
+		{"filter added", with(func(s *Subscription) { s.Filters = append(s.Filters, game1) }), []string{"add " + describeFilter(game1)}},
+		{"filter removed", with(func(s *Subscription) { s.Filters = s.Filters[:1] }), []string{"remove " + describeFilter(match)}},
+		{"all filters removed", with(func(s *Subscription) { s.Filters = nil }), []string{"remove " + describeFilter(match), "remove " + describeFilter(series)}},
+		{
+			"filter replaced by a narrower one",
+			with(func(s *Subscription) { s.Filters = []SubscriptionFilter{game2, match, game1} }),
+			[]string{"remove " + describeFilter(series), "add " + describeFilter(game1), "add " + describeFilter(game2)},
+		},
+		{
+			"id fields count",
+			with(func(s *Subscription) { s.Filters = []SubscriptionFilter{team, match} }),
+			[]string{"remove " + describeFilter(series), "add " + describeFilter(team)},
+		},
+		{
+			"everything",
+			with(func(s *Subscription) {
+				s.Name = "prod"
+				s.Description = "live"
+				s.Filters = []SubscriptionFilter{series}
+			}),
+			[]string{"change name: dev -> prod", "change description: local -> live", "remove " + describeFilter(match)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := diffSubscriptions(base, test.to)
+			if got := describeChanges(changes); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			// The library compares the same way
+			if same := pushclient.SameSubscription(base, test.to); same != (len(changes) == 0) {
+				t.Errorf("SameSubscription is %t with %d changes", same, len(changes))
+			}
+			if same := pushclient.SameSubscription(test.to, base); same != (len(changes) == 0) {
+				t.Errorf("SameSubscription with the arguments swapped is %t with %d changes", same, len(changes))
+			}
+		})
+	}
+}
+
+func TestSummarizeChanges(t *testing.T) {
+	f := &SubscriptionFilter{Channel: "series"}
+	tests := []struct {
+		changes []subscriptionChange
+		want    string
+	}{
+		{nil, ""},
+		{[]subscriptionChange{{Op: "add", Filter: f}}, "1 filter added"},
+		{[]subscriptionChange{{Op: "add", Filter: f}, {Op: "add", Filter: f}}, "2 filters added"},
+		{[]subscriptionChange{{Op: "remove", Filter: f}}, "1 filter removed"},
+		{[]subscriptionChange{{Op: "remove", Filter: f}, {Op: "add", Filter: f}, {Op: "add", Filter: f}}, "2 filters added, 1 removed"},
+		{[]subscriptionChange{{Op: "change", Field: "name"}, {Op: "remove", Filter: f}, {Op: "change", Field: "description"}}, "1 filter removed, name changed, description changed"},
+	}
+
+	for _, test := range tests {
+		if got := summarizeChanges(test.changes); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestWriteSubscriptionDiff(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	from := Subscription{Name: "dev", Filters: []SubscriptionFilter{{Channel: "series"}, {Channel: "match"}}}
+	to := Subscription{Name: "prod", Filters: []SubscriptionFilter{{Channel: "match"}, {Channel: "series", GameID: 1}}}
+
+	var buf bytes.Buffer
+	writeSubscriptionDiff(&buf, "server", "file", from, diffSubscriptions(from, to))
+	want := `--- server
++++ file
+-name: dev
++name: prod
+ filters:
+   {"channel":"match"}
+-  {"channel":"series"}
++  {"channel":"series","game_id":1}
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Used by the edit command when $EDITOR isn't set
+const defaultEditor = "vi"
+
+// Lines starting with this are written above the subscription when it has
+// to be fixed, and removed before it is parsed
+const editBannerPrefix = "//"
+
+// Opens a subscription registered on the server in $EDITOR and replaces it
+// with the edited version. Nothing is sent if the subscription wasn't
+// changed.
+func editCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("The edit command needs a subscription id or name")
+	}
+
+	err := validateCredentialFlags()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	server, err := apiClient.FetchSubscription(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("Failed to fetch subscription '%s'. Error: %v", args[0], err)
+	}
+
+	err = checkSubscriptionOwner(server, *ownerTagFlag, *forceForeignFlag, "edit")
+	if err != nil {
+		return err
+	}
+
+	original, err := json.MarshalIndent(server, "", "  ")
+	if err != nil {
+		return err
+	}
+	original = append(original, '\n')
+
+	tmp, err := ioutil.TempFile("", "subscription-*.json")
+	if err != nil {
+		return err
+	}
+	fileName := tmp.Name()
+	tmp.Close()
+	defer os.Remove(fileName)
+
+	edited, err := editSubscriptionFile(fileName, original)
+	if err != nil {
+		return err
+	}
+	if edited == nil {
+		log.Printf("[INFO] Subscription '%s' was not changed\n", args[0])
+		return nil
+	}
+
+	// The ID is read-only, the subscription that was opened is updated
+	edited.ID = server.ID
+
+	changes := diffSubscriptions(server, *edited)
+	if len(changes) == 0 {
+		log.Printf("[INFO] Subscription '%s' was not changed\n", args[0])
+		return nil
+	}
+	writeSubscriptionDiff(os.Stdout, args[0]+" (server)", args[0]+" (edited)", server, changes)
+
+	_, _, err = apiClient.UpdateSubscription(ctx, *edited)
+	if err != nil {
+		return fmt.Errorf("Failed to update subscription '%s'. Error: %v", args[0], err)
+	}
+	log.Printf("[INFO] Updated subscription %s\n", server.ID)
+
+	return nil
+}
+
+// Writes content to the file and opens it in the editor until it holds a
+// valid subscription. Returns nil when the file is saved without changes,
+// also after an error has been shown.
+func editSubscriptionFile(fileName string, content []byte) (*Subscription, error) {
+	for {
+		err := ioutil.WriteFile(fileName, content, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		err = runEditor(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(b, content) {
+			return nil, nil
+		}
+
+		b = withoutEditBanner(b)
+		sub, err := parseSubscriptionSpec(b)
+		if err == nil {
+			return &sub, nil
+		}
+
+		// Open the editor again with the error above what was written
+		content = append([]byte(fmt.Sprintf("%s The subscription is invalid, fix it or save without changes to abort.\n%s Error: %s\n",
+			editBannerPrefix, editBannerPrefix, foldLines(err.Error()))), b...)
+	}
+}
+
+func withoutEditBanner(b []byte) []byte {
+	lines := strings.SplitAfter(string(b), "\n")
+	for len(lines) > 0 && strings.HasPrefix(lines[0], editBannerPrefix) {
+		lines = lines[1:]
+	}
+
+	return []byte(strings.Join(lines, ""))
+}
+
+// Runs $EDITOR, which may include arguments, e.g. 'code --wait'
+func runEditor(fileName string) error {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{defaultEditor}
+	}
+
+	cmd := exec.Command(editor[0], append(editor[1:], fileName)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Editor '%s' failed. Error: %v", strings.Join(editor, " "), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+)
+
+// Set up the library's EnsureSubscription for the spec options: an
+// existing subscription with another owner is left alone unless
+// forceForeign is set, and its description is kept unless clearDescription
+// is set. The owner tag must already be in the description of the spec.
+func ensureOptions(clearDescription bool, ownerTag string, forceForeign bool) pushclient.EnsureOptions {
+	return pushclient.EnsureOptions{
+		CheckExisting: func(existing Subscription) error {
+			return checkSubscriptionOwner(existing, ownerTag, forceForeign, "update")
+		},
+		Merge: func(existing Subscription, sub Subscription) Subscription {
+			return keepExistingDescription(existing, sub, clearDescription, ownerTag)
+		},
+	}
+}
+
+// A spec without a description would otherwise wipe the description that
+// is set on the server
+func keepExistingDescription(existing Subscription, sub Subscription, clearDescription bool, ownerTag string) Subscription {
+	if _, text := parseOwnerTag(sub.Description); text == "" && !clearDescription {
+		sub.Description = existing.Description
+		if ownerTag != "" {
+			sub.Description = withOwnerTag(existing.Description, ownerTag)
+		}
+	}
+
+	return sub
+}
+
+// Works out what EnsureSubscription would do with only read requests. The
+// existing subscription is looked up by the name of the spec, a spec
+// without a name is always created. Returns the subscription that would be
+// sent, or the existing one if it wouldn't be updated.
+func planEnsureSubscription(ctx context.Context, sub Subscription, clearDescription bool, ownerTag string, forceForeign bool) (Subscription, pushclient.EnsureResult, []subscriptionChange, error) {
+	if ownerTag != "" {
+		sub.Description = withOwnerTag(sub.Description, ownerTag)
+	}
+	if sub.Name == "" {
+		return sub, pushclient.EnsureCreated, nil, nil
+	}
+
+	existing, err := apiClient.FetchSubscription(ctx, sub.Name)
+	if err == pushclient.ErrSubscriptionNotFound {
+		return sub, pushclient.EnsureCreated, nil, nil
+	} else if err != nil {
+		return Subscription{}, 0, nil, fmt.Errorf("Failed to look up subscription '%s'. Error: %v", sub.Name, err)
+	}
+
+	err = checkSubscriptionOwner(existing, ownerTag, forceForeign, "update")
+	if err != nil {
+		return Subscription{}, 0, nil, err
+	}
+
+	sub.ID = existing.ID
+	sub = keepExistingDescription(existing, sub, clearDescription, ownerTag)
+	changes := diffSubscriptions(existing, sub)
+	if len(changes) == 0 {
+		return existing, pushclient.EnsureUnchanged, nil, nil
+	}
+
+	return sub, pushclient.EnsureUpdated, changes, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// A spec without a description keeps the one on the server, unless
+// '--clear-description' is given. The owner tag doesn't count as a
+// description of its own.
+func TestKeepExistingDescription(t *testing.T) {
+	tests := []struct {
+		name             string
+		existing         string
+		local            string
+		clearDescription bool
+		ownerTag         string
+		want             string
+	}{
+		{"kept", "Live odds", "", false, "", "Live odds"},
+		{"cleared", "Live odds", "", true, "", ""},
+		{"replaced", "Live odds", "Scores", false, "", "Scores"},
+		{"replaced and clear", "Live odds", "Scores", true, "", "Scores"},
+		{"none on the server", "", "", false, "", ""},
+		{"kept with owner tag", "Live odds", "[owner:odds]", false, "odds", "Live odds [owner:odds]"},
+		{"kept and tagged", "Live odds [owner:odds]", "[owner:odds]", false, "odds", "Live odds [owner:odds]"},
+		{"cleared with owner tag", "Live odds [owner:odds]", "[owner:odds]", true, "odds", "[owner:odds]"},
+		{"replaced with owner tag", "Live odds [owner:odds]", "Scores [owner:odds]", false, "odds", "Scores [owner:odds]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			existing := Subscription{Name: "dev", Description: test.existing}
+			local := Subscription{Name: "dev", Description: test.local}
+			got := keepExistingDescription(existing, local, test.clearDescription, test.ownerTag)
+			if got.Description != test.want {
+				t.Errorf("got description '%s', want '%s'", got.Description, test.want)
+			}
+		})
+	}
+}
+
+func TestCutDescription(t *testing.T) {
+	long := strings.Repeat("x", maxListDescription+10)
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"short", "Live odds", "Live odds"},
+		{"several lines", "Live\nodds", "Live odds"},
+		{"long", long, long[:maxListDescription-3] + "..."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := cutDescription(test.in); got != test.want {
+				t.Errorf("got '%s', want '%s'", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	flag "github.com/spf13/pflag"
+)
+
+var outDirFlag = flag.String("out-dir", "", "export: directory to write one spec file per subscription to, with '--all'")
+
+// Characters kept as they are in the file names of exported specs
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// A subscription without the read-only ID, as written to spec files
+type exportedSpec struct {
+	Description string               `json:"description,omitempty"`
+	Name        string               `json:"name,omitempty"`
+	Filters     []SubscriptionFilter `json:"filters"`
+}
+
+// Writes registered subscriptions as spec files that can be given to
+// '--subscription-file' again. 'export <id-or-name>' writes one to stdout or
+// '--out', 'export --all --out-dir=dir' writes all of them to a directory.
+func exportCommand(args []string) error {
+	if *pruneAllFlag {
+		if len(args) > 0 {
+			return fmt.Errorf("The export command takes no subscription with '--all'")
+		}
+		if *outDirFlag == "" {
+			return fmt.Errorf("The export command needs '--out-dir' with '--all'")
+		}
+	} else if len(args) != 1 {
+		return fmt.Errorf("The export command needs a subscription id or name, or '--all'")
+	}
+
+	err := validateCredentialFlags()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if !*pruneAllFlag {
+		sub, err := apiClient.FetchSubscription(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("Failed to fetch subscription '%s'. Error: %v", args[0], err)
+		}
+
+		b, err := marshalSpec(sub)
+		if err != nil {
+			return err
+		}
+		return writeCommandOutput(b)
+	}
+
+	b, err := apiClient.FetchSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("Subscriptions list request failed. Error: %v", err)
+	}
+
+	var subs []Subscription
+	err = json.Unmarshal(b, &subs)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal subscriptions. Error: %v", err)
+	}
+
+	err = os.MkdirAll(*outDirFlag, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		b, err := marshalSpec(sub)
+		if err != nil {
+			return err
+		}
+
+		fileName := filepath.Join(*outDirFlag, specFileName(sub))
+		err = ioutil.WriteFile(fileName, b, 0644)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Exported subscription %s to '%s'\n", sub.ID, fileName)
+	}
+	log.Printf("[INFO] Exported %d subscriptions\n", len(subs))
+
+	return nil
+}
+
+// Returns the subscription as an indented spec without the ID. The filters
+// are sorted and duplicates removed, so that exporting the same
+// subscription always gives the same file.
+func marshalSpec(sub Subscription) ([]byte, error) {
+	b, err := json.MarshalIndent(exportedSpec{
+		Description: sub.Description,
+		Name:        sub.Name,
+		Filters:     sortedFilters(filterSet(sub.Filters)),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+// The file name of an exported spec, from the name of the subscription.
+// A name with characters that aren't safe in file names gets them replaced
+// with '_' and a hash of the name appended, so that two names that only
+// differ in those characters don't end up in the same file. Subscriptions
+// without a name use the ID.
+func specFileName(sub Subscription) string {
+	if sub.Name == "" {
+		return sub.ID.String() + ".json"
+	}
+
+	safe := unsafeFileNameChars.ReplaceAllString(sub.Name, "_")
+	if safe != sub.Name {
+		sum := sha1.Sum([]byte(sub.Name))
+		safe += "-" + hex.EncodeToString(sum[:4])
+	}
+
+	return safe + ".json"
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+)
+
+// Exit codes of the get command
+const (
+	getExitFound    = 0
+	getExitFailed   = 1
+	getExitNotFound = 2
+)
+
+// Prints a subscription registered on the server as indented JSON, or as
+// compact JSON on one line with '--json'. The output can be used as a
+// '--subscription-file' as it is.
+func getCommand(args []string) error {
+	if len(args) != 1 {
+		return &exitCodeError{code: getExitFailed, err: fmt.Errorf("The get command needs a subscription id or name")}
+	}
+
+	err := validateCredentialFlags()
+	if err != nil {
+		return &exitCodeError{code: getExitFailed, err: err}
+	}
+
+	sub, err := apiClient.FetchSubscription(context.Background(), args[0])
+	if err == pushclient.ErrSubscriptionNotFound {
+		return &exitCodeError{code: getExitNotFound, err: fmt.Errorf("Subscription '%s' not found", args[0])}
+	} else if err != nil {
+		return &exitCodeError{code: getExitFailed, err: fmt.Errorf("Failed to fetch subscription '%s'. Error: %v", args[0], err)}
+	}
+
+	var b []byte
+	if *jsonFlag {
+		b, err = json.Marshal(sub)
+	} else {
+		b, err = json.MarshalIndent(sub, "", "  ")
+	}
+	if err != nil {
+		return &exitCodeError{code: getExitFailed, err: err}
+	}
+
+	err = writeCommandOutput(append(b, '\n'))
+	if err != nil {
+		return &exitCodeError{code: getExitFailed, err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Subscriptions sharing the same first name segment, e.g. all subscriptions
+// named 'team-...' when grouping by '-'
+type subscriptionGroup struct {
+	Prefix        string
+	Subscriptions []Subscription
+}
+
+// Name of the group for subscriptions without a name
+const unnamedGroup = "(unnamed)"
+
+func filterSubscriptionsByName(subs []Subscription, substr string) []Subscription {
+	if substr == "" {
+		return subs
+	}
+
+	var filtered []Subscription
+	for _, s := range subs {
+		if strings.Contains(s.Name, substr) {
+			filtered = append(filtered, s)
+		}
+	}
+
+	return filtered
+}
+
+// Groups the subscriptions by the part of the name before the first
+// separator. Names without the separator form a group of their own. Groups
+// are sorted by prefix and the subscriptions in a group by name.
+func groupSubscriptionsByPrefix(subs []Subscription, separator string) []subscriptionGroup {
+	byPrefix := make(map[string][]Subscription)
+	for _, s := range subs {
+		prefix := s.Name
+		if separator != "" {
+			prefix = strings.SplitN(s.Name, separator, 2)[0]
+		}
+		if prefix == "" {
+			prefix = unnamedGroup
+		}
+
+		byPrefix[prefix] = append(byPrefix[prefix], s)
+	}
+
+	groups := make([]subscriptionGroup, 0, len(byPrefix))
+	for prefix, subs := range byPrefix {
+		sort.SliceStable(subs, func(i, j int) bool {
+			if subs[i].Name != subs[j].Name {
+				return subs[i].Name < subs[j].Name
+			}
+			return subs[i].ID.String() < subs[j].ID.String()
+		})
+		groups = append(groups, subscriptionGroup{Prefix: prefix, Subscriptions: subs})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Prefix < groups[j].Prefix
+	})
+
+	return groups
+}
+
+// Prints the subscription list from the push service, grouped and filtered
+// according to '--group-by-prefix', '--filter-name' and '--owner'
+func printSubscriptions(tag string, subsJSON []byte) error {
+	if !flag.CommandLine.Changed("group-by-prefix") && *filterNameFlag == "" && *ownerFilterFlag == "" {
+		printJsonWithTag(tag, subsJSON)
+		return nil
+	}
+
+	var subs []Subscription
+	err := json.Unmarshal(subsJSON, &subs)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal subscriptions. Error: %v", err)
+	}
+	subs = filterSubscriptionsByName(subs, *filterNameFlag)
+	subs = filterSubscriptionsByOwner(subs, *ownerFilterFlag)
+
+	if !flag.CommandLine.Changed("group-by-prefix") {
+		return printSubscriptionList(tag, subs)
+	}
+
+	groups := groupSubscriptionsByPrefix(subs, *groupByPrefixFlag)
+	for _, g := range groups {
+		err = printSubscriptionList(fmt.Sprintf("%s: %s (%d)", tag, g.Prefix, len(g.Subscriptions)), g.Subscriptions)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func printSubscriptionList(tag string, subs []Subscription) error {
+	if subs == nil {
+		subs = []Subscription{}
+	}
+
+	b, err := json.Marshal(subs)
+	if err != nil {
+		return err
+	}
+	printJsonWithTag(tag, b)
+
+	return nil
+}
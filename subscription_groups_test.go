@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+// Subscriptions with the given names and IDs ending in their position
+func namedSubscriptions(names ...string) []Subscription {
+	subs := make([]Subscription, len(names))
+	for i, name := range names {
+		subs[i] = Subscription{
+			ID:   uuid.Must(uuid.FromString(fmt.Sprintf("00000000-0000-0000-0000-%012d", i))),
+			Name: name,
+		}
+	}
+
+	return subs
+}
+
+// The prefix of every group followed by the names in it
+func groupNames(groups []subscriptionGroup) [][]string {
+	var got [][]string
+	for _, g := range groups {
+		names := []string{g.Prefix}
+		for _, s := range g.Subscriptions {
+			names = append(names, s.Name)
+		}
+		got = append(got, names)
+	}
+
+	return got
+}
+
+func TestGroupSubscriptionsByPrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     []string
+		separator string
+		want      [][]string
+	}{
+		{
+			"by dash",
+			[]string{"team-b", "match-1", "team-a", "match-0"},
+			"-",
+			[][]string{{"match", "match-0", "match-1"}, {"team", "team-a", "team-b"}},
+		},
+		{
+			"no separator in the name",
+			[]string{"team", "team-a", "teams"},
+			"-",
+			[][]string{{"team", "team", "team-a"}, {"teams", "teams"}},
+		},
+		{
+			"starts with the separator",
+			[]string{"-a", "", "b"},
+			"-",
+			[][]string{{unnamedGroup, "", "-a"}, {"b", "b"}},
+		},
+		{
+			"only the first separator counts",
+			[]string{"a-b-c", "a-c", "a--"},
+			"-",
+			[][]string{{"a", "a--", "a-b-c", "a-c"}},
+		},
+		{
+			"longer separator",
+			[]string{"cs::go", "cs:go", "cs::2"},
+			"::",
+			[][]string{{"cs", "cs::2", "cs::go"}, {"cs:go", "cs:go"}},
+		},
+		{
+			"unicode",
+			[]string{"é-1", "e-1", "ö-1", "z-1", "日本-1"},
+			"-",
+			[][]string{{"e", "e-1"}, {"z", "z-1"}, {"é", "é-1"}, {"ö", "ö-1"}, {"日本", "日本-1"}},
+		},
+		{
+			"unicode separator",
+			[]string{"a→b", "a→c", "b"},
+			"→",
+			[][]string{{"a", "a→b", "a→c"}, {"b", "b"}},
+		},
+		{
+			"empty separator groups by the whole name",
+			[]string{"b-1", "a-1", "a-1"},
+			"",
+			[][]string{{"a-1", "a-1", "a-1"}, {"b-1", "b-1"}},
+		},
+		{
+			"upper case sorts first",
+			[]string{"team-a", "Team-a"},
+			"-",
+			[][]string{{"Team", "Team-a"}, {"team", "team-a"}},
+		},
+		{
+			"no subscriptions",
+			nil,
+			"-",
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := groupNames(groupSubscriptionsByPrefix(namedSubscriptions(test.names...), test.separator))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// Subscriptions with the same name are ordered by ID, whatever order the
+// server sent them in
+func TestGroupSubscriptionsByPrefixSameName(t *testing.T) {
+	subs := namedSubscriptions("a-1", "a-1", "a-1")
+	reversed := []Subscription{subs[2], subs[0], subs[1]}
+
+	groups := groupSubscriptionsByPrefix(reversed, "-")
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(groups))
+	}
+	if !reflect.DeepEqual(groups[0].Subscriptions, subs) {
+		t.Errorf("got %v, want %v", groups[0].Subscriptions, subs)
+	}
+}
+
+func TestFilterSubscriptionsByName(t *testing.T) {
+	subs := namedSubscriptions("team-a", "match-team", "Team-b", "", "日本-team")
+	tests := []struct {
+		substr string
+		want   []string
+	}{
+		{"", []string{"team-a", "match-team", "Team-b", "", "日本-team"}},
+		{"team", []string{"team-a", "match-team", "日本-team"}},
+		{"Team", []string{"Team-b"}},
+		{"日本", []string{"日本-team"}},
+		{"-", []string{"team-a", "match-team", "Team-b", "日本-team"}},
+		{"missing", nil},
+	}
+
+	for _, test := range tests {
+		var got []string
+		for _, s := range filterSubscriptionsByName(subs, test.substr) {
+			got = append(got, s.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("filter %q: got %q, want %q", test.substr, got, test.want)
+		}
+	}
+}
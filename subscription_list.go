@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/tabwriter"
+)
+
+// Longer descriptions are cut in the table
+const maxListDescription = 60
+
+// Prints the subscriptions registered on the server as a table, or as a
+// JSON array with '--json' or '--output=json'. '--filter-name' and
+// '--owner' narrow the list down like at startup.
+func listCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("The list command takes no arguments")
+	}
+	if *outputFlag != "text" && *outputFlag != "json" {
+		return fmt.Errorf("Unknown output format '%s', must be 'text' or 'json'", *outputFlag)
+	}
+
+	err := validateCredentialFlags()
+	if err != nil {
+		return err
+	}
+
+	b, err := apiClient.FetchSubscriptions(context.Background())
+	if err != nil {
+		return fmt.Errorf("Subscriptions list request failed. Error: %v", err)
+	}
+
+	var subs []Subscription
+	err = json.Unmarshal(b, &subs)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal subscriptions. Error: %v", err)
+	}
+	registered := len(subs)
+	subs = filterSubscriptionsByName(subs, *filterNameFlag)
+	subs = filterSubscriptionsByOwner(subs, *ownerFilterFlag)
+
+	if *jsonFlag || *outputFlag == "json" {
+		if subs == nil {
+			subs = []Subscription{}
+		}
+		b, err := json.MarshalIndent(subs, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeCommandOutput(append(b, '\n'))
+	}
+
+	if registered == 0 {
+		log.Println("[INFO] No subscriptions registered")
+		return nil
+	} else if len(subs) == 0 {
+		log.Printf("[INFO] None of the %d registered subscriptions match '--filter-name' and '--owner'\n", registered)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tDESCRIPTION\tFILTERS")
+	for _, s := range subs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", s.ID, s.Name, cutDescription(s.Description), len(s.Filters))
+	}
+	w.Flush()
+
+	return writeCommandOutput(buf.Bytes())
+}
+
+// Keeps the description on one line and cuts it if it is long
+func cutDescription(s string) string {
+	s = strings.NewReplacer("\r", " ", "\n", " ", "\t", " ").Replace(s)
+	r := []rune(s)
+	if len(r) <= maxListDescription {
+		return s
+	}
+
+	return string(r[:maxListDescription-3]) + "..."
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	flag "github.com/spf13/pflag"
+)
+
+var subscriptionDescriptionFlag = flag.String("subscription-description", "", "Description of the subscription registered from '--subscription-file', instead of the description in the file")
+
+// Reads the spec files given with '--subscription-file' into one
+// subscription. With several files their filters are merged, the name and
+// description are taken from the first file that has them, and files with
+// different names are rejected unless '--subscription-name' is given.
+func readSubscriptionSpecs(fileNames []string) (Subscription, error) {
+	var merged Subscription
+	nameFrom := ""
+	seen := make(map[SubscriptionFilter]bool)
+	for _, fileName := range fileNames {
+		sub, err := readSubscriptionSpec(fileName)
+		if err != nil {
+			if len(fileNames) > 1 {
+				return Subscription{}, fmt.Errorf("'%s': %v", fileName, err)
+			}
+			return Subscription{}, err
+		}
+
+		if sub.Name != "" && merged.Name == "" {
+			merged.Name = sub.Name
+			nameFrom = fileName
+		} else if sub.Name != "" && sub.Name != merged.Name && *subscriptionNameFlag == "" {
+			return Subscription{}, fmt.Errorf("'%s' names the subscription '%s' but '%s' names it '%s', use '--subscription-name' to choose the name", nameFrom, merged.Name, fileName, sub.Name)
+		}
+		if merged.Description == "" {
+			merged.Description = sub.Description
+		}
+
+		// The same filter in several files is only registered once
+		for _, f := range sub.Filters {
+			if !seen[f] {
+				seen[f] = true
+				merged.Filters = append(merged.Filters, f)
+			}
+		}
+	}
+
+	if *subscriptionNameFlag != "" {
+		merged.Name = *subscriptionNameFlag
+	}
+	if *subscriptionDescriptionFlag != "" {
+		merged.Description = *subscriptionDescriptionFlag
+	}
+
+	if len(fileNames) > 1 {
+		b, _ := json.Marshal(merged.Filters)
+		log.Printf("[INFO] Merged %d subscription files into the subscription '%s' with %d filters: %s\n", len(fileNames), merged.Name, len(merged.Filters), b)
+	}
+
+	return merged, validateSubscription(merged)
+}
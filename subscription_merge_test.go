@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadSubscriptionSpecs(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       []string
+		nameFlag    string
+		want        Subscription
+		wantErrPart string // Part of the error, no error if empty
+	}{
+		{
+			name: "duplicate filters collapse",
+			files: []string{
+				`{"name": "games", "filters": [{"channel": "series", "game_id": 1}, {"channel": "match"}]}`,
+				`{"filters": [{"game_id": 1, "channel": "series"}, {"channel": "series", "game_id": 2}]}`,
+				`{"name": "games", "filters": [{"channel": "match"}]}`,
+			},
+			want: Subscription{Name: "games", Filters: []SubscriptionFilter{
+				{Channel: "series", GameID: 1}, {Channel: "match"}, {Channel: "series", GameID: 2},
+			}},
+		},
+		{
+			name: "name and description from the first file that has them",
+			files: []string{
+				`{"filters": [{"channel": "series"}]}`,
+				`{"name": "lol", "description": "League", "filters": [{"channel": "match"}]}`,
+				`{"name": "lol", "description": "Other", "filters": [{"channel": "match"}]}`,
+			},
+			want: Subscription{Name: "lol", Description: "League", Filters: []SubscriptionFilter{{Channel: "series"}, {Channel: "match"}}},
+		},
+		{
+			name: "conflicting names",
+			files: []string{
+				`{"name": "lol", "filters": [{"channel": "series", "game_id": 1}]}`,
+				`{"name": "dota", "filters": [{"channel": "series", "game_id": 2}]}`,
+			},
+			wantErrPart: "names the subscription 'lol' but",
+		},
+		{
+			name: "conflicting names with --subscription-name",
+			files: []string{
+				`{"name": "lol", "filters": [{"channel": "series", "game_id": 1}]}`,
+				`{"name": "dota", "filters": [{"channel": "series", "game_id": 2}]}`,
+			},
+			nameFlag: "games",
+			want:     Subscription{Name: "games", Filters: []SubscriptionFilter{{Channel: "series", GameID: 1}, {Channel: "series", GameID: 2}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLog(t)
+			defer func(name string) { *subscriptionNameFlag = name }(*subscriptionNameFlag)
+			*subscriptionNameFlag = test.nameFlag
+
+			dir := t.TempDir()
+			var fileNames []string
+			for i, spec := range test.files {
+				fileName := filepath.Join(dir, fmt.Sprintf("spec%d.json", i))
+				if err := ioutil.WriteFile(fileName, []byte(spec), 0644); err != nil {
+					t.Fatal(err)
+				}
+				fileNames = append(fileNames, fileName)
+			}
+
+			got, err := readSubscriptionSpecs(fileNames)
+			if test.wantErrPart != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErrPart) {
+					t.Fatalf("got error %v, want one containing '%s'", err, test.wantErrPart)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("merged into %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var subscriptionNameFlag = flag.String("subscription-name", "", "The name of a subscription that has been registered previously, or with '--subscription-file' the name to register the subscription with instead of the name in the file")
+
+// At most this many similar names are suggested when a name isn't found
+const maxNameSuggestions = 5
+
+// Returns the ID of the subscription with exactly the given name. Names
+// are unique on the server, so there is at most one.
+func resolveSubscriptionName(ctx context.Context, name string) (string, error) {
+	b, err := apiClient.FetchSubscriptions(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Subscriptions list request failed. Error: %v", err)
+	}
+
+	var subs []Subscription
+	err = json.Unmarshal(b, &subs)
+	if err != nil {
+		return "", fmt.Errorf("Failed to unmarshal subscriptions. Error: %v", err)
+	}
+
+	for _, s := range subs {
+		if s.Name == name {
+			return s.ID.String(), nil
+		}
+	}
+
+	similar := similarNames(name, subs)
+	if len(similar) == 0 {
+		return "", fmt.Errorf("No subscription named '%s' among the %d registered subscriptions", name, len(subs))
+	}
+
+	return "", fmt.Errorf("No subscription named '%s', did you mean '%s'?", name, strings.Join(similar, "', '"))
+}
+
+// Returns the names that differ from name only in case, contain it or are
+// a few edits away, the closest first
+func similarNames(name string, subs []Subscription) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+
+	lower := strings.ToLower(name)
+	maxDistance := len(lower)/3 + 1
+	var candidates []candidate
+	for _, s := range subs {
+		if s.Name == "" {
+			continue
+		}
+
+		other := strings.ToLower(s.Name)
+		d := editDistance(lower, other)
+		if d <= maxDistance || strings.Contains(other, lower) || strings.Contains(lower, other) {
+			candidates = append(candidates, candidate{name: s.Name, distance: d})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var names []string
+	for i := 0; i < len(candidates) && i < maxNameSuggestions; i++ {
+		names = append(names, candidates[i].name)
+	}
+
+	return names
+}
+
+// The Levenshtein distance between a and b, counted in runes
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Where the subscription the client connects to comes from
+type planSource int
+
+const (
+	planExisting planSource = iota // '--subscription-id' by ID or name, or '--subscription-name'
+	planFromFile                   // '--subscription-file' or '--filter', registered or updated
+	planResume                     // Only '--reconnect-token', the server tells which subscription
+)
+
+// What the client will do with the subscription, decided from the flags
+// before anything is sent to the server
+type subscriptionPlan struct {
+	source          planSource
+	subscription    string // The ID or name
+	byName          bool   // The subscription is looked up by name in the list of subscriptions
+	spec            specSource
+	resume          bool // A reconnect token is given
+	deleteIfCreated bool // A subscription created from the spec file is deleted on exit
+	ttl             time.Duration
+}
+
+// Decides what to do with the subscription from the subscription flags, and
+// rejects the combinations where that would be ambiguous
+func planSubscription(spec specSource, idOrName string, name string, reconnectToken string, keep bool, ttl time.Duration) (subscriptionPlan, error) {
+	if name != "" && idOrName != "" {
+		return subscriptionPlan{}, fmt.Errorf("The options '--subscription-name' and '--subscription-id' can't be combined")
+	}
+	if len(spec.files) > 0 && len(spec.filters) > 0 {
+		return subscriptionPlan{}, fmt.Errorf("The options '--subscription-file' and '--filter' can't be combined, put the filters in the file")
+	}
+	if len(spec.filters) > 0 && idOrName != "" {
+		return subscriptionPlan{}, fmt.Errorf("The options '--filter' and '--subscription-id' can't be combined, the filters are registered as a new subscription")
+	}
+	if len(spec.files) > 0 && idOrName != "" {
+		return subscriptionPlan{}, fmt.Errorf("The options '--subscription-file' and '--subscription-id' can't be combined, use the file to register or update a subscription and the ID to connect to an existing one")
+	}
+	if len(spec.filters) > 0 && reconnectToken != "" {
+		return subscriptionPlan{}, fmt.Errorf("The options '--filter' and '--reconnect-token' can't be combined, a reconnect token resumes an existing subscriber, give its subscription with '--subscription-id'")
+	}
+	if len(spec.files) > 0 && reconnectToken != "" {
+		return subscriptionPlan{}, fmt.Errorf("The options '--subscription-file' and '--reconnect-token' can't be combined, a reconnect token resumes an existing subscriber, give its subscription with '--subscription-id'")
+	}
+	if keep && !spec.given() {
+		return subscriptionPlan{}, fmt.Errorf("The option '--keep-subscription' needs '--subscription-file' or '--filter', other subscriptions are never deleted")
+	}
+	if ttl > 0 && keep {
+		return subscriptionPlan{}, fmt.Errorf("The options '--subscription-ttl' and '--keep-subscription' can't be combined")
+	}
+	if ttl > 0 && !spec.given() {
+		return subscriptionPlan{}, fmt.Errorf("The option '--subscription-ttl' needs a subscription created from '--subscription-file' or '--filter'")
+	}
+
+	switch {
+	case spec.given():
+		return subscriptionPlan{source: planFromFile, spec: spec, deleteIfCreated: !keep, ttl: ttl}, nil
+	case idOrName != "":
+		return subscriptionPlan{source: planExisting, subscription: idOrName, resume: reconnectToken != ""}, nil
+	case name != "":
+		return subscriptionPlan{source: planExisting, subscription: name, byName: true, resume: reconnectToken != ""}, nil
+	case reconnectToken != "":
+		return subscriptionPlan{source: planResume, resume: true}, nil
+	}
+
+	return subscriptionPlan{}, fmt.Errorf("You need to provide one of the options '--subscription-file', '--filter', '--subscription-id', '--subscription-name' or '--reconnect-token'")
+}
+
+// A single statement of what will happen to the subscription, logged at
+// startup
+func (p subscriptionPlan) String() string {
+	var s string
+	switch p.source {
+	case planFromFile:
+		s = fmt.Sprintf("Registering the subscription from %s, or updating the existing one with the same name.", p.spec)
+		if p.deleteIfCreated && p.ttl > 0 {
+			s += fmt.Sprintf(" A subscription created now is deleted on exit or when '--subscription-ttl' %s elapses, an existing one is kept.", p.ttl)
+		} else if p.deleteIfCreated {
+			s += " A subscription created now is deleted on exit, an existing one is kept."
+		} else {
+			s += " The subscription is kept on exit."
+		}
+	case planExisting:
+		s = fmt.Sprintf("Connecting to the existing subscription '%s'", p.subscription)
+		if p.byName {
+			s = fmt.Sprintf("Connecting to the existing subscription named '%s'", p.subscription)
+		}
+		if p.resume {
+			s += ", resuming the subscriber of the reconnect token"
+		}
+		s += ". It is not changed and kept on exit."
+	case planResume:
+		s = "Resuming the subscriber of the reconnect token. Its subscription is not changed and kept on exit."
+	}
+
+	return s
+}
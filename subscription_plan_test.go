@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanSubscription(t *testing.T) {
+	file := specSource{files: []string{"sub.json"}}
+	filters := specSource{filters: []string{"channel=series"}}
+	both := specSource{files: []string{"sub.json"}, filters: []string{"channel=series"}}
+	const token = "6a3da4b5-c0c8-4d0a-9f4a-0a0a0a0a0c01"
+
+	tests := []struct {
+		name           string
+		spec           specSource
+		idOrName       string
+		subName        string
+		reconnectToken string
+		keep           bool
+		ttl            time.Duration
+		want           subscriptionPlan
+		wantErr        string // Part of the error, empty if accepted
+	}{
+		{name: "file", spec: file, want: subscriptionPlan{source: planFromFile, spec: file, deleteIfCreated: true}},
+		{name: "filters", spec: filters, want: subscriptionPlan{source: planFromFile, spec: filters, deleteIfCreated: true}},
+		{name: "file, kept", spec: file, keep: true, want: subscriptionPlan{source: planFromFile, spec: file}},
+		{name: "file with ttl", spec: file, ttl: time.Hour, want: subscriptionPlan{source: planFromFile, spec: file, deleteIfCreated: true, ttl: time.Hour}},
+		{name: "id", idOrName: "sub", want: subscriptionPlan{source: planExisting, subscription: "sub"}},
+		{name: "id and token", idOrName: "sub", reconnectToken: token, want: subscriptionPlan{source: planExisting, subscription: "sub", resume: true}},
+		{name: "name", subName: "sub", want: subscriptionPlan{source: planExisting, subscription: "sub", byName: true}},
+		{name: "name and token", subName: "sub", reconnectToken: token, want: subscriptionPlan{source: planExisting, subscription: "sub", byName: true, resume: true}},
+		{name: "only token", reconnectToken: token, want: subscriptionPlan{source: planResume, resume: true}},
+
+		{name: "nothing", wantErr: "You need to provide one of the options"},
+		{name: "name and id", idOrName: "a", subName: "b", wantErr: "'--subscription-name' and '--subscription-id'"},
+		{name: "file and filters", spec: both, wantErr: "'--subscription-file' and '--filter'"},
+		{name: "filters and id", spec: filters, idOrName: "sub", wantErr: "'--filter' and '--subscription-id'"},
+		{name: "file and id", spec: file, idOrName: "sub", wantErr: "'--subscription-file' and '--subscription-id'"},
+		{name: "filters and token", spec: filters, reconnectToken: token, wantErr: "'--filter' and '--reconnect-token'"},
+		{name: "file and token", spec: file, reconnectToken: token, wantErr: "'--subscription-file' and '--reconnect-token'"},
+		{name: "keep without file", idOrName: "sub", keep: true, wantErr: "'--keep-subscription' needs"},
+		{name: "keep with only token", reconnectToken: token, keep: true, wantErr: "'--keep-subscription' needs"},
+		{name: "ttl and keep", spec: file, keep: true, ttl: time.Hour, wantErr: "'--subscription-ttl' and '--keep-subscription'"},
+		{name: "ttl without file", idOrName: "sub", ttl: time.Hour, wantErr: "'--subscription-ttl' needs"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := planSubscription(test.spec, test.idOrName, test.subName, test.reconnectToken, test.keep, test.ttl)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// Every plan says what happens to the subscription on exit
+func TestSubscriptionPlanString(t *testing.T) {
+	tests := []struct {
+		plan subscriptionPlan
+		want string
+	}{
+		{subscriptionPlan{source: planFromFile, spec: specSource{filters: []string{"channel=series"}}, deleteIfCreated: true}, "A subscription created now is deleted on exit, an existing one is kept."},
+		{subscriptionPlan{source: planFromFile, spec: specSource{files: []string{"sub.json"}}, deleteIfCreated: true, ttl: time.Hour}, "deleted on exit or when '--subscription-ttl' 1h0m0s elapses"},
+		{subscriptionPlan{source: planFromFile, spec: specSource{files: []string{"sub.json"}}}, "The subscription is kept on exit."},
+		{subscriptionPlan{source: planExisting, subscription: "sub", resume: true}, "Connecting to the existing subscription 'sub', resuming the subscriber of the reconnect token. It is not changed and kept on exit."},
+		{subscriptionPlan{source: planExisting, subscription: "sub", byName: true}, "Connecting to the existing subscription named 'sub'. It is not changed and kept on exit."},
+		{subscriptionPlan{source: planResume, resume: true}, "Its subscription is not changed and kept on exit."},
+	}
+
+	for _, test := range tests {
+		if got := test.plan.String(); !strings.Contains(got, test.want) {
+			t.Errorf("got %q, want it to contain %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+)
+
+// Exit codes of the register command
+const (
+	registerExitDone    = 0
+	registerExitFailed  = 1
+	registerExitUnknown = 2 // The name is taken, but the server didn't say by which subscription
+)
+
+// What the register command writes on stdout
+type registerOutput struct {
+	ID     uuid.UUID `json:"id"`
+	Name   string    `json:"name,omitempty"`
+	Result string    `json:"result"` // 'created', 'updated' or 'unchanged'
+}
+
+// Registers the subscription in '--subscription-file', or updates the one
+// with the same name, and prints its ID as JSON. The subscription is never
+// deleted afterwards, it is meant to be used by subscribers started later.
+func registerCommand(args []string) error {
+	if len(args) > 0 {
+		return &exitCodeError{code: registerExitFailed, err: fmt.Errorf("The register command takes no arguments, give the spec with '--subscription-file'")}
+	}
+	if !specFromFlags().given() {
+		return &exitCodeError{code: registerExitFailed, err: fmt.Errorf("The register command needs the option '--subscription-file' or '--filter'")}
+	}
+
+	err := validateCredentialFlags()
+	if err != nil {
+		return &exitCodeError{code: registerExitFailed, err: err}
+	}
+
+	// Only the result is written on stdout, what was done is logged
+	registered, result, err := registerSubscriptionSpec(context.Background(), specFromFlags())
+	if errors.Is(err, pushclient.ErrExistingSubscriptionUnknown) {
+		return &exitCodeError{code: registerExitUnknown, err: fmt.Errorf("A subscription with the same name already exists, but the server didn't return its ID, so it was not updated")}
+	} else if err != nil {
+		return &exitCodeError{code: registerExitFailed, err: err}
+	}
+
+	b, err := json.Marshal(registerOutput{
+		ID:     registered.ID,
+		Name:   registered.Name,
+		Result: result.String(),
+	})
+	if err != nil {
+		return &exitCodeError{code: registerExitFailed, err: err}
+	}
+
+	err = writeCommandOutput(append(b, '\n'))
+	if err != nil {
+		return &exitCodeError{code: registerExitFailed, err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	prettyjson "github.com/hokaccha/go-prettyjson"
+	flag "github.com/spf13/pflag"
+)
+
+var themeFlag = flag.String("theme", "default", "Colors and indent of the pretty-printed JSON, a theme from '--list-themes' optionally followed by changes, e.g. 'default,key=magenta,indent=4'")
+var listThemesFlag = flag.Bool("list-themes", false, "Print a sample message in each built-in theme and exit")
+
+// The colors of the parts of the pretty-printed JSON and its indent
+type prettyTheme struct {
+	Key    []color.Attribute
+	String []color.Attribute
+	Number []color.Attribute
+	Bool   []color.Attribute
+	Null   []color.Attribute
+	Indent int
+}
+
+var builtinThemes = map[string]prettyTheme{
+	// The colors of go-prettyjson
+	"default": {
+		Key:    []color.Attribute{color.FgBlue, color.Bold},
+		String: []color.Attribute{color.FgGreen, color.Bold},
+		Number: []color.Attribute{color.FgCyan, color.Bold},
+		Bool:   []color.Attribute{color.FgYellow, color.Bold},
+		Null:   []color.Attribute{color.FgBlack, color.Bold},
+		Indent: 2,
+	},
+	// Readable on dark backgrounds, where bold black and blue get lost
+	"dark": {
+		Key:    []color.Attribute{color.FgHiCyan},
+		String: []color.Attribute{color.FgHiGreen},
+		Number: []color.Attribute{color.FgHiYellow},
+		Bool:   []color.Attribute{color.FgHiMagenta},
+		Null:   []color.Attribute{color.FgHiBlack},
+		Indent: 2,
+	},
+	// Readable on light backgrounds, without the bright colors
+	"light": {
+		Key:    []color.Attribute{color.FgBlue},
+		String: []color.Attribute{color.FgRed},
+		Number: []color.Attribute{color.FgMagenta},
+		Bool:   []color.Attribute{color.FgGreen},
+		Null:   []color.Attribute{color.FgBlack},
+		Indent: 2,
+	},
+	// Only the keys stand out
+	"mono": {
+		Key:    []color.Attribute{color.Bold},
+		String: []color.Attribute{color.Reset},
+		Number: []color.Attribute{color.Reset},
+		Bool:   []color.Attribute{color.Reset},
+		Null:   []color.Attribute{color.Faint},
+		Indent: 2,
+	},
+}
+
+// The color names accepted in '--theme', all bold like the default theme,
+// or bright with a 'bright-' prefix
+var themeColors = map[string]color.Attribute{
+	"black":   color.FgBlack,
+	"red":     color.FgRed,
+	"green":   color.FgGreen,
+	"yellow":  color.FgYellow,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+	"white":   color.FgWhite,
+}
+
+// The colors the tag of a message can get, picked by its channel
+var channelTagColors = []color.Attribute{
+	color.FgRed,
+	color.FgGreen,
+	color.FgYellow,
+	color.FgBlue,
+	color.FgMagenta,
+	color.FgCyan,
+	color.FgHiRed,
+	color.FgHiGreen,
+	color.FgHiYellow,
+	color.FgHiBlue,
+	color.FgHiMagenta,
+	color.FgHiCyan,
+}
+
+// Set at startup from '--theme', used for the colored output
+var prettyFormatter = prettyjson.NewFormatter()
+
+// Parses '--theme', a theme name optionally followed by comma separated
+// changes to it
+func parseTheme(s string) (prettyTheme, error) {
+	parts := strings.Split(s, ",")
+	theme, ok := builtinThemes[parts[0]]
+	if !ok {
+		return theme, fmt.Errorf("Unknown theme '%s', must be one of %s", parts[0], strings.Join(themeNames(), ", "))
+	}
+
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return theme, fmt.Errorf("Invalid theme change '%s', must be on the form 'part=color' or 'indent=N'", part)
+		}
+
+		if kv[0] == "indent" {
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n < 0 || n > 8 {
+				return theme, fmt.Errorf("Invalid theme indent '%s', must be between 0 and 8", kv[1])
+			}
+			theme.Indent = n
+			continue
+		}
+
+		attrs, err := parseThemeColor(kv[1])
+		if err != nil {
+			return theme, err
+		}
+		switch kv[0] {
+		case "key":
+			theme.Key = attrs
+		case "string":
+			theme.String = attrs
+		case "number":
+			theme.Number = attrs
+		case "bool":
+			theme.Bool = attrs
+		case "null":
+			theme.Null = attrs
+		default:
+			return theme, fmt.Errorf("Unknown theme part '%s', must be key, string, number, bool, null or indent", kv[0])
+		}
+	}
+
+	return theme, nil
+}
+
+func parseThemeColor(name string) ([]color.Attribute, error) {
+	bright := strings.HasPrefix(name, "bright-")
+	c, ok := themeColors[strings.TrimPrefix(name, "bright-")]
+	if !ok {
+		return nil, fmt.Errorf("Unknown theme color '%s', must be black, red, green, yellow, blue, magenta, cyan or white, optionally with a 'bright-' prefix", name)
+	}
+
+	if bright {
+		// The bright colors are 60 above the normal ones
+		return []color.Attribute{c + 60}, nil
+	}
+
+	return []color.Attribute{c, color.Bold}, nil
+}
+
+func themeNames() []string {
+	names := make([]string, 0, len(builtinThemes))
+	for name := range builtinThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func newThemeFormatter(theme prettyTheme) *prettyjson.Formatter {
+	f := prettyjson.NewFormatter()
+	f.KeyColor = color.New(theme.Key...)
+	f.StringColor = color.New(theme.String...)
+	f.NumberColor = color.New(theme.Number...)
+	f.BoolColor = color.New(theme.Bool...)
+	f.NullColor = color.New(theme.Null...)
+	f.Indent = theme.Indent
+
+	return f
+}
+
+func setupTheme() {
+	theme, _ := parseTheme(*themeFlag)
+	prettyFormatter = newThemeFormatter(theme)
+}
+
+// Colors the tag of a message by its channel, so that the messages of
+// different channels are told apart at a glance. The same channel always
+// gets the same color.
+func channelTag(tag string, channel string) string {
+	if channel == "" {
+		return tag
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(channel))
+	c := channelTagColors[h.Sum32()%uint32(len(channelTagColors))]
+
+	return color.New(c, color.Bold).Sprint(tag)
+}
+
+// A message with values of every kind, shown by '--list-themes'
+var themeSample = []byte(`{"channel":"series","uuid":"8f6e6c0e-0b5a-4b6f-9a7e-0f6b2f1c1d2e","created":"2021-03-10T12:00:00Z","payload":{"id":12345,"title":"Grand final","best_of":5,"live":true,"winner":null}}`)
+
+// Prints the sample message in each built-in theme, for '--list-themes'
+func printThemes() error {
+	var buf bytes.Buffer
+	for _, name := range themeNames() {
+		s, err := newThemeFormatter(builtinThemes[name]).Format(themeSample)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s:\n%s\n\n", channelTag(name, name), s)
+	}
+	if !colorOutput {
+		buf.WriteString("Colors are disabled since stdout isn't a terminal, use '--color=always' to see them.\n")
+	}
+
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var transformFileFlag = flag.String("transform-file", "", "JSON file with drop, rename and set operations applied to messages before they reach the sinks")
+var displayTransformedFlag = flag.Bool("display-transformed", false, "Print messages as transformed by '--transform-file' instead of as received")
+var recordTransformedFlag = flag.Bool("record-transformed", false, "Keep the transformed messages in the '--recent-messages' buffer instead of the received ones")
+
+// One operation of the '--transform-file'. Paths are dot separated like the
+// payload paths of the filter attribution, but start at the message, e.g.
+// 'payload.player.real_name'. A path through an array applies to each of
+// its elements.
+type transformOp struct {
+	Drop   string      `json:"drop,omitempty"`   // Removes the field at the path
+	Rename string      `json:"rename,omitempty"` // Renames the field at the path to To
+	To     string      `json:"to,omitempty"`     // New key of a renamed field, in the same object
+	Set    string      `json:"set,omitempty"`    // Sets the field at the path to Value, creating objects on the way
+	Value  interface{} `json:"value,omitempty"`
+}
+
+// The operations of the '--transform-file', applied in order. Nil when no
+// file is given.
+var messageTransforms *transforms
+
+type transforms struct {
+	ops []transformOp
+}
+
+func readTransforms(fileName string) (*transforms, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []transformOp
+	err = json.Unmarshal(b, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse transforms. Error: %v", err)
+	}
+
+	for i, op := range ops {
+		err = validateTransformOp(op)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid transform %d. Error: %v", i+1, err)
+		}
+	}
+
+	return &transforms{ops: ops}, nil
+}
+
+func validateTransformOp(op transformOp) error {
+	n := 0
+	for _, path := range []string{op.Drop, op.Rename, op.Set} {
+		if path != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("Exactly one of 'drop', 'rename' and 'set' must be given")
+	}
+
+	if op.Rename != "" && (op.To == "" || strings.Contains(op.To, ".")) {
+		return fmt.Errorf("'rename' needs a 'to' key without dots")
+	}
+	if op.Rename == "" && op.To != "" {
+		return fmt.Errorf("'to' can only be used with 'rename'")
+	}
+	if op.Set == "" && op.Value != nil {
+		return fmt.Errorf("'value' can only be used with 'set'")
+	}
+
+	for _, path := range []string{op.Drop, op.Rename, op.Set} {
+		for _, key := range payloadPath(path) {
+			if path != "" && key == "" {
+				return fmt.Errorf("The path '%s' has an empty segment", path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the message with all operations applied
+func (t *transforms) apply(message []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(message))
+	d.UseNumber()
+	var m map[string]interface{}
+	err := d.Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range t.ops {
+		switch {
+		case op.Drop != "":
+			dropPath(m, payloadPath(op.Drop))
+		case op.Rename != "":
+			renamePath(m, payloadPath(op.Rename), op.To)
+		case op.Set != "":
+			setPath(m, payloadPath(op.Set), op.Value)
+		}
+	}
+
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	err = e.Encode(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Calls f with each object that holds the last key of the path, walking
+// into every element of the arrays on the way. Paths that don't exist are
+// skipped.
+func walkPath(v interface{}, path []string, f func(obj map[string]interface{}, key string)) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			walkPath(e, path, f)
+		}
+	case map[string]interface{}:
+		if len(path) == 1 {
+			f(v, path[0])
+			return
+		}
+		next, ok := v[path[0]]
+		if ok {
+			walkPath(next, path[1:], f)
+		}
+	}
+}
+
+func dropPath(m map[string]interface{}, path []string) {
+	walkPath(m, path, func(obj map[string]interface{}, key string) {
+		delete(obj, key)
+	})
+}
+
+// Renames the key in every object holding it. A key that already exists
+// under the new name is never overwritten, the object is left as it is.
+func renamePath(m map[string]interface{}, path []string, to string) {
+	walkPath(m, path, func(obj map[string]interface{}, key string) {
+		v, ok := obj[key]
+		if !ok {
+			return
+		}
+		if _, exists := obj[to]; exists {
+			stats.transformCollision()
+			return
+		}
+		delete(obj, key)
+		obj[to] = v
+	})
+}
+
+// Sets the value in every object at the path. Missing objects on the way
+// are created, other values on the way are left alone.
+func setPath(v interface{}, path []string, value interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			setPath(e, path, value)
+		}
+	case map[string]interface{}:
+		if len(path) == 1 {
+			v[path[0]] = value
+			return
+		}
+		next, ok := v[path[0]]
+		if !ok {
+			next = make(map[string]interface{})
+			v[path[0]] = next
+		}
+		setPath(next, path[1:], value)
+	}
+}
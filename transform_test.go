@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Parses the operations like readTransforms does
+func parseTransforms(t *testing.T, ops string) *transforms {
+	t.Helper()
+
+	fileName := filepath.Join(t.TempDir(), "transforms.json")
+	if err := ioutil.WriteFile(fileName, []byte(ops), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := readTransforms(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tr
+}
+
+func renameCollisions() int {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	return stats.renameCollisions
+}
+
+func TestTransformsApply(t *testing.T) {
+	tests := []struct {
+		name       string
+		ops        string
+		message    string
+		want       string
+		collisions int
+	}{
+		{
+			"drop",
+			`[{"drop": "payload.secret"}]`,
+			`{"channel": "series", "payload": {"id": 1, "secret": "x"}}`,
+			`{"channel":"series","payload":{"id":1}}`,
+			0,
+		},
+		{
+			"drop a whole object",
+			`[{"drop": "payload"}]`,
+			`{"channel": "series", "payload": {"id": 1}}`,
+			`{"channel":"series"}`,
+			0,
+		},
+		{
+			"drop in every array element",
+			`[{"drop": "payload.rosters.players.age"}]`,
+			`{"payload": {"rosters": [{"players": [{"id": 1, "age": 20}, {"id": 2}]}, {"players": [{"id": 3, "age": 30}]}]}}`,
+			`{"payload":{"rosters":[{"players":[{"id":1},{"id":2}]},{"players":[{"id":3}]}]}}`,
+			0,
+		},
+		{
+			"drop a missing path",
+			`[{"drop": "payload.missing.field"}, {"drop": "nowhere"}]`,
+			`{"payload": {"id": 1}}`,
+			`{"payload":{"id":1}}`,
+			0,
+		},
+		{
+			"drop through a scalar",
+			`[{"drop": "payload.id.value"}]`,
+			`{"payload": {"id": 1}}`,
+			`{"payload":{"id":1}}`,
+			0,
+		},
+		{
+			"array of scalars is left alone",
+			`[{"drop": "payload.tags.name"}]`,
+			`{"payload": {"tags": ["a", "b", 3]}}`,
+			`{"payload":{"tags":["a","b",3]}}`,
+			0,
+		},
+		{
+			"rename",
+			`[{"rename": "payload.real_name", "to": "name"}]`,
+			`{"payload": {"real_name": "Jane"}}`,
+			`{"payload":{"name":"Jane"}}`,
+			0,
+		},
+		{
+			"rename in array elements",
+			`[{"rename": "payload.players.nick", "to": "name"}]`,
+			`{"payload": {"players": [{"nick": "a"}, {"id": 2}, {"nick": null}]}}`,
+			`{"payload":{"players":[{"name":"a"},{"id":2},{"name":null}]}}`,
+			0,
+		},
+		{
+			"rename a missing path",
+			`[{"rename": "payload.nick", "to": "name"}, {"rename": "missing.nick", "to": "name"}]`,
+			`{"payload": {"id": 1}}`,
+			`{"payload":{"id":1}}`,
+			0,
+		},
+		{
+			"rename onto an existing key",
+			`[{"rename": "payload.nick", "to": "name"}]`,
+			`{"payload": {"nick": "a", "name": "b"}}`,
+			`{"payload":{"name":"b","nick":"a"}}`,
+			1,
+		},
+		{
+			"rename onto an existing key in some array elements",
+			`[{"rename": "payload.players.nick", "to": "name"}]`,
+			`{"payload": {"players": [{"nick": "a", "name": "b"}, {"nick": "c"}, {"nick": "d", "name": null}]}}`,
+			`{"payload":{"players":[{"name":"b","nick":"a"},{"name":"c"},{"name":null,"nick":"d"}]}}`,
+			2,
+		},
+		{
+			"rename to the same key",
+			`[{"rename": "payload.name", "to": "name"}]`,
+			`{"payload": {"name": "a"}}`,
+			`{"payload":{"name":"a"}}`,
+			1,
+		},
+		{
+			"set",
+			`[{"set": "source", "value": "abios"}]`,
+			`{"payload": {}}`,
+			`{"payload":{},"source":"abios"}`,
+			0,
+		},
+		{
+			"set creates the objects on the way",
+			`[{"set": "meta.origin.name", "value": {"a": [1, 2]}}]`,
+			`{"payload": {}}`,
+			`{"meta":{"origin":{"name":{"a":[1,2]}}},"payload":{}}`,
+			0,
+		},
+		{
+			"set in every array element",
+			`[{"set": "payload.players.seen", "value": true}]`,
+			`{"payload": {"players": [{"id": 1}, {"id": 2}]}}`,
+			`{"payload":{"players":[{"id":1,"seen":true},{"id":2,"seen":true}]}}`,
+			0,
+		},
+		{
+			"set through a scalar",
+			`[{"set": "payload.id.value", "value": 1}]`,
+			`{"payload": {"id": 7}}`,
+			`{"payload":{"id":7}}`,
+			0,
+		},
+		{
+			"set replaces the value",
+			`[{"set": "payload.id", "value": null}]`,
+			`{"payload": {"id": 7}}`,
+			`{"payload":{"id":null}}`,
+			0,
+		},
+		{
+			"operations run in order",
+			`[{"rename": "payload.nick", "to": "name"}, {"drop": "payload.nick"}, {"set": "payload.nick", "value": "x"}]`,
+			`{"payload": {"nick": "a"}}`,
+			`{"payload":{"name":"a","nick":"x"}}`,
+			0,
+		},
+		{
+			"numbers and html are kept as sent",
+			`[{"drop": "payload.x"}]`,
+			`{"payload": {"big": 12345678901234567890, "f": 1.50, "html": "<b>&</b>"}}`,
+			`{"payload":{"big":12345678901234567890,"f":1.50,"html":"<b>&</b>"}}`,
+			0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr := parseTransforms(t, test.ops)
+			before := renameCollisions()
+
+			got, err := tr.apply([]byte(test.message))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+			if n := renameCollisions() - before; n != test.collisions {
+				t.Errorf("%d rename collisions were counted, want %d", n, test.collisions)
+			}
+		})
+	}
+}
+
+func TestTransformsApplyInvalid(t *testing.T) {
+	tr := parseTransforms(t, `[{"drop": "payload"}]`)
+
+	for _, message := range []string{`not json`, `[1, 2]`, `"text"`} {
+		if _, err := tr.apply([]byte(message)); err == nil {
+			t.Errorf("%s was transformed", message)
+		}
+	}
+}
+
+func TestValidateTransformOp(t *testing.T) {
+	tests := []struct {
+		op    string
+		valid bool
+	}{
+		{`{"drop": "payload.a"}`, true},
+		{`{"rename": "payload.a", "to": "b"}`, true},
+		{`{"set": "payload.a", "value": 1}`, true},
+		{`{"set": "payload.a"}`, true},
+		{`{}`, false},
+		{`{"drop": "a", "set": "b"}`, false},
+		{`{"rename": "payload.a"}`, false},
+		{`{"rename": "payload.a", "to": "b.c"}`, false},
+		{`{"drop": "payload.a", "to": "b"}`, false},
+		{`{"drop": "payload.a", "value": 1}`, false},
+		{`{"drop": "payload..a"}`, false},
+		{`{"drop": ".a"}`, false},
+		{`{"set": "a.", "value": 1}`, false},
+	}
+
+	for _, test := range tests {
+		var op transformOp
+		if err := json.Unmarshal([]byte(test.op), &op); err != nil {
+			t.Fatal(err)
+		}
+		err := validateTransformOp(op)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %t", test.op, err, test.valid)
+		}
+	}
+}
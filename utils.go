@@ -2,35 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	prettyjson "github.com/hokaccha/go-prettyjson"
+	"github.com/mattn/go-isatty"
+	flag "github.com/spf13/pflag"
 )
 
-// Custom status codes sent by the server for the 'close' command.
-// The websocket standard (RFC6455) allocates the
-// 4000-4999 range to application specific status codes.
-const (
-	CloseMissingSecret         = 4000 // Missing access token in ws setup request
-	CloseInvalidSecret         = 4001 // Invalid access token in ws setup request
-	CloseNotAuthorized         = 4002 // Client account does not have access to the push API
-	CloseMaxNumSubscribers     = 4003 // Max number of concurrent subscribers connected for client id
-	CloseMaxNumSubscriptions   = 4004 // Max number of registered subscriptions exist for client id
-	CloseInvalidReconnectToken = 4005 // Invalid reconnect token in ws setup request
-	CloseMissingSubscriptionID = 4006 // Missing subscription id in ws setup request
-	CloseUnknownSubscriptionID = 4007 // The supplied subscriber id in ws setup request does not exist in server
-	CloseInternalError         = 4500 // Unspecified error due to problem in server
-)
+// Set at startup, when true every message is printed as one line of compact JSON
+var singleLineOutput bool
 
 func stdPrettyPrint(v interface{}) ([]byte, error) {
 	s, err := json.MarshalIndent(v, "", "   ")
@@ -42,7 +36,7 @@ func stdPrettyPrint(v interface{}) ([]byte, error) {
 }
 
 func coloredPrettyPrint(v interface{}) ([]byte, error) {
-	s, err := prettyjson.Marshal(v)
+	s, err := prettyFormatter.Marshal(v)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to marshal struct. Error: %v, Msg: %v", err, v)
 	}
@@ -50,71 +44,160 @@ func coloredPrettyPrint(v interface{}) ([]byte, error) {
 	return s, nil
 }
 
-func tryUnmarshalJSONAsPushMessage(jsonMsg []byte, printStruct bool) (PushMessage, error) {
-	var msg PushMessage
-	err := json.Unmarshal(jsonMsg, &msg)
+// Replaces a double-encoded payload with the object it contains, so that the
+// message is routed and printed the same way as one with a plain payload.
+// Everything else in the message is kept as it was received.
+func normalizePayload(jsonMsg []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(jsonMsg, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload string
+	err = json.Unmarshal(fields["payload"], &payload)
 	if err != nil {
-		e := fmt.Errorf("Error when unmarshalling incoming json. Error:%v, JSON:%s",
-			err.Error(), string(jsonMsg))
-		return PushMessage{}, e
+		return nil, err
 	}
+	fields["payload"] = json.RawMessage(payload)
 
-	return msg, nil
+	return json.Marshal(fields)
 }
 
+// Top-level fields that may hold the creation time of a message, in the
+// order they are looked for
+var createdFieldNames = []string{"created", "created_at", "timestamp"}
+
+// Logs a JSON document for debugging, e.g. the config or the init message.
+// Push messages are printed with printMessageOutput instead.
 func printJsonWithTag(tag string, msg []byte) {
-	var createdAt time.Time
-	var s []byte
-	var v interface{}
-	var o map[string]interface{}
-	var a []map[string]interface{}
+	entry, ok := formatJsonWithTag(tag, msg, singleLineOutput)
+	if ok {
+		log.Print(entry)
+	}
+}
 
-	if bytes.HasPrefix(msg, []byte("[")) {
-		err := json.Unmarshal(msg, &a)
-		if err != nil {
-			log.Printf("[ERROR] Failed to unmarshal message. Error: %s, Msg: %+v\n", err, a)
-			return
-		}
+// Formats the document with a tag and its size and latency, either pretty
+// printed below a header or as one line. Failures are logged, and false is
+// returned.
+func formatJsonWithTag(tag string, msg []byte, singleLine bool) (string, bool) {
+	v, err := decodeJSON(msg)
+	if err != nil {
+		log.Printf("[ERROR] Failed to unmarshal message. Error: %s, Msg: %s\n", err, foldLines(string(msg)))
+		return "", false
+	}
 
-		v = a
-	} else {
-		err := json.Unmarshal(msg, &o)
-		if err != nil {
-			log.Printf("[ERROR] Failed to unmarshal message. Error: %s, Msg: %+v\n", err, o)
-			return
-		}
+	return formatValueWithTag(tag, len(msg), v, messageCreatedAt(v), singleLine)
+}
 
-		if ts, ok := o["created"]; ok {
-			s, ok := ts.(string)
-			if ok {
-				createdAt, _ = time.Parse(time.RFC3339, s)
-			}
-		}
+// Any kind of JSON value is accepted, not only objects and arrays. Numbers
+// are kept as they were sent instead of being converted to floats.
+func decodeJSON(msg []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	err := dec.Decode(&v)
 
-		v = o
+	return v, err
+}
+
+// Formats an already decoded document of size bytes like formatJsonWithTag,
+// with the latency counted from createdAt unless it is the zero time
+func formatValueWithTag(tag string, size int, v interface{}, createdAt time.Time, singleLine bool) (string, bool) {
+	if singleLine {
+		return formatSingleLineWithTag(tag, size, v, createdAt)
 	}
 
+	var s []byte
 	var err error
-	if *noPPFlag {
+
+	if !colorOutput {
 		s, err = stdPrettyPrint(v)
 	} else {
 		s, err = coloredPrettyPrint(v)
 	}
 	if err != nil {
-		log.Println("[ERROR] Failed to prettyprint message. Error:", s)
-		return
+		log.Println("[ERROR] Failed to prettyprint message. Error:", err)
+		return "", false
 	}
 
 	if !createdAt.IsZero() {
 		latency := roundDuration(time.Since(createdAt), time.Millisecond)
-		log.Printf("[%s] (latency: %s; %d bytes w/o pretty print):\n%s\n\n", tag, latency, len(msg), string(s))
-	} else {
-		log.Printf("[%s] (%d bytes w/o pretty print):\n%s\n\n", tag, len(msg), string(s))
+		return fmt.Sprintf("[%s] (latency: %s; %d bytes w/o pretty print):\n%s\n\n", tag, latency, size, string(s)), true
+	}
+
+	return fmt.Sprintf("[%s] (%d bytes w/o pretty print):\n%s\n\n", tag, size, string(s)), true
+}
+
+// Returns the creation time of a decoded message, or the zero time if the
+// message isn't an object with a valid timestamp at the top level. Fields
+// with the same names in nested objects are not used.
+func messageCreatedAt(v interface{}) time.Time {
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return time.Time{}
+	}
+
+	for _, name := range createdFieldNames {
+		ts, ok := o[name].(string)
+		if !ok {
+			continue
+		}
+
+		createdAt, err := time.Parse(time.RFC3339, ts)
+		if err == nil {
+			return createdAt
+		}
+	}
+
+	return time.Time{}
+}
+
+// Formats the message as one line of compact JSON with the information
+// from the human-friendly banner folded into fields next to the message
+// itself. Newlines inside string values stay escaped as \n by the JSON
+// encoder.
+func formatSingleLineWithTag(tag string, size int, v interface{}, createdAt time.Time) (string, bool) {
+	entry := outputEnvelope{
+		V:     *outputVersionFlag,
+		Tag:   tag,
+		Bytes: size,
+		Data:  v,
+	}
+	if !createdAt.IsZero() {
+		entry.Latency = roundDuration(time.Since(createdAt), time.Millisecond).String()
 	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(entry)
+	if err != nil {
+		log.Println("[ERROR] Failed to marshal message. Error:", err)
+		return "", false
+	}
+
+	return b.String(), true
+}
+
+// Escapes line breaks so that free-form text, e.g. a raw message included
+// in an error, doesn't span several lines in single-line mode
+func foldLines(s string) string {
+	if !singleLineOutput {
+		return s
+	}
+
+	return strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(s)
 }
 
-// Intercept 'ctrl-c' and remove the subscription before shutdown if needed
-func setupShutdownHandler(subscriptionIDOrName string, doRemoveSubscription bool) {
+// Reports if f is a terminal, using the same check as the color library
+// uses when it decides whether to colorize the output or not
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// Intercept 'ctrl-c' and cancel the context, the client then shuts down
+func setupShutdownHandler(cancel context.CancelFunc) {
 	sigs := make(chan os.Signal, 1)
 
 	// `signal.Notify` registers the given channel to
@@ -125,110 +208,481 @@ func setupShutdownHandler(subscriptionIDOrName string, doRemoveSubscription bool
 	// signals.
 	go func() {
 		<-sigs
+		log.Println("[INFO] Shutting down")
+		cancel()
+	}()
+}
 
-		if doRemoveSubscription {
-			err := deleteSubscription(subscriptionIDOrName)
-			if err != nil {
-				log.Println("[ERROR] Failed to delete subscription. Error: ", err)
-			} else {
-				log.Println("[INFO] Deleted subscription ", subscriptionIDOrName)
-			}
+// Sleeps for d, or until ctx is done in which case ctx.Err() is returned
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Held for the rest of the process once shutdown has started, so that the
+// cleanup only runs once
+var shutdownMu sync.Mutex
+
+// Set once shutdown has started, a websocket closed after that must not be
+// resumed
+var shuttingDown int32
+
+// Ends the process at the end of shutdown, replaced by tests
+var exitProcess = os.Exit
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// Closes the websocket connection and all files, deletes the subscription
+// from the server if wanted, and exits with the given code
+func shutdown(subscriptionIDOrName string, doRemoveSubscription bool, exitCode int) {
+	shutdownMu.Lock()
+	atomic.StoreInt32(&shuttingDown, 1)
+	emitEvent(lifecycleEvent{Event: eventShutdownInitiated, SubscriptionID: subscriptionIDOrName, ExitCode: &exitCode})
+	stopReloads()
+
+	if doRemoveSubscription {
+		err := deleteOwnedSubscription(context.Background(), subscriptionIDOrName)
+		if err != nil {
+			log.Println("[ERROR] Failed to delete subscription. Error: ", err)
+		} else {
+			log.Println("[INFO] Deleted subscription ", subscriptionIDOrName)
+			emitEvent(lifecycleEvent{Event: eventSubscriptionDeleted, SubscriptionID: subscriptionIDOrName})
 		}
+	}
 
-		err := disconnectWebsocket()
+	// No ping may race the close handshake
+	stopKeepAlive()
+	if !drainClosedWebsocket() {
+		err := closeWebsocket()
 		if err != nil {
 			log.Println("[ERROR] Failed to do clean websocket disconnect. Error: ", err)
 		} else {
 			log.Println("[INFO] Disconnected websocket connection")
 		}
+	}
 
-		// Exit with success code
-		os.Exit(0)
-	}()
-}
+	if messageSinks != nil {
+		messageSinks.shutdown(*sinkTimeoutFlag)
+	}
 
-func requestAccessToken(clientID string, clientSecret string) (string, error) {
-	URL := *apiURLFlag + "/oauth/access_token"
-	form := url.Values{}
-	form.Add("client_id", clientID)
-	form.Add("client_secret", clientSecret)
-	form.Add("grant_type", "client_credentials")
+	if messageQuarantine != nil {
+		err := messageQuarantine.close()
+		if err != nil {
+			log.Println("[ERROR] Failed to close quarantine file. Error: ", err)
+		}
+	}
 
-	req, err := http.NewRequest("POST", URL, strings.NewReader(form.Encode()))
-	if err != nil {
-		return "", err
+	if *statusFileFlag != "" {
+		err := os.Remove(*statusFileFlag)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("[ERROR] Failed to remove status file. Error: ", err)
+		}
 	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+	if !*noSummaryFlag {
+		stats.printSummary()
 	}
-	defer resp.Body.Close()
+	exitCode = latencyExitCode(exitCode, stats.snapshot())
 
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("Failed to read response body. Error: %v", err)
+	if *pidFileFlag != "" {
+		removePidFile(*pidFileFlag)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Unexpected status code: %d", resp.StatusCode)
+	emitEvent(lifecycleEvent{Event: eventShutdownCompleted, SubscriptionID: subscriptionIDOrName, ExitCode: &exitCode})
+	if events != nil {
+		events.close()
 	}
 
-	var authResponse struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int    `json:"expires_in"`
-		TokenType   string `json:"token_type"`
+	exitProcess(exitCode)
+}
+
+// Returns the base URL used for creating v2 access tokens. An explicit
+// '--access-token-url' is always used, otherwise '--api-addr' takes
+// precedence over the default.
+func accessTokenBaseURL() string {
+	if *apiAddrFlag != "" && !flag.CommandLine.Changed("access-token-url") {
+		return strings.TrimSuffix(*apiAddrFlag, "/")
 	}
-	err = json.Unmarshal(respBody, &authResponse)
+
+	return *apiURLFlag
+}
+
+// Checks that '--api-addr' is an absolute http or https URL
+func validateAPIAddr(addr string) error {
+	u, err := url.Parse(addr)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("Invalid '--api-addr' '%s'. Error: %v", addr, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid '--api-addr' '%s', must be an http:// or https:// URL", addr)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("Invalid '--api-addr' '%s', can't have a query or fragment", addr)
 	}
 
-	return authResponse.AccessToken, nil
+	return nil
 }
 
-func buildHTTPURLFromWSURL(wsURL string) string {
-	u, _ := url.Parse(wsURL)
-	var scheme string
-	if u.Scheme == "wss" {
-		scheme = "https"
-	} else {
-		scheme = "http"
+func readSubscriptionSpec(fileName string) (Subscription, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return Subscription{}, err
 	}
 
-	u.Scheme = scheme
+	b, err = expandSpecVars(b)
+	if err != nil {
+		return Subscription{}, err
+	}
 
-	return u.String()
+	return parseSubscriptionSpec(b)
 }
 
-func readSubscriptionSpec(fileName string) (Subscription, error) {
-	b, err := ioutil.ReadFile(fileName)
+func parseSubscriptionSpec(b []byte) (Subscription, error) {
 	var sub Subscription
+	err := json.Unmarshal(b, &sub)
 	if err != nil {
 		return sub, err
 	}
 
-	err = json.Unmarshal(b, &sub)
+	for _, field := range unknownSpecFields(b) {
+		log.Printf("[WARN] Unknown field '%s' in the subscription specification, it is not sent to the server\n", field)
+	}
+
+	if sub.Name != "" && url.PathEscape(sub.Name) != sub.Name {
+		log.Printf("[WARN] The subscription name '%s' contains characters that must be escaped in URLs, "+
+			"names with only letters, digits, '-', '_' and '.' are the safest to use\n", sub.Name)
+	}
 
-	return sub, err
+	return sub, validateSubscription(sub)
 }
 
-func validateFlags() error {
-	// Check that auth credentials have been given.
-	if *clientV3SecretFlag == "" {
-		if *clientV2IDFlag == "" || *clientV2SecretFlag == "" {
-			return fmt.Errorf("You need to provide the API authentication credentials. '--secret' for v3 auth or '--client-id' and '--client-secret' for v2 auth")
+// Returns the fields of a subscription specification that aren't in the
+// Subscription and SubscriptionFilter structs, which json.Unmarshal
+// silently drops. Field names are matched case-insensitively, like
+// json.Unmarshal does.
+func unknownSpecFields(b []byte) []string {
+	var spec map[string]json.RawMessage
+	if json.Unmarshal(b, &spec) != nil {
+		return nil
+	}
+
+	schema := jsonSchema(reflect.TypeOf(Subscription{}))
+	known := schema["properties"].(map[string]interface{})
+	filterSchema := known["filters"].(map[string]interface{})["items"].(map[string]interface{})
+	knownInFilters := filterSchema["properties"].(map[string]interface{})
+
+	unknown := unknownKeys(spec, known, "")
+	var filters []map[string]json.RawMessage
+	if json.Unmarshal(spec["filters"], &filters) == nil {
+		for i, f := range filters {
+			unknown = append(unknown, unknownKeys(f, knownInFilters, fmt.Sprintf("filters[%d].", i))...)
+		}
+	}
+
+	return unknown
+}
+
+func unknownKeys(obj map[string]json.RawMessage, known map[string]interface{}, prefix string) []string {
+	var unknown []string
+	for key := range obj {
+		found := false
+		for name := range known {
+			if strings.EqualFold(key, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, prefix+key)
 		}
 	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+func validateFlags() error {
+	err := validateCredentialFlags()
+	if err != nil {
+		return err
+	}
 
 	// Check that a subscription specification has been given by either
 	// 1. A filename for a subscription spec
 	// 2. An id that points to an already existing subscription on the server-side
 	// 3. A reconnect token in order to connect to an existing subscriber
-	if *subscriptionFileFlag == "" && *subscriptionIDFlag == "" && *reconnectTokenFlag == "" {
-		return fmt.Errorf("You need to provide one of the options '--subscription-file', '--subscription-id' or '--reconnect-token'")
+	// and that it is clear what happens to the subscription on exit
+	_, err = planSubscription(specFromFlags(), *subscriptionIDFlag, *subscriptionNameFlag, *reconnectTokenFlag, *keepSubscription, *subscriptionTTLFlag)
+	if err != nil {
+		return err
+	}
+
+	if len(*filterFlag) > 0 {
+		_, err = subscriptionFromFilterFlags(*filterFlag)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *drainTimeoutFlag <= 0 {
+		return fmt.Errorf("The option '--drain-timeout' must be positive")
+	}
+
+	if *pingIntervalFlag != 0 && (*pingIntervalFlag < minPingInterval || *pingIntervalFlag > maxPingInterval) {
+		return fmt.Errorf("The option '--ping-interval' must be between %s and %s", minPingInterval, maxPingInterval)
+	}
+	if *maxFailedPingsFlag < 0 {
+		return fmt.Errorf("The option '--max-failed-pings' can't be negative")
+	}
+	if *pongTimeoutFlag < 0 {
+		return fmt.Errorf("The option '--pong-timeout' can't be negative")
+	}
+
+	if *pauseBufferFlag < 0 {
+		return fmt.Errorf("The option '--pause-buffer' can't be negative")
+	}
+
+	if *recentMessagesFlag < 0 {
+		return fmt.Errorf("The option '--recent-messages' can't be negative")
+	}
+	if *recentMaxBytesFlag < 1 {
+		return fmt.Errorf("The option '--recent-max-bytes' must be at least 1")
+	}
+
+	if *maxReconnectAttemptsFlag < 0 {
+		return fmt.Errorf("The option '--max-reconnect-attempts' can't be negative")
+	}
+
+	if *verifySubscriptionIntervalFlag < 0 {
+		return fmt.Errorf("The option '--verify-subscription-interval' can't be negative")
+	}
+
+	if *watchSubscriptionFlag < 0 {
+		return fmt.Errorf("The option '--watch-subscription' can't be negative")
+	}
+	if *followSubscriptionChangesFlag && *watchSubscriptionFlag == 0 {
+		return fmt.Errorf("The option '--follow-subscription-changes' needs '--watch-subscription'")
+	}
+
+	if *statsIntervalFlag < 0 {
+		return fmt.Errorf("The option '--stats-interval' can't be negative")
+	}
+	if *statsVerboseFlag && *statsIntervalFlag == 0 {
+		return fmt.Errorf("The option '--stats-verbose' needs '--stats-interval'")
+	}
+
+	err = validateOutputFormat()
+	if err != nil {
+		return err
+	}
+
+	_, err = newLocalChannelFilter(*onlyChannelFlag, *excludeChannelFlag)
+	if err != nil {
+		return err
+	}
+	_, err = newLocalIDFilter(*onlyGameIDFlag, *onlySeriesIDFlag, *onlyMatchIDFlag)
+	if err != nil {
+		return err
+	}
+
+	err = validateLatencyFlags()
+	if err != nil {
+		return err
+	}
+
+	if *transformFileFlag == "" && (*displayTransformedFlag || *recordTransformedFlag) {
+		return fmt.Errorf("The options '--display-transformed' and '--record-transformed' need '--transform-file'")
+	}
+
+	if *maxUnhealthyDurationFlag < 0 {
+		return fmt.Errorf("The option '--max-unhealthy-duration' can't be negative")
+	}
+	if *maxErrorRateFlag < 0 {
+		return fmt.Errorf("The option '--max-error-rate' can't be negative")
+	}
+
+	if *seriesSilenceThresholdFlag <= 0 {
+		return fmt.Errorf("The option '--series-silence-threshold' must be positive")
+	}
+	if *coverageIntervalFlag < 0 {
+		return fmt.Errorf("The option '--coverage-interval' can't be negative")
+	}
+
+	if *gapWarnThresholdFlag < 0 {
+		return fmt.Errorf("The option '--gap-warn-threshold' can't be negative")
+	}
+
+	if *firstMessageTimeoutFlag < 0 {
+		return fmt.Errorf("The option '--first-message-timeout' can't be negative")
+	}
+	if *firstMessageRequiredFlag && *firstMessageTimeoutFlag == 0 {
+		return fmt.Errorf("The option '--first-message-required' needs '--first-message-timeout'")
+	}
+
+	if *replayWindowFlag <= 0 {
+		return fmt.Errorf("The option '--replay-window' must be positive")
+	}
+	if *seenUUIDsFlag < 1 {
+		return fmt.Errorf("The option '--seen-uuids' must be at least 1")
+	}
+	if *orderingKeysFlag < 1 {
+		return fmt.Errorf("The option '--ordering-keys' must be at least 1")
+	}
+
+	err = validateSinkFlags()
+	if err != nil {
+		return err
+	}
+
+	if *wsReadBufferFlag < 0 || *wsWriteBufferFlag < 0 {
+		return fmt.Errorf("The websocket buffer sizes can't be negative")
+	}
+
+	if *subscriptionTTLFlag < 0 {
+		return fmt.Errorf("The option '--subscription-ttl' can't be negative")
+	}
+
+	if *quarantineMaxSizeFlag < 0 {
+		return fmt.Errorf("The option '--quarantine-max-size' can't be negative")
+	}
+
+	if *signatureRequiredFlag && *verifySignatureKeyFileFlag == "" {
+		return fmt.Errorf("The option '--signature-required' needs '--verify-signature-key-file'")
+	}
+
+	if *sinkHeartbeatFlag < 0 {
+		return fmt.Errorf("The option '--sink-heartbeat' can't be negative")
+	}
+	if *sinkHeartbeatFlag > 0 && len(*routeFlag) == 0 && *routeDefaultFlag == "" {
+		return fmt.Errorf("The option '--sink-heartbeat' needs at least one '--route' or '--route-default' to write to")
+	}
+	if *sinkHeartbeatFlag > 0 && *sinkHeartbeatChannelFlag == "" {
+		return fmt.Errorf("The option '--sink-heartbeat-channel' can't be empty")
+	}
+
+	return validateCommonFlags()
+}
+
+func validateCredentialFlags() error {
+	if *clientV3SecretFlag != "" && *secretFileFlag != "" {
+		return fmt.Errorf("The options '--secret' and '--secret-file' can't be combined")
+	}
+	if *clientV2SecretFlag != "" && *clientSecretFileFlag != "" {
+		return fmt.Errorf("The options '--client-secret' and '--client-secret-file' can't be combined")
+	}
+
+	// Check that auth credentials have been given.
+	if *clientV3SecretFlag == "" && *secretFileFlag == "" {
+		if *clientV2IDFlag == "" || (*clientV2SecretFlag == "" && *clientSecretFileFlag == "") {
+			return fmt.Errorf("You need to provide the API authentication credentials. '--secret' or '--secret-file' for v3 auth or '--client-id' and '--client-secret' or '--client-secret-file' for v2 auth")
+		}
+	}
+
+	// The files are read again later, but a missing file should stop the
+	// client at startup
+	for _, fileName := range []string{*secretFileFlag, *clientSecretFileFlag} {
+		if fileName == "" {
+			continue
+		}
+		_, err := readSecretFile(fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *v2AuthStyleFlag != "query" && *v2AuthStyleFlag != "header" {
+		return fmt.Errorf("Unknown '--v2-auth-style' '%s', must be 'query' or 'header'", *v2AuthStyleFlag)
+	}
+
+	return nil
+}
+
+// Checks the flags used both when subscribing and by the commands
+func validateCommonFlags() error {
+	level, err := parseLogLevel(*logLevelFlag)
+	if err != nil {
+		return err
+	}
+
+	err = validateColorFlag()
+	if err != nil {
+		return err
+	}
+	_, err = parseTheme(*themeFlag)
+	if err != nil {
+		return err
+	}
+
+	if *apiAddrFlag != "" {
+		err = validateAPIAddr(*apiAddrFlag)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = validateOwnerTag(*ownerTagFlag)
+	if err != nil {
+		return err
+	}
+
+	err = validateOutputVersion(*outputVersionFlag)
+	if err != nil {
+		return err
+	}
+
+	if *daemonFlag && flag.NArg() > 0 {
+		return fmt.Errorf("The option '--daemon' can't be used with commands")
+	}
+
+	if *apiRateFlag != "" {
+		_, err = parseAPIRate(*apiRateFlag)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = parseHeaderFlags(*headerFlag)
+	if err != nil {
+		return err
+	}
+
+	_, err = parseSpecVars(*specVarFlag)
+	if err != nil {
+		return err
+	}
+
+	// '--silent' means warnings and errors only, asking for more than that
+	// at the same time is contradictory
+	if *silentFlag && flag.CommandLine.Changed("log-level") && level < levelWarn {
+		return fmt.Errorf("The option '--silent' can't be combined with '--log-level=%s'", *logLevelFlag)
+	}
+
+	return nil
+}
+
+// Checks that a subscription specification makes sense before it is sent
+// to the push service
+func validateSubscription(sub Subscription) error {
+	if len(sub.Filters) == 0 {
+		return fmt.Errorf("The subscription has no filters")
+	}
+
+	for i, f := range sub.Filters {
+		if f == (SubscriptionFilter{}) {
+			return fmt.Errorf("Filter %d is empty", i)
+		}
+		if f.GameID < 0 || f.SeriesID < 0 || f.MatchID < 0 || f.TournamentID < 0 || f.TeamID < 0 || f.PlayerID < 0 {
+			return fmt.Errorf("Filter %d has a negative ID", i)
+		}
 	}
 
 	return nil
@@ -253,3 +707,26 @@ func roundDuration(d, r time.Duration) time.Duration {
 	}
 	return d
 }
+
+// Writes the file through a temporary file in the same directory and a
+// rename, so that readers never see a partially written file. The file is
+// only readable by the user.
+func writeFileAtomic(fileName string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fileName)
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+func TestDecodeJSONKinds(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{"object", `{"a": 1}`, `{"a":1}`},
+		{"array", `[1, "two", null]`, `[1,"two",null]`},
+		{"string", `"text"`, `"text"`},
+		{"integer", `12345678901234567890`, `12345678901234567890`},
+		{"float", `1.50`, `1.50`},
+		{"true", `true`, `true`},
+		{"false", `false`, `false`},
+		{"null", `null`, `null`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := decodeJSON([]byte(test.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry, ok := formatSingleLineWithTag("tag", len(test.msg), v, time.Time{})
+			if !ok {
+				t.Fatal("the value couldn't be formatted")
+			}
+			if want := `"data":` + test.want + "}\n"; !strings.HasSuffix(entry, want) {
+				t.Errorf("got %q, want it to end with %q", entry, want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONInvalid(t *testing.T) {
+	for _, msg := range []string{``, `{`, `{"a": }`, `not json`} {
+		if _, err := decodeJSON([]byte(msg)); err == nil {
+			t.Errorf("%q was decoded", msg)
+		}
+	}
+}
+
+func TestMessageCreatedAt(t *testing.T) {
+	created := time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		msg  string
+		want time.Time
+	}{
+		{"created", `{"created": "2020-05-17T12:30:00Z"}`, created},
+		{"created_at", `{"created_at": "2020-05-17T12:30:00Z"}`, created},
+		{"timestamp", `{"timestamp": "2020-05-17T14:30:00+02:00"}`, created},
+		{"first valid field", `{"created": "yesterday", "timestamp": "2020-05-17T12:30:00Z"}`, created},
+		{"created before timestamp", `{"timestamp": "2021-01-01T00:00:00Z", "created": "2020-05-17T12:30:00Z"}`, created},
+		{"no field", `{"id": 1}`, time.Time{}},
+		{"date only", `{"created": "2020-05-17"}`, time.Time{}},
+		{"no time zone", `{"created": "2020-05-17T12:30:00"}`, time.Time{}},
+		{"space separator", `{"created": "2020-05-17 12:30:00Z"}`, time.Time{}},
+		{"empty string", `{"created": ""}`, time.Time{}},
+		{"unix time", `{"created": 1589718600}`, time.Time{}},
+		{"null", `{"created": null}`, time.Time{}},
+		{"nested", `{"payload": {"created": "2020-05-17T12:30:00Z"}}`, time.Time{}},
+		{"nested in array", `{"items": [{"created": "2020-05-17T12:30:00Z"}]}`, time.Time{}},
+		{"array", `[{"created": "2020-05-17T12:30:00Z"}]`, time.Time{}},
+		{"string", `"2020-05-17T12:30:00Z"`, time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := decodeJSON([]byte(test.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := messageCreatedAt(v); !got.Equal(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatJsonWithTag(t *testing.T) {
+	discardLog(t)
+	msg := `{"id": 1, "created": "2020-05-17T12:30:00Z"}`
+
+	entry, ok := formatJsonWithTag("init", []byte(msg), false)
+	if !ok {
+		t.Fatal("the message couldn't be formatted")
+	}
+	if want := "[init] (latency: "; !strings.HasPrefix(entry, want) {
+		t.Errorf("got %q, want it to start with %q", entry, want)
+	}
+	if want := "44 bytes w/o pretty print):\n"; !strings.Contains(entry, want) {
+		t.Errorf("got %q, want it to contain %q", entry, want)
+	}
+
+	entry, ok = formatJsonWithTag("init", []byte(`[1, 2]`), false)
+	if !ok {
+		t.Fatal("the array couldn't be formatted")
+	}
+	if want := "[init] (6 bytes w/o pretty print):\n"; !strings.HasPrefix(entry, want) {
+		t.Errorf("got %q, want it to start with %q", entry, want)
+	}
+
+	if _, ok := formatJsonWithTag("init", []byte(`{"id": `), false); ok {
+		t.Errorf("malformed JSON was formatted")
+	}
+}
+
+// Reads the spec from a file like '--subscription-file' does
+func readTestSpec(t *testing.T, spec string) (Subscription, error) {
+	t.Helper()
+
+	fileName := filepath.Join(t.TempDir(), "spec.json")
+	if err := ioutil.WriteFile(fileName, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return readSubscriptionSpec(fileName)
+}
+
+// Registers the subscription with a test server and returns the body of
+// the POST request
+func registeredBody(t *testing.T, sub Subscription) []byte {
+	t.Helper()
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/subscription" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"id": "7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b"}`))
+	}))
+	defer server.Close()
+
+	client, err := pushclient.New(pushclient.Config{
+		Addr: "ws" + strings.TrimPrefix(server.URL, "http"),
+		Auth: pushclient.NewSecretAuth("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.RegisterSubscription(context.Background(), sub); err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func decodeObject(t *testing.T, b []byte) map[string]interface{} {
+	t.Helper()
+
+	var v map[string]interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+
+	return v
+}
+
+// Every field of the spec file reaches the server
+func TestSubscriptionSpecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{"channel only", `{"name": "dev", "filters": [{"channel": "series"}]}`},
+		{"all filter fields", `{
+			"name": "dev",
+			"description": "every id",
+			"filters": [
+				{"channel": "series", "game_id": 1, "series_id": 2, "match_id": 3, "tournament_id": 4, "team_id": 5, "player_id": 6}
+			]
+		}`},
+		{"one field per filter", `{
+			"name": "players",
+			"filters": [
+				{"tournament_id": 100},
+				{"team_id": 200},
+				{"player_id": 300},
+				{"channel": "match", "player_id": 300}
+			]
+		}`},
+		{"without name", `{"filters": [{"game_id": 5, "team_id": 7}]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+			sub, err := readTestSpec(t, test.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := decodeObject(t, registeredBody(t, sub))
+			want := decodeObject(t, []byte(test.spec))
+			// The read-only ID is always sent, as the nil UUID for a new
+			// subscription
+			if got["id"] != "00000000-0000-0000-0000-000000000000" {
+				t.Errorf("got the id %v", got["id"])
+			}
+			delete(got, "id")
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("the server got %v, want %v", got, want)
+			}
+			if logged.Len() > 0 {
+				t.Errorf("a known spec was warned about: %s", logged)
+			}
+		})
+	}
+}
+
+func TestSubscriptionSpecUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want []string
+	}{
+		{"none", `{"name": "dev", "filters": [{"channel": "series", "team_id": 5}]}`, nil},
+		{"case-insensitive", `{"Name": "dev", "Filters": [{"Channel": "series", "TEAM_ID": 5}]}`, nil},
+		{"top level", `{"name": "dev", "owner": "me", "filters": [{"channel": "series"}]}`, []string{"owner"}},
+		{"in filters", `{"filters": [{"channel": "series"}, {"team": 5, "player": 6, "channel": "match"}]}`, []string{"filters[1].player", "filters[1].team"}},
+		{"misspelled id", `{"filters": [{"channel": "series", "tournamentid": 3}]}`, []string{"filters[0].tournamentid"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+			if _, err := readTestSpec(t, test.spec); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := unknownSpecFields([]byte(test.spec)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			for _, field := range test.want {
+				if want := "[WARN] Unknown field '" + field + "'"; !strings.Contains(logged.String(), want) {
+					t.Errorf("the log has no %q: %s", want, logged)
+				}
+			}
+			if len(test.want) == 0 && logged.Len() > 0 {
+				t.Errorf("got warnings: %s", logged)
+			}
+		})
+	}
+}
+
+func TestSubscriptionSpecInvalidFilters(t *testing.T) {
+	for _, spec := range []string{
+		`{"filters": []}`,
+		`{"filters": [{}]}`,
+		`{"filters": [{"channel": "series", "team_id": -1}]}`,
+		`{"filters": [{"player_id": -5}]}`,
+		`{"filters": [{"tournament_id": "4"}]}`,
+	} {
+		discardLog(t)
+		if _, err := readTestSpec(t, spec); err == nil {
+			t.Errorf("%s was accepted", spec)
+		}
+	}
+}
+
+// Names that need escaping reach the API and the websocket query unchanged,
+// and a spec with one of them is accepted with a warning
+func TestSubscriptionNameEscaping(t *testing.T) {
+	tests := []string{"dev team", "a/b", "what?", "100%", "#1 & co", "lämpö"}
+	for _, name := range tests {
+		t.Run(name, func(t *testing.T) {
+			var paths []string
+			var query string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if websocket.IsWebSocketUpgrade(r) {
+					query = r.URL.Query().Get("subscription_id")
+					http.NotFound(w, r)
+					return
+				}
+				// One path segment, so a '/' in the name must arrive escaped
+				segment := strings.TrimPrefix(r.URL.EscapedPath(), "/subscription/")
+				if strings.Contains(segment, "/") {
+					segment = "not escaped: " + segment
+				} else if s, err := url.PathUnescape(segment); err == nil {
+					segment = s
+				}
+				paths = append(paths, r.Method+" "+segment)
+				if r.Method == http.MethodGet {
+					w.Write([]byte(`{"id": "7b9f0b1e-5c3a-4d6e-9f2a-1c2d3e4f5a6b", "name": "` + name + `"}`))
+				}
+			}))
+			defer server.Close()
+
+			client, err := pushclient.New(pushclient.Config{
+				Addr: "ws" + strings.TrimPrefix(server.URL, "http"),
+				Auth: pushclient.NewSecretAuth("secret"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			apiClient = client
+			defer func() { apiClient = nil }()
+
+			if err := deleteOwnedSubscription(context.Background(), name); err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"GET " + name, "DELETE " + name}
+			if !reflect.DeepEqual(paths, want) {
+				t.Errorf("got requests %q, want %q", paths, want)
+			}
+
+			client.Dial(context.Background(), uuid.Nil, name)
+			if query != name {
+				t.Errorf("the websocket got subscription_id '%s', want '%s'", query, name)
+			}
+
+			logged := captureLog(t)
+			if _, err := readTestSpec(t, `{"name": "`+name+`", "filters": [{"channel": "series"}]}`); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(logged.String(), "must be escaped") {
+				t.Errorf("no warning about the name, logged: %s", logged)
+			}
+		})
+	}
+}
+
+func TestNormalizePayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string // Empty when an error is expected
+	}{
+		{
+			"double-encoded object",
+			`{"channel": "series", "payload": "{\"id\": 1, \"name\": \"a\"}"}`,
+			`{"channel":"series","payload":{"id":1,"name":"a"}}`,
+		},
+		{
+			"nested strings kept",
+			`{"payload": "{\"text\": \"{\\\"x\\\": 1}\"}", "uuid": "u"}`,
+			`{"payload":{"text":"{\"x\": 1}"},"uuid":"u"}`,
+		},
+		{"object payload", `{"payload": {"id": 1}}`, ""},
+		{"invalid string payload", `{"payload": "not json"}`, ""},
+		{"no payload", `{"channel": "series"}`, ""},
+		{"not an object", `[1, 2]`, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := normalizePayload([]byte(test.message))
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("got %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateAPIAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr string
+	}{
+		{"https://gateway.example.com/v0", ""},
+		{"http://localhost:9090", ""},
+		{"wss://gateway.example.com/v0", "must be an http:// or https:// URL"},
+		{"gateway.example.com/v0", "must be an http:// or https:// URL"},
+		{"https:///v0", "must be an http:// or https:// URL"},
+		{"https://gateway.example.com/v0?x=1", "can't have a query or fragment"},
+		{"https://gateway.example.com/v0#x", "can't have a query or fragment"},
+		{"https://gateway example.com", "Invalid '--api-addr'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.addr, func(t *testing.T) {
+			err := validateAPIAddr(test.addr)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("got error %v, want one containing '%s'", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// The API client built from the flags sends requests to '--api-addr' if
+// given and dials '--addr' for the websocket, and v2 access tokens are
+// created on '--api-addr' too
+func TestAPIAddrFlag(t *testing.T) {
+	tests := []struct {
+		name      string
+		addr      string
+		apiAddr   string
+		wantAPI   string
+		wantToken string
+	}{
+		{"derived", "wss://push.example.com/v0", "", "https://push.example.com/v0", *apiURLFlag},
+		{"https API with wss", "wss://push.example.com/v0", "https://gateway.example.com/api/", "https://gateway.example.com/api", "https://gateway.example.com/api"},
+		{"http API with wss", "wss://push.example.com/v0", "http://localhost:9090", "http://localhost:9090", "http://localhost:9090"},
+		{"https API with ws", "ws://localhost:8080/v0", "https://gateway.example.com", "https://gateway.example.com", "https://gateway.example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(addr, apiAddr string) { *addrFlag, *apiAddrFlag = addr, apiAddr }(*addrFlag, *apiAddrFlag)
+			*addrFlag, *apiAddrFlag = test.addr, test.apiAddr
+			auth = pushclient.NewSecretAuth("secret")
+			defer func() { auth = nil }()
+
+			client, err := newAPIClientFromFlags()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := client.APIAddr(); got != test.wantAPI {
+				t.Errorf("API requests go to '%s', want '%s'", got, test.wantAPI)
+			}
+			if got := client.Config().Addr; got != test.addr {
+				t.Errorf("the websocket is dialed on '%s', want '%s'", got, test.addr)
+			}
+			if got := accessTokenBaseURL(); got != test.wantToken {
+				t.Errorf("access tokens are created on '%s', want '%s'", got, test.wantToken)
+			}
+		})
+	}
+}
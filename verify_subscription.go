@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	flag "github.com/spf13/pflag"
+)
+
+var verifySubscriptionIntervalFlag = flag.Duration("verify-subscription-interval", 0, "Check with this interval that the subscription still exists on the server, e.g. '5m'")
+
+// Exit code used when the subscription was deleted on the server and there
+// is no spec file to register it again from
+const subscriptionMissingExitCode = 5
+
+// The interval is doubled, up to this many times the configured one, while
+// the API is rate limiting the client
+const maxVerifyBackoffFactor = 8
+
+// Periodically fetches the subscription, so that a subscription deleted by
+// someone else while the websocket stays connected is noticed. If the spec
+// file is given the subscription is registered again and the client
+// connects to it as a new subscriber, otherwise the client exits.
+func verifySubscriptionLoop(ctx context.Context, interval time.Duration, spec specSource) {
+	wait := interval
+	for {
+		if sleepContext(ctx, wait) != nil {
+			return
+		}
+
+		// The reconnect itself tells whether the subscription is gone
+		if !stats.snapshot().connected {
+			continue
+		}
+
+		subscription := currentSubscription()
+		_, err := apiClient.FetchSubscription(ctx, subscription)
+		if err == pushclient.ErrAPIRateLimited {
+			if wait < maxVerifyBackoffFactor*interval {
+				wait *= 2
+			}
+			log.Printf("[WARN] Rate limited when verifying the subscription, checking again in %s\n", wait)
+			continue
+		}
+		wait = interval
+		if err != pushclient.ErrSubscriptionNotFound {
+			if err != nil {
+				log.Println("[ERROR] Failed to verify that the subscription exists. Error: ", err)
+			}
+			continue
+		}
+
+		log.Printf("[ERROR] Subscription %s no longer exists on the server, it was deleted while connected\n", subscription)
+		emitEvent(lifecycleEvent{Event: eventSubscriptionMissing, SubscriptionID: subscription})
+
+		if !spec.given() {
+			log.Println("[ERROR] No '--subscription-file' or '--filter' to register it again from, shutting down")
+			shutdown(subscription, false, subscriptionMissingExitCode)
+			return
+		}
+
+		err = registerSubscriptionAgain(ctx, spec, !*keepSubscription)
+		if err != nil {
+			log.Println("[ERROR] Failed to register the subscription again, shutting down. Error: ", err)
+			shutdown(subscription, false, subscriptionMissingExitCode)
+			return
+		}
+
+		log.Println("[INFO] Connecting to the registered subscription as a new subscriber")
+		err = disconnectWebsocket()
+		if err != nil {
+			log.Println("[ERROR] Failed to close websocket for reconnect. Error: ", err)
+		}
+	}
+}
+
+// Registers the deleted subscription again from the spec and makes it the
+// one the client reconnects to and, if the client created it and
+// deleteIfCreated is set, deletes on exit. The subscriber belonged to the
+// deleted subscription, so its reconnect token can't be used.
+func registerSubscriptionAgain(ctx context.Context, spec specSource, deleteIfCreated bool) error {
+	id, existed, err := registerOrUpdateSubscription(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	switchSubscription(id, !existed && deleteIfCreated)
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// A push service whose API has no subscriptions until one is registered,
+// and whose websocket sends an init message for testSubscriptionID
+type registeringServer struct {
+	url        string
+	registered uuid.UUID
+	closes     chan struct{} // A close frame was received on the websocket
+
+	mu           sync.Mutex
+	exists       bool   // The subscription is registered
+	deleted      string // The ID of the deleted subscription
+	failRegister bool   // Registering fails with 500 Internal Server Error
+}
+
+func newRegisteringServer(t *testing.T, exists bool) *registeringServer {
+	t.Helper()
+
+	s := &registeringServer{registered: uuid.Must(uuid.NewV4()), closes: make(chan struct{}, 1), exists: exists}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			s.serveWebsocket(upgrader, w, r)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		path := s.registeredPath()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/subscription":
+			if s.failRegister {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if s.exists {
+				w.Header().Set("Location", s.registered.String())
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			s.exists = true
+			fmt.Fprintf(w, `{"id": "%s"}`, s.registered)
+		case r.Method == http.MethodGet && r.URL.Path == path && s.exists:
+			fmt.Fprintf(w, `{"id": "%s", "filters": [{"channel": "series"}]}`, s.registered)
+		case r.Method == http.MethodDelete && r.URL.Path == path && s.exists:
+			s.deleted = s.registered.String()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	s.url = "ws" + strings.TrimPrefix(server.URL, "http")
+
+	return s
+}
+
+func (s *registeringServer) registeredPath() string {
+	return "/subscription/" + s.registered.String()
+}
+
+func (s *registeringServer) serveWebsocket(upgrader websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetCloseHandler(func(code int, text string) error {
+		s.closes <- struct{}{}
+		return conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+	})
+	init := fmt.Sprintf(`{"channel": "system", "cmd": "init", "subscriber_id": "%s", "reconnect_token": "%s", "subscription": {"id": "%s", "name": "dev"}, "reconnected": false}`, uuid.Must(uuid.NewV4()), testToken, testSubscriptionID)
+	conn.WriteMessage(websocket.TextMessage, []byte(init))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// Makes the server the push service of the client until the test ends
+func useRegisteringServer(t *testing.T, s *registeringServer) {
+	t.Helper()
+
+	client, err := pushclient.New(pushclient.Config{
+		Addr: s.url,
+		Auth: pushclient.NewSecretAuth("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiClient = client
+	t.Cleanup(func() {
+		stopKeepAlive()
+		if conn := client.Conn(); conn != nil {
+			conn.Close()
+		}
+		apiClient = nil
+		stats.setConnected(false)
+		switchSubscription("", false)
+	})
+}
+
+var devSpec = specSource{filters: []string{"channel=series"}}
+
+// A subscription deleted while connected is registered again, the client
+// switches to it as a new subscriber and drops the connection to reconnect
+func TestVerifyRegistersDeletedSubscriptionAgain(t *testing.T) {
+	discardLog(t)
+	server := newRegisteringServer(t, false)
+	useRegisteringServer(t, server)
+
+	setCurrentSubscription(testSubscriptionID.String())
+	setRemoveSubscriptionOnExit(true)
+	if err := setupPushServiceConnection(context.Background(), uuid.Nil, currentSubscription()); err != nil {
+		t.Fatal(err)
+	}
+	if currentReconnectToken() != testToken {
+		t.Fatalf("the current token is %s, want %s", currentReconnectToken(), testToken)
+	}
+	// Reads the server's answer to the close handshake, as the read loop would
+	conn := apiClient.Conn()
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		verifySubscriptionLoop(ctx, 10*time.Millisecond, devSpec)
+		close(done)
+	}()
+	select {
+	case <-server.closes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection wasn't dropped to reconnect")
+	}
+	cancel()
+	<-done
+
+	if got := currentSubscription(); got != server.registered.String() {
+		t.Errorf("the current subscription is %s, want the registered %s", got, server.registered)
+	}
+	if got := currentReconnectToken(); got != uuid.Nil {
+		t.Errorf("the current token is %s, the old subscriber's can't be used", got)
+	}
+	if !removeSubscriptionOnExit() {
+		t.Error("the registered subscription isn't deleted on exit")
+	}
+}
+
+// A subscription deleted while connected makes the client exit with the
+// subscription-missing exit code when there is no spec to register it
+// again from, or registering it again fails
+func TestVerifyExitsWhenSubscriptionMissing(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         specSource
+		failRegister bool
+		wantLog      string
+	}{
+		{"no spec", specSource{}, false, "No '--subscription-file' or '--filter' to register it again from"},
+		{"registering fails", devSpec, true, "Failed to register the subscription again"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logged := captureLog(t)
+			server := newRegisteringServer(t, false)
+			server.failRegister = test.failRegister
+			useRegisteringServer(t, server)
+			exited := catchExit(t)
+
+			setCurrentSubscription(testSubscriptionID.String())
+			if err := setupPushServiceConnection(context.Background(), uuid.Nil, currentSubscription()); err != nil {
+				t.Fatal(err)
+			}
+			conn := apiClient.Conn()
+			go func() {
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go verifySubscriptionLoop(ctx, 10*time.Millisecond, test.spec)
+			select {
+			case code := <-exited:
+				if code != subscriptionMissingExitCode {
+					t.Errorf("exited with code %d, want %d", code, subscriptionMissingExitCode)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the client didn't exit")
+			}
+
+			for _, want := range []string{"Subscription " + testSubscriptionID.String() + " no longer exists on the server", test.wantLog} {
+				if !strings.Contains(logged.String(), want) {
+					t.Errorf("the log doesn't contain '%s':\n%s", want, logged)
+				}
+			}
+			if got := currentSubscription(); got != testSubscriptionID.String() {
+				t.Errorf("the current subscription is %s, want it unchanged", got)
+			}
+		})
+	}
+}
+
+// On exit the subscription registered again is deleted, not the one that
+// was deleted while connected, unless the client didn't create it or
+// '--keep-subscription' was given
+func TestRegisteredAgainRemovedOnExit(t *testing.T) {
+	tests := []struct {
+		name            string
+		exists          bool
+		deleteIfCreated bool
+		wantRemove      bool
+	}{
+		{"created", false, true, true},
+		{"created and kept", false, false, false},
+		{"registered by someone else", true, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discardLog(t)
+			server := newRegisteringServer(t, test.exists)
+			useRegisteringServer(t, server)
+			switchSubscription(testSubscriptionID.String(), true)
+
+			if err := registerSubscriptionAgain(context.Background(), devSpec, test.deleteIfCreated); err != nil {
+				t.Fatal(err)
+			}
+			if got := removeSubscriptionOnExit(); got != test.wantRemove {
+				t.Fatalf("removed on exit: %v, want %v", got, test.wantRemove)
+			}
+			if !test.wantRemove {
+				return
+			}
+
+			if err := deleteOwnedSubscription(context.Background(), currentSubscription()); err != nil {
+				t.Fatal(err)
+			}
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if server.deleted != server.registered.String() {
+				t.Errorf("deleted '%s', want the registered %s", server.deleted, server.registered)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Periodically fetches the subscription and logs how it changed since it
+// was last fetched, e.g. when someone else updated its filters. With
+// follow the websocket is closed cleanly on a change, so that the read
+// loop reconnects with the reconnect token and the new filters take
+// effect.
+func watchSubscriptionLoop(ctx context.Context, interval time.Duration, follow bool) {
+	known, etag, _, err := apiClient.FetchSubscriptionIfChanged(ctx, currentSubscription(), "")
+	if err != nil {
+		log.Println("[ERROR] Failed to fetch subscription to watch. Error: ", err)
+	}
+	haveKnown := err == nil
+
+	for {
+		if sleepContext(ctx, interval) != nil {
+			return
+		}
+
+		sub, newETag, notModified, err := apiClient.FetchSubscriptionIfChanged(ctx, currentSubscription(), etag)
+		if err != nil {
+			log.Println("[ERROR] Failed to fetch watched subscription. Error: ", err)
+			continue
+		}
+		if notModified {
+			continue
+		}
+		etag = newETag
+		if !haveKnown {
+			known = sub
+			haveKnown = true
+			continue
+		}
+
+		changes := diffSubscriptions(known, sub)
+		if len(changes) == 0 {
+			continue
+		}
+		known = sub
+
+		log.Printf("[WARN] Subscription %s was changed by someone else\n", currentSubscription())
+		for _, c := range changes {
+			logSubscriptionChange(c)
+		}
+
+		if follow {
+			log.Println("[INFO] Reconnecting so the new subscription takes effect")
+			err = disconnectWebsocket()
+			if err != nil {
+				log.Println("[ERROR] Failed to close websocket for reconnect. Error: ", err)
+			}
+		}
+	}
+}
+
+func logSubscriptionChange(c subscriptionChange) {
+	switch c.Op {
+	case "add":
+		b, _ := json.Marshal(c.Filter)
+		log.Printf("[WARN] Filter added: %s\n", b)
+	case "remove":
+		b, _ := json.Marshal(c.Filter)
+		log.Printf("[WARN] Filter removed: %s\n", b)
+	case "change":
+		log.Printf("[WARN] Changed %s from '%s' to '%s'\n", c.Field, c.From, c.To)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AbiosGaming/push-api-client/pkg/pushclient"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// A push service whose subscription can be changed by the test, as if by
+// someone else. It answers with 304 Not Modified while the ETag matches.
+type watchedServer struct {
+	url    string
+	closes chan struct{} // A close frame was received on the websocket
+
+	mu          sync.Mutex
+	filters     string
+	version     int
+	fetches     int
+	notModified int
+}
+
+func newWatchedServer(t *testing.T) *watchedServer {
+	t.Helper()
+
+	s := &watchedServer{closes: make(chan struct{}, 1), filters: `[{"channel": "series"}]`}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			s.serveWebsocket(upgrader, w, r)
+			return
+		}
+		if r.Method != http.MethodGet || r.URL.Path != "/subscription/"+testSubscriptionID.String() {
+			http.NotFound(w, r)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.fetches++
+		etag := fmt.Sprintf(`"v%d"`, s.version)
+		if r.Header.Get("If-None-Match") == etag {
+			s.notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `{"id": "%s", "name": "dev", "filters": %s}`, testSubscriptionID, s.filters)
+	}))
+	t.Cleanup(server.Close)
+	s.url = "ws" + strings.TrimPrefix(server.URL, "http")
+
+	return s
+}
+
+func (s *watchedServer) serveWebsocket(upgrader websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetCloseHandler(func(code int, text string) error {
+		s.closes <- struct{}{}
+		return conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+	})
+	init := fmt.Sprintf(`{"channel": "system", "cmd": "init", "subscriber_id": "%s", "reconnect_token": "%s", "subscription": {"id": "%s", "name": "dev"}, "reconnected": false}`, uuid.Must(uuid.NewV4()), testToken, testSubscriptionID)
+	conn.WriteMessage(websocket.TextMessage, []byte(init))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// Changes the subscription, as if someone else updated it
+func (s *watchedServer) change(filters string) {
+	s.mu.Lock()
+	s.filters = filters
+	s.version++
+	s.mu.Unlock()
+}
+
+// Waits until the server has been polled n more times
+func (s *watchedServer) waitForFetches(t *testing.T, n int) {
+	t.Helper()
+
+	s.mu.Lock()
+	want := s.fetches + n
+	s.mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		fetches := s.fetches
+		s.mu.Unlock()
+		if fetches >= want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the subscription was fetched %d times, want %d", fetches, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// A change made by someone else is logged, and with follow the client
+// reconnects so that it takes effect. Polling an unchanged subscription
+// only gets 304 Not Modified.
+func TestWatchSubscriptionLoop(t *testing.T) {
+	for _, follow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("follow %v", follow), func(t *testing.T) {
+			logged := captureLog(t)
+			server := newWatchedServer(t)
+			client, err := pushclient.New(pushclient.Config{
+				Addr: server.url,
+				Auth: pushclient.NewSecretAuth("secret"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			apiClient = client
+			t.Cleanup(func() {
+				stopKeepAlive()
+				if conn := client.Conn(); conn != nil {
+					conn.Close()
+				}
+				apiClient = nil
+				stats.setConnected(false)
+				switchSubscription("", false)
+			})
+
+			setCurrentSubscription(testSubscriptionID.String())
+			if err := setupPushServiceConnection(context.Background(), uuid.Nil, currentSubscription()); err != nil {
+				t.Fatal(err)
+			}
+			// Reads the server's answer to the close handshake, as the read loop would
+			conn := apiClient.Conn()
+			go func() {
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				watchSubscriptionLoop(ctx, 10*time.Millisecond, follow)
+				close(done)
+			}()
+			server.waitForFetches(t, 3)
+			server.change(`[{"channel": "series"}, {"channel": "match", "game_id": 1}]`)
+
+			if follow {
+				select {
+				case <-server.closes:
+				case <-time.After(5 * time.Second):
+					t.Fatal("the connection wasn't dropped to reconnect")
+				}
+			} else {
+				server.waitForFetches(t, 3)
+				select {
+				case <-server.closes:
+					t.Error("the connection was dropped without '--follow-subscription-changes'")
+				default:
+				}
+			}
+			cancel()
+			<-done
+			stopKeepAlive()
+
+			server.mu.Lock()
+			notModified := server.notModified
+			server.mu.Unlock()
+			if notModified < 2 {
+				t.Errorf("got %d Not Modified answers, want the unchanged subscription polled with its ETag", notModified)
+			}
+
+			wantLines := []string{
+				"Subscription " + testSubscriptionID.String() + " was changed by someone else",
+				`Filter added: {"channel":"match","game_id":1}`,
+			}
+			if follow {
+				wantLines = append(wantLines, "Reconnecting so the new subscription takes effect")
+			}
+			for _, want := range wantLines {
+				if !strings.Contains(logged.String(), want) {
+					t.Errorf("the log doesn't contain '%s':\n%s", want, logged)
+				}
+			}
+		})
+	}
+}